@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// envNamePattern matches the names of environment variables an account may
+// override
+var envNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// accountNamePattern matches account names, which name their directory in
+// multi-account mode
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// accountSelectors choose the overrides and cannot be overridden
+var accountSelectors = map[string]bool{
+	"BRIDGE_ACCOUNT":         true,
+	"BRIDGE_ACCOUNTS":        true,
+	"ACCOUNT_OVERRIDES":      true,
+	"ACCOUNT_OVERRIDES_FILE": true,
+}
+
+// loadAccountOverrides reads ACCOUNT_OVERRIDES, or the file named by
+// ACCOUNT_OVERRIDES_FILE, as JSON of {"<account>": {"<VARIABLE>": value}}.
+// It returns nil when neither is set.
+func loadAccountOverrides() (map[string]map[string]json.RawMessage, error) {
+	raw := []byte(envString("ACCOUNT_OVERRIDES", ""))
+	if path := envString("ACCOUNT_OVERRIDES_FILE", ""); path != "" {
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read ACCOUNT_OVERRIDES_FILE: %v", err)
+		}
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var accounts map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to parse account overrides: %v", err)
+	}
+	return accounts, nil
+}
+
+// applyAccountOverrides lets several WhatsApp accounts share one
+// configuration. The section of BRIDGE_ACCOUNT in the account overrides
+// replaces those environment variables before anything reads them, so a
+// high-volume support number can use another store backend, media policy,
+// webhooks and rate limits than a personal archive. Values that are not
+// strings, such as the WEBHOOKS array, are set as their JSON; null unsets
+// the variable, restoring the default. It returns the account and the
+// variables it overrode.
+func applyAccountOverrides() (string, []string, error) {
+	account := envString("BRIDGE_ACCOUNT", "")
+	if account == "" {
+		return "", nil, nil
+	}
+
+	accounts, err := loadAccountOverrides()
+	if err != nil || accounts == nil {
+		return account, nil, err
+	}
+	overrides, ok := accounts[account]
+	if !ok {
+		return account, nil, fmt.Errorf("no overrides for account %q", account)
+	}
+
+	// A nil value unsets the variable
+	values := make(map[string]*string, len(overrides))
+	for name, value := range overrides {
+		if !envNamePattern.MatchString(name) || accountSelectors[name] {
+			return account, nil, fmt.Errorf("account %s cannot override %q", account, name)
+		}
+		if string(value) == "null" {
+			values[name] = nil
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err != nil {
+			// Numbers, booleans, arrays and objects keep their JSON
+			text = string(value)
+		}
+		values[name] = &text
+	}
+
+	// Only set once every name is known to be valid
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		if value == nil {
+			err = os.Unsetenv(name)
+		} else {
+			err = os.Setenv(name, *value)
+		}
+		if err != nil {
+			return account, nil, fmt.Errorf("failed to set %s: %v", name, err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return account, names, nil
+}
+
+// runAccounts is the multi-account mode: one process serves every account
+// listed in BRIDGE_ACCOUNTS by running a bridge for each with its
+// BRIDGE_ACCOUNT set. Each bridge runs in accounts/<account>, so its
+// session, store directory and downloads stay apart; relative paths in the
+// configuration resolve there. Accounts need their own BRIDGE_PORT
+// override. Output is prefixed with the account, and SIGINT and SIGTERM are
+// passed on. It returns once every bridge has exited.
+func runAccounts(accounts []string) error {
+	overrides, err := loadAccountOverrides()
+	if err != nil {
+		return err
+	}
+	ports := make(map[string]string)
+	for i, account := range accounts {
+		account = strings.TrimSpace(account)
+		accounts[i] = account
+		if !accountNamePattern.MatchString(account) {
+			return fmt.Errorf("invalid account name %q", account)
+		}
+		port := envString("BRIDGE_PORT", "8080")
+		if value, ok := overrides[account]["BRIDGE_PORT"]; ok {
+			port = strings.Trim(string(value), `"`)
+		}
+		if other, taken := ports[port]; taken {
+			return fmt.Errorf("accounts %s and %s both use BRIDGE_PORT %s", other, account, port)
+		}
+		ports[port] = account
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the bridge executable: %v", err)
+	}
+	// The bridges run in their own directories
+	env := os.Environ()
+	if path := envString("ACCOUNT_OVERRIDES_FILE", ""); path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			env = append(env, "ACCOUNT_OVERRIDES_FILE="+abs)
+		}
+	}
+
+	var commands []*exec.Cmd
+	for _, account := range accounts {
+		dir := filepath.Join("accounts", account)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+		cmd := exec.Command(executable, os.Args[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(env[:len(env):len(env)], "BRIDGE_ACCOUNT="+account)
+		cmd.Stdout = &accountOutput{prefix: "[" + account + "] ", out: os.Stdout}
+		cmd.Stderr = &accountOutput{prefix: "[" + account + "] ", out: os.Stderr}
+		if err := cmd.Start(); err != nil {
+			for _, started := range commands {
+				started.Process.Signal(syscall.SIGTERM)
+				started.Wait()
+			}
+			return fmt.Errorf("failed to start account %s: %v", account, err)
+		}
+		fmt.Printf("Started account %s (pid %d) in %s\n", account, cmd.Process.Pid, dir)
+		commands = append(commands, cmd)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			for _, cmd := range commands {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+	for i, cmd := range commands {
+		wg.Add(1)
+		go func(account string, cmd *exec.Cmd) {
+			defer wg.Done()
+			if err := cmd.Wait(); err != nil {
+				fmt.Fprintf(os.Stderr, "Account %s exited: %v\n", account, err)
+				mu.Lock()
+				failed = append(failed, account)
+				mu.Unlock()
+			}
+		}(accounts[i], cmd)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("accounts %s exited with errors", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// accountOutput prefixes every line a bridge writes with its account
+type accountOutput struct {
+	prefix string
+	out    io.Writer
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *accountOutput) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(w.out, "%s%s", w.prefix, w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
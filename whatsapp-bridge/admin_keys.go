@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name           string     `json:"name"`
+	Scopes         []APIScope `json:"scopes"`
+	DailySendQuota int        `json:"daily_send_quota,omitempty"`
+}
+
+// APIKeyResponse represents the response for API key management calls. The
+// secret is only included right after a key is created or rotated.
+type APIKeyResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message,omitempty"`
+	Key     *APIKey  `json:"key,omitempty"`
+	Keys    []APIKey `json:"keys,omitempty"`
+}
+
+// writeAPIKeyResponse encodes a key management response with a status code
+func writeAPIKeyResponse(w http.ResponseWriter, status int, resp APIKeyResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAdminKeysRoute guards /api/admin/keys. It needs the admin scope
+// like the other admin routes, except while no key exists: then the first
+// key is created with the API_BOOTSTRAP_SECRET, since whoever creates it
+// owns the bridge.
+func handleAdminKeysRoute(w http.ResponseWriter, r *http.Request) {
+	if apiKeys.HasKeys() || oidcVerifier != nil {
+		withAuth(ScopeAdmin, handleAdminKeys)(w, r)
+		return
+	}
+
+	secret := envString("API_BOOTSTRAP_SECRET", "")
+	presented := r.Header.Get("X-Bootstrap-Secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(secret)) != 1 {
+		http.Error(w, "Creating the first API key needs the X-Bootstrap-Secret header matching API_BOOTSTRAP_SECRET", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	handleAdminKeys(w, r)
+}
+
+// handleAdminKeys serves GET /api/admin/keys to list keys and POST to create
+// one. The first key always gets the admin scope, so it can manage the
+// others.
+func handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAPIKeyResponse(w, http.StatusOK, APIKeyResponse{
+			Success: true,
+			Keys:    apiKeys.List(),
+		})
+
+	case http.MethodPost:
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if !apiKeys.HasKeys() && !hasScope(req.Scopes, ScopeAdmin) {
+			req.Scopes = append(req.Scopes, ScopeAdmin)
+		}
+
+		key, err := apiKeys.Create(req.Name, req.Scopes, req.DailySendQuota)
+		if err != nil {
+			writeAPIKeyResponse(w, http.StatusBadRequest, APIKeyResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to create key: %v", err),
+			})
+			return
+		}
+
+		writeAPIKeyResponse(w, http.StatusCreated, APIKeyResponse{
+			Success: true,
+			Message: "Store this key now, it cannot be shown again",
+			Key:     key,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// hasScope reports whether a scope list contains a scope
+func hasScope(scopes []APIScope, scope APIScope) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeKeyName reads the {"name"} body used by rotate and revoke
+func decodeKeyName(r *http.Request) (string, error) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return "", fmt.Errorf("Invalid request format")
+	}
+	if req.Name == "" {
+		return "", fmt.Errorf("Key name is required")
+	}
+	return req.Name, nil
+}
+
+// keyErrorStatus maps key store errors to HTTP status codes
+func keyErrorStatus(err error) int {
+	if err == errAPIKeyNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// handleAdminRotateKey serves POST /api/admin/keys/rotate
+func handleAdminRotateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, err := decodeKeyName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := apiKeys.Rotate(name)
+	if err != nil {
+		writeAPIKeyResponse(w, keyErrorStatus(err), APIKeyResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to rotate key: %v", err),
+		})
+		return
+	}
+
+	writeAPIKeyResponse(w, http.StatusOK, APIKeyResponse{
+		Success: true,
+		Message: "Store this key now, it cannot be shown again",
+		Key:     key,
+	})
+}
+
+// handleAdminRevokeKey serves POST /api/admin/keys/revoke
+func handleAdminRevokeKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, err := decodeKeyName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := apiKeys.Revoke(name); err != nil {
+		writeAPIKeyResponse(w, keyErrorStatus(err), APIKeyResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to revoke key: %v", err),
+		})
+		return
+	}
+
+	writeAPIKeyResponse(w, http.StatusOK, APIKeyResponse{
+		Success: true,
+		Message: fmt.Sprintf("Key %s revoked", name),
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIVersion is the current version of the bridge HTTP API
+const APIVersion = 1
+
+// apiVersionHeader lets clients pin the API version they were written for
+const apiVersionHeader = "X-API-Version"
+
+// handleAPI registers an API handler under /api/v1<path> and keeps the
+// unversioned /api<path> route as a deprecated alias. Both require an API
+// key with the given scope once keys are configured.
+func handleAPI(path string, scope APIScope, handler http.HandlerFunc) {
+	versioned := fmt.Sprintf("/api/v%d%s", APIVersion, path)
+	handler = withAuth(scope, handler)
+
+	http.HandleFunc(versioned, withAPIVersion(handler))
+	http.HandleFunc("/api"+path, withAPIVersion(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", versioned))
+		handler(w, r)
+	}))
+}
+
+// withAPIVersion negotiates the API version. Requests asking for a version
+// this bridge does not implement are rejected instead of being served with
+// possibly incompatible semantics.
+func withAPIVersion(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requested := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(apiVersionHeader)), "v"); requested != "" {
+			if requested != fmt.Sprint(APIVersion) {
+				w.Header().Set(apiVersionHeader, fmt.Sprint(APIVersion))
+				http.Error(w, fmt.Sprintf("Unsupported API version %q, this bridge serves version %d", requested, APIVersion), http.StatusNotAcceptable)
+				return
+			}
+		}
+
+		w.Header().Set(apiVersionHeader, fmt.Sprint(APIVersion))
+		handler(w, r)
+	}
+}
+
+// gzipMinSize is the smallest response body worth compressing
+const gzipMinSize = 1024
+
+// bufferedResponse captures a handler's response so it can be hashed and
+// compressed before anything is sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+// withConditionalGzip adds an ETag to successful GET responses, answers
+// matching If-None-Match requests with 304 Not Modified, and gzips larger
+// bodies for clients that accept it. Meant for list endpoints that clients
+// poll repeatedly.
+func withConditionalGzip(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		handler(buf, r)
+		body := buf.body.Bytes()
+
+		if buf.status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			sum := sha256.Sum256(body)
+			// Weak because the same tag covers the plain and gzipped encodings
+			etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+			w.Header().Set("ETag", etag)
+
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if len(body) >= gzipMinSize && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(buf.status)
+
+			gz := gzip.NewWriter(w)
+			gz.Write(body)
+			gz.Close()
+			return
+		}
+
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches the ETag,
+// using weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// approvalRequired reports whether sends by a key must be approved, from
+// the key names listed in APPROVAL_REQUIRED_KEYS. OIDC principals are named
+// "oidc:<subject>".
+func approvalRequired(key *APIKey) bool {
+	if key == nil {
+		return false
+	}
+	for _, name := range envList("APPROVAL_REQUIRED_KEYS") {
+		if name == key.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalsResponse represents the response for the approvals API
+type ApprovalsResponse struct {
+	Success  bool          `json:"success"`
+	Message  string        `json:"message,omitempty"`
+	Messages []HeldMessage `json:"messages"`
+}
+
+// ApprovalRequest represents the request body to approve or reject a message
+type ApprovalRequest struct {
+	MessageID string `json:"message_id"`
+	// Urgent sends an approved message immediately, even in quiet hours
+	Urgent bool `json:"urgent,omitempty"`
+}
+
+// handleApprovals serves GET /api/approvals with the messages waiting for
+// approval
+func handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	held, err := outbox.PendingApproval()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ApprovalsResponse{
+			Success:  false,
+			Message:  fmt.Sprintf("Failed to list approvals: %v", err),
+			Messages: []HeldMessage{},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(ApprovalsResponse{Success: true, Messages: held})
+}
+
+// handleApprovalDecision serves POST /api/approvals/approve and
+// /api/approvals/reject
+func handleApprovalDecision(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ApprovalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.MessageID == "" {
+			http.Error(w, "Message ID is required", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		var releaseAt time.Time
+		message := "Message rejected"
+		if approve {
+			// Approved messages still respect the recipient's quiet hours
+			if !req.Urgent {
+				releaseAt = approvalReleaseTime(req.MessageID)
+			}
+			err = outbox.Approve(req.MessageID, releaseAt)
+			message = "Message approved"
+			if !releaseAt.IsZero() {
+				message = fmt.Sprintf("Message approved, held for quiet hours until %s", releaseAt.Format(time.RFC3339))
+			}
+		} else {
+			err = outbox.Reject(req.MessageID)
+		}
+
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == errHeldMessageNotFound {
+				status = http.StatusNotFound
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message":    message,
+			"message_id": req.MessageID,
+		})
+	}
+}
+
+// approvalReleaseTime returns the end of the quiet window the recipient of
+// a pending message is in, or zero to send right away
+func approvalReleaseTime(messageID string) time.Time {
+	msg, err := outbox.Get(messageID)
+	if err != nil {
+		return time.Time{}
+	}
+
+	recipient, err := types.ParseJID(msg.Recipient)
+	if err != nil {
+		return time.Time{}
+	}
+
+	releaseAt, _ := quietHours.HoldUntil(recipient, time.Now())
+	return releaseAt
+}
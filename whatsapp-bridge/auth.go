@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIScope grants access to a group of API routes
+type APIScope string
+
+// Route scopes. Keys with the admin scope may call every route.
+const (
+	ScopePublic APIScope = ""
+	ScopeRead   APIScope = "read"
+	ScopeSend   APIScope = "send"
+	ScopeMedia  APIScope = "media"
+	ScopeAdmin  APIScope = "admin"
+)
+
+// validScopes lists the scopes that can be granted to a key
+var validScopes = map[APIScope]bool{
+	ScopeRead:  true,
+	ScopeSend:  true,
+	ScopeMedia: true,
+	ScopeAdmin: true,
+}
+
+// API key sources. Configured keys come from API_KEYS and can only be
+// changed by editing the configuration; stored keys are managed at runtime
+// through the admin API.
+const (
+	KeySourceConfig = "config"
+	KeySourceStore  = "store"
+)
+
+// APIKey is a credential for the bridge API
+type APIKey struct {
+	Name   string     `json:"name"`
+	Key    string     `json:"key,omitempty"`
+	Scopes []APIScope `json:"scopes"`
+	// DailySendQuota caps the messages accepted for sending per UTC day, 0
+	// means unlimited
+	DailySendQuota int       `json:"daily_send_quota,omitempty"`
+	Source         string    `json:"source,omitempty"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+}
+
+// HasScope reports whether the key may call routes of the given scope
+func (k *APIKey) HasScope(scope APIScope) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScopes rejects scopes the bridge does not know
+func validateScopes(scopes []APIScope) error {
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// APIKeyUsage is the send usage of a key on a given day
+type APIKeyUsage struct {
+	Name           string     `json:"name"`
+	Scopes         []APIScope `json:"scopes"`
+	DailySendQuota int        `json:"daily_send_quota"`
+	Sends          int        `json:"sends"`
+}
+
+// APIKeyStore authenticates API keys and tracks their daily send usage in
+// the bridge database. Keys from API_KEYS are combined with keys created
+// through the admin API, which are persisted as hashes.
+type APIKeyStore struct {
+	db *sql.DB
+	// configured holds the API_KEYS entries by name
+	configured map[string]*APIKey
+	// keys are indexed by the SHA-256 of the key so lookups do not compare
+	// secrets directly
+	keys map[string]*APIKey
+	// enforced stays set once any key has existed, so revoking the last
+	// key does not reopen the API
+	enforced bool
+	mutex    sync.RWMutex
+
+	// usageMutex serializes quota checks with the usage increment
+	usageMutex sync.Mutex
+}
+
+// apiKeys is the active key store. Authentication is enforced once it has
+// held a key.
+var apiKeys *APIKeyStore
+
+// NewAPIKeyStore loads the keys configured in API_KEYS, a JSON array of
+// {"name","key","scopes","daily_send_quota"}, together with the keys stored
+// in the bridge database. While neither exists the API stays
+// unauthenticated.
+func NewAPIKeyStore(db *sql.DB) (*APIKeyStore, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			name TEXT PRIMARY KEY,
+			key_hash TEXT UNIQUE NOT NULL,
+			scopes TEXT NOT NULL,
+			daily_send_quota INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS api_auth (
+			enforced_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS api_key_usage (
+			key_name TEXT,
+			day TEXT,
+			sends INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (key_name, day)
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key tables: %v", err)
+	}
+
+	s := &APIKeyStore{db: db, configured: make(map[string]*APIKey)}
+
+	if raw := envString("API_KEYS", ""); raw != "" {
+		var configured []APIKey
+		if err := json.Unmarshal([]byte(raw), &configured); err != nil {
+			return nil, fmt.Errorf("failed to parse API_KEYS: %v", err)
+		}
+
+		for i := range configured {
+			key := configured[i]
+			if key.Name == "" || key.Key == "" {
+				return nil, fmt.Errorf("API key %d needs a name and a key", i)
+			}
+			if s.configured[key.Name] != nil {
+				return nil, fmt.Errorf("duplicate API key name %q", key.Name)
+			}
+			if err := validateScopes(key.Scopes); err != nil {
+				return nil, fmt.Errorf("API key %q: %v", key.Name, err)
+			}
+			key.Source = KeySourceConfig
+			s.configured[key.Name] = &key
+		}
+	}
+
+	var enforced int
+	if err := db.QueryRow("SELECT COUNT(*) FROM api_auth").Scan(&enforced); err != nil {
+		return nil, fmt.Errorf("failed to read API auth state: %v", err)
+	}
+	s.enforced = enforced > 0
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// enforce records that keys exist, keeping authentication on for good
+func (s *APIKeyStore) enforce() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.enforced {
+		return nil
+	}
+	if _, err := s.db.Exec("INSERT INTO api_auth (enforced_at) VALUES (?)", time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record API auth state: %v", err)
+	}
+	s.enforced = true
+	return nil
+}
+
+// reload rebuilds the lookup table from the configured and stored keys
+func (s *APIKeyStore) reload() error {
+	keys := make(map[string]*APIKey)
+	for _, key := range s.configured {
+		keys[hashAPIKey(key.Key)] = key
+	}
+
+	rows, err := s.db.Query("SELECT name, key_hash, scopes, daily_send_quota, created_at FROM api_keys")
+	if err != nil {
+		return fmt.Errorf("failed to load API keys: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key := &APIKey{Source: KeySourceStore}
+		var keyHash, scopes string
+		if err := rows.Scan(&key.Name, &keyHash, &scopes, &key.DailySendQuota, &key.CreatedAt); err != nil {
+			return fmt.Errorf("failed to load API keys: %v", err)
+		}
+		if s.configured[key.Name] != nil {
+			// API_KEYS wins over a stored key of the same name
+			continue
+		}
+		if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+			return fmt.Errorf("failed to load API key %q: %v", key.Name, err)
+		}
+		keys[keyHash] = key
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load API keys: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+
+	if len(keys) > 0 {
+		return s.enforce()
+	}
+	return nil
+}
+
+// hashAPIKey returns the lookup hash of a key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIKey returns a new random key
+func generateAPIKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	return "wab_" + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// usageDay is the quota bucket for a point in time
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Enabled reports whether authentication is on, which it is from the
+// first key on, even after every key is revoked
+func (s *APIKeyStore) Enabled() bool {
+	if s == nil {
+		return false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.enforced || len(s.keys) > 0
+}
+
+// HasKeys reports whether any key can authenticate right now
+func (s *APIKeyStore) HasKeys() bool {
+	if s == nil {
+		return false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.keys) > 0
+}
+
+// Authenticate returns the key matching the presented secret, or nil
+func (s *APIKeyStore) Authenticate(secret string) *APIKey {
+	if secret == "" {
+		return nil
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.keys[hashAPIKey(secret)]
+}
+
+// List returns every active key without its secret, sorted by name
+func (s *APIKeyStore) List() []APIKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		listed := *key
+		listed.Key = ""
+		keys = append(keys, listed)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// Create stores a new key and returns it including the generated secret,
+// which is not retrievable afterwards
+func (s *APIKeyStore) Create(name string, scopes []APIScope, dailySendQuota int) (*APIKey, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	if err := validateScopes(scopes); err != nil {
+		return nil, err
+	}
+	if dailySendQuota < 0 {
+		return nil, fmt.Errorf("daily_send_quota cannot be negative")
+	}
+	if s.configured[name] != nil {
+		return nil, fmt.Errorf("key %q already exists in API_KEYS", name)
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	encodedScopes, _ := json.Marshal(scopes)
+	createdAt := time.Now().UTC()
+
+	_, err = s.db.Exec(
+		"INSERT INTO api_keys (name, key_hash, scopes, daily_send_quota, created_at) VALUES (?, ?, ?, ?, ?)",
+		name, hashAPIKey(secret), string(encodedScopes), dailySendQuota, createdAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("key %q already exists", name)
+		}
+		return nil, fmt.Errorf("failed to store key: %v", err)
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return &APIKey{
+		Name:           name,
+		Key:            secret,
+		Scopes:         scopes,
+		DailySendQuota: dailySendQuota,
+		Source:         KeySourceStore,
+		CreatedAt:      createdAt,
+	}, nil
+}
+
+// Rotate replaces the secret of a stored key, invalidating the old one
+func (s *APIKeyStore) Rotate(name string) (*APIKey, error) {
+	if s.configured[name] != nil {
+		return nil, fmt.Errorf("key %q is configured in API_KEYS and cannot be rotated at runtime", name)
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec("UPDATE api_keys SET key_hash = ? WHERE name = ?", hashAPIKey(secret), name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate key: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, errAPIKeyNotFound
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	key := s.Authenticate(secret)
+	if key == nil {
+		return nil, errAPIKeyNotFound
+	}
+	rotated := *key
+	rotated.Key = secret
+	return &rotated, nil
+}
+
+// Revoke deletes a stored key
+func (s *APIKeyStore) Revoke(name string) error {
+	if s.configured[name] != nil {
+		return fmt.Errorf("key %q is configured in API_KEYS and cannot be revoked at runtime", name)
+	}
+
+	result, err := s.db.Exec("DELETE FROM api_keys WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to revoke key: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errAPIKeyNotFound
+	}
+
+	return s.reload()
+}
+
+// errAPIKeyNotFound is returned when rotating or revoking an unknown key
+var errAPIKeyNotFound = fmt.Errorf("API key not found")
+
+// ReserveSend counts a send against the key's daily quota. It returns false
+// without counting when the quota is used up. Sends without a key are not
+// counted.
+func (s *APIKeyStore) ReserveSend(key *APIKey) (bool, error) {
+	if s == nil || key == nil {
+		return true, nil
+	}
+
+	s.usageMutex.Lock()
+	defer s.usageMutex.Unlock()
+
+	day := usageDay(time.Now())
+
+	if key.DailySendQuota > 0 {
+		var sends int
+		err := s.db.QueryRow("SELECT sends FROM api_key_usage WHERE key_name = ? AND day = ?", key.Name, day).Scan(&sends)
+		if err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("failed to read usage: %v", err)
+		}
+		if sends >= key.DailySendQuota {
+			return false, nil
+		}
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO api_key_usage (key_name, day, sends) VALUES (?, ?, 1)
+		ON CONFLICT(key_name, day) DO UPDATE SET sends = sends + 1`,
+		key.Name, day,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record usage: %v", err)
+	}
+
+	return true, nil
+}
+
+// ReleaseSend refunds a send reserved at the given time that was not sent,
+// because it failed or was rejected
+func (s *APIKeyStore) ReleaseSend(name string, reservedAt time.Time) {
+	if s == nil || name == "" {
+		return
+	}
+
+	s.usageMutex.Lock()
+	defer s.usageMutex.Unlock()
+
+	_, err := s.db.Exec("UPDATE api_key_usage SET sends = sends - 1 WHERE key_name = ? AND day = ? AND sends > 0", name, usageDay(reservedAt))
+	if err != nil {
+		fmt.Printf("Failed to refund send of key %s: %v\n", name, err)
+	}
+}
+
+// Usage returns the send usage of every key on the given day
+func (s *APIKeyStore) Usage(day string) ([]APIKeyUsage, error) {
+	rows, err := s.db.Query("SELECT key_name, sends FROM api_key_usage WHERE day = ?", day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sends := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		sends[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := s.List()
+	usage := make([]APIKeyUsage, 0, len(keys))
+	for _, key := range keys {
+		usage = append(usage, APIKeyUsage{
+			Name:           key.Name,
+			Scopes:         key.Scopes,
+			DailySendQuota: key.DailySendQuota,
+			Sends:          sends[key.Name],
+		})
+	}
+	return usage, nil
+}
+
+// presentedAPIKey reads the secret from the Authorization bearer token or
+// the X-API-Key header
+func presentedAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// apiKeyContextKey is the request context key holding the caller's APIKey
+type apiKeyContextKey struct{}
+
+// requestAPIKey returns the key the request was authenticated with, nil when
+// authentication is disabled or the route is public
+func requestAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+// authenticateRequest resolves the caller from a static API key or, when
+// OIDC is configured, from a bearer token issued by the identity provider
+func authenticateRequest(r *http.Request) *APIKey {
+	secret := presentedAPIKey(r)
+	if secret == "" {
+		return nil
+	}
+
+	if apiKeys.Enabled() {
+		if key := apiKeys.Authenticate(secret); key != nil {
+			return key
+		}
+	}
+
+	if oidcVerifier != nil && looksLikeJWT(secret) {
+		principal, err := oidcVerifier.Authenticate(secret)
+		if err == nil {
+			return principal
+		}
+		fmt.Printf("Rejected OIDC token: %v\n", err)
+	}
+
+	return nil
+}
+
+// withAuth requires an API key or OIDC token carrying the given scope.
+// Public routes and deployments without any configured authentication are
+// not checked. The send quota is charged by the outbound sender once a
+// message is accepted.
+func withAuth(scope APIScope, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if scope == ScopePublic || (!apiKeys.Enabled() && oidcVerifier == nil) {
+			handler(w, r)
+			return
+		}
+
+		key := authenticateRequest(r)
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="whatsapp-bridge"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !key.HasScope(scope) {
+			http.Error(w, fmt.Sprintf("Forbidden: API key lacks the %q scope", scope), http.StatusForbidden)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+// requireScope checks a scope beyond the one a route is registered with,
+// for routes whose writes need more than their reads. It answers 403 and
+// returns false when the caller lacks the scope.
+func requireScope(w http.ResponseWriter, r *http.Request, scope APIScope) bool {
+	if !apiKeys.Enabled() && oidcVerifier == nil {
+		return true
+	}
+	if key := requestAPIKey(r); key == nil || !key.HasScope(scope) {
+		http.Error(w, fmt.Sprintf("Forbidden: API key lacks the %q scope", scope), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleAdminUsage serves GET /api/admin/usage?day=<YYYY-MM-DD>, the send
+// usage of every API key, defaulting to today
+func handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = usageDay(time.Now())
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, "Invalid day, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	usage, err := apiKeys.Usage(day)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Failed to read usage: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"day":     day,
+		"keys":    usage,
+	})
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/eventschema"
+)
+
+// Commands that switch bot mode in a chat. Either side can send the off
+// command; only the owner can switch it back on.
+const (
+	botCommandOn  = "!ai on"
+	botCommandOff = "!ai off"
+)
+
+// BotChat is the bot mode setting of one chat
+type BotChat struct {
+	ChatJID   string    `json:"chat_jid"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// HandoffReason is why the bot was last switched off, if it was
+	HandoffReason string `json:"handoff_reason,omitempty"`
+}
+
+// BotRequest is the body posted to the configured responder
+type BotRequest struct {
+	ChatJID string              `json:"chat_jid"`
+	Message MessageEventPayload `json:"message"`
+	// History holds the most recent messages of the chat, oldest first and
+	// including Message
+	History []SummaryMessage `json:"history"`
+}
+
+// BotResponse is the answer expected back from the responder
+type BotResponse struct {
+	// Reply is sent to the chat, nothing is sent when it is empty
+	Reply string `json:"reply"`
+	// Handoff switches the bot off in the chat after the reply is sent
+	Handoff bool `json:"handoff"`
+}
+
+// BotMode answers incoming messages in chats where it is enabled by posting
+// them to an LLM or webhook responder and sending its reply. The owner
+// replying from their phone, or either side sending "!ai off", hands the
+// chat to a human and emits conversation.handoff.
+type BotMode struct {
+	db             *sql.DB
+	client         *whatsmeow.Client
+	messageStore   MessageStoreInterface
+	url            string
+	token          string
+	historyLimit   int
+	handoffMessage string
+	httpClient     *http.Client
+	logger         waLog.Logger
+}
+
+// botMode is the active bot, nil when disabled
+var botMode *BotMode
+
+// NewBotMode creates the bot_chats table in the bridge database. It returns
+// nil when BOT_URL is not set.
+func NewBotMode(db *sql.DB, client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) (*BotMode, error) {
+	url := envString("BOT_URL", "")
+	if url == "" {
+		return nil, nil
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS bot_chats (
+			chat_jid TEXT PRIMARY KEY,
+			enabled BOOLEAN NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NOT NULL,
+			handoff_reason TEXT NOT NULL DEFAULT ''
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot chats table: %v", err)
+	}
+
+	return &BotMode{
+		db:             db,
+		client:         client,
+		messageStore:   messageStore,
+		url:            url,
+		token:          envString("BOT_TOKEN", ""),
+		historyLimit:   envInt("BOT_HISTORY_LIMIT", 20),
+		handoffMessage: envString("BOT_HANDOFF_MESSAGE", ""),
+		httpClient:     &http.Client{Timeout: envDuration("BOT_TIMEOUT", 30*time.Second)},
+		logger:         logger,
+	}, nil
+}
+
+// Enabled reports whether the bot answers in a chat
+func (b *BotMode) Enabled(chatJID string) (bool, error) {
+	var enabled bool
+	err := b.db.QueryRow("SELECT enabled FROM bot_chats WHERE chat_jid = ?", chatJID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// Enable switches the bot on in a chat
+func (b *BotMode) Enable(chatJID string) error {
+	_, err := b.db.Exec(
+		`INSERT INTO bot_chats (chat_jid, enabled, updated_at, handoff_reason) VALUES (?, 1, ?, '')
+		ON CONFLICT(chat_jid) DO UPDATE SET enabled = 1, updated_at = excluded.updated_at, handoff_reason = ''`,
+		chatJID, time.Now().UTC(),
+	)
+	return err
+}
+
+// Handoff switches the bot off in a chat and emits conversation.handoff. It
+// does nothing when the bot was not on.
+func (b *BotMode) Handoff(chatJID, reason, messageID string) error {
+	now := time.Now().UTC()
+	result, err := b.db.Exec(
+		"UPDATE bot_chats SET enabled = 0, updated_at = ?, handoff_reason = ? WHERE chat_jid = ? AND enabled = 1",
+		now, reason, chatJID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	b.logger.Infof("Handed %s to a human (%s)", chatJID, reason)
+
+	// Emitted from a new goroutine as this may run inside an event subscriber
+	go emitEvent(EventHandoff, chatJID, HandoffEventPayload{
+		ChatJID:   chatJID,
+		Reason:    reason,
+		MessageID: messageID,
+		Timestamp: now,
+	})
+	return nil
+}
+
+// List returns the chats with a bot mode setting, most recently changed first
+func (b *BotMode) List() ([]BotChat, error) {
+	rows, err := b.db.Query("SELECT chat_jid, enabled, updated_at, handoff_reason FROM bot_chats ORDER BY updated_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []BotChat{}
+	for rows.Next() {
+		var chat BotChat
+		if err := rows.Scan(&chat.ChatJID, &chat.Enabled, &chat.UpdatedAt, &chat.HandoffReason); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// botCommand normalizes message text for comparison with the bot commands
+func botCommand(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(content), " "))
+}
+
+// HandleEvent implements EventSubscriber. Messages the bridge sends are not
+// echoed back as events, so an outbound message in an enabled chat was typed
+// by the owner on another device and means a human took over.
+func (b *BotMode) HandleEvent(evt Event) {
+	if evt.Type != EventMessageReceived && evt.Type != EventMessageSent {
+		return
+	}
+
+	var payload MessageEventPayload
+	if err := evt.DecodePayload(&payload); err != nil {
+		b.logger.Warnf("Failed to decode event %s for bot mode: %v", evt.ID, err)
+		return
+	}
+
+	switch command := botCommand(payload.Content); {
+	case command == botCommandOn && payload.IsFromMe:
+		if err := b.Enable(payload.ChatJID); err != nil {
+			b.logger.Warnf("Failed to enable bot in %s: %v", payload.ChatJID, err)
+		}
+		return
+
+	case command == botCommandOff:
+		reason := eventschema.HandoffContactRequest
+		if payload.IsFromMe {
+			reason = eventschema.HandoffOwnerCommand
+		}
+		b.handoff(payload.ChatJID, reason, payload.ID, !payload.IsFromMe)
+		return
+	}
+
+	enabled, err := b.Enabled(payload.ChatJID)
+	if err != nil {
+		b.logger.Warnf("Failed to read bot mode of %s: %v", payload.ChatJID, err)
+		return
+	}
+	if !enabled {
+		return
+	}
+
+	if payload.IsFromMe {
+		b.handoff(payload.ChatJID, eventschema.HandoffHumanReply, payload.ID, false)
+		return
+	}
+
+	// Subscribers must not block, the responder may take seconds
+	go b.respond(payload)
+}
+
+// handoff switches the bot off, telling the contact when they asked for a
+// human and BOT_HANDOFF_MESSAGE is set
+func (b *BotMode) handoff(chatJID, reason, messageID string, notify bool) {
+	enabled, err := b.Enabled(chatJID)
+	if err == nil && enabled {
+		err = b.Handoff(chatJID, reason, messageID)
+	}
+	if err != nil {
+		b.logger.Warnf("Failed to hand off %s: %v", chatJID, err)
+		return
+	}
+	if enabled && notify && b.handoffMessage != "" {
+		go b.send(chatJID, b.handoffMessage)
+	}
+}
+
+// respond asks the responder for a reply to an inbound message and sends it
+func (b *BotMode) respond(payload MessageEventPayload) {
+	req := BotRequest{ChatJID: payload.ChatJID, Message: payload, History: []SummaryMessage{}}
+
+	// Messages come back newest first, the responder gets them in order
+	messages, err := b.messageStore.GetMessages(payload.ChatJID, MessageQuery{Limit: b.historyLimit})
+	if err != nil {
+		b.logger.Warnf("Failed to load history of %s for the bot: %v", payload.ChatJID, err)
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		req.History = append(req.History, SummaryMessage{
+			Time:      msg.Time,
+			Sender:    msg.Sender,
+			Content:   msg.Content,
+			IsFromMe:  msg.IsFromMe,
+			MediaType: msg.MediaType,
+		})
+	}
+
+	resp, err := b.requestReply(req)
+	if err != nil {
+		b.logger.Warnf("Bot responder failed for %s: %v", payload.ChatJID, err)
+		return
+	}
+
+	// A human may have taken over while the responder was thinking
+	if enabled, err := b.Enabled(payload.ChatJID); err != nil || !enabled {
+		return
+	}
+
+	if resp.Reply != "" {
+		b.send(payload.ChatJID, resp.Reply)
+	}
+	if resp.Handoff {
+		if err := b.Handoff(payload.ChatJID, eventschema.HandoffResponder, payload.ID); err != nil {
+			b.logger.Warnf("Failed to hand off %s: %v", payload.ChatJID, err)
+		}
+	}
+}
+
+// requestReply posts the message to the responder
+func (b *BotMode) requestReply(req BotRequest) (*BotResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", b.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if b.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("responder error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var result BotResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return &result, nil
+}
+
+// send sends a bot message to a chat through the checks of /api/send, so
+// it may be held for quiet hours or approval like any other message
+func (b *BotMode) send(chatJID, text string) {
+	outbound, validationErrors := composeMessage(b.client, SendMessageRequest{Recipient: chatJID, Message: text})
+	if len(validationErrors) > 0 {
+		b.logger.Warnf("Failed to send bot reply to %s: %s", chatJID, validationErrors[0].Message)
+		return
+	}
+	if _, response := outboundSender.Dispatch(outbound, SendOptions{}); !response.Success {
+		b.logger.Warnf("Failed to send bot reply to %s: %s", chatJID, response.Message)
+	}
+}
+
+// BotChatRequest represents the request body for switching bot mode
+type BotChatRequest struct {
+	ChatJID string `json:"chat_jid"`
+	Enabled bool   `json:"enabled"`
+}
+
+// BotChatsResponse represents the response for the bot mode API
+type BotChatsResponse struct {
+	Success bool      `json:"success"`
+	Message string    `json:"message,omitempty"`
+	Chats   []BotChat `json:"chats"`
+}
+
+// handleBotChats serves GET /api/bot/chats, listing bot mode per chat, and
+// POST /api/bot/chats {"chat_jid","enabled"} to switch it
+func handleBotChats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if botMode == nil {
+		http.Error(w, "Bot mode is not configured (set BOT_URL)", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req BotChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if req.Enabled {
+			err = botMode.Enable(req.ChatJID)
+		} else {
+			err = botMode.Handoff(req.ChatJID, eventschema.HandoffOwnerCommand, "")
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(BotChatsResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to update bot mode: %v", err),
+				Chats:   []BotChat{},
+			})
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	chats, err := botMode.List()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(BotChatsResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to list bot chats: %v", err),
+			Chats:   []BotChat{},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(BotChatsResponse{Success: true, Chats: chats})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// bridgeDB holds bridge-internal state (event journal, consumer cursors and
+// similar) independently of the selected message store backend
+var bridgeDB *sql.DB
+
+// openBridgeDB opens the bridge state database in the store directory
+func openBridgeDB() (*sql.DB, error) {
+	if err := os.MkdirAll("store", 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:store/bridge.db?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bridge database: %v", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open bridge database: %v", err)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a string map bounded in size and entry age. Once full, the
+// least recently used entry is evicted; entries older than the TTL are
+// dropped when read. It is safe for concurrent use.
+type lruCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// lruEntry is the value of an lruCache list element
+type lruEntry struct {
+	key      string
+	value    string
+	storedAt time.Time
+}
+
+// newLRUCache creates a cache of at most maxEntries entries, each kept for
+// ttl. A zero ttl keeps entries until they are evicted.
+func newLRUCache(maxEntries int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxEntries: max(maxEntries, 1),
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of a key and marks it as recently used
+func (c *lruCache) Get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*lruEntry)
+	if c.ttl > 0 && time.Since(entry.storedAt) > c.ttl {
+		c.remove(element)
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set stores a value, evicting the least recently used entry when full
+func (c *lruCache) Set(key, value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.storedAt = value, time.Now()
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, storedAt: time.Now()})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes a key
+func (c *lruCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Len returns the number of entries, including expired ones not yet read
+func (c *lruCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// remove drops an element; the caller holds the mutex
+func (c *lruCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
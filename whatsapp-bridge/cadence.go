@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// SendingCadence spaces out messages sent to the same recipient in quick
+// succession the way a person would type them: before each follow-up the
+// recipient sees the typing indicator for a while proportional to the
+// message length, with some randomness so sequences do not look scripted.
+type SendingCadence struct {
+	// charsPerSecond is the simulated typing speed
+	charsPerSecond int
+	minTyping      time.Duration
+	maxTyping      time.Duration
+	// window is how long after a send the next one to the same recipient
+	// counts as part of a sequence
+	window time.Duration
+	logger waLog.Logger
+
+	mutex sync.Mutex
+	// recipients holds one entry per recipient with a recent send
+	recipients map[types.JID]*cadenceRecipient
+}
+
+// cadenceRecipient serializes the sends to one recipient
+type cadenceRecipient struct {
+	mutex sync.Mutex
+	// users and lastSend are guarded by the cadence mutex; users counts the
+	// sends holding or waiting for the recipient's turn
+	users    int
+	lastSend time.Time
+}
+
+// sendingCadence is the active cadence, nil when disabled
+var sendingCadence *SendingCadence
+
+// NewSendingCadence returns the cadence configured by the HUMAN_CADENCE
+// variables, or nil when HUMAN_CADENCE is false
+func NewSendingCadence(logger waLog.Logger) *SendingCadence {
+	if !envBool("HUMAN_CADENCE", false) {
+		return nil
+	}
+
+	c := &SendingCadence{
+		charsPerSecond: max(envInt("HUMAN_CADENCE_CHARS_PER_SECOND", 12), 1),
+		minTyping:      envDuration("HUMAN_CADENCE_MIN_TYPING", time.Second),
+		maxTyping:      envDuration("HUMAN_CADENCE_MAX_TYPING", 10*time.Second),
+		window:         envDuration("HUMAN_CADENCE_WINDOW", 2*time.Minute),
+		logger:         logger,
+		recipients:     make(map[types.JID]*cadenceRecipient),
+	}
+	if c.maxTyping < c.minTyping {
+		c.maxTyping = c.minTyping
+	}
+	return c
+}
+
+// typingDuration is how long typing the message takes at the configured
+// speed, varied by up to a third either way and kept within the bounds
+func (c *SendingCadence) typingDuration(message string) time.Duration {
+	typing := time.Duration(utf8.RuneCountInString(message)) * time.Second / time.Duration(c.charsPerSecond)
+	typing = typing*2/3 + time.Duration(rand.Int63n(int64(typing*2/3)+1))
+	if typing < c.minTyping {
+		typing = c.minTyping
+	}
+	if typing > c.maxTyping {
+		typing = c.maxTyping
+	}
+	return typing
+}
+
+// Begin waits until the message may be sent to the recipient, showing the
+// typing indicator when it follows an earlier message of a sequence. It
+// holds the recipient's turn until the returned function is called after
+// the send, so concurrent sends to one recipient go out one by one.
+func (c *SendingCadence) Begin(client *whatsmeow.Client, recipient types.JID, message string) func() {
+	if c == nil {
+		return func() {}
+	}
+
+	recipient = recipient.ToNonAD()
+	c.mutex.Lock()
+	entry, ok := c.recipients[recipient]
+	if !ok {
+		entry = &cadenceRecipient{}
+		c.recipients[recipient] = entry
+	}
+	entry.users++
+	c.mutex.Unlock()
+
+	entry.mutex.Lock()
+	c.mutex.Lock()
+	lastSend := entry.lastSend
+	c.mutex.Unlock()
+	if !lastSend.IsZero() && time.Since(lastSend) < c.window {
+		c.showTyping(client, recipient, c.typingDuration(message))
+	}
+
+	return func() {
+		c.mutex.Lock()
+		entry.lastSend = time.Now()
+		entry.users--
+		c.forgetIdle()
+		c.mutex.Unlock()
+		entry.mutex.Unlock()
+	}
+}
+
+// showTyping shows the typing indicator to the recipient for a while
+func (c *SendingCadence) showTyping(client *whatsmeow.Client, recipient types.JID, typing time.Duration) {
+	ctx := context.Background()
+	if err := client.SendChatPresence(ctx, recipient, types.ChatPresenceComposing, types.ChatPresenceMediaText); err != nil {
+		c.logger.Debugf("Failed to send typing indicator to %s: %v", recipient, err)
+	}
+	time.Sleep(typing)
+	if err := client.SendChatPresence(ctx, recipient, types.ChatPresencePaused, types.ChatPresenceMediaText); err != nil {
+		c.logger.Debugf("Failed to clear typing indicator for %s: %v", recipient, err)
+	}
+}
+
+// forgetIdle drops recipients whose sequence has ended. The caller holds
+// the mutex.
+func (c *SendingCadence) forgetIdle() {
+	for recipient, entry := range c.recipients {
+		if entry.users == 0 && time.Since(entry.lastSend) >= c.window {
+			delete(c.recipients, recipient)
+		}
+	}
+}
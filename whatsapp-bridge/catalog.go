@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/eventschema"
+)
+
+// ProductInfo is the structured catalog data of a product, order or product
+// inquiry message
+type ProductInfo = eventschema.ProductPayload
+
+// ProductStore is implemented by message stores that can keep a message's
+// catalog data alongside it
+type ProductStore interface {
+	StoreProductInfo(id, chatJID string, product *ProductInfo) error
+}
+
+// StoreProductInfo saves catalog data as JSON on the message row
+func (store *MessageStore) StoreProductInfo(id, chatJID string, product *ProductInfo) error {
+	encoded, err := json.Marshal(product)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec("UPDATE messages SET product = ? WHERE id = ? AND chat_jid = ?", string(encoded), id, chatJID)
+	return err
+}
+
+// StoreProductInfo saves catalog data under "product" in the message metadata
+func (s *SupabaseMessageStore) StoreProductInfo(id, chatJID string, product *ProductInfo) error {
+	return s.mergeMetadata(id, chatJID, map[string]interface{}{"product": product})
+}
+
+// productSnapshotInfo converts a product snapshot to its structured form
+func productSnapshotInfo(kind string, msg *waProto.ProductMessage) *ProductInfo {
+	product := msg.GetProduct()
+	return &ProductInfo{
+		Kind:                kind,
+		ProductID:           product.GetProductID(),
+		Title:               product.GetTitle(),
+		Description:         product.GetDescription(),
+		CurrencyCode:        product.GetCurrencyCode(),
+		PriceAmount1000:     product.GetPriceAmount1000(),
+		SalePriceAmount1000: product.GetSalePriceAmount1000(),
+		RetailerID:          product.GetRetailerID(),
+		URL:                 product.GetURL(),
+		BusinessOwnerJID:    msg.GetBusinessOwnerJID(),
+		CatalogTitle:        msg.GetCatalog().GetTitle(),
+	}
+}
+
+// extractProductInfo returns the catalog data of a message: a shared
+// product, an order, or a message quoting a product, which is how customers
+// ask about an item. It returns nil for other messages.
+func extractProductInfo(msg *waProto.Message) *ProductInfo {
+	if msg == nil {
+		return nil
+	}
+
+	if product := msg.GetProductMessage(); product != nil {
+		return productSnapshotInfo(eventschema.ProductKindProduct, product)
+	}
+
+	if order := msg.GetOrderMessage(); order != nil {
+		return &ProductInfo{
+			Kind:              eventschema.ProductKindOrder,
+			OrderID:           order.GetOrderID(),
+			OrderTitle:        order.GetOrderTitle(),
+			ItemCount:         order.GetItemCount(),
+			TotalAmount1000:   order.GetTotalAmount1000(),
+			TotalCurrencyCode: order.GetTotalCurrencyCode(),
+			BusinessOwnerJID:  order.GetSellerJID(),
+		}
+	}
+
+	if quoted := messageContextInfo(msg).GetQuotedMessage().GetProductMessage(); quoted != nil {
+		return productSnapshotInfo(eventschema.ProductKindInquiry, quoted)
+	}
+
+	return nil
+}
+
+// productText is the content stored for product and order messages, which
+// carry no regular text
+func productText(msg *waProto.Message) string {
+	if product := msg.GetProductMessage(); product != nil {
+		if body := product.GetBody(); body != "" {
+			return body
+		}
+		return product.GetProduct().GetTitle()
+	}
+	if order := msg.GetOrderMessage(); order != nil {
+		if text := order.GetMessage(); text != "" {
+			return text
+		}
+		return order.GetOrderTitle()
+	}
+	return ""
+}
+
+// ProductMessageRequest represents the request body for sending a catalog
+// product. WhatsApp renders the product from the snapshot sent with it, so
+// everything but product_id is optional but should match the catalog.
+type ProductMessageRequest struct {
+	Recipient string `json:"recipient"`
+	ProductID string `json:"product_id"`
+	// BusinessOwnerJID owns the catalog, the logged in account by default
+	BusinessOwnerJID string `json:"business_owner_jid,omitempty"`
+	Title            string `json:"title,omitempty"`
+	Description      string `json:"description,omitempty"`
+	CurrencyCode     string `json:"currency_code,omitempty"`
+	PriceAmount1000  int64  `json:"price_amount_1000,omitempty"`
+	RetailerID       string `json:"retailer_id,omitempty"`
+	URL              string `json:"url,omitempty"`
+	// ImagePath is a local image uploaded as the product image
+	ImagePath    string `json:"image_path,omitempty"`
+	CatalogTitle string `json:"catalog_title,omitempty"`
+	Body         string `json:"body,omitempty"`
+	Footer       string `json:"footer,omitempty"`
+	// IdempotencyKey and Urgent work as they do for /api/send
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Urgent         bool   `json:"urgent,omitempty"`
+}
+
+// buildProductMessage builds the product message for a request, uploading
+// the product image if one is given. On failure it returns a nil message,
+// the error and one of the SendFailure reasons.
+func buildProductMessage(client *whatsmeow.Client, req ProductMessageRequest) (*waProto.Message, string, string) {
+	owner := req.BusinessOwnerJID
+	if owner == "" {
+		if client.Store.ID == nil {
+			return nil, "Not logged in", SendFailureNotConnected
+		}
+		owner = client.Store.ID.ToNonAD().String()
+	}
+
+	snapshot := &waProto.ProductMessage_ProductSnapshot{ProductID: proto.String(req.ProductID)}
+	if req.Title != "" {
+		snapshot.Title = proto.String(req.Title)
+	}
+	if req.Description != "" {
+		snapshot.Description = proto.String(req.Description)
+	}
+	if req.CurrencyCode != "" {
+		snapshot.CurrencyCode = proto.String(req.CurrencyCode)
+		snapshot.PriceAmount1000 = proto.Int64(req.PriceAmount1000)
+	}
+	if req.RetailerID != "" {
+		snapshot.RetailerID = proto.String(req.RetailerID)
+	}
+	if req.URL != "" {
+		snapshot.URL = proto.String(req.URL)
+	}
+
+	if req.ImagePath != "" {
+		image, err := os.Open(req.ImagePath)
+		if err != nil {
+			return nil, fmt.Sprintf("Failed to read product image: %v", err), SendFailureMediaUnreadable
+		}
+		defer image.Close()
+
+		resp, err := client.UploadReader(context.Background(), image, nil, whatsmeow.MediaImage)
+		if err != nil {
+			return nil, fmt.Sprintf("Failed to upload product image: %v", err), SendFailureMediaUpload
+		}
+
+		mimeType := "image/jpeg"
+		if strings.HasSuffix(strings.ToLower(req.ImagePath), ".png") {
+			mimeType = "image/png"
+		}
+		snapshot.ProductImage = &waProto.ImageMessage{
+			Mimetype:      proto.String(mimeType),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+		}
+		snapshot.ProductImageCount = proto.Uint32(1)
+	}
+
+	product := &waProto.ProductMessage{
+		Product:          snapshot,
+		BusinessOwnerJID: proto.String(owner),
+	}
+	if req.CatalogTitle != "" {
+		product.Catalog = &waProto.ProductMessage_CatalogSnapshot{Title: proto.String(req.CatalogTitle)}
+	}
+	if req.Body != "" {
+		product.Body = proto.String(req.Body)
+	}
+	if req.Footer != "" {
+		product.Footer = proto.String(req.Footer)
+	}
+
+	return &waProto.Message{ProductMessage: product}, "", ""
+}
+
+// handleSendProduct serves POST /api/send/product, sending a catalog item
+func handleSendProduct(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ProductMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		// The composer checks the recipient, body length and image. A product
+		// needs no text, its ID stands in when there is no body.
+		text := req.Body
+		if text == "" {
+			text = req.ProductID
+		}
+		outbound, validationErrors := composeMessage(client, SendMessageRequest{Recipient: req.Recipient, Message: text, MediaPath: req.ImagePath})
+		if req.ProductID == "" {
+			validationErrors = append(validationErrors, ValidationError{Field: "product_id", Code: ValidationRequired, Message: "Product ID is required"})
+		}
+		if req.BusinessOwnerJID != "" {
+			if _, err := types.ParseJID(req.BusinessOwnerJID); err != nil {
+				validationErrors = append(validationErrors, ValidationError{Field: "business_owner_jid", Code: ValidationInvalidJID, Message: fmt.Sprintf("Invalid JID: %v", err)})
+			}
+		}
+		if len(validationErrors) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: validationErrors[0].Message,
+				Errors:  validationErrors,
+			})
+			return
+		}
+
+		// The content policy sees all the text the product shows
+		outbound.Body = strings.Join(nonEmpty(req.Body, req.Title, req.Description), "\n")
+		req.ImagePath = outbound.MediaPath
+		outbound.Product = &req
+
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		}
+
+		status, response := outboundSender.Dispatch(outbound, SendOptions{
+			IdempotencyKey: req.IdempotencyKey,
+			Urgent:         req.Urgent,
+			Key:            requestAPIKey(r),
+		})
+		if response.Success && status == http.StatusOK && response.Status == "" {
+			response.Message = fmt.Sprintf("Product %s sent to %s", req.ProductID, outbound.Recipient)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// nonEmpty returns the strings that are not empty
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// maxImportRows bounds the conversations in one import request
+const maxImportRows = 10000
+
+// ChatImporter is implemented by message stores that can create a
+// conversation before any message of it arrives
+type ChatImporter interface {
+	// ImportChat creates the chat unless it exists and sets its name when
+	// one is given. It reports whether the chat was created.
+	ImportChat(jid, name string) (bool, error)
+}
+
+// ImportChat creates a chat without touching the last message time of an
+// existing one
+func (store *MessageStore) ImportChat(jid, name string) (bool, error) {
+	result, err := store.db.Exec("INSERT OR IGNORE INTO chats (jid, name) VALUES (?, ?)", jid, name)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		emitEvent(EventConversationCreated, jid, ConversationEventPayload{ChatJID: jid, Name: name})
+		return true, nil
+	}
+	if name != "" {
+		_, err = store.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", name, jid)
+	}
+	return false, err
+}
+
+// ImportChat creates a Supabase conversation unless one exists and sets
+// its contact name when one is given
+func (s *SupabaseMessageStore) ImportChat(jid, name string) (bool, error) {
+	conversationID, err := s.client.FindConversation(jid)
+	if err != nil {
+		return false, err
+	}
+	if conversationID != "" {
+		s.cacheConversationID(jid, conversationID)
+		if name != "" {
+			return false, s.client.UpdateConversationName(jid, name)
+		}
+		return false, nil
+	}
+
+	conversationID, err = s.client.GetOrCreateConversation(jid, name)
+	if err != nil {
+		return false, err
+	}
+	s.cacheConversationID(jid, conversationID)
+	return true, nil
+}
+
+// ImportedChat is one conversation of an import. JID may also be given as a
+// phone number.
+type ImportedChat struct {
+	JID      string   `json:"jid"`
+	Name     string   `json:"name,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Assignee string   `json:"assignee,omitempty"`
+}
+
+// ChatImportRequest represents the JSON request body of a chat import
+type ChatImportRequest struct {
+	Conversations []ImportedChat `json:"conversations"`
+}
+
+// ChatImportError describes a row that could not be imported. Rows are
+// numbered from 1, not counting a CSV header.
+type ChatImportError struct {
+	Row     int    `json:"row"`
+	JID     string `json:"jid,omitempty"`
+	Message string `json:"message"`
+}
+
+// ChatImportResponse represents the response for the chat import API
+type ChatImportResponse struct {
+	Success  bool              `json:"success"`
+	Message  string            `json:"message,omitempty"`
+	Imported int               `json:"imported"`
+	Created  int               `json:"created"`
+	Errors   []ChatImportError `json:"errors,omitempty"`
+}
+
+// parseImportCSV reads conversations from CSV with a header row naming the
+// jid, name, tags and assignee columns in any order. Tags are separated
+// by ";" or "|".
+func parseImportCSV(r io.Reader) ([]ImportedChat, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["jid"]; !ok {
+		if _, ok := columns["phone"]; !ok {
+			return nil, fmt.Errorf("CSV header needs a jid or phone column")
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var chats []ImportedChat
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return chats, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+
+		chat := ImportedChat{
+			JID:      field(record, "jid"),
+			Name:     field(record, "name"),
+			Assignee: field(record, "assignee"),
+		}
+		if chat.JID == "" {
+			chat.JID = field(record, "phone")
+		}
+		for _, tag := range strings.FieldsFunc(field(record, "tags"), func(r rune) bool { return r == ';' || r == '|' }) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				chat.Tags = append(chat.Tags, tag)
+			}
+		}
+		chats = append(chats, chat)
+	}
+}
+
+// importJID resolves the JID or phone number of an imported conversation
+func importJID(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("JID is required")
+	}
+
+	if strings.Contains(value, "@") {
+		jid, err := types.ParseJID(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid JID: %v", err)
+		}
+		return jid.ToNonAD().String(), validateJID(jid.ToNonAD().String())
+	}
+
+	phone := normalizePhone(value)
+	if !isE164Digits(phone) {
+		return "", fmt.Errorf("invalid phone number %q", value)
+	}
+	return types.NewJID(phone, types.DefaultUserServer).String(), nil
+}
+
+// handleChatImport serves POST /api/chats/import, creating conversations in
+// bulk from JSON or CSV (Content-Type text/csv) so CRM data exists before
+// the first message arrives. Tags and assignees go to the inbox. Rows that
+// fail are reported without stopping the import.
+func handleChatImport(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		store, ok := messageStore.(ChatImporter)
+		if !ok {
+			http.Error(w, "The message store does not support imports", http.StatusServiceUnavailable)
+			return
+		}
+
+		var chats []ImportedChat
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "text/csv" {
+			var err error
+			if chats, err = parseImportCSV(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			var req ChatImportRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			chats = req.Conversations
+		}
+
+		if len(chats) == 0 {
+			http.Error(w, "No conversations to import", http.StatusBadRequest)
+			return
+		}
+		if len(chats) > maxImportRows {
+			http.Error(w, fmt.Sprintf("At most %d conversations can be imported at once", maxImportRows), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		resp := ChatImportResponse{Success: true}
+		for i, chat := range chats {
+			fail := func(format string, args ...interface{}) {
+				resp.Errors = append(resp.Errors, ChatImportError{Row: i + 1, JID: chat.JID, Message: fmt.Sprintf(format, args...)})
+			}
+
+			jid, err := importJID(chat.JID)
+			if err != nil {
+				fail("%v", err)
+				continue
+			}
+
+			created, err := store.ImportChat(jid, chat.Name)
+			if err != nil {
+				fail("Failed to create conversation: %v", err)
+				continue
+			}
+			if created {
+				resp.Created++
+			}
+
+			if inboxProjection != nil {
+				if chat.Name != "" {
+					err = inboxProjection.update(jid, "name", chat.Name)
+				}
+				if err == nil && chat.Tags != nil {
+					err = inboxProjection.SetTags(jid, chat.Tags)
+				}
+				if err == nil && chat.Assignee != "" {
+					err = inboxProjection.Assign(jid, chat.Assignee)
+				}
+				if err != nil {
+					fail("Failed to update inbox: %v", err)
+					continue
+				}
+			}
+			resp.Imported++
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
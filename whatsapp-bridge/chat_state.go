@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ChatStateChange is a change of a chat's archive, pin or mute state made
+// on another device. Unset fields are left alone.
+type ChatStateChange struct {
+	Archived *bool
+	Pinned   *bool
+	Muted    *bool
+	// MutedUntil is when a mute ends, nil for a mute without end
+	MutedUntil *time.Time
+}
+
+// ChatStateStore is implemented by message stores that mirror the archive,
+// pin and mute state of chats
+type ChatStateStore interface {
+	SetChatState(jid string, change ChatStateChange) error
+}
+
+// SetChatState updates the archived, pinned and muted columns of a chat
+func (store *MessageStore) SetChatState(jid string, change ChatStateChange) error {
+	if change.Archived != nil {
+		if _, err := store.db.Exec("UPDATE chats SET archived = ? WHERE jid = ?", *change.Archived, jid); err != nil {
+			return err
+		}
+	}
+	if change.Pinned != nil {
+		if _, err := store.db.Exec("UPDATE chats SET pinned = ? WHERE jid = ?", *change.Pinned, jid); err != nil {
+			return err
+		}
+	}
+	if change.Muted != nil {
+		if _, err := store.db.Exec("UPDATE chats SET muted = ?, muted_until = ? WHERE jid = ?", *change.Muted, change.MutedUntil, jid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Conversation statuses the chat state is mirrored to
+const (
+	ConversationActive   = "active"
+	ConversationArchived = "archived"
+)
+
+// SetChatState mirrors archiving to the status of a Supabase conversation
+// and pins and mutes to its is_pinned, is_muted and muted_until columns,
+// created with e.g.
+//
+//	ALTER TABLE conversations ADD COLUMN is_pinned boolean NOT NULL DEFAULT false,
+//		ADD COLUMN is_muted boolean NOT NULL DEFAULT false, ADD COLUMN muted_until timestamptz;
+//
+// Unarchiving only reactivates conversations that are archived, so a status
+// set by the inbox, such as closed, is kept.
+func (s *SupabaseMessageStore) SetChatState(jid string, change ChatStateChange) error {
+	if err := validateJID(jid); err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("contact_identifier=eq.%s&channel=eq.whatsapp", pgValue(jid))
+
+	if change.Archived != nil {
+		status, statusFilter := ConversationArchived, filter
+		if !*change.Archived {
+			status, statusFilter = ConversationActive, filter+"&status=eq."+ConversationArchived
+		}
+		err := s.client.patchConversation(statusFilter, func(map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"status": status}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update conversation status: %v", err)
+		}
+	}
+
+	update := make(map[string]interface{})
+	if change.Pinned != nil {
+		update["is_pinned"] = *change.Pinned
+	}
+	if change.Muted != nil {
+		update["is_muted"] = *change.Muted
+		update["muted_until"] = nil
+		if change.MutedUntil != nil {
+			update["muted_until"] = change.MutedUntil.UTC().Format(time.RFC3339)
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	err := s.client.patchConversation(filter, func(map[string]interface{}) map[string]interface{} {
+		return update
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update conversation flags: %v", err)
+	}
+	return nil
+}
+
+// handleChatState mirrors archive, pin and mute changes made on the phone
+// or another device to the store, when it supports them
+func handleChatState(messageStore MessageStoreInterface, evt interface{}, logger waLog.Logger) {
+	store, ok := messageStore.(ChatStateStore)
+	if !ok {
+		return
+	}
+
+	var jid string
+	var change ChatStateChange
+	switch v := evt.(type) {
+	case *events.Archive:
+		archived := v.Action.GetArchived()
+		jid, change.Archived = v.JID.String(), &archived
+	case *events.Pin:
+		pinned := v.Action.GetPinned()
+		jid, change.Pinned = v.JID.String(), &pinned
+	case *events.Mute:
+		muted := v.Action.GetMuted()
+		jid, change.Muted = v.JID.String(), &muted
+		// The end is in milliseconds, -1 when the mute has none
+		if end := v.Action.GetMuteEndTimestamp(); muted && end > 0 {
+			mutedUntil := time.UnixMilli(end)
+			change.MutedUntil = &mutedUntil
+		}
+	default:
+		return
+	}
+
+	if err := store.SetChatState(jid, change); err != nil {
+		logger.Warnf("Failed to store chat state of %s: %v", jid, err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Validation error codes returned by the composer
+const (
+	ValidationRequired      = "required"
+	ValidationInvalidJID    = "invalid_jid"
+	ValidationInvalidPhone  = "invalid_phone"
+	ValidationNotOnWhatsApp = "not_on_whatsapp"
+	ValidationTooLong       = "too_long"
+	ValidationMediaNotFound = "media_not_found"
+	ValidationTooLarge      = "too_large"
+	ValidationTemplate      = "invalid_template"
+	// ValidationMediaNotAllowed marks media paths outside MEDIA_UPLOAD_DIR
+	ValidationMediaNotAllowed = "media_not_allowed"
+)
+
+// ValidationError describes one problem with an outbound message
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// OutboundMessage is a send request that passed validation
+type OutboundMessage struct {
+	Recipient types.JID
+	// Phone is the recipient in E.164 form, empty for groups and LIDs
+	Phone     string
+	Body      string
+	MediaPath string
+	// RowID is the Supabase messages row a Realtime send came from, which
+	// stands in for the stored message
+	RowID string
+	// Product is sent instead of Body and MediaPath for catalog sends;
+	// Body then only holds the product's text for the content policy
+	Product *ProductMessageRequest
+}
+
+// onWhatsAppEntry is a cached registration check
+type onWhatsAppEntry struct {
+	jid       types.JID
+	isIn      bool
+	checkedAt time.Time
+}
+
+var (
+	onWhatsAppCache = make(map[string]onWhatsAppEntry)
+	onWhatsAppMutex sync.Mutex
+)
+
+// composeMessage validates a send request and resolves its recipient. All
+// problems are reported at once so a client can fix them in one round trip.
+func composeMessage(client *whatsmeow.Client, req SendMessageRequest) (*OutboundMessage, []ValidationError) {
+	var errs []ValidationError
+	invalid := func(field, code, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	out := &OutboundMessage{Body: req.Message, MediaPath: req.MediaPath}
+
+	// Recipient: a JID on a server we can send to, or a phone number
+	recipient := strings.TrimSpace(req.Recipient)
+	switch {
+	case recipient == "":
+		invalid("recipient", ValidationRequired, "Recipient is required")
+
+	case strings.Contains(recipient, "@"):
+		jid, err := types.ParseJID(recipient)
+		if err != nil {
+			invalid("recipient", ValidationInvalidJID, "Invalid JID: %v", err)
+			break
+		}
+		switch jid.Server {
+		case types.DefaultUserServer:
+			if !isE164Digits(jid.User) {
+				invalid("recipient", ValidationInvalidJID, "JID user part must be a phone number with 8 to 15 digits")
+				break
+			}
+			out.Phone = "+" + jid.User
+		case types.GroupServer, types.HiddenUserServer:
+		default:
+			invalid("recipient", ValidationInvalidJID, "Cannot send to JIDs on server %q", jid.Server)
+		}
+		out.Recipient = jid.ToNonAD()
+
+	default:
+		digits := normalizePhone(recipient)
+		if !isE164Digits(digits) {
+			invalid("recipient", ValidationInvalidPhone, "Phone number must have 8 to 15 digits including the country code")
+			break
+		}
+		out.Phone = "+" + digits
+		out.Recipient = types.NewJID(digits, types.DefaultUserServer)
+	}
+
+	// Canned responses are rendered in the recipient's language
+	if req.Template != "" && !out.Recipient.IsEmpty() {
+		text, err := renderForChat(req.Template, out.Recipient.String(), req.Language, req.Variables)
+		if err != nil {
+			invalid("template", ValidationTemplate, "%v", err)
+		}
+		req.Message, out.Body = text, text
+	}
+
+	// Body and media
+	if req.Message == "" && req.MediaPath == "" && req.Template == "" {
+		invalid("message", ValidationRequired, "Message or media path is required")
+	}
+
+	maxBody := envInt("MAX_MESSAGE_LENGTH", 65536)
+	if req.MediaPath != "" {
+		maxBody = envInt("MAX_CAPTION_LENGTH", 1024)
+	}
+	if length := utf8.RuneCountInString(req.Message); length > maxBody {
+		invalid("message", ValidationTooLong, "Message is %d characters, longer than the %d character limit", length, maxBody)
+	}
+
+	if req.MediaPath != "" {
+		path, allowed := resolveUploadPath(req.MediaPath)
+		info, err := os.Stat(path)
+		switch {
+		case !allowed:
+			invalid("media_path", ValidationMediaNotAllowed, "Media file %s is outside the upload directory", req.MediaPath)
+		case err != nil || info.IsDir():
+			invalid("media_path", ValidationMediaNotFound, "Media file not found: %s", req.MediaPath)
+		case info.Size() > mediaMaxUploadBytes():
+			invalid("media_path", ValidationTooLarge, "Media file is %d bytes, larger than the %d byte limit", info.Size(), mediaMaxUploadBytes())
+		}
+		out.MediaPath = path
+	}
+
+	// Only ask WhatsApp about numbers once everything else is valid
+	if len(errs) == 0 && out.Phone != "" && envBool("CHECK_ON_WHATSAPP", true) {
+		if jid, isIn, ok := checkOnWhatsApp(client, out.Phone); ok {
+			if !isIn {
+				invalid("recipient", ValidationNotOnWhatsApp, "%s is not registered on WhatsApp", out.Phone)
+			} else if !jid.IsEmpty() {
+				out.Recipient = jid
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// resolveUploadPath resolves a media path within MEDIA_UPLOAD_DIR, where
+// relative paths start. It reports false for paths that leave the
+// directory, also through a symlink. Without MEDIA_UPLOAD_DIR any path on
+// the bridge host is allowed.
+func resolveUploadPath(path string) (string, bool) {
+	dir := envString("MEDIA_UPLOAD_DIR", "")
+	if dir == "" {
+		return path, true
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return path, false
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path, false
+	}
+	return path, true
+}
+
+// isE164Digits reports whether digits is a plausible E.164 number without
+// the leading plus
+func isE164Digits(digits string) bool {
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkOnWhatsApp looks up whether a phone number is registered, caching
+// the answer for CHECK_ON_WHATSAPP_TTL. The last result is false when the
+// lookup itself failed, in which case the send is allowed to proceed.
+func checkOnWhatsApp(client *whatsmeow.Client, phone string) (types.JID, bool, bool) {
+	ttl := envDuration("CHECK_ON_WHATSAPP_TTL", 24*time.Hour)
+
+	onWhatsAppMutex.Lock()
+	entry, cached := onWhatsAppCache[phone]
+	onWhatsAppMutex.Unlock()
+	if cached && time.Since(entry.checkedAt) < ttl {
+		return entry.jid, entry.isIn, true
+	}
+
+	if !client.IsConnected() {
+		return types.JID{}, false, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results, err := client.IsOnWhatsApp(ctx, []string{phone})
+	if err != nil || len(results) == 0 {
+		return types.JID{}, false, false
+	}
+
+	entry = onWhatsAppEntry{jid: results[0].JID, isIn: results[0].IsIn, checkedAt: time.Now()}
+	onWhatsAppMutex.Lock()
+	onWhatsAppCache[phone] = entry
+	onWhatsAppMutex.Unlock()
+
+	return entry.jid, entry.isIn, true
+}
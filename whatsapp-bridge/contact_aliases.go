@@ -0,0 +1,504 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// PersonLinker is implemented by message stores that can record which
+// person a chat belongs to
+type PersonLinker interface {
+	LinkPerson(jid, personID string) error
+}
+
+// ChatMerger is implemented by message stores that can move the history of
+// one chat into another
+type ChatMerger interface {
+	MergeChats(fromJID, intoJID string) error
+}
+
+// LinkPerson sets the person ID of a chat; an empty ID unlinks it
+func (store *MessageStore) LinkPerson(jid, personID string) error {
+	var value interface{}
+	if personID != "" {
+		value = personID
+	}
+	_, err := store.db.Exec("UPDATE chats SET person_id = ? WHERE jid = ?", value, jid)
+	return err
+}
+
+// MergeChats moves every message of one chat into another and removes the
+// emptied chat. Messages already present in the target are dropped. While
+// soft deletes are enabled both are trashed instead.
+func (store *MessageStore) MergeChats(fromJID, intoJID string) error {
+	dropMessages, dropChat := "DELETE FROM messages WHERE chat_jid = ?", "DELETE FROM chats WHERE jid = ?"
+	dropArgs := []interface{}{fromJID}
+	if softDeletes != nil {
+		dropMessages = "UPDATE messages SET trashed_at = ? WHERE chat_jid = ? AND trashed_at IS NULL"
+		dropChat = "UPDATE chats SET trashed_at = ? WHERE jid = ?"
+		dropArgs = []interface{}{time.Now().UTC(), fromJID}
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// The target chat must exist for the messages' foreign key
+		{`INSERT OR IGNORE INTO chats (jid, name, last_message_time, person_id)
+			SELECT ?, name, last_message_time, person_id FROM chats WHERE jid = ?`, []interface{}{intoJID, fromJID}},
+		{"UPDATE OR IGNORE messages SET chat_jid = ? WHERE chat_jid = ?", []interface{}{intoJID, fromJID}},
+		{dropMessages, dropArgs},
+		{`UPDATE chats SET last_message_time = (SELECT MAX(timestamp) FROM messages WHERE chat_jid = ?)
+			WHERE jid = ? AND EXISTS (SELECT 1 FROM messages WHERE chat_jid = ?)`, []interface{}{intoJID, intoJID, intoJID}},
+		{dropChat, dropArgs},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LinkPerson sets the person_id of a conversation in Supabase
+func (s *SupabaseMessageStore) LinkPerson(jid, personID string) error {
+	var value interface{}
+	if personID != "" {
+		value = personID
+	}
+	if err := validateJID(jid); err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("contact_identifier=eq.%s&channel=eq.whatsapp", pgValue(jid))
+	return s.client.patchConversation(filter, func(map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"person_id": value,
+		}
+	})
+}
+
+// MergeChats moves the messages of one conversation into another and
+// deletes the emptied conversation, or trashes it while soft deletes are
+// enabled. It calls the merge_conversations function of migration 0008,
+// which does so in one transaction; databases without it get the steps as
+// separate requests.
+func (s *SupabaseMessageStore) MergeChats(fromJID, intoJID string) error {
+	fromID, err := s.client.FindConversation(fromJID)
+	if err != nil || fromID == "" {
+		return err
+	}
+	intoID, err := s.client.GetOrCreateConversation(intoJID, "")
+	if err != nil {
+		return err
+	}
+	defer s.conversationCache.Delete(fromJID)
+
+	_, err = s.client.makeRequest("POST", "rpc/merge_conversations", map[string]interface{}{
+		"source": fromID,
+		"target": intoID,
+		"trash":  softDeletes != nil,
+	})
+	if err == nil {
+		return nil
+	}
+	if apiErr, ok := err.(*SupabaseAPIError); !ok || apiErr.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to merge conversations: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("messages?conversation_id=eq.%s", pgValue(fromID))
+	update := map[string]interface{}{"conversation_id": intoID}
+	if _, err := s.client.makeRequest("PATCH", endpoint, update); err != nil {
+		return fmt.Errorf("failed to move messages: %v", err)
+	}
+
+	if softDeletes != nil {
+		trashedAt := time.Now().UTC().Format(time.RFC3339)
+		err := s.client.patchConversation(fmt.Sprintf("id=eq.%s", pgValue(fromID)), func(map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"trashed_at": trashedAt}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to trash conversation: %v", err)
+		}
+	} else if _, err := s.client.makeRequest("DELETE", fmt.Sprintf("conversations?id=eq.%s", pgValue(fromID)), nil); err != nil {
+		return fmt.Errorf("failed to delete conversation: %v", err)
+	}
+	return nil
+}
+
+// errPersonNotFound is returned for JIDs and person IDs without aliases
+var errPersonNotFound = errors.New("person not found")
+
+// Person is a set of JIDs (old and new numbers, LIDs) that belong to the
+// same contact
+type Person struct {
+	PersonID string   `json:"person_id"`
+	JIDs     []string `json:"jids"`
+}
+
+// ContactAliases links JIDs that belong to the same person under a person
+// ID. The links live in the bridge database and are copied to the message
+// store, so chats created later for a linked JID get the person ID too.
+type ContactAliases struct {
+	db     *sql.DB
+	store  MessageStoreInterface
+	logger waLog.Logger
+}
+
+// contactAliases is the active alias registry
+var contactAliases *ContactAliases
+
+// NewContactAliases creates the contact_aliases table in the bridge database
+func NewContactAliases(db *sql.DB, store MessageStoreInterface, logger waLog.Logger) (*ContactAliases, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS contact_aliases (
+			jid TEXT PRIMARY KEY,
+			person_id TEXT NOT NULL,
+			created_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_contact_aliases_person ON contact_aliases(person_id);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create contact aliases table: %v", err)
+	}
+
+	return &ContactAliases{db: db, store: store, logger: logger}, nil
+}
+
+// newPersonID generates a random person ID
+func newPersonID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "person_" + hex.EncodeToString(b)
+}
+
+// PersonOf returns the person ID a JID is linked to, or an empty string
+func (a *ContactAliases) PersonOf(jid string) (string, error) {
+	var personID string
+	err := a.db.QueryRow("SELECT person_id FROM contact_aliases WHERE jid = ?", jid).Scan(&personID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return personID, err
+}
+
+// Get returns a person with all of their JIDs
+func (a *ContactAliases) Get(personID string) (*Person, error) {
+	rows, err := a.db.Query("SELECT jid FROM contact_aliases WHERE person_id = ? ORDER BY created_at, jid", personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	person := &Person{PersonID: personID, JIDs: []string{}}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		person.JIDs = append(person.JIDs, jid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(person.JIDs) == 0 {
+		return nil, errPersonNotFound
+	}
+	return person, nil
+}
+
+// Link puts JIDs under one person. Without a person ID the person of an
+// already linked JID is reused, or a new one is generated. JIDs linked to
+// other people move over, merging those people into this one.
+func (a *ContactAliases) Link(personID string, jids []string) (*Person, error) {
+	if personID == "" {
+		for _, jid := range jids {
+			existing, err := a.PersonOf(jid)
+			if err != nil {
+				return nil, err
+			}
+			if existing != "" {
+				personID = existing
+				break
+			}
+		}
+	}
+	if personID == "" {
+		personID = newPersonID()
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, jid := range jids {
+		// Every JID of a person being merged in follows it
+		_, err := tx.Exec(
+			`UPDATE contact_aliases SET person_id = ?
+			WHERE person_id IN (SELECT person_id FROM contact_aliases WHERE jid = ?)`,
+			personID, jid,
+		)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO contact_aliases (jid, person_id, created_at) VALUES (?, ?, ?) ON CONFLICT(jid) DO NOTHING",
+			jid, personID, now,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	person, err := a.Get(personID)
+	if err != nil {
+		return nil, err
+	}
+	for _, jid := range person.JIDs {
+		a.syncStore(jid, personID)
+	}
+	return person, nil
+}
+
+// Unlink removes a JID from its person
+func (a *ContactAliases) Unlink(jid string) error {
+	result, err := a.db.Exec("DELETE FROM contact_aliases WHERE jid = ?", jid)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errPersonNotFound
+	}
+	a.syncStore(jid, "")
+	return nil
+}
+
+// Merge moves the history of every chat of a person into one of their JIDs
+func (a *ContactAliases) Merge(person *Person, intoJID string) error {
+	merger, ok := a.store.(ChatMerger)
+	if !ok {
+		return fmt.Errorf("the message store cannot merge conversations")
+	}
+
+	for _, jid := range person.JIDs {
+		if jid == intoJID {
+			continue
+		}
+		if err := merger.MergeChats(jid, intoJID); err != nil {
+			return fmt.Errorf("failed to merge %s into %s: %v", jid, intoJID, err)
+		}
+
+		// The bridge's own state of the chat follows its messages
+		if inboxProjection != nil {
+			if err := inboxProjection.MergeChat(jid, intoJID); err != nil {
+				a.logger.Warnf("Failed to merge inbox entry of %s into %s: %v", jid, intoJID, err)
+			}
+		}
+		if conversationStats != nil {
+			if err := conversationStats.MergeChat(jid, intoJID); err != nil {
+				a.logger.Warnf("Failed to merge metrics of %s into %s: %v", jid, intoJID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// syncStore copies a person ID to the message store when it supports it
+func (a *ContactAliases) syncStore(jid, personID string) {
+	linker, ok := a.store.(PersonLinker)
+	if !ok {
+		return
+	}
+	if err := linker.LinkPerson(jid, personID); err != nil {
+		a.logger.Warnf("Failed to store person of %s: %v", jid, err)
+	}
+}
+
+// HandleEvent implements EventSubscriber by tagging new chats of linked
+// JIDs with their person ID
+func (a *ContactAliases) HandleEvent(evt Event) {
+	if evt.Type != EventConversationCreated {
+		return
+	}
+
+	var payload ConversationEventPayload
+	if err := evt.DecodePayload(&payload); err != nil {
+		a.logger.Warnf("Failed to decode %s event: %v", evt.Type, err)
+		return
+	}
+
+	personID, err := a.PersonOf(payload.ChatJID)
+	if err != nil {
+		a.logger.Warnf("Failed to look up person of %s: %v", payload.ChatJID, err)
+		return
+	}
+	if personID != "" {
+		a.syncStore(payload.ChatJID, personID)
+	}
+}
+
+// PersonResponse represents the response for the contact alias APIs
+type PersonResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message,omitempty"`
+	Person  *Person `json:"person,omitempty"`
+}
+
+// LinkContactsRequest represents the request body to link JIDs to a person
+type LinkContactsRequest struct {
+	// PersonID is optional; see ContactAliases.Link
+	PersonID string   `json:"person_id,omitempty"`
+	JIDs     []string `json:"jids"`
+	// MergeInto moves the history of all the person's chats into this JID
+	MergeInto string `json:"merge_into,omitempty"`
+}
+
+// UnlinkContactRequest represents the request body to unlink a JID
+type UnlinkContactRequest struct {
+	JID string `json:"jid"`
+}
+
+// writePersonResponse writes a PersonResponse, mapping errors to statuses
+func writePersonResponse(w http.ResponseWriter, person *Person, message string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == errPersonNotFound {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(PersonResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(PersonResponse{Success: true, Message: message, Person: person})
+}
+
+// handleContactPerson serves GET /api/contacts/person?jid= or ?person_id=
+func handleContactPerson(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	personID := r.URL.Query().Get("person_id")
+	if jid := r.URL.Query().Get("jid"); jid != "" {
+		var err error
+		if personID, err = contactAliases.PersonOf(jid); err != nil {
+			writePersonResponse(w, nil, "", err)
+			return
+		}
+		if personID == "" {
+			writePersonResponse(w, nil, "", errPersonNotFound)
+			return
+		}
+	}
+	if personID == "" {
+		http.Error(w, "jid or person_id is required", http.StatusBadRequest)
+		return
+	}
+
+	person, err := contactAliases.Get(personID)
+	writePersonResponse(w, person, "", err)
+}
+
+// handleContactLink serves POST /api/contacts/link
+func handleContactLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LinkContactsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	jids := make([]string, 0, len(req.JIDs))
+	for _, raw := range req.JIDs {
+		jid, err := types.ParseJID(strings.TrimSpace(raw))
+		if err != nil || jid.User == "" {
+			http.Error(w, fmt.Sprintf("Invalid JID %q", raw), http.StatusBadRequest)
+			return
+		}
+		jids = append(jids, jid.String())
+	}
+	if len(jids) == 0 {
+		http.Error(w, "At least one JID is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.MergeInto != "" {
+		listed := false
+		for _, jid := range jids {
+			listed = listed || jid == req.MergeInto
+		}
+		if !listed {
+			http.Error(w, "merge_into must be one of the listed JIDs", http.StatusBadRequest)
+			return
+		}
+	}
+
+	person, err := contactAliases.Link(req.PersonID, jids)
+	if err != nil {
+		writePersonResponse(w, nil, "", fmt.Errorf("failed to link contacts: %v", err))
+		return
+	}
+
+	message := fmt.Sprintf("Linked %d JIDs to %s", len(person.JIDs), person.PersonID)
+	if req.MergeInto != "" {
+		if err := contactAliases.Merge(person, req.MergeInto); err != nil {
+			writePersonResponse(w, nil, "", err)
+			return
+		}
+		message += fmt.Sprintf(", conversations merged into %s", req.MergeInto)
+	}
+
+	writePersonResponse(w, person, message, nil)
+}
+
+// handleContactUnlink serves POST /api/contacts/unlink
+func handleContactUnlink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UnlinkContactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.JID == "" {
+		http.Error(w, "JID is required", http.StatusBadRequest)
+		return
+	}
+
+	err := contactAliases.Unlink(req.JID)
+	writePersonResponse(w, nil, fmt.Sprintf("Unlinked %s", req.JID), err)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ContactMatch represents a single ranked result of a contact search
+type ContactMatch struct {
+	JID          string `json:"jid"`
+	Phone        string `json:"phone"`
+	Name         string `json:"name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+	Score        int    `json:"score"`
+	MatchedOn    string `json:"matched_on"`
+}
+
+// ContactSearchResponse represents the response for the contact search API
+type ContactSearchResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message,omitempty"`
+	Query   string         `json:"query"`
+	Results []ContactMatch `json:"results"`
+}
+
+// searchTokens splits text into lowercase alphanumeric tokens
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// scoreName ranks how well the query tokens match a name. Every query token
+// contributes the score of its best matching name token, so noise words in a
+// request like "find Jan from the garage" simply add nothing.
+func scoreName(queryTokens []string, fullQuery, name string) int {
+	if name == "" {
+		return 0
+	}
+
+	nameTokens := searchTokens(name)
+	if len(nameTokens) == 0 {
+		return 0
+	}
+
+	score := 0
+	if strings.EqualFold(strings.Join(nameTokens, " "), fullQuery) {
+		score += 50
+	}
+
+	for _, qt := range queryTokens {
+		best := 0
+		for _, nt := range nameTokens {
+			switch {
+			case qt == nt:
+				best = max(best, 40)
+			case len(qt) >= 2 && strings.HasPrefix(nt, qt):
+				best = max(best, 25)
+			case len(qt) >= 4 && strings.Contains(nt, qt):
+				best = max(best, 15)
+			case len(qt) >= 4 && editDistanceAtMostOne(qt, nt):
+				// Tolerate a single typo in longer tokens
+				best = max(best, 10)
+			}
+		}
+		score += best
+	}
+
+	return score
+}
+
+// scorePhone ranks how well a normalized query number matches a JID user part
+func scorePhone(queryDigits, phone string) int {
+	if len(queryDigits) < 4 || phone == "" {
+		return 0
+	}
+
+	switch {
+	case queryDigits == phone:
+		return 100
+	case strings.HasSuffix(phone, queryDigits):
+		return 70
+	case strings.Contains(phone, queryDigits):
+		return 50
+	}
+
+	return 0
+}
+
+// editDistanceAtMostOne reports whether a and b differ by at most one
+// insertion, deletion or substitution
+func editDistanceAtMostOne(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > 1 {
+		return false
+	}
+
+	// Skip the common prefix, then the remainders must line up after one edit
+	i := 0
+	for i < len(ra) && ra[i] == rb[i] {
+		i++
+	}
+	if len(ra) == len(rb) {
+		return string(ra[i+1:]) == string(rb[i+1:])
+	}
+	return string(ra[i:]) == string(rb[i+1:])
+}
+
+// searchContacts matches the query against names, push names and phone
+// numbers in the device contact store and returns the best results first
+func searchContacts(client *whatsmeow.Client, query string, limit int) ([]ContactMatch, error) {
+	contacts, err := client.Store.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	queryTokens := searchTokens(query)
+	fullQuery := strings.Join(queryTokens, " ")
+	queryDigits := normalizePhone(query)
+
+	var matches []ContactMatch
+	for jid, info := range contacts {
+		if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+			continue
+		}
+
+		match := ContactMatch{
+			JID:          jid.String(),
+			Phone:        jid.User,
+			Name:         info.FullName,
+			PushName:     info.PushName,
+			BusinessName: info.BusinessName,
+		}
+
+		candidates := []struct {
+			field string
+			score int
+		}{
+			{"phone", scorePhone(queryDigits, jid.User)},
+			{"name", scoreName(queryTokens, fullQuery, info.FullName)},
+			{"first_name", scoreName(queryTokens, fullQuery, info.FirstName)},
+			{"push_name", scoreName(queryTokens, fullQuery, info.PushName)},
+			{"business_name", scoreName(queryTokens, fullQuery, info.BusinessName)},
+		}
+		for _, c := range candidates {
+			if c.score > match.Score {
+				match.Score = c.score
+				match.MatchedOn = c.field
+			}
+		}
+
+		if match.Score > 0 {
+			matches = append(matches, match)
+		}
+	}
+
+	// Highest score first, address-book names before push names on ties
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if (matches[i].Name != "") != (matches[j].Name != "") {
+			return matches[i].Name != ""
+		}
+		return matches[i].JID < matches[j].JID
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, nil
+}
+
+// handleContactSearch serves /api/contacts/search?q=<query>&limit=<n>
+func handleContactSearch(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+			return
+		}
+
+		limit := 10
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			parsed, err := strconv.Atoi(limitParam)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		results, err := searchContacts(client, query, limit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ContactSearchResponse{
+				Success: false,
+				Message: "Failed to load contacts: " + err.Error(),
+				Query:   query,
+				Results: []ContactMatch{},
+			})
+			return
+		}
+
+		if results == nil {
+			results = []ContactMatch{}
+		}
+
+		json.NewEncoder(w).Encode(ContactSearchResponse{
+			Success: true,
+			Query:   query,
+			Results: results,
+		})
+	}
+}
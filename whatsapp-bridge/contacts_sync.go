@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ContactRecord is the names the device store knows for a contact
+type ContactRecord struct {
+	JID          string
+	FirstName    string
+	FullName     string
+	PushName     string
+	BusinessName string
+}
+
+// ContactStore is implemented by message stores that keep contacts
+type ContactStore interface {
+	StoreContacts(contacts []ContactRecord) error
+}
+
+// StoreContacts upserts contacts in the database
+func (store *MessageStore) StoreContacts(contacts []ContactRecord) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, contact := range contacts {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO contacts (jid, first_name, full_name, push_name, business_name, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+			contact.JID, contact.FirstName, contact.FullName, contact.PushName, contact.BusinessName, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// supabaseContactConflict is the unique key of a contacts row. The Supabase
+// table is created with e.g.
+//
+//	CREATE TABLE contacts (
+//		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+//		jid text NOT NULL,
+//		channel text NOT NULL DEFAULT 'whatsapp',
+//		first_name text,
+//		full_name text,
+//		push_name text,
+//		business_name text,
+//		updated_at timestamptz NOT NULL DEFAULT now(),
+//		UNIQUE (jid, channel)
+//	);
+//
+// so names can be resolved server-side by joining on
+// conversations.contact_identifier or messages.sender.
+const supabaseContactConflict = "jid,channel"
+
+// supabaseContact is a contacts row
+type supabaseContact struct {
+	JID          string    `json:"jid"`
+	Channel      string    `json:"channel"`
+	FirstName    string    `json:"first_name"`
+	FullName     string    `json:"full_name"`
+	PushName     string    `json:"push_name"`
+	BusinessName string    `json:"business_name"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// StoreContacts upserts contacts in Supabase in chunks of
+// SUPABASE_BATCH_SIZE rows
+func (s *SupabaseMessageStore) StoreContacts(contacts []ContactRecord) error {
+	now := time.Now().UTC()
+	rows := make([]supabaseContact, len(contacts))
+	for i, contact := range contacts {
+		rows[i] = supabaseContact{
+			JID:          contact.JID,
+			Channel:      "whatsapp",
+			FirstName:    contact.FirstName,
+			FullName:     contact.FullName,
+			PushName:     contact.PushName,
+			BusinessName: contact.BusinessName,
+			UpdatedAt:    now,
+		}
+	}
+
+	batchSize := max(envInt("SUPABASE_BATCH_SIZE", 500), 1)
+	for start := 0; start < len(rows); start += batchSize {
+		end := min(start+batchSize, len(rows))
+		if _, err := s.client.makeUpsertRequest("contacts", supabaseContactConflict, rows[start:end]); err != nil {
+			return fmt.Errorf("failed to store contacts: %v", err)
+		}
+	}
+	return nil
+}
+
+// ContactSync copies the push names and address book names the device
+// store learns from app state sync to the message store. Only contacts
+// whose names changed since the last sync are written.
+type ContactSync struct {
+	client   *whatsmeow.Client
+	store    ContactStore
+	interval time.Duration
+	logger   waLog.Logger
+	trigger  chan struct{}
+
+	mutex  sync.Mutex
+	synced map[string]ContactRecord
+}
+
+// contactSync is the active contact sync, nil when disabled
+var contactSync *ContactSync
+
+// NewContactSync starts syncing contacts every CONTACT_SYNC_INTERVAL. It
+// returns nil when CONTACT_SYNC is not enabled or the store cannot keep
+// contacts.
+func NewContactSync(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) *ContactSync {
+	if !envBool("CONTACT_SYNC", false) {
+		return nil
+	}
+
+	store, ok := messageStore.(ContactStore)
+	if !ok {
+		logger.Warnf("Message store does not support contacts, contact sync disabled")
+		return nil
+	}
+
+	c := &ContactSync{
+		client:   client,
+		store:    store,
+		interval: envDuration("CONTACT_SYNC_INTERVAL", time.Hour),
+		logger:   logger,
+		trigger:  make(chan struct{}, 1),
+		synced:   make(map[string]ContactRecord),
+	}
+	go c.run()
+	return c
+}
+
+// Trigger asks for a sync without waiting for the interval, such as after
+// connecting when app state sync brings new names
+func (c *ContactSync) Trigger() {
+	if c == nil {
+		return
+	}
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// run syncs on every interval and trigger
+func (c *ContactSync) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.trigger:
+		}
+		c.sync()
+	}
+}
+
+// sync writes the contacts that changed since the last sync
+func (c *ContactSync) sync() {
+	contacts, err := c.client.Store.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		c.logger.Warnf("Failed to read contacts: %v", err)
+		return
+	}
+
+	c.mutex.Lock()
+	var changed []ContactRecord
+	for jid, info := range contacts {
+		record := contactRecord(jid, info)
+		if c.synced[record.JID] != record {
+			changed = append(changed, record)
+		}
+	}
+	c.mutex.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	if err := c.store.StoreContacts(changed); err != nil {
+		c.logger.Warnf("Failed to sync %d contacts: %v", len(changed), err)
+		return
+	}
+
+	c.mutex.Lock()
+	for _, record := range changed {
+		c.synced[record.JID] = record
+	}
+	c.mutex.Unlock()
+	c.logger.Infof("Synced %d contacts", len(changed))
+}
+
+// contactRecord converts a device store contact
+func contactRecord(jid types.JID, info types.ContactInfo) ContactRecord {
+	return ContactRecord{
+		JID:          jid.ToNonAD().String(),
+		FirstName:    info.FirstName,
+		FullName:     info.FullName,
+		PushName:     info.PushName,
+		BusinessName: info.BusinessName,
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Content policy actions for violating messages
+const (
+	// PolicyActionReject refuses the send with a validation error
+	PolicyActionReject = "reject"
+	// PolicyActionApprove holds the message until it is approved through
+	// the approvals API
+	PolicyActionApprove = "approve"
+)
+
+// ValidationContentPolicy is the validation code of content policy violations
+const ValidationContentPolicy = "content_policy"
+
+// ContentPolicyConfig is the JSON configuration of CONTENT_POLICY
+type ContentPolicyConfig struct {
+	// BlockedKeywords match case-insensitively anywhere in the message
+	BlockedKeywords []string `json:"blocked_keywords,omitempty"`
+	// BlockedPatterns are regular expressions matched against the message
+	BlockedPatterns []string `json:"blocked_patterns,omitempty"`
+	// MaxLinks is the largest number of links allowed, 0 for no limit
+	MaxLinks int `json:"max_links,omitempty"`
+	// Action is PolicyActionReject (default) or PolicyActionApprove
+	Action string `json:"action,omitempty"`
+}
+
+// ContentPolicy checks outbound message text before it is sent
+type ContentPolicy struct {
+	keywords []string
+	patterns []*regexp.Regexp
+	maxLinks int
+	action   string
+}
+
+// contentPolicy is the active content policy, nil when disabled
+var contentPolicy *ContentPolicy
+
+// linkPattern finds URLs and bare domains with a path or www prefix
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// NewContentPolicy loads CONTENT_POLICY. It returns nil when nothing is
+// configured.
+func NewContentPolicy() (*ContentPolicy, error) {
+	raw := envString("CONTENT_POLICY", "")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var config ContentPolicyConfig
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse CONTENT_POLICY: %v", err)
+	}
+
+	p := &ContentPolicy{maxLinks: config.MaxLinks, action: config.Action}
+	switch p.action {
+	case "":
+		p.action = PolicyActionReject
+	case PolicyActionReject, PolicyActionApprove:
+	default:
+		return nil, fmt.Errorf("invalid content policy action %q", config.Action)
+	}
+
+	for _, keyword := range config.BlockedKeywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			p.keywords = append(p.keywords, strings.ToLower(keyword))
+		}
+	}
+	for _, pattern := range config.BlockedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content policy pattern %q: %v", pattern, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+
+	return p, nil
+}
+
+// Action returns what should happen to a violating message
+func (p *ContentPolicy) Action() string {
+	return p.action
+}
+
+// Check returns the policy violations of a message body
+func (p *ContentPolicy) Check(body string) []ValidationError {
+	if p == nil || body == "" {
+		return nil
+	}
+
+	var violations []ValidationError
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, ValidationError{
+			Field:   "message",
+			Code:    ValidationContentPolicy,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	lower := strings.ToLower(body)
+	for _, keyword := range p.keywords {
+		if strings.Contains(lower, keyword) {
+			violate("Message contains blocked keyword %q", keyword)
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(body) {
+			violate("Message matches blocked pattern %q", re.String())
+		}
+	}
+	if p.maxLinks > 0 {
+		if links := len(linkPattern.FindAllString(body, -1)); links > p.maxLinks {
+			violate("Message contains %d links, more than the %d allowed", links, p.maxLinks)
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/eventschema"
+)
+
+// GroupDigest is the activity of a group over one day, also the payload of
+// group.digest events
+type GroupDigest = eventschema.GroupDigestPayload
+
+// digestPageSize is the number of messages read per page while digesting
+const digestPageSize = 500
+
+// GroupDigests builds daily activity digests of groups. When GROUP_DIGEST_TIME
+// is set, the digests of the previous day are pushed every day at that time
+// as group.digest events, and as one summary message to GROUP_DIGEST_SEND_TO.
+type GroupDigests struct {
+	client       *whatsmeow.Client
+	messageStore MessageStoreInterface
+	location     *time.Location
+	topSenders   int
+	sendTo       string
+	logger       waLog.Logger
+}
+
+// groupDigests is the active digest builder
+var groupDigests *GroupDigests
+
+// NewGroupDigests reads the digest configuration. Days run from midnight to
+// midnight in GROUP_DIGEST_TIMEZONE, UTC by default.
+func NewGroupDigests(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) (*GroupDigests, error) {
+	location, err := time.LoadLocation(envString("GROUP_DIGEST_TIMEZONE", "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GROUP_DIGEST_TIMEZONE: %v", err)
+	}
+
+	d := &GroupDigests{
+		client:       client,
+		messageStore: messageStore,
+		location:     location,
+		topSenders:   envInt("GROUP_DIGEST_TOP_SENDERS", 5),
+		sendTo:       envString("GROUP_DIGEST_SEND_TO", ""),
+		logger:       logger,
+	}
+
+	if at := envString("GROUP_DIGEST_TIME", ""); at != "" {
+		clock, err := parseClock(at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GROUP_DIGEST_TIME: %v", err)
+		}
+		go d.runDaily(clock)
+	}
+	return d, nil
+}
+
+// dayStart returns midnight of the day containing t in the digest timezone
+func (d *GroupDigests) dayStart(t time.Time) time.Time {
+	local := t.In(d.location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, d.location)
+}
+
+// Build digests a group's messages of the day containing day
+func (d *GroupDigests) Build(chatJID string, day time.Time) (*GroupDigest, error) {
+	start := d.dayStart(day)
+	end := start.AddDate(0, 0, 1)
+
+	// After is exclusive, so start just before midnight
+	query := MessageQuery{Limit: digestPageSize, After: start.Add(-time.Nanosecond), Before: end}
+	var messages []Message
+	for {
+		page, err := d.messageStore.GetMessages(chatJID, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read messages: %v", err)
+		}
+		messages = append(messages, page...)
+		if len(page) < digestPageSize {
+			break
+		}
+		last := page[len(page)-1]
+		query.CursorTime, query.CursorID = last.Time, last.ID
+	}
+
+	digest := &GroupDigest{
+		ChatJID:             chatJID,
+		Date:                start.Format("2006-01-02"),
+		MessageCount:        len(messages),
+		TopSenders:          []eventschema.DigestSender{},
+		UnansweredQuestions: []eventschema.DigestQuestion{},
+	}
+	if jid, err := types.ParseJID(chatJID); err == nil {
+		if info, err := cachedGroupInfo(d.client, jid); err == nil {
+			digest.Name = info.Name
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, msg := range messages {
+		counts[msg.Sender]++
+	}
+	for sender, count := range counts {
+		digest.TopSenders = append(digest.TopSenders, eventschema.DigestSender{Sender: sender, Count: count})
+	}
+	sort.Slice(digest.TopSenders, func(i, j int) bool {
+		if digest.TopSenders[i].Count != digest.TopSenders[j].Count {
+			return digest.TopSenders[i].Count > digest.TopSenders[j].Count
+		}
+		return digest.TopSenders[i].Sender < digest.TopSenders[j].Sender
+	})
+	if len(digest.TopSenders) > d.topSenders {
+		digest.TopSenders = digest.TopSenders[:d.topSenders]
+	}
+
+	// The quoted messages tell which replies answer the account
+	if err := hydrateQuoted(d.messageStore, chatJID, messages); err != nil {
+		return nil, fmt.Errorf("failed to read quoted messages: %v", err)
+	}
+
+	// Messages are newest first, so walking forward the account's latest
+	// message is known before the older questions it may answer
+	var lastOwn time.Time
+	for _, msg := range messages {
+		if msg.IsFromMe {
+			if msg.Time.After(lastOwn) {
+				lastOwn = msg.Time
+			}
+			continue
+		}
+
+		directed := msg.MentionedMe || (msg.Quoted != nil && msg.Quoted.IsFromMe)
+		if !directed || !strings.Contains(msg.Content, "?") || lastOwn.After(msg.Time) {
+			continue
+		}
+		digest.UnansweredQuestions = append(digest.UnansweredQuestions, eventschema.DigestQuestion{
+			ID:        msg.ID,
+			Sender:    msg.Sender,
+			Content:   msg.Content,
+			Timestamp: msg.Time,
+		})
+	}
+
+	return digest, nil
+}
+
+// BuildAll digests every group with messages on the day containing day
+func (d *GroupDigests) BuildAll(day time.Time) ([]*GroupDigest, error) {
+	chats, err := d.messageStore.GetChats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chats: %v", err)
+	}
+
+	start := d.dayStart(day)
+	var jids []string
+	for chatJID, lastMessageTime := range chats {
+		if strings.HasSuffix(chatJID, "@"+types.GroupServer) && !lastMessageTime.Before(start) {
+			jids = append(jids, chatJID)
+		}
+	}
+	sort.Strings(jids)
+
+	digests := []*GroupDigest{}
+	for _, chatJID := range jids {
+		digest, err := d.Build(chatJID, day)
+		if err != nil {
+			return nil, fmt.Errorf("failed to digest %s: %v", chatJID, err)
+		}
+		if digest.MessageCount > 0 {
+			digests = append(digests, digest)
+		}
+	}
+	return digests, nil
+}
+
+// runDaily pushes the previous day's digests every day at the given time
+func (d *GroupDigests) runDaily(clock time.Duration) {
+	for {
+		now := time.Now().In(d.location)
+		next := d.dayStart(now).Add(clock)
+		if !next.After(now) {
+			next = d.dayStart(now.AddDate(0, 0, 1)).Add(clock)
+		}
+		time.Sleep(time.Until(next))
+
+		d.push(next.AddDate(0, 0, -1))
+	}
+}
+
+// push emits the digests of a day and sends the summary message
+func (d *GroupDigests) push(day time.Time) {
+	digests, err := d.BuildAll(day)
+	if err != nil {
+		d.logger.Warnf("Failed to build group digests: %v", err)
+		return
+	}
+
+	for _, digest := range digests {
+		emitEvent(EventGroupDigest, digest.ChatJID, digest)
+	}
+
+	if d.sendTo == "" || len(digests) == 0 {
+		return
+	}
+	if ok, result, _ := sendWhatsAppMessage(d.client, d.sendTo, formatDigests(digests), "", d.client.GenerateMessageID()); !ok {
+		d.logger.Warnf("Failed to send group digest: %s", result)
+	}
+}
+
+// formatDigests renders digests as a plain text message
+func formatDigests(digests []*GroupDigest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Group digest for %s\n", digests[0].Date)
+	for _, digest := range digests {
+		name := digest.Name
+		if name == "" {
+			name = digest.ChatJID
+		}
+		fmt.Fprintf(&b, "\n*%s*: %d messages\n", name, digest.MessageCount)
+
+		senders := make([]string, len(digest.TopSenders))
+		for i, sender := range digest.TopSenders {
+			senders[i] = fmt.Sprintf("%s (%d)", sender.Sender, sender.Count)
+		}
+		if len(senders) > 0 {
+			fmt.Fprintf(&b, "Top senders: %s\n", strings.Join(senders, ", "))
+		}
+
+		for _, question := range digest.UnansweredQuestions {
+			fmt.Fprintf(&b, "? %s: %s\n", question.Sender, question.Content)
+		}
+	}
+	return b.String()
+}
+
+// handleGroupDigest serves GET /api/groups/digest?chat_jid=<jid>&date=YYYY-MM-DD.
+// The date defaults to yesterday; without chat_jid every group active that
+// day is digested.
+func handleGroupDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	day := time.Now().In(groupDigests.location).AddDate(0, 0, -1)
+	if date := r.URL.Query().Get("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, groupDigests.location)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+
+	var digests []*GroupDigest
+	if chatJID := r.URL.Query().Get("chat_jid"); chatJID != "" {
+		digest, err := groupDigests.Build(chatJID, day)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build digest: %v", err), http.StatusInternalServerError)
+			return
+		}
+		digests = []*GroupDigest{digest}
+	} else {
+		var err error
+		if digests, err = groupDigests.BuildAll(day); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build digests: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"digests": digests,
+	})
+}
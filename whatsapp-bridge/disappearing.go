@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Ways of handling messages sent with a disappearing timer
+const (
+	// DisappearingKeep stores them like any other message
+	DisappearingKeep = "keep"
+	// DisappearingTag marks the stored copy with when it expires
+	DisappearingTag = "tag"
+	// DisappearingDelete tags the stored copy and deletes it, with any
+	// downloaded media, when WhatsApp would
+	DisappearingDelete = "delete"
+)
+
+// EphemeralStore is implemented by message stores that can tag and delete
+// disappearing messages
+type EphemeralStore interface {
+	MarkEphemeral(id, chatJID string, expiresAt time.Time) error
+	DeleteMessage(id, chatJID string) error
+}
+
+// MarkEphemeral records when a stored message disappears
+func (store *MessageStore) MarkEphemeral(id, chatJID string, expiresAt time.Time) error {
+	_, err := store.db.Exec("UPDATE messages SET expires_at = ? WHERE id = ? AND chat_jid = ?", expiresAt, id, chatJID)
+	return err
+}
+
+// DeleteMessage removes a stored message, or trashes it while soft deletes
+// are enabled so it is purged with the rest of the trash
+func (store *MessageStore) DeleteMessage(id, chatJID string) error {
+	if softDeletes != nil {
+		_, err := store.TrashMessage(id, chatJID, time.Now())
+		return err
+	}
+	_, err := store.db.Exec("DELETE FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID)
+	return err
+}
+
+// MarkEphemeral tags a message in Supabase as ephemeral in its metadata
+func (s *SupabaseMessageStore) MarkEphemeral(id, chatJID string, expiresAt time.Time) error {
+	return s.mergeMetadata(id, chatJID, map[string]interface{}{
+		"ephemeral":  true,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// DeleteMessage removes a message from Supabase, or trashes it while soft
+// deletes are enabled
+func (s *SupabaseMessageStore) DeleteMessage(id, chatJID string) error {
+	if softDeletes != nil {
+		_, err := s.TrashMessage(id, chatJID, time.Now())
+		return err
+	}
+	filter, err := s.messageFilter(id, chatJID)
+	if err != nil || filter == "" {
+		return err
+	}
+	_, err = s.client.makeRequest("DELETE", "messages?"+filter, nil)
+	return err
+}
+
+// DisappearingMessages applies DISAPPEARING_MODE to messages sent with a
+// disappearing timer, so the archive honours what the sender expects.
+// Expiry times are kept in the bridge database, which lets deletion work
+// the same for every store and survive restarts.
+type DisappearingMessages struct {
+	db       *sql.DB
+	messages MessageStoreInterface
+	store    EphemeralStore
+	mode     string
+	logger   waLog.Logger
+}
+
+// disappearingMessages is the active handler, nil when disabled
+var disappearingMessages *DisappearingMessages
+
+// NewDisappearingMessages creates the ephemeral_messages table in the bridge
+// database and, in delete mode, starts deleting expired messages. It
+// returns nil in keep mode or when the store cannot tag messages.
+func NewDisappearingMessages(db *sql.DB, messageStore MessageStoreInterface, logger waLog.Logger) (*DisappearingMessages, error) {
+	mode := envString("DISAPPEARING_MODE", DisappearingTag)
+	switch mode {
+	case DisappearingKeep:
+		return nil, nil
+	case DisappearingTag, DisappearingDelete:
+	default:
+		return nil, fmt.Errorf("invalid DISAPPEARING_MODE %q", mode)
+	}
+
+	store, ok := messageStore.(EphemeralStore)
+	if !ok {
+		return nil, nil
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ephemeral_messages (
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (chat_jid, message_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_ephemeral_messages_expires ON ephemeral_messages(expires_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ephemeral messages table: %v", err)
+	}
+
+	d := &DisappearingMessages{db: db, messages: messageStore, store: store, mode: mode, logger: logger}
+	if mode == DisappearingDelete {
+		go d.run(envDuration("DISAPPEARING_CHECK_INTERVAL", time.Minute))
+	}
+	return d, nil
+}
+
+// HandleMessage tags a stored message that carries a disappearing timer.
+// WhatsApp counts the timer from when the message was sent.
+func (d *DisappearingMessages) HandleMessage(msg *events.Message) {
+	if d == nil {
+		return
+	}
+
+	expiration := messageContextInfo(msg.Message).GetExpiration()
+	if expiration == 0 {
+		return
+	}
+
+	chatJID := msg.Info.Chat.String()
+	expiresAt := msg.Info.Timestamp.Add(time.Duration(expiration) * time.Second).UTC()
+
+	if err := d.store.MarkEphemeral(msg.Info.ID, chatJID, expiresAt); err != nil {
+		d.logger.Warnf("Failed to tag disappearing message %s: %v", msg.Info.ID, err)
+	}
+
+	_, err := d.db.Exec(
+		"INSERT OR REPLACE INTO ephemeral_messages (chat_jid, message_id, expires_at) VALUES (?, ?, ?)",
+		chatJID, msg.Info.ID, expiresAt,
+	)
+	if err != nil {
+		d.logger.Warnf("Failed to record disappearing message %s: %v", msg.Info.ID, err)
+	}
+}
+
+// run deletes expired messages on every interval
+func (d *DisappearingMessages) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.deleteExpired()
+	}
+}
+
+// deleteExpired removes messages whose timer ran out from the store and the
+// media cache
+func (d *DisappearingMessages) deleteExpired() {
+	rows, err := d.db.Query(
+		"SELECT chat_jid, message_id FROM ephemeral_messages WHERE expires_at <= ? ORDER BY expires_at LIMIT 500",
+		time.Now().UTC(),
+	)
+	if err != nil {
+		d.logger.Warnf("Failed to read expired messages: %v", err)
+		return
+	}
+
+	type expired struct{ chatJID, messageID string }
+	var due []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.chatJID, &e.messageID); err != nil {
+			d.logger.Warnf("Failed to read expired message: %v", err)
+			continue
+		}
+		due = append(due, e)
+	}
+	rows.Close()
+
+	for _, e := range due {
+		// Look up the media file before the message is trashed
+		_, filename, _, _, _, _, _, mediaErr := d.messages.GetMediaInfo(e.messageID, e.chatJID)
+
+		if err := d.store.DeleteMessage(e.messageID, e.chatJID); err != nil {
+			d.logger.Warnf("Failed to delete disappearing message %s: %v", e.messageID, err)
+			continue
+		}
+
+		if mediaErr == nil && filename != "" {
+			chatDir := fmt.Sprintf("store/%s", strings.ReplaceAll(e.chatJID, ":", "_"))
+			if localPath, err := mediaLocalPath(chatDir, filename); err != nil {
+				d.logger.Warnf("Not deleting media of disappearing message %s: %v", e.messageID, err)
+			} else if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+				d.logger.Warnf("Failed to delete media of disappearing message %s: %v", e.messageID, err)
+			}
+		}
+
+		if _, err := d.db.Exec("DELETE FROM ephemeral_messages WHERE chat_jid = ? AND message_id = ?", e.chatJID, e.messageID); err != nil {
+			d.logger.Warnf("Failed to clear disappearing message %s: %v", e.messageID, err)
+		}
+	}
+}
@@ -0,0 +1,773 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// DualMessageStore keeps messages in the local SQLite database for fast
+// reads and media downloads and mirrors every write to Supabase for other
+// apps. SQLite is the source of truth: reads come from it and its errors
+// are returned, while a failed Supabase write is only logged and left to
+// the reconciliation job to repair.
+type DualMessageStore struct {
+	primary   *MessageStore
+	secondary *SupabaseMessageStore
+	logger    waLog.Logger
+
+	// Reconciliation compares messages of the last window, at most limit
+	// per chat, and copies the ones Supabase lacks when repair is set
+	window time.Duration
+	limit  int
+	repair bool
+
+	// reconcileMu keeps runs from overlapping; reportMu guards lastReport
+	reconcileMu sync.Mutex
+	reportMu    sync.Mutex
+	lastReport  *DualWriteReport
+}
+
+var _ MessageStoreInterface = (*DualMessageStore)(nil)
+
+func init() {
+	RegisterMessageStore("dual", func(logger waLog.Logger) (MessageStoreInterface, error) {
+		return NewDualMessageStore(logger)
+	})
+}
+
+// NewDualMessageStore opens both stores and starts reconciling them every
+// DUAL_WRITE_RECONCILE_INTERVAL, unless it is 0
+func NewDualMessageStore(logger waLog.Logger) (*DualMessageStore, error) {
+	primary, err := NewMessageStore()
+	if err != nil {
+		return nil, err
+	}
+	store, err := openSupabaseStore(logger)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+	secondary := store.(*SupabaseMessageStore)
+	// SQLite already emits conversation.created for every new chat
+	secondary.client.quiet = true
+
+	d := &DualMessageStore{
+		primary:   primary,
+		secondary: secondary,
+		logger:    logger,
+		window:    envDuration("DUAL_WRITE_RECONCILE_WINDOW", 24*time.Hour),
+		limit:     max(envInt("DUAL_WRITE_RECONCILE_LIMIT", 1000), 1),
+		repair:    envBool("DUAL_WRITE_REPAIR", true),
+	}
+	if interval := envDuration("DUAL_WRITE_RECONCILE_INTERVAL", time.Hour); interval > 0 {
+		go d.run(interval)
+	}
+	return d, nil
+}
+
+// supabaseStoreOf returns the Supabase store behind a message store, for
+// features that talk to Supabase directly
+func supabaseStoreOf(messageStore MessageStoreInterface) (*SupabaseMessageStore, bool) {
+	switch store := messageStore.(type) {
+	case *SupabaseMessageStore:
+		return store, true
+	case *DualMessageStore:
+		return store.secondary, true
+	}
+	return nil, false
+}
+
+// mirror repeats a write on Supabase after it succeeded on SQLite
+func (d *DualMessageStore) mirror(what string, write func() error) {
+	if err := write(); err != nil {
+		d.logger.Warnf("Failed to mirror %s to Supabase, left for reconciliation: %v", what, err)
+	}
+}
+
+// Close closes the SQLite database
+func (d *DualMessageStore) Close() error {
+	d.secondary.Close()
+	return d.primary.Close()
+}
+
+// StoreChat stores a chat in both stores
+func (d *DualMessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	if err := d.primary.StoreChat(jid, name, lastMessageTime); err != nil {
+		return err
+	}
+	d.mirror("chat "+jid, func() error { return d.secondary.StoreChat(jid, name, lastMessageTime) })
+	return nil
+}
+
+// StoreMessage stores a message in both stores
+func (d *DualMessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
+	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
+	if err := d.primary.StoreMessage(id, chatJID, sender, content, timestamp, isFromMe,
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength); err != nil {
+		return err
+	}
+	d.mirror("message "+id, func() error {
+		return d.secondary.StoreMessage(id, chatJID, sender, content, timestamp, isFromMe,
+			mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+	})
+	return nil
+}
+
+// StoreMessages stores a history sync batch in both stores
+func (d *DualMessageStore) StoreMessages(records []MessageRecord) error {
+	if err := d.primary.StoreMessages(records); err != nil {
+		return err
+	}
+	d.mirror(fmt.Sprintf("%d history messages", len(records)), func() error { return d.secondary.StoreMessages(records) })
+	return nil
+}
+
+// GetMessages reads from SQLite
+func (d *DualMessageStore) GetMessages(chatJID string, query MessageQuery) ([]Message, error) {
+	return d.primary.GetMessages(chatJID, query)
+}
+
+// GetChats reads from SQLite
+func (d *DualMessageStore) GetChats() (map[string]time.Time, error) {
+	return d.primary.GetChats()
+}
+
+// GetMediaInfo reads from SQLite
+func (d *DualMessageStore) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
+	return d.primary.GetMediaInfo(id, chatJID)
+}
+
+// dualTx records the writes of a SQLite transaction to replay them on
+// Supabase once it committed
+type dualTx struct {
+	tx     StoreTx
+	writes []func(tx StoreTx) error
+}
+
+func (t *dualTx) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	if err := t.tx.StoreChat(jid, name, lastMessageTime); err != nil {
+		return err
+	}
+	t.writes = append(t.writes, func(tx StoreTx) error { return tx.StoreChat(jid, name, lastMessageTime) })
+	return nil
+}
+
+func (t *dualTx) StoreMessage(record MessageRecord) error {
+	if err := t.tx.StoreMessage(record); err != nil {
+		return err
+	}
+	t.writes = append(t.writes, func(tx StoreTx) error { return tx.StoreMessage(record) })
+	return nil
+}
+
+// InTransaction runs fn in a SQLite transaction and replays its writes on
+// Supabase after the commit
+func (d *DualMessageStore) InTransaction(fn func(tx StoreTx) error) error {
+	var recorded *dualTx
+	err := d.primary.InTransaction(func(tx StoreTx) error {
+		// fn may be retried, keep only the writes of the last attempt
+		recorded = &dualTx{tx: tx}
+		return fn(recorded)
+	})
+	if err != nil || len(recorded.writes) == 0 {
+		return err
+	}
+	d.mirror("transaction", func() error {
+		return d.secondary.InTransaction(func(tx StoreTx) error {
+			for _, write := range recorded.writes {
+				if err := write(tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	return nil
+}
+
+// StoreProductInfo stores a product in both stores
+func (d *DualMessageStore) StoreProductInfo(id, chatJID string, product *ProductInfo) error {
+	if err := d.primary.StoreProductInfo(id, chatJID, product); err != nil {
+		return err
+	}
+	d.mirror("product of message "+id, func() error { return d.secondary.StoreProductInfo(id, chatJID, product) })
+	return nil
+}
+
+// SetChatState changes a chat's state in both stores
+func (d *DualMessageStore) SetChatState(jid string, change ChatStateChange) error {
+	if err := d.primary.SetChatState(jid, change); err != nil {
+		return err
+	}
+	d.mirror("state of chat "+jid, func() error { return d.secondary.SetChatState(jid, change) })
+	return nil
+}
+
+// StoreContacts stores contacts in both stores
+func (d *DualMessageStore) StoreContacts(contacts []ContactRecord) error {
+	if err := d.primary.StoreContacts(contacts); err != nil {
+		return err
+	}
+	d.mirror(fmt.Sprintf("%d contacts", len(contacts)), func() error { return d.secondary.StoreContacts(contacts) })
+	return nil
+}
+
+// MarkEphemeral sets a message's expiry in both stores
+func (d *DualMessageStore) MarkEphemeral(id, chatJID string, expiresAt time.Time) error {
+	if err := d.primary.MarkEphemeral(id, chatJID, expiresAt); err != nil {
+		return err
+	}
+	d.mirror("expiry of message "+id, func() error { return d.secondary.MarkEphemeral(id, chatJID, expiresAt) })
+	return nil
+}
+
+// DeleteMessage deletes a message from both stores
+func (d *DualMessageStore) DeleteMessage(id, chatJID string) error {
+	if err := d.primary.DeleteMessage(id, chatJID); err != nil {
+		return err
+	}
+	d.mirror("deletion of message "+id, func() error { return d.secondary.DeleteMessage(id, chatJID) })
+	return nil
+}
+
+// StoreEnrichment stores sentiment and language in both stores
+func (d *DualMessageStore) StoreEnrichment(id, chatJID, sentiment, language string) error {
+	if err := d.primary.StoreEnrichment(id, chatJID, sentiment, language); err != nil {
+		return err
+	}
+	d.mirror("enrichment of message "+id, func() error { return d.secondary.StoreEnrichment(id, chatJID, sentiment, language) })
+	return nil
+}
+
+// ExistingMessages checks SQLite
+func (d *DualMessageStore) ExistingMessages(chatJID string, ids []string) (map[string]bool, error) {
+	return d.primary.ExistingMessages(chatJID, ids)
+}
+
+// StoreMentions stores mentions in both stores
+func (d *DualMessageStore) StoreMentions(id, chatJID string, mentioned []string, mentionedMe bool) error {
+	if err := d.primary.StoreMentions(id, chatJID, mentioned, mentionedMe); err != nil {
+		return err
+	}
+	d.mirror("mentions of message "+id, func() error { return d.secondary.StoreMentions(id, chatJID, mentioned, mentionedMe) })
+	return nil
+}
+
+// GetMentions reads from SQLite
+func (d *DualMessageStore) GetMentions(query MessageQuery) ([]Message, error) {
+	return d.primary.GetMentions(query)
+}
+
+// StoreEdit stores an edit in both stores
+func (d *DualMessageStore) StoreEdit(id, chatJID, body string, editedAt time.Time) error {
+	if err := d.primary.StoreEdit(id, chatJID, body, editedAt); err != nil {
+		return err
+	}
+	d.mirror("edit of message "+id, func() error { return d.secondary.StoreEdit(id, chatJID, body, editedAt) })
+	return nil
+}
+
+// MarkRevoked marks a message revoked in both stores
+func (d *DualMessageStore) MarkRevoked(id, chatJID string, revokedAt time.Time) error {
+	if err := d.primary.MarkRevoked(id, chatJID, revokedAt); err != nil {
+		return err
+	}
+	d.mirror("revocation of message "+id, func() error { return d.secondary.MarkRevoked(id, chatJID, revokedAt) })
+	return nil
+}
+
+// UpdateMessageStatus updates delivery status in both stores
+func (d *DualMessageStore) UpdateMessageStatus(chatJID string, ids []string, status string) error {
+	if err := d.primary.UpdateMessageStatus(chatJID, ids, status); err != nil {
+		return err
+	}
+	d.mirror("message status in "+chatJID, func() error { return d.secondary.UpdateMessageStatus(chatJID, ids, status) })
+	return nil
+}
+
+// UpdateChatName renames a chat in both stores
+func (d *DualMessageStore) UpdateChatName(jid, name string) error {
+	if err := d.primary.UpdateChatName(jid, name); err != nil {
+		return err
+	}
+	d.mirror("name of chat "+jid, func() error { return d.secondary.UpdateChatName(jid, name) })
+	return nil
+}
+
+// ReplaceParticipants sets a group's members in both stores
+func (d *DualMessageStore) ReplaceParticipants(groupJID string, participants []ParticipantRecord) error {
+	if err := d.primary.ReplaceParticipants(groupJID, participants); err != nil {
+		return err
+	}
+	d.mirror("members of "+groupJID, func() error { return d.secondary.ReplaceParticipants(groupJID, participants) })
+	return nil
+}
+
+// AddParticipants adds group members in both stores
+func (d *DualMessageStore) AddParticipants(groupJID string, participants []ParticipantRecord) error {
+	if err := d.primary.AddParticipants(groupJID, participants); err != nil {
+		return err
+	}
+	d.mirror("members of "+groupJID, func() error { return d.secondary.AddParticipants(groupJID, participants) })
+	return nil
+}
+
+// RemoveParticipants removes group members in both stores
+func (d *DualMessageStore) RemoveParticipants(groupJID string, jids []string) error {
+	if err := d.primary.RemoveParticipants(groupJID, jids); err != nil {
+		return err
+	}
+	d.mirror("members of "+groupJID, func() error { return d.secondary.RemoveParticipants(groupJID, jids) })
+	return nil
+}
+
+// SetParticipantRole changes member roles in both stores
+func (d *DualMessageStore) SetParticipantRole(groupJID string, jids []string, role string) error {
+	if err := d.primary.SetParticipantRole(groupJID, jids, role); err != nil {
+		return err
+	}
+	d.mirror("member roles of "+groupJID, func() error { return d.secondary.SetParticipantRole(groupJID, jids, role) })
+	return nil
+}
+
+// StorePin stores a pin in both stores
+func (d *DualMessageStore) StorePin(pin PinRecord) error {
+	if err := d.primary.StorePin(pin); err != nil {
+		return err
+	}
+	d.mirror("pin of message "+pin.MessageID, func() error { return d.secondary.StorePin(pin) })
+	return nil
+}
+
+// PatchMessageMetadata merges metadata into a message in both stores
+func (d *DualMessageStore) PatchMessageMetadata(id, chatJID string, patch map[string]interface{}) error {
+	if err := d.primary.PatchMessageMetadata(id, chatJID, patch); err != nil {
+		return err
+	}
+	d.mirror("metadata of message "+id, func() error { return d.secondary.PatchMessageMetadata(id, chatJID, patch) })
+	return nil
+}
+
+// StoreQuote stores a reply's quote in both stores
+func (d *DualMessageStore) StoreQuote(id, chatJID string, quote Quote) error {
+	if err := d.primary.StoreQuote(id, chatJID, quote); err != nil {
+		return err
+	}
+	d.mirror("quote of message "+id, func() error { return d.secondary.StoreQuote(id, chatJID, quote) })
+	return nil
+}
+
+// MessagesByID reads from SQLite
+func (d *DualMessageStore) MessagesByID(chatJID string, ids []string) (map[string]Message, error) {
+	return d.primary.MessagesByID(chatJID, ids)
+}
+
+// StoreReaction stores a reaction in both stores
+func (d *DualMessageStore) StoreReaction(reaction ReactionRecord) error {
+	if err := d.primary.StoreReaction(reaction); err != nil {
+		return err
+	}
+	d.mirror("reaction to message "+reaction.MessageID, func() error { return d.secondary.StoreReaction(reaction) })
+	return nil
+}
+
+// ReactionSummaries reads from SQLite
+func (d *DualMessageStore) ReactionSummaries(chatJID string, ids []string) (map[string][]ReactionSummary, error) {
+	return d.primary.ReactionSummaries(chatJID, ids)
+}
+
+// MarkChatRead marks a chat read in both stores
+func (d *DualMessageStore) MarkChatRead(chatJID string, upTo time.Time) error {
+	if err := d.primary.MarkChatRead(chatJID, upTo); err != nil {
+		return err
+	}
+	d.mirror("read state of "+chatJID, func() error { return d.secondary.MarkChatRead(chatJID, upTo) })
+	return nil
+}
+
+// TrashChat trashes a chat in both stores, reporting SQLite's result
+func (d *DualMessageStore) TrashChat(jid string, at time.Time) (bool, error) {
+	trashed, err := d.primary.TrashChat(jid, at)
+	if err != nil {
+		return false, err
+	}
+	d.mirror("trashing of chat "+jid, func() error { _, err := d.secondary.TrashChat(jid, at); return err })
+	return trashed, nil
+}
+
+// RestoreChat restores a chat in both stores, reporting SQLite's result
+func (d *DualMessageStore) RestoreChat(jid string) (bool, error) {
+	restored, err := d.primary.RestoreChat(jid)
+	if err != nil {
+		return false, err
+	}
+	d.mirror("restoring of chat "+jid, func() error { _, err := d.secondary.RestoreChat(jid); return err })
+	return restored, nil
+}
+
+// TrashMessage trashes a message in both stores, reporting SQLite's result
+func (d *DualMessageStore) TrashMessage(id, chatJID string, at time.Time) (bool, error) {
+	trashed, err := d.primary.TrashMessage(id, chatJID, at)
+	if err != nil {
+		return false, err
+	}
+	d.mirror("trashing of message "+id, func() error { _, err := d.secondary.TrashMessage(id, chatJID, at); return err })
+	return trashed, nil
+}
+
+// RestoreMessage restores a message in both stores, reporting SQLite's
+// result
+func (d *DualMessageStore) RestoreMessage(id, chatJID string) (bool, error) {
+	restored, err := d.primary.RestoreMessage(id, chatJID)
+	if err != nil {
+		return false, err
+	}
+	d.mirror("restoring of message "+id, func() error { _, err := d.secondary.RestoreMessage(id, chatJID); return err })
+	return restored, nil
+}
+
+// PurgeTrashed purges both stores and returns the rows SQLite deleted
+func (d *DualMessageStore) PurgeTrashed(before time.Time) (int, error) {
+	deleted, err := d.primary.PurgeTrashed(before)
+	if err != nil {
+		return 0, err
+	}
+	d.mirror("purge of trashed rows", func() error { _, err := d.secondary.PurgeTrashed(before); return err })
+	return deleted, nil
+}
+
+// StoreChatSummary stores a chat summary in both stores
+func (d *DualMessageStore) StoreChatSummary(chatJID, summary string, updatedAt time.Time) error {
+	if err := d.primary.StoreChatSummary(chatJID, summary, updatedAt); err != nil {
+		return err
+	}
+	d.mirror("summary of "+chatJID, func() error { return d.secondary.StoreChatSummary(chatJID, summary, updatedAt) })
+	return nil
+}
+
+// ImportChat imports a chat into both stores, reporting whether SQLite
+// created it
+func (d *DualMessageStore) ImportChat(jid, name string) (bool, error) {
+	created, err := d.primary.ImportChat(jid, name)
+	if err != nil {
+		return false, err
+	}
+	d.mirror("import of chat "+jid, func() error { _, err := d.secondary.ImportChat(jid, name); return err })
+	return created, nil
+}
+
+// LinkPerson links a chat to a person in both stores
+func (d *DualMessageStore) LinkPerson(jid, personID string) error {
+	if err := d.primary.LinkPerson(jid, personID); err != nil {
+		return err
+	}
+	d.mirror("person of chat "+jid, func() error { return d.secondary.LinkPerson(jid, personID) })
+	return nil
+}
+
+// MergeChats merges two chats in both stores
+func (d *DualMessageStore) MergeChats(fromJID, intoJID string) error {
+	if err := d.primary.MergeChats(fromJID, intoJID); err != nil {
+		return err
+	}
+	d.mirror("merge of "+fromJID+" into "+intoJID, func() error { return d.secondary.MergeChats(fromJID, intoJID) })
+	return nil
+}
+
+// PreloadCache fills Supabase's conversation cache, SQLite has none
+func (d *DualMessageStore) PreloadCache() (int, error) {
+	return d.secondary.PreloadCache()
+}
+
+// DualWriteReport is the outcome of comparing the two stores
+type DualWriteReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Since is the start of the window of messages compared
+	Since  time.Time `json:"since"`
+	Repair bool      `json:"repair"`
+	// Chats is the number of chats with messages in the window
+	Chats                     int      `json:"chats"`
+	ChatsMissingInSQLite      []string `json:"chats_missing_in_sqlite,omitempty"`
+	ChatsMissingInSupabase    []string `json:"chats_missing_in_supabase,omitempty"`
+	MessagesMissingInSQLite   int      `json:"messages_missing_in_sqlite"`
+	MessagesMissingInSupabase int      `json:"messages_missing_in_supabase"`
+	// Repaired counts the chats and messages copied across
+	Repaired int      `json:"repaired"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// run reconciles the stores on every interval
+func (d *DualMessageStore) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.Reconcile(d.repair)
+	}
+}
+
+// LastReport returns the report of the last reconciliation, nil before the
+// first
+func (d *DualMessageStore) LastReport() *DualWriteReport {
+	d.reportMu.Lock()
+	defer d.reportMu.Unlock()
+	return d.lastReport
+}
+
+// Reconcile compares the chats of both stores and the messages of the
+// reconciliation window, and with repair copies what Supabase lacks from
+// SQLite. What only Supabase has is reported but not copied: SQLite is the
+// source of truth, and it may have deleted, expired or merged those rows
+// while the mirrored write failed.
+func (d *DualMessageStore) Reconcile(repair bool) *DualWriteReport {
+	d.reconcileMu.Lock()
+	defer d.reconcileMu.Unlock()
+
+	now := time.Now().UTC()
+	report := &DualWriteReport{StartedAt: now, Since: now.Add(-d.window), Repair: repair}
+	fail := func(format string, args ...interface{}) {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+
+	primaryChats, err := d.primary.GetChats()
+	if err != nil {
+		fail("failed to list SQLite chats: %v", err)
+	}
+	secondaryChats, err := d.secondary.GetChats()
+	if err != nil {
+		fail("failed to list Supabase chats: %v", err)
+	}
+
+	if len(report.Errors) == 0 {
+		report.ChatsMissingInSupabase = d.reconcileChats(report, primaryChats, secondaryChats, d.secondary)
+		report.ChatsMissingInSQLite = d.reconcileChats(report, secondaryChats, primaryChats, nil)
+
+		active := make(map[string]bool)
+		for _, chats := range []map[string]time.Time{primaryChats, secondaryChats} {
+			for jid, lastMessageTime := range chats {
+				if lastMessageTime.After(report.Since) {
+					active[jid] = true
+				}
+			}
+		}
+		report.Chats = len(active)
+		for jid := range active {
+			d.reconcileMessages(report, jid)
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if len(report.Errors) > 0 || report.MessagesMissingInSQLite > 0 || report.MessagesMissingInSupabase > 0 ||
+		len(report.ChatsMissingInSQLite) > 0 || len(report.ChatsMissingInSupabase) > 0 {
+		d.logger.Warnf("Dual-write reconciliation: %d chats and %d messages missing in Supabase, %d chats and %d messages missing in SQLite, %d repaired, %d errors",
+			len(report.ChatsMissingInSupabase), report.MessagesMissingInSupabase,
+			len(report.ChatsMissingInSQLite), report.MessagesMissingInSQLite, report.Repaired, len(report.Errors))
+	}
+
+	d.reportMu.Lock()
+	d.lastReport = report
+	d.reportMu.Unlock()
+	return report
+}
+
+// reconcileChats returns the chats of from missing in to, sorted, creating
+// them in target when repairing and target is not nil
+func (d *DualMessageStore) reconcileChats(report *DualWriteReport, from, to map[string]time.Time, target MessageStoreInterface) []string {
+	var missing []string
+	for jid, lastMessageTime := range from {
+		if _, ok := to[jid]; ok {
+			continue
+		}
+		missing = append(missing, jid)
+		if !report.Repair || target == nil {
+			continue
+		}
+		// The name resolver fills in the name later
+		if err := target.StoreChat(jid, "", lastMessageTime); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to copy chat %s: %v", jid, err))
+			continue
+		}
+		report.Repaired++
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// reconcileMessages compares a chat's messages in the window and copies
+// the ones Supabase lacks when repairing. Both stores window by the time
+// the message was sent in WhatsApp, but rows written before Supabase kept
+// that time have their insert time instead, so a message missing from the
+// other store's window is looked up by ID before it counts as missing.
+func (d *DualMessageStore) reconcileMessages(report *DualWriteReport, jid string) {
+	query := MessageQuery{Limit: d.limit, After: report.Since}
+	primaryMessages, err := d.primary.GetMessages(jid, query)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to read SQLite messages of %s: %v", jid, err))
+		return
+	}
+	secondaryMessages, err := d.secondary.GetMessages(jid, query)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to read Supabase messages of %s: %v", jid, err))
+		return
+	}
+
+	// A full page holds only the newest messages, so compare no further
+	// back than the oldest message of a full page
+	cutoff := report.Since
+	for _, messages := range [][]Message{primaryMessages, secondaryMessages} {
+		if len(messages) == d.limit && messages[len(messages)-1].Time.After(cutoff) {
+			cutoff = messages[len(messages)-1].Time
+		}
+	}
+
+	missingInSupabase, err := absentMessages(d.secondary, jid, missingMessages(primaryMessages, secondaryMessages, cutoff))
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to look up Supabase messages of %s: %v", jid, err))
+		return
+	}
+	missingInSQLite, err := absentMessages(d.primary, jid, missingMessages(secondaryMessages, primaryMessages, cutoff))
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to look up SQLite messages of %s: %v", jid, err))
+		return
+	}
+	report.MessagesMissingInSupabase += len(missingInSupabase)
+	report.MessagesMissingInSQLite += len(missingInSQLite)
+	if !report.Repair {
+		return
+	}
+
+	if err := copyMessages(d.primary, d.secondary, missingInSupabase); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to copy messages of %s to Supabase: %v", jid, err))
+	} else {
+		report.Repaired += len(missingInSupabase)
+	}
+}
+
+// missingMessages returns the messages of from at or after cutoff that
+// are not in to
+func missingMessages(from, to []Message, cutoff time.Time) []Message {
+	present := make(map[string]bool, len(to))
+	for _, msg := range to {
+		present[msg.ID] = true
+	}
+
+	var missing []Message
+	for _, msg := range from {
+		if !msg.Time.Before(cutoff) && !present[msg.ID] {
+			missing = append(missing, msg)
+		}
+	}
+	return missing
+}
+
+// absentMessages returns the messages a store does not have at any time
+func absentMessages(store MessageExistenceStore, chatJID string, messages []Message) ([]Message, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	existing, err := store.ExistingMessages(chatJID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var absent []Message
+	for _, msg := range messages {
+		if !existing[msg.ID] {
+			absent = append(absent, msg)
+		}
+	}
+	return absent, nil
+}
+
+// copyMessages writes messages read from one store into another as one
+// batch, with the download details of their media
+func copyMessages(from MessageStoreInterface, to BatchMessageStore, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	records := make([]MessageRecord, 0, len(messages))
+	for _, msg := range messages {
+		record := MessageRecord{
+			ID:            msg.ID,
+			ChatJID:       msg.ChatJID,
+			Sender:        msg.Sender,
+			Content:       msg.Content,
+			Timestamp:     msg.Time,
+			IsFromMe:      msg.IsFromMe,
+			MediaType:     msg.MediaType,
+			Filename:      msg.Filename,
+			QuotedID:      msg.QuotedID,
+			QuotedSender:  msg.QuotedSender,
+			QuotedSnippet: msg.QuotedSnippet,
+			Mentions:      msg.Mentions,
+			MentionedMe:   msg.MentionedMe,
+		}
+		if msg.MediaType != "" {
+			_, _, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err := from.GetMediaInfo(msg.ID, msg.ChatJID)
+			if err != nil {
+				return fmt.Errorf("failed to read media of message %s: %v", msg.ID, err)
+			}
+			record.URL = url
+			record.MediaKey = mediaKey
+			record.FileSHA256 = fileSHA256
+			record.FileEncSHA256 = fileEncSHA256
+			record.FileLength = fileLength
+		}
+		records = append(records, record)
+	}
+	return to.StoreMessages(records)
+}
+
+// DualWriteResponse represents the response for the dual-write API
+type DualWriteResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message,omitempty"`
+	Report  *DualWriteReport `json:"report,omitempty"`
+}
+
+// handleDualWrite serves /api/admin/dual-write. GET returns the report of
+// the last reconciliation, POST reconciles now, repairing unless
+// ?repair=false.
+func handleDualWrite(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, ok := messageStore.(*DualMessageStore)
+		if !ok {
+			http.Error(w, "Dual-write mode is disabled", http.StatusNotFound)
+			return
+		}
+
+		var report *DualWriteReport
+		switch r.Method {
+		case http.MethodGet:
+			report = store.LastReport()
+		case http.MethodPost:
+			repair := store.repair
+			if value := r.URL.Query().Get("repair"); value != "" {
+				repair = value == "true"
+			}
+			report = store.Reconcile(repair)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if report == nil {
+			json.NewEncoder(w).Encode(DualWriteResponse{Success: true, Message: "No reconciliation has run yet"})
+			return
+		}
+		json.NewEncoder(w).Encode(DualWriteResponse{Success: true, Report: report})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// EdgeFunctionConfig maps an event type to a Supabase Edge Function
+type EdgeFunctionConfig struct {
+	// Event is the event type to react to, or "*" for every event
+	Event string `json:"event"`
+	// Function is the Edge Function name, invoked at /functions/v1/<name>
+	Function string `json:"function"`
+	// Template is an optional Go template producing the JSON request body
+	Template string `json:"template,omitempty"`
+
+	tmpl *template.Template
+}
+
+// EdgeFunctionInvoker calls configured Edge Functions for emitted events
+type EdgeFunctionInvoker struct {
+	client    *SupabaseClient
+	functions []EdgeFunctionConfig
+	logger    waLog.Logger
+}
+
+// NewEdgeFunctionInvoker loads the EDGE_FUNCTIONS configuration, a JSON array
+// of EdgeFunctionConfig. It returns nil when nothing is configured.
+func NewEdgeFunctionInvoker(logger waLog.Logger) (*EdgeFunctionInvoker, error) {
+	raw := envString("EDGE_FUNCTIONS", "")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var functions []EdgeFunctionConfig
+	if err := json.Unmarshal([]byte(raw), &functions); err != nil {
+		return nil, fmt.Errorf("failed to parse EDGE_FUNCTIONS: %v", err)
+	}
+
+	for i := range functions {
+		fn := &functions[i]
+		if fn.Event == "" || fn.Function == "" {
+			return nil, fmt.Errorf("edge function %d needs both event and function", i)
+		}
+		if fn.Template != "" {
+			tmpl, err := parsePayloadTemplate(fn.Function, fn.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for edge function %s: %v", fn.Function, err)
+			}
+			fn.tmpl = tmpl
+		}
+	}
+
+	client, err := NewSupabaseClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &EdgeFunctionInvoker{
+		client:    client,
+		functions: functions,
+		logger:    logger,
+	}, nil
+}
+
+// HandleEvent implements EventSubscriber, invoking matching functions in the
+// background
+func (inv *EdgeFunctionInvoker) HandleEvent(evt Event) {
+	for _, fn := range inv.functions {
+		if fn.Event != "*" && fn.Event != evt.Type {
+			continue
+		}
+		go inv.invoke(fn, evt)
+	}
+}
+
+// invoke renders the payload and calls a single Edge Function
+func (inv *EdgeFunctionInvoker) invoke(fn EdgeFunctionConfig, evt Event) {
+	body, err := renderEventPayload(fn.tmpl, evt)
+	if err != nil {
+		inv.logger.Warnf("Edge function %s: %v", fn.Function, err)
+		return
+	}
+
+	if _, err := inv.client.makeServiceRequest("POST", "functions/v1/"+fn.Function, body); err != nil {
+		inv.logger.Warnf("Edge function %s failed for event %s: %v", fn.Function, evt.ID, err)
+	}
+}
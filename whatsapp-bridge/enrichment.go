@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// EnrichmentStore is implemented by message stores that can persist
+// classifier results alongside a stored message
+type EnrichmentStore interface {
+	StoreEnrichment(id, chatJID, sentiment, language string) error
+}
+
+// ClassifierRequest is the body posted to the configured classifier endpoint
+type ClassifierRequest struct {
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid"`
+	Sender    string `json:"sender"`
+	Text      string `json:"text"`
+}
+
+// ClassifierResponse is the result expected back from the classifier endpoint
+type ClassifierResponse struct {
+	Sentiment string `json:"sentiment"`
+	Language  string `json:"language"`
+}
+
+// Enricher classifies incoming messages in the background and stores the
+// detected sentiment and language with the message
+type Enricher struct {
+	url    string
+	token  string
+	client *http.Client
+	store  EnrichmentStore
+	queue  chan ClassifierRequest
+	logger waLog.Logger
+}
+
+// messageEnricher is the active enrichment stage, nil when disabled
+var messageEnricher *Enricher
+
+// NewEnricher creates the enrichment stage from environment variables. It
+// returns nil when CLASSIFIER_URL is not set or the store cannot persist the
+// results.
+func NewEnricher(messageStore MessageStoreInterface, logger waLog.Logger) *Enricher {
+	url := envString("CLASSIFIER_URL", "")
+	if url == "" {
+		return nil
+	}
+
+	store, ok := messageStore.(EnrichmentStore)
+	if !ok {
+		logger.Warnf("Message store does not support enrichment, classifier disabled")
+		return nil
+	}
+
+	e := &Enricher{
+		url:    url,
+		token:  envString("CLASSIFIER_TOKEN", ""),
+		client: &http.Client{Timeout: envDuration("CLASSIFIER_TIMEOUT", 10*time.Second)},
+		store:  store,
+		queue:  make(chan ClassifierRequest, envInt("CLASSIFIER_QUEUE_SIZE", 256)),
+		logger: logger,
+	}
+
+	for i := 0; i < max(1, envInt("CLASSIFIER_WORKERS", 2)); i++ {
+		go e.run()
+	}
+
+	return e
+}
+
+// Enqueue schedules a message for classification without blocking the
+// event handler. Messages are dropped when the queue is full.
+func (e *Enricher) Enqueue(id, chatJID, sender, text string) {
+	if e == nil || text == "" {
+		return
+	}
+
+	select {
+	case e.queue <- ClassifierRequest{MessageID: id, ChatJID: chatJID, Sender: sender, Text: text}:
+	default:
+		e.logger.Warnf("Classifier queue full, skipping message %s", id)
+	}
+}
+
+// run processes queued messages until the queue is closed
+func (e *Enricher) run() {
+	for req := range e.queue {
+		result, err := e.classify(req)
+		if err != nil {
+			e.logger.Warnf("Failed to classify message %s: %v", req.MessageID, err)
+			continue
+		}
+
+		if err := e.store.StoreEnrichment(req.MessageID, req.ChatJID, result.Sentiment, result.Language); err != nil {
+			e.logger.Warnf("Failed to store enrichment for message %s: %v", req.MessageID, err)
+		}
+
+		// The latest message's language becomes the chat's preference
+		chatLanguages.Detected(req.ChatJID, result.Language)
+	}
+}
+
+// classify posts a message to the classifier endpoint
+func (e *Enricher) classify(req ClassifierRequest) (*ClassifierResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("classifier error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var result ClassifierResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	return &result, nil
+}
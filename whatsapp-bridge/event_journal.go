@@ -0,0 +1,306 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// EventJournal durably records every emitted event so consumers that cannot
+// hold a connection open can poll and acknowledge them
+type EventJournal struct {
+	db     *sql.DB
+	logger waLog.Logger
+}
+
+// JournalEntry is a journaled event together with its sequence number
+type JournalEntry struct {
+	Seq   int64           `json:"seq"`
+	Event json.RawMessage `json:"event"`
+}
+
+// eventJournal is the active journal, nil when disabled
+var eventJournal *EventJournal
+
+// NewEventJournal creates the journal tables in the bridge database and
+// starts pruning entries older than EVENT_JOURNAL_RETENTION
+func NewEventJournal(db *sql.DB, logger waLog.Logger) (*EventJournal, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS event_journal (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			id TEXT UNIQUE,
+			type TEXT,
+			chat_jid TEXT,
+			time TIMESTAMP,
+			event TEXT
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_event_journal_time ON event_journal(time);
+		CREATE INDEX IF NOT EXISTS idx_event_journal_chat ON event_journal(chat_jid);
+
+		CREATE TABLE IF NOT EXISTS event_consumers (
+			name TEXT PRIMARY KEY,
+			acked_seq INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal tables: %v", err)
+	}
+
+	j := &EventJournal{db: db, logger: logger}
+
+	if retention := envDuration("EVENT_JOURNAL_RETENTION", 7*24*time.Hour); retention > 0 {
+		go j.prune(retention)
+	}
+
+	return j, nil
+}
+
+// HandleEvent implements EventSubscriber by appending the event to the journal
+func (j *EventJournal) HandleEvent(evt Event) {
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		j.logger.Warnf("Failed to encode event %s: %v", evt.ID, err)
+		return
+	}
+
+	_, err = j.db.Exec(
+		"INSERT OR IGNORE INTO event_journal (id, type, chat_jid, time, event) VALUES (?, ?, ?, ?, ?)",
+		evt.ID, evt.Type, evt.Chat, evt.Time, string(raw),
+	)
+	if err != nil {
+		j.logger.Warnf("Failed to journal event %s: %v", evt.ID, err)
+	}
+}
+
+// prune periodically removes journal entries older than the retention window
+func (j *EventJournal) prune(retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().UTC().Add(-retention)
+		if _, err := j.db.Exec("DELETE FROM event_journal WHERE time < ?", cutoff); err != nil {
+			j.logger.Warnf("Failed to prune event journal: %v", err)
+		}
+	}
+}
+
+// Cursor returns the last acknowledged sequence number of a consumer
+func (j *EventJournal) Cursor(consumer string) (int64, error) {
+	var seq int64
+	err := j.db.QueryRow("SELECT acked_seq FROM event_consumers WHERE name = ?", consumer).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// JournalFilter narrows down the events returned by Range. Zero fields do
+// not filter.
+type JournalFilter struct {
+	Types   []string
+	ChatJID string
+	// From and To bound the event time, inclusive and exclusive
+	From time.Time
+	To   time.Time
+}
+
+// Read returns up to limit events after the given sequence number, optionally
+// restricted to a set of event types
+func (j *EventJournal) Read(after int64, limit int, types []string) ([]JournalEntry, error) {
+	return j.Range(after, limit, JournalFilter{Types: types})
+}
+
+// Range returns up to limit events after the given sequence number that
+// match the filter, in sequence order
+func (j *EventJournal) Range(after int64, limit int, filter JournalFilter) ([]JournalEntry, error) {
+	query := "SELECT seq, event FROM event_journal WHERE seq > ?"
+	args := []interface{}{after}
+
+	if len(filter.Types) > 0 {
+		query += " AND type IN (" + strings.TrimSuffix(strings.Repeat("?,", len(filter.Types)), ",") + ")"
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+	if filter.ChatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, filter.ChatJID)
+	}
+	if !filter.From.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query += " AND time < ?"
+		args = append(args, filter.To.UTC())
+	}
+
+	query += " ORDER BY seq ASC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []JournalEntry{}
+	for rows.Next() {
+		var entry JournalEntry
+		var raw string
+		if err := rows.Scan(&entry.Seq, &raw); err != nil {
+			return nil, err
+		}
+		entry.Event = json.RawMessage(raw)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Ack moves a consumer's cursor forward. Cursors never move backwards, so
+// late or duplicate acknowledgements are harmless.
+func (j *EventJournal) Ack(consumer string, seq int64) (int64, error) {
+	_, err := j.db.Exec(`
+		INSERT INTO event_consumers (name, acked_seq, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			acked_seq = MAX(acked_seq, excluded.acked_seq),
+			updated_at = excluded.updated_at`,
+		consumer, seq, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return j.Cursor(consumer)
+}
+
+// EventPollResponse represents the response for the event poll API
+type EventPollResponse struct {
+	Success  bool           `json:"success"`
+	Message  string         `json:"message,omitempty"`
+	Consumer string         `json:"consumer"`
+	Cursor   int64          `json:"cursor"`
+	Events   []JournalEntry `json:"events"`
+}
+
+// EventAckRequest represents the request body for the event ack API
+type EventAckRequest struct {
+	Consumer string `json:"consumer"`
+	Cursor   int64  `json:"cursor"`
+}
+
+// handleEventPoll serves GET /api/events/poll?consumer=<name>. Events after
+// the consumer's acknowledged cursor are returned until they are acked, which
+// gives at-least-once delivery.
+func handleEventPoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if eventJournal == nil {
+		http.Error(w, "Event journal is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	consumer := query.Get("consumer")
+	if consumer == "" {
+		http.Error(w, "Query parameter consumer is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if limitParam := query.Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, 1000)
+	}
+
+	var types []string
+	if typesParam := query.Get("types"); typesParam != "" {
+		types = strings.Split(typesParam, ",")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	cursor, err := eventJournal.Cursor(consumer)
+	if err == nil {
+		var entries []JournalEntry
+		entries, err = eventJournal.Read(cursor, limit, types)
+		if err == nil {
+			if len(entries) > 0 {
+				cursor = entries[len(entries)-1].Seq
+			}
+			json.NewEncoder(w).Encode(EventPollResponse{
+				Success:  true,
+				Consumer: consumer,
+				Cursor:   cursor,
+				Events:   entries,
+			})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(EventPollResponse{
+		Success:  false,
+		Message:  fmt.Sprintf("Failed to read events: %v", err),
+		Consumer: consumer,
+		Events:   []JournalEntry{},
+	})
+}
+
+// handleEventAck serves POST /api/events/ack
+func handleEventAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if eventJournal == nil {
+		http.Error(w, "Event journal is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req EventAckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.Consumer == "" || req.Cursor <= 0 {
+		http.Error(w, "Consumer and a positive cursor are required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	cursor, err := eventJournal.Ack(req.Consumer, req.Cursor)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Failed to acknowledge events: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"consumer": req.Consumer,
+		"cursor":   cursor,
+	})
+}
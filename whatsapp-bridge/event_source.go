@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// EventSource stamps configured fields into the source of every event, so
+// consumers fed by several deployments can tell them apart. Values are
+// static text or templates computed per event, rendered like payload
+// templates against the event's JSON with extra hostname and env
+// functions, e.g.
+// {"deployment": "eu-1", "host": "{{hostname}}", "phone": "{{.account}}"}.
+type EventSource struct {
+	static   map[string]string
+	computed map[string]*template.Template
+}
+
+// eventSourceFuncs are the functions of computed source fields
+var eventSourceFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"hostname": func() string {
+		host, _ := os.Hostname()
+		return host
+	},
+	"env": os.Getenv,
+}
+
+// eventSource is the active event source, nil when no fields are set
+var eventSource *EventSource
+
+// NewEventSource reads the JSON object EVENT_SOURCE and the shorthands
+// EVENT_DEPLOYMENT, EVENT_ENVIRONMENT and EVENT_ACCOUNT_LABEL, which take
+// precedence. It returns nil when no fields are set.
+func NewEventSource() (*EventSource, error) {
+	values := make(map[string]string)
+	if raw := envString("EVENT_SOURCE", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			return nil, fmt.Errorf("failed to parse EVENT_SOURCE: %v", err)
+		}
+	}
+	for name, variable := range map[string]string{
+		"deployment":    "EVENT_DEPLOYMENT",
+		"environment":   "EVENT_ENVIRONMENT",
+		"account_label": "EVENT_ACCOUNT_LABEL",
+	} {
+		if value := envString(variable, ""); value != "" {
+			values[name] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	s := &EventSource{static: make(map[string]string), computed: make(map[string]*template.Template)}
+	for name, value := range values {
+		if !strings.Contains(value, "{{") {
+			s.static[name] = value
+			continue
+		}
+		tmpl, err := template.New("source." + name).Funcs(eventSourceFuncs).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event source field %s: %v", name, err)
+		}
+		s.computed[name] = tmpl
+	}
+	return s, nil
+}
+
+// fields returns the source of an event. A computed field that fails to
+// render is left out rather than dropping the event.
+func (s *EventSource) fields(evt Event) map[string]string {
+	if s == nil {
+		return nil
+	}
+
+	fields := make(map[string]string, len(s.static)+len(s.computed))
+	for name, value := range s.static {
+		fields[name] = value
+	}
+	if len(s.computed) == 0 {
+		return fields
+	}
+
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return fields
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fields
+	}
+
+	for name, tmpl := range s.computed {
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			fmt.Printf("Failed to render event source field %s: %v\n", name, err)
+			continue
+		}
+		fields[name] = out.String()
+	}
+	return fields
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"whatsapp-client/eventschema"
+)
+
+// Event types emitted by the bridge
+const (
+	EventMessageReceived     = eventschema.TypeMessageReceived
+	EventMessageSent         = eventschema.TypeMessageSent
+	EventConversationCreated = eventschema.TypeConversationCreated
+	EventMessageDelivered    = eventschema.TypeMessageDelivered
+	EventMessageRead         = eventschema.TypeMessageRead
+	EventSnoozeEnded         = eventschema.TypeSnoozeEnded
+	EventHandoff             = eventschema.TypeHandoff
+	EventMessageMentioned    = eventschema.TypeMessageMentioned
+	EventGroupDigest         = eventschema.TypeGroupDigest
+	EventWriteQueueAlarm     = eventschema.TypeWriteQueueAlarm
+)
+
+// Event is an internal notification about something that happened in the
+// bridge, fanned out to integrations such as Edge Functions. The envelope is
+// published in the eventschema package so consumers can code against it.
+type Event = eventschema.Envelope
+
+// MessageEventPayload is the payload of message.received, message.sent and
+// message.mentioned
+type MessageEventPayload = eventschema.MessagePayload
+
+// ConversationEventPayload is the payload of conversation.created
+type ConversationEventPayload = eventschema.ConversationPayload
+
+// ReceiptEventPayload is the payload of message.delivered and message.read
+type ReceiptEventPayload = eventschema.ReceiptPayload
+
+// SnoozeEventPayload is the payload of conversation.snooze_ended
+type SnoozeEventPayload = eventschema.SnoozePayload
+
+// HandoffEventPayload is the payload of conversation.handoff
+type HandoffEventPayload = eventschema.HandoffPayload
+
+// WriteQueueAlarmPayload is the payload of write_queue.alarm
+type WriteQueueAlarmPayload = eventschema.WriteQueueAlarmPayload
+
+// EventSubscriber receives every emitted event. Subscribers are called
+// synchronously from the emitting goroutine and must not block.
+type EventSubscriber func(evt Event)
+
+var (
+	eventSubscribers []EventSubscriber
+	eventAccount     string
+	eventMutex       sync.RWMutex
+)
+
+// setEventAccount records the logged in account stamped on every event
+func setEventAccount(account string) {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	eventAccount = account
+}
+
+// subscribeEvents registers a subscriber for all future events
+func subscribeEvents(subscriber EventSubscriber) {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	eventSubscribers = append(eventSubscribers, subscriber)
+}
+
+// newEventID generates a random identifier for an event
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// emitEvent builds an event and hands it to every subscriber
+func emitEvent(eventType, chatJID string, payload interface{}) {
+	eventMutex.RLock()
+	subscribers := eventSubscribers
+	account := eventAccount
+	eventMutex.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Failed to encode %s event payload: %v\n", eventType, err)
+		return
+	}
+
+	evt := Event{
+		ID:      newEventID(),
+		Type:    eventType,
+		Version: eventschema.SchemaVersion,
+		Time:    time.Now().UTC(),
+		Account: account,
+		Chat:    chatJID,
+		Payload: raw,
+	}
+	evt.Source = eventSource.fields(evt)
+
+	for _, subscriber := range subscribers {
+		subscriber(evt)
+	}
+}
+
+// parsePayloadTemplate compiles a payload template. Templates are rendered
+// against the event's JSON representation, so fields use their JSON names
+// (e.g. {{json .payload.content}}).
+func parsePayloadTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// renderEventPayload renders an event through a payload template, or as
+// plain JSON when no template is given. The result must be valid JSON.
+func renderEventPayload(tmpl *template.Template, evt Event) (json.RawMessage, error) {
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+	if tmpl == nil {
+		return raw, nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template: %v", err)
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template %s did not produce valid JSON", tmpl.Name())
+	}
+
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+// handleEventSchema serves GET /api/events/schema with the JSON Schema of the
+// event envelope
+func handleEventSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if version := r.URL.Query().Get("version"); version != "" && version != fmt.Sprint(eventschema.SchemaVersion) {
+		http.Error(w, "Unknown schema version", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("X-Event-Schema-Version", fmt.Sprint(eventschema.SchemaVersion))
+	w.Write(eventschema.JSONSchema)
+}
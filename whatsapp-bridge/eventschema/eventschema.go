@@ -0,0 +1,234 @@
+// Package eventschema defines the versioned envelope for events emitted by
+// the WhatsApp bridge to webhooks, Edge Functions and the event poll API.
+//
+// Consumers should switch on Envelope.Type and check Envelope.Version before
+// decoding the payload. Fields are only ever added within a version; removing
+// or changing a field bumps SchemaVersion.
+package eventschema
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the current version of the event envelope and payloads
+const SchemaVersion = 1
+
+// Event types
+const (
+	TypeMessageReceived     = "message.received"
+	TypeMessageSent         = "message.sent"
+	TypeConversationCreated = "conversation.created"
+	TypeMessageDelivered    = "message.delivered"
+	TypeMessageRead         = "message.read"
+	TypeSnoozeEnded         = "conversation.snooze_ended"
+	TypeHandoff             = "conversation.handoff"
+	// TypeMessageMentioned is emitted next to message.received for messages
+	// that @mention the logged in account
+	TypeMessageMentioned = "message.mentioned"
+	TypeGroupDigest      = "group.digest"
+	// TypeWriteQueueAlarm is emitted when the local queue of Supabase writes
+	// crosses its size or age threshold, and again when it is back under
+	TypeWriteQueueAlarm = "write_queue.alarm"
+)
+
+// JSONSchema is the JSON Schema document describing SchemaVersion
+//
+//go:embed schema.json
+var JSONSchema []byte
+
+// Envelope wraps every emitted event
+type Envelope struct {
+	// ID uniquely identifies the event, use it to deduplicate redeliveries
+	ID string `json:"id"`
+	// Type is one of the Type* constants
+	Type string `json:"type"`
+	// Version is the schema version the payload conforms to
+	Version int `json:"version"`
+	// Time is when the bridge emitted the event
+	Time time.Time `json:"time"`
+	// Account is the JID of the WhatsApp account the bridge is logged in as
+	Account string `json:"account,omitempty"`
+	// Chat is the JID of the chat the event relates to, if any
+	Chat string `json:"chat,omitempty"`
+	// Source holds the configured fields that tell deployments apart, such
+	// as deployment, environment and account_label
+	Source map[string]string `json:"source,omitempty"`
+	// Payload holds the type specific data
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DecodePayload unmarshals the payload into v, typically a pointer to one of
+// the payload structs in this package
+func (e *Envelope) DecodePayload(v interface{}) error {
+	if e.Version > SchemaVersion {
+		return fmt.Errorf("unsupported event schema version %d", e.Version)
+	}
+	return json.Unmarshal(e.Payload, v)
+}
+
+// MessagePayload is the payload of message.received, message.sent and
+// message.mentioned
+type MessagePayload struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	// Product is set for catalog products, orders and product inquiries
+	Product *ProductPayload `json:"product,omitempty"`
+	// MentionedMe is set when the message @mentions the logged in account
+	MentionedMe bool `json:"mentioned_me,omitempty"`
+}
+
+// Kinds of catalog related messages
+const (
+	// ProductKindProduct is a product shared from a catalog
+	ProductKindProduct = "product"
+	// ProductKindOrder is an order placed from a catalog
+	ProductKindOrder = "order"
+	// ProductKindInquiry is a message asking about a quoted product
+	ProductKindInquiry = "inquiry"
+)
+
+// ProductPayload describes the catalog item or order a message refers to.
+// Amounts are in thousandths of the currency unit.
+type ProductPayload struct {
+	// Kind is one of the ProductKind* constants
+	Kind                string `json:"kind"`
+	ProductID           string `json:"product_id,omitempty"`
+	Title               string `json:"title,omitempty"`
+	Description         string `json:"description,omitempty"`
+	CurrencyCode        string `json:"currency_code,omitempty"`
+	PriceAmount1000     int64  `json:"price_amount_1000,omitempty"`
+	SalePriceAmount1000 int64  `json:"sale_price_amount_1000,omitempty"`
+	RetailerID          string `json:"retailer_id,omitempty"`
+	URL                 string `json:"url,omitempty"`
+	BusinessOwnerJID    string `json:"business_owner_jid,omitempty"`
+	CatalogTitle        string `json:"catalog_title,omitempty"`
+	OrderID             string `json:"order_id,omitempty"`
+	OrderTitle          string `json:"order_title,omitempty"`
+	ItemCount           int32  `json:"item_count,omitempty"`
+	TotalAmount1000     int64  `json:"total_amount_1000,omitempty"`
+	TotalCurrencyCode   string `json:"total_currency_code,omitempty"`
+}
+
+// ConversationPayload is the payload of conversation.created
+type ConversationPayload struct {
+	ChatJID string `json:"chat_jid"`
+	Name    string `json:"name,omitempty"`
+}
+
+// Reasons a conversation snooze ended
+const (
+	SnoozeEndedExpired    = "expired"
+	SnoozeEndedNewMessage = "new_message"
+)
+
+// SnoozePayload is the payload of conversation.snooze_ended, a reminder to
+// look at a conversation again
+type SnoozePayload struct {
+	ChatJID      string    `json:"chat_jid"`
+	Name         string    `json:"name,omitempty"`
+	SnoozedUntil time.Time `json:"snoozed_until"`
+	// Reason is SnoozeEndedExpired or SnoozeEndedNewMessage
+	Reason string `json:"reason"`
+}
+
+// Reasons the bot handed a conversation to a human
+const (
+	// HandoffHumanReply means the owner replied from another device
+	HandoffHumanReply = "human_reply"
+	// HandoffContactRequest means the contact sent "!ai off"
+	HandoffContactRequest = "contact_request"
+	// HandoffOwnerCommand means the owner sent "!ai off" or disabled the bot
+	// through the API
+	HandoffOwnerCommand = "owner_command"
+	// HandoffResponder means the responder asked for a human
+	HandoffResponder = "responder"
+)
+
+// HandoffPayload is the payload of conversation.handoff, emitted when bot
+// mode is switched off in a chat so a human can take over
+type HandoffPayload struct {
+	ChatJID string `json:"chat_jid"`
+	// Reason is one of the Handoff* constants
+	Reason string `json:"reason"`
+	// MessageID is the message that caused the hand-off, if any
+	MessageID string    `json:"message_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GroupDigestPayload is the payload of group.digest, the activity of a group
+// over one day
+type GroupDigestPayload struct {
+	ChatJID string `json:"chat_jid"`
+	Name    string `json:"name,omitempty"`
+	// Date is the digested day as YYYY-MM-DD in the digest timezone
+	Date         string         `json:"date"`
+	MessageCount int            `json:"message_count"`
+	TopSenders   []DigestSender `json:"top_senders"`
+	// UnansweredQuestions are questions that mention or reply to the logged
+	// in account and got no message from it later that day
+	UnansweredQuestions []DigestQuestion `json:"unanswered_questions"`
+}
+
+// DigestSender is the number of messages a member sent to a group
+type DigestSender struct {
+	Sender string `json:"sender"`
+	Count  int    `json:"count"`
+}
+
+// DigestQuestion is an unanswered question in a group digest
+type DigestQuestion struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Write queue alarm states
+const (
+	AlarmRaised  = "raised"
+	AlarmCleared = "cleared"
+)
+
+// WriteQueueAlarmPayload is the payload of write_queue.alarm. Writes queue
+// up locally while Supabase is unreachable, so a growing queue is the sign
+// of an outage that would otherwise go unnoticed.
+type WriteQueueAlarmPayload struct {
+	// State is AlarmRaised or AlarmCleared
+	State string `json:"state"`
+	// Reasons lists the exceeded thresholds, "size" and "age"
+	Reasons []string `json:"reasons,omitempty"`
+	Pending int      `json:"pending"`
+	// OldestAt is when the oldest queued write was queued
+	OldestAt      *time.Time `json:"oldest_at,omitempty"`
+	MaxPending    int        `json:"max_pending,omitempty"`
+	MaxAgeSeconds int64      `json:"max_age_seconds,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+// Receipt statuses, in the order a sent message moves through them
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+)
+
+// ReceiptPayload is the payload of message.delivered and message.read. It is
+// only emitted for messages sent through the bridge API.
+type ReceiptPayload struct {
+	MessageID string    `json:"message_id"`
+	ChatJID   string    `json:"chat_jid"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	// IdempotencyKey is the key passed when the message was sent, if any
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
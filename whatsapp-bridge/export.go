@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// exportMediaTypes are the message media types included in an export
+var exportMediaTypes = []string{"image", "video", "audio", "document"}
+
+// exportPageSize is the number of messages read from the store per page
+const exportPageSize = 500
+
+// MediaExportEntry describes one media message in an export manifest
+type MediaExportEntry struct {
+	MessageID string    `json:"message_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Sender    string    `json:"sender"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type"`
+	Filename  string    `json:"filename,omitempty"`
+	Caption   string    `json:"caption,omitempty"`
+	// File is the path inside the archive, empty when the media could not
+	// be downloaded
+	File  string `json:"file,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// MediaExportManifest is written to manifest.json in every export
+type MediaExportManifest struct {
+	ChatJID    string             `json:"chat_jid"`
+	After      *time.Time         `json:"after,omitempty"`
+	Before     *time.Time         `json:"before,omitempty"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Exported   int                `json:"exported"`
+	Failed     int                `json:"failed"`
+	Media      []MediaExportEntry `json:"media"`
+}
+
+// exportMessages returns every media message of a chat in the query's date
+// range, oldest first
+func exportMessages(messageStore MessageStoreInterface, chatJID string, query MessageQuery) ([]Message, error) {
+	query.Limit = exportPageSize
+	query.MediaTypes = exportMediaTypes
+
+	var messages []Message
+	for {
+		page, err := messageStore.GetMessages(chatJID, query)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+		if len(page) < exportPageSize {
+			break
+		}
+		last := page[len(page)-1]
+		query.CursorTime, query.CursorID = last.Time, last.ID
+	}
+
+	// Pages come newest first; records read better in chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// exportFileName builds a unique, sortable archive path for a media message
+func exportFileName(msg Message) string {
+	name := path.Base(strings.ReplaceAll(msg.Filename, "\\", "/"))
+	if name == "." || name == "/" {
+		name = msg.MediaType
+	}
+	return fmt.Sprintf("media/%s_%s_%s", msg.Time.UTC().Format("20060102_150405"), msg.ID, name)
+}
+
+// addExportFile copies a downloaded media file into the archive
+func addExportFile(archive *zip.Writer, name, localPath string, modified time.Time) (int64, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	writer, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(writer, file)
+}
+
+// writeMediaExport writes a zip of every media file in the chat matching
+// the query to out, with a manifest.json describing each one. Media that is
+// not cached yet is downloaded first; media that cannot be downloaded is
+// listed in the manifest with the error. It stops early when ctx is done.
+func writeMediaExport(ctx context.Context, out io.Writer, client *whatsmeow.Client, messageStore MessageStoreInterface,
+	chatJID string, messages []Message, query MessageQuery) (*MediaExportManifest, error) {
+
+	manifest := &MediaExportManifest{
+		ChatJID:    chatJID,
+		ExportedAt: time.Now().UTC(),
+		Media:      []MediaExportEntry{},
+	}
+	if !query.After.IsZero() {
+		manifest.After = &query.After
+	}
+	if !query.Before.IsZero() {
+		manifest.Before = &query.Before
+	}
+
+	archive := zip.NewWriter(out)
+
+	for _, msg := range messages {
+		// Stop downloading once the caller has gone away
+		if ctx.Err() != nil {
+			archive.Close()
+			return nil, ctx.Err()
+		}
+
+		entry := MediaExportEntry{
+			MessageID: msg.ID,
+			Timestamp: msg.Time,
+			Sender:    msg.Sender,
+			IsFromMe:  msg.IsFromMe,
+			MediaType: msg.MediaType,
+			Filename:  msg.Filename,
+			Caption:   msg.Content,
+		}
+
+		_, _, _, localPath, err := downloadMedia(client, messageStore, msg.ID, chatJID)
+		if err == nil {
+			name := exportFileName(msg)
+			entry.Size, err = addExportFile(archive, name, localPath, msg.Time)
+			if err == nil {
+				entry.File = name
+			}
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			manifest.Failed++
+		} else {
+			manifest.Exported++
+		}
+		manifest.Media = append(manifest.Media, entry)
+	}
+
+	writer, err := archive.Create("manifest.json")
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	return manifest, archive.Close()
+}
+
+// exportArchiveName is the file name offered for a chat's media export
+func exportArchiveName(chatJID string, exportedAt time.Time) string {
+	return fmt.Sprintf("%s_media_%s.zip", strings.Split(chatJID, "@")[0], exportedAt.Format("20060102"))
+}
+
+// handleExportMedia serves GET /api/chats/export?chat_jid=<jid>, streaming a
+// zip of the chat's media as written by writeMediaExport. The after and
+// before parameters limit the date range.
+func handleExportMedia(client *whatsmeow.Client, messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Query parameter chat_jid is required", http.StatusBadRequest)
+			return
+		}
+
+		var query MessageQuery
+		if err := parseMessageFilters(r, &query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		messages, err := exportMessages(messageStore, chatJID, query)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportArchiveName(chatJID, time.Now().UTC())))
+
+		// Errors past this point can only cut the stream short
+		writeMediaExport(r.Context(), w, client, messageStore, chatJID, messages, query)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// groupInfoCache keeps group metadata, including participants and their
+// admin status, so chat names, mention resolution and permission checks do
+// not need a group info request per message. Entries are dropped when
+// WhatsApp reports a change to the group.
+var (
+	groupInfoCache = make(map[types.JID]*types.GroupInfo)
+	groupInfoMutex sync.RWMutex
+)
+
+// cacheGroupInfo stores group metadata in the cache
+func cacheGroupInfo(info *types.GroupInfo) {
+	groupInfoMutex.Lock()
+	groupInfoCache[info.JID] = info
+	groupInfoMutex.Unlock()
+}
+
+// invalidateGroupInfo drops a group from the cache so the next lookup
+// fetches fresh metadata
+func invalidateGroupInfo(jid types.JID) {
+	groupInfoMutex.Lock()
+	delete(groupInfoCache, jid)
+	groupInfoMutex.Unlock()
+}
+
+// cachedGroupInfo returns group metadata from the cache, fetching and
+// caching it on a miss
+func cachedGroupInfo(client *whatsmeow.Client, jid types.JID) (*types.GroupInfo, error) {
+	groupInfoMutex.RLock()
+	info, ok := groupInfoCache[jid]
+	groupInfoMutex.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err := client.GetGroupInfo(context.Background(), jid)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheGroupInfo(info)
+	return info, nil
+}
+
+// groupParticipant finds a member of a group by phone number or LID
+func groupParticipant(client *whatsmeow.Client, groupJID, user types.JID) (*types.GroupParticipant, error) {
+	info, err := cachedGroupInfo(client, groupJID)
+	if err != nil {
+		return nil, err
+	}
+
+	user = user.ToNonAD()
+	for i := range info.Participants {
+		participant := &info.Participants[i]
+		if participant.JID == user || participant.PhoneNumber == user || participant.LID == user {
+			return participant, nil
+		}
+	}
+	return nil, nil
+}
+
+// isGroupAdmin reports whether a user is an admin of a group
+func isGroupAdmin(client *whatsmeow.Client, groupJID, user types.JID) bool {
+	participant, err := groupParticipant(client, groupJID, user)
+	return err == nil && participant != nil && (participant.IsAdmin || participant.IsSuperAdmin)
+}
+
+// handleGroupEvent keeps the group cache in sync with membership and
+// metadata changes
+func handleGroupEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.GroupInfo:
+		// Participant, admin and name changes all invalidate the entry
+		invalidateGroupInfo(v.JID)
+
+	case *events.JoinedGroup:
+		info := v.GroupInfo
+		cacheGroupInfo(&info)
+
+	case *events.Picture:
+		// A new group icon comes with changed group metadata
+		if v.JID.Server == types.GroupServer {
+			invalidateGroupInfo(v.JID)
+		}
+	}
+}
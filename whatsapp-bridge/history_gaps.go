@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MessageExistenceStore is implemented by message stores that can check
+// which of a set of message IDs they hold
+type MessageExistenceStore interface {
+	ExistingMessages(chatJID string, ids []string) (map[string]bool, error)
+}
+
+// ExistingMessages returns which of the IDs are stored in a chat
+func (store *MessageStore) ExistingMessages(chatJID string, ids []string) (map[string]bool, error) {
+	args := []interface{}{chatJID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := store.db.Query(
+		"SELECT id FROM messages WHERE chat_jid = ? AND id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+")",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+	return existing, rows.Err()
+}
+
+// ExistingMessages returns which of the WhatsApp message IDs are stored in
+// a chat's conversation in Supabase
+func (s *SupabaseMessageStore) ExistingMessages(chatJID string, ids []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	conversationID, err := s.conversationID(chatJID)
+	if err != nil || conversationID == "" {
+		return existing, err
+	}
+	endpoint := fmt.Sprintf("messages?channel=eq.whatsapp&conversation_id=eq.%s&external_id=in.%s&select=external_id",
+		pgValue(conversationID), pgList(ids))
+	resp, err := s.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+
+	var messages []struct {
+		ExternalID string `json:"external_id"`
+	}
+	if err := json.Unmarshal(resp, &messages); err != nil {
+		return nil, fmt.Errorf("failed to parse message response: %v", err)
+	}
+
+	for _, msg := range messages {
+		existing[msg.ExternalID] = true
+	}
+	return existing, nil
+}
+
+// History gap statuses
+const (
+	// GapSuspected marks an ID seen in a quote or receipt that has not been
+	// checked against the store yet
+	GapSuspected = "suspected"
+	// GapOpen marks a message confirmed missing, being requested from the
+	// phone
+	GapOpen         = "open"
+	GapRepaired     = "repaired"
+	GapUnrepairable = "unrepairable"
+)
+
+// Sources of history gaps
+const (
+	GapSourceQuote   = "quote"
+	GapSourceReceipt = "receipt"
+)
+
+// HistoryGap is a message referenced by another message or a receipt but
+// missing from the store
+type HistoryGap struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	Source    string `json:"source"`
+	Status    string `json:"status"`
+	// AnchorID is the stored message the repair request asks for history
+	// before; receipt gaps have none and can only be repaired passively
+	AnchorID   string    `json:"anchor_id,omitempty"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// HistoryGaps records messages that other messages quote, or receipts name,
+// but the store does not hold, and asks the phone for the history around
+// them. Candidates are checked in the background after a grace period, so
+// the event handler never waits on the store and messages still being
+// written are not reported.
+type HistoryGaps struct {
+	db          *sql.DB
+	client      *whatsmeow.Client
+	store       MessageExistenceStore
+	grace       time.Duration
+	retryDelay  time.Duration
+	maxAttempts int
+	count       int
+	logger      waLog.Logger
+}
+
+// historyGaps is the active gap detector, nil when disabled
+var historyGaps *HistoryGaps
+
+// NewHistoryGaps creates the history_gaps table in the bridge database and
+// starts checking gaps every HISTORY_GAP_CHECK_INTERVAL. It returns nil
+// when HISTORY_GAPS is false or the store cannot look up messages.
+func NewHistoryGaps(db *sql.DB, client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) (*HistoryGaps, error) {
+	store, ok := messageStore.(MessageExistenceStore)
+	if !ok || !envBool("HISTORY_GAPS", true) {
+		return nil, nil
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS history_gaps (
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			status TEXT NOT NULL,
+			anchor_id TEXT NOT NULL DEFAULT '',
+			anchor_from_me BOOLEAN NOT NULL DEFAULT 0,
+			anchor_time TIMESTAMP,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			detected_at TIMESTAMP,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (chat_jid, message_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_history_gaps_status ON history_gaps(status, updated_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create history gaps table: %v", err)
+	}
+
+	g := &HistoryGaps{
+		db:          db,
+		client:      client,
+		store:       store,
+		grace:       envDuration("HISTORY_GAP_GRACE", time.Minute),
+		retryDelay:  envDuration("HISTORY_GAP_RETRY_DELAY", 10*time.Minute),
+		maxAttempts: max(envInt("HISTORY_GAP_ATTEMPTS", 3), 1),
+		count:       max(envInt("HISTORY_GAP_REQUEST_COUNT", 50), 1),
+		logger:      logger,
+	}
+	go g.run(envDuration("HISTORY_GAP_CHECK_INTERVAL", time.Minute))
+	return g, nil
+}
+
+// suspect records a possibly missing message unless it is already known
+func (g *HistoryGaps) suspect(chatJID, messageID, source string, anchor *types.MessageInfo) {
+	var anchorID string
+	var anchorFromMe bool
+	var anchorTime interface{}
+	if anchor != nil {
+		anchorID, anchorFromMe, anchorTime = anchor.ID, anchor.IsFromMe, anchor.Timestamp
+	}
+
+	now := time.Now().UTC()
+	_, err := g.db.Exec(
+		`INSERT INTO history_gaps (chat_jid, message_id, source, status, anchor_id, anchor_from_me, anchor_time, detected_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(chat_jid, message_id) DO NOTHING`,
+		chatJID, messageID, source, GapSuspected, anchorID, anchorFromMe, anchorTime, now, now,
+	)
+	if err != nil {
+		g.logger.Warnf("Failed to record history gap %s in %s: %v", messageID, chatJID, err)
+	}
+}
+
+// messageContextInfo returns the context info of the message types that
+// carry one, holding the quoted message and disappearing timer
+func messageContextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	switch {
+	case msg == nil:
+		return nil
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// HandleMessage records the message a new message quotes, with the new
+// message as the anchor to request history before
+func (g *HistoryGaps) HandleMessage(msg *events.Message) {
+	if g == nil {
+		return
+	}
+
+	quotedID := messageContextInfo(msg.Message).GetStanzaID()
+	if quotedID == "" {
+		return
+	}
+	g.suspect(msg.Info.Chat.String(), quotedID, GapSourceQuote, &msg.Info)
+}
+
+// HandleReceipt records the messages a receipt names. Messages sent
+// through the API are skipped, as their receipts do not mean history is
+// missing.
+func (g *HistoryGaps) HandleReceipt(receipt *events.Receipt) {
+	if g == nil {
+		return
+	}
+
+	for _, id := range receipt.MessageIDs {
+		if sendTracker != nil && sendTracker.Tracks(id) {
+			continue
+		}
+		g.suspect(receipt.Chat.String(), id, GapSourceReceipt, nil)
+	}
+}
+
+// run checks and repairs gaps on every interval
+func (g *HistoryGaps) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.check()
+	}
+}
+
+// check confirms suspected gaps, resolves repaired ones and requests
+// history for the rest
+func (g *HistoryGaps) check() {
+	now := time.Now().UTC()
+
+	rows, err := g.db.Query(
+		`SELECT chat_jid, message_id, status, anchor_id, anchor_from_me, anchor_time, attempts FROM history_gaps
+		WHERE (status = ? AND updated_at <= ?) OR (status = ? AND updated_at <= ?)
+		ORDER BY chat_jid LIMIT 500`,
+		GapSuspected, now.Add(-g.grace), GapOpen, now.Add(-g.retryDelay),
+	)
+	if err != nil {
+		g.logger.Warnf("Failed to read history gaps: %v", err)
+		return
+	}
+
+	type candidate struct {
+		messageID, status, anchorID string
+		anchorFromMe                bool
+		anchorTime                  sql.NullTime
+		attempts                    int
+	}
+	byChat := make(map[string][]candidate)
+	for rows.Next() {
+		var chatJID string
+		var c candidate
+		if err := rows.Scan(&chatJID, &c.messageID, &c.status, &c.anchorID, &c.anchorFromMe, &c.anchorTime, &c.attempts); err != nil {
+			g.logger.Warnf("Failed to read history gap: %v", err)
+			continue
+		}
+		byChat[chatJID] = append(byChat[chatJID], c)
+	}
+	rows.Close()
+
+	for chatJID, candidates := range byChat {
+		ids := make([]string, len(candidates))
+		for i, c := range candidates {
+			ids[i] = c.messageID
+		}
+		existing, err := g.store.ExistingMessages(chatJID, ids)
+		if err != nil {
+			g.logger.Warnf("Failed to check history gaps in %s: %v", chatJID, err)
+			continue
+		}
+
+		// One request covers every gap sharing an anchor
+		requested := make(map[string]error)
+		for _, c := range candidates {
+			switch {
+			case existing[c.messageID] && c.status == GapSuspected:
+				// Never missing after all
+				g.exec("DELETE FROM history_gaps WHERE chat_jid = ? AND message_id = ?", chatJID, c.messageID)
+			case existing[c.messageID]:
+				g.setStatus(chatJID, c.messageID, GapRepaired, c.attempts, "")
+			case c.attempts >= g.maxAttempts:
+				g.setStatus(chatJID, c.messageID, GapUnrepairable, c.attempts, fmt.Sprintf("still missing after %d attempts", c.attempts))
+			case c.anchorID == "" || !c.anchorTime.Valid:
+				// Nothing to request history before; wait for it to arrive
+				g.setStatus(chatJID, c.messageID, GapOpen, c.attempts+1, "no anchor message to request history before")
+			default:
+				err, done := requested[c.anchorID]
+				if !done {
+					err = g.request(chatJID, c.anchorID, c.anchorFromMe, c.anchorTime.Time)
+					requested[c.anchorID] = err
+				}
+				lastError := ""
+				if err != nil {
+					lastError = err.Error()
+				}
+				g.setStatus(chatJID, c.messageID, GapOpen, c.attempts+1, lastError)
+			}
+		}
+	}
+}
+
+// request asks the phone for the history before an anchor message
+func (g *HistoryGaps) request(chatJID, anchorID string, anchorFromMe bool, anchorTime time.Time) error {
+	if !g.client.IsConnected() || g.client.Store.ID == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return err
+	}
+
+	request := g.client.BuildHistorySyncRequest(&types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: anchorFromMe},
+		ID:            anchorID,
+		Timestamp:     anchorTime,
+	}, g.count)
+
+	_, err = g.client.SendMessage(context.Background(), g.client.Store.ID.ToNonAD(), request, whatsmeow.SendRequestExtra{Peer: true})
+	return err
+}
+
+// setStatus updates a gap after a check
+func (g *HistoryGaps) setStatus(chatJID, messageID, status string, attempts int, lastError string) {
+	g.exec(
+		"UPDATE history_gaps SET status = ?, attempts = ?, last_error = ?, updated_at = ? WHERE chat_jid = ? AND message_id = ?",
+		status, attempts, lastError, time.Now().UTC(), chatJID, messageID,
+	)
+}
+
+// exec runs a statement, logging failures
+func (g *HistoryGaps) exec(query string, args ...interface{}) {
+	if _, err := g.db.Exec(query, args...); err != nil {
+		g.logger.Warnf("Failed to update history gaps: %v", err)
+	}
+}
+
+// List returns confirmed gaps, newest first, optionally with one status
+func (g *HistoryGaps) List(status string, limit int) ([]HistoryGap, error) {
+	query := "SELECT chat_jid, message_id, source, status, anchor_id, attempts, last_error, detected_at, updated_at FROM history_gaps WHERE status != ?"
+	args := []interface{}{GapSuspected}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY detected_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := g.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gaps := []HistoryGap{}
+	for rows.Next() {
+		var gap HistoryGap
+		if err := rows.Scan(&gap.ChatJID, &gap.MessageID, &gap.Source, &gap.Status, &gap.AnchorID, &gap.Attempts,
+			&gap.LastError, &gap.DetectedAt, &gap.UpdatedAt); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, gap)
+	}
+	return gaps, rows.Err()
+}
+
+// HistoryGapsResponse represents the response for the history gaps API
+type HistoryGapsResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message,omitempty"`
+	Gaps    []HistoryGap `json:"gaps"`
+}
+
+// handleHistoryGaps serves GET /api/admin/gaps?status=<status>, listing
+// missing messages and whether they were repaired
+func handleHistoryGaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if historyGaps == nil {
+		http.Error(w, "History gap detection is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", GapOpen, GapRepaired, GapUnrepairable:
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseLimit(r, 100, 1000)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	gaps, err := historyGaps.List(status, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(HistoryGapsResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to list history gaps: %v", err),
+			Gaps:    []HistoryGap{},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(HistoryGapsResponse{Success: true, Gaps: gaps})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// IdentityChange is a change of a contact's encryption identity, shown in
+// WhatsApp as "security code changed"
+type IdentityChange struct {
+	JID string `json:"jid"`
+	// Device is the contact's device whose identity changed, 0 for the
+	// primary phone
+	Device    uint16    `json:"device"`
+	Timestamp time.Time `json:"timestamp"`
+	// Implicit is set when the change was noticed from an untrusted
+	// identity while decrypting, rather than announced by the server
+	Implicit   bool      `json:"implicit"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// IdentityChanges keeps an audit log of identity changes per contact in the
+// bridge database
+type IdentityChanges struct {
+	db     *sql.DB
+	logger waLog.Logger
+}
+
+// identityChanges is the active identity change log
+var identityChanges *IdentityChanges
+
+// NewIdentityChanges creates the identity_changes table in the bridge
+// database
+func NewIdentityChanges(db *sql.DB, logger waLog.Logger) (*IdentityChanges, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS identity_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			jid TEXT NOT NULL,
+			device INTEGER NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			implicit BOOLEAN NOT NULL,
+			recorded_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS identity_changes_jid_timestamp ON identity_changes (jid, timestamp);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity changes table: %v", err)
+	}
+	return &IdentityChanges{db: db, logger: logger}, nil
+}
+
+// HandleIdentityChange records an identity change event
+func (c *IdentityChanges) HandleIdentityChange(evt *events.IdentityChange) {
+	if c == nil {
+		return
+	}
+
+	timestamp := evt.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	_, err := c.db.Exec(
+		"INSERT INTO identity_changes (jid, device, timestamp, implicit, recorded_at) VALUES (?, ?, ?, ?, ?)",
+		evt.JID.ToNonAD().String(), evt.JID.Device, timestamp.UTC(), evt.Implicit, time.Now().UTC(),
+	)
+	if err != nil {
+		c.logger.Warnf("Failed to record identity change of %s: %v", evt.JID, err)
+	}
+}
+
+// List returns identity changes newest first, of one contact when jid is
+// set and from since onwards when it is not zero
+func (c *IdentityChanges) List(jid string, since time.Time, limit int) ([]IdentityChange, error) {
+	query := "SELECT jid, device, timestamp, implicit, recorded_at FROM identity_changes WHERE 1 = 1"
+	var args []interface{}
+	if jid != "" {
+		query += " AND jid = ?"
+		args = append(args, jid)
+	}
+	if !since.IsZero() {
+		query += " AND timestamp >= ?"
+		args = append(args, since.UTC())
+	}
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []IdentityChange{}
+	for rows.Next() {
+		var change IdentityChange
+		if err := rows.Scan(&change.JID, &change.Device, &change.Timestamp, &change.Implicit, &change.RecordedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// IdentityChangesResponse represents the response for the identity changes
+// API
+type IdentityChangesResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message,omitempty"`
+	Changes []IdentityChange `json:"changes"`
+}
+
+// handleIdentityChanges serves GET
+// /api/admin/identity-changes?jid=<jid>&since=<RFC3339>, the audit log of
+// contacts' security code changes
+func handleIdentityChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var jid string
+	if param := r.URL.Query().Get("jid"); param != "" {
+		parsed, err := types.ParseJID(param)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JID: %v", err), http.StatusBadRequest)
+			return
+		}
+		jid = parsed.ToNonAD().String()
+	}
+
+	var since time.Time
+	if param := r.URL.Query().Get("since"); param != "" {
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			http.Error(w, "Invalid since timestamp, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	limit, err := parseLimit(r, 100, 1000)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	changes, err := identityChanges.List(jid, since, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(IdentityChangesResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to list identity changes: %v", err),
+			Changes: []IdentityChange{},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(IdentityChangesResponse{Success: true, Changes: changes})
+}
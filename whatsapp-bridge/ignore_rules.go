@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Classes of messages that can be ignored
+const (
+	// IgnoreStatus skips status updates posted to status@broadcast
+	IgnoreStatus = "status"
+	// IgnoreBroadcast skips messages sent through broadcast lists
+	IgnoreBroadcast = "broadcast"
+	// IgnoreNewsletter skips channel posts
+	IgnoreNewsletter = "newsletter"
+	// IgnoreReaction skips emoji reactions
+	IgnoreReaction = "reaction"
+	// IgnoreProtocol skips protocol messages such as disappearing timer
+	// changes and history sync notifications. Edits and revokes have their
+	// own classes, so ignoring protocol messages keeps edit tracking.
+	IgnoreProtocol = "protocol"
+	// IgnoreEdit skips edits, leaving stored messages as first received
+	IgnoreEdit = "edit"
+	// IgnoreRevoke skips revokes, keeping messages deleted for everyone
+	IgnoreRevoke = "revoke"
+	// IgnoreSecurityCode skips "security code changed" notices, which are
+	// then not recorded in the identity change log
+	IgnoreSecurityCode = "security_code"
+	// IgnoreGroupIcon skips group icon changes
+	IgnoreGroupIcon = "group_icon"
+)
+
+// ignoreClasses lists the valid IGNORE_MESSAGES entries
+var ignoreClasses = map[string]bool{
+	IgnoreStatus:       true,
+	IgnoreBroadcast:    true,
+	IgnoreNewsletter:   true,
+	IgnoreReaction:     true,
+	IgnoreProtocol:     true,
+	IgnoreEdit:         true,
+	IgnoreRevoke:       true,
+	IgnoreSecurityCode: true,
+	IgnoreGroupIcon:    true,
+}
+
+// IgnoreRules drops noisy messages before they are stored or forwarded, so
+// the message store only holds human conversation
+type IgnoreRules struct {
+	classes map[string]bool
+	chats   map[string]bool
+}
+
+// ignoreRules is the active rule set, nil when nothing is ignored
+var ignoreRules *IgnoreRules
+
+// NewIgnoreRules reads the classes to ignore from IGNORE_MESSAGES and chats
+// to ignore entirely from IGNORE_CHATS, both comma-separated. It returns nil
+// when neither is set.
+func NewIgnoreRules() (*IgnoreRules, error) {
+	classes, chats := envList("IGNORE_MESSAGES"), envList("IGNORE_CHATS")
+	if len(classes) == 0 && len(chats) == 0 {
+		return nil, nil
+	}
+
+	r := &IgnoreRules{classes: make(map[string]bool), chats: make(map[string]bool)}
+	for _, class := range classes {
+		if !ignoreClasses[class] {
+			return nil, fmt.Errorf("unknown IGNORE_MESSAGES class %q", class)
+		}
+		r.classes[class] = true
+	}
+	for _, chat := range chats {
+		jid, err := types.ParseJID(chat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IGNORE_CHATS entry %q: %v", chat, err)
+		}
+		r.chats[jid.ToNonAD().String()] = true
+	}
+	return r, nil
+}
+
+// IgnoresChat reports whether every message of a chat is ignored
+func (r *IgnoreRules) IgnoresChat(chat types.JID) bool {
+	if r == nil {
+		return false
+	}
+
+	switch {
+	case r.chats[chat.ToNonAD().String()]:
+		return true
+	case chat == types.StatusBroadcastJID:
+		return r.classes[IgnoreStatus]
+	case chat.Server == types.BroadcastServer:
+		return r.classes[IgnoreBroadcast]
+	case chat.Server == types.NewsletterServer:
+		return r.classes[IgnoreNewsletter]
+	}
+	return false
+}
+
+// Ignores reports whether a message in a chat should be skipped
+func (r *IgnoreRules) Ignores(chat types.JID, msg *waProto.Message) bool {
+	if r == nil {
+		return false
+	}
+
+	switch {
+	case r.IgnoresChat(chat):
+		return true
+	case msg.GetReactionMessage() != nil:
+		return r.classes[IgnoreReaction]
+	case msg.GetProtocolMessage() != nil:
+		switch msg.GetProtocolMessage().GetType() {
+		case waProto.ProtocolMessage_MESSAGE_EDIT:
+			return r.classes[IgnoreEdit]
+		case waProto.ProtocolMessage_REVOKE:
+			return r.classes[IgnoreRevoke]
+		}
+		return r.classes[IgnoreProtocol]
+	}
+	return false
+}
+
+// IgnoresEvent reports whether a notification that is not a message, such
+// as a security code or group icon change, should be skipped
+func (r *IgnoreRules) IgnoresEvent(evt interface{}) bool {
+	if r == nil {
+		return false
+	}
+
+	switch v := evt.(type) {
+	case *events.IdentityChange:
+		return r.classes[IgnoreSecurityCode] || r.IgnoresChat(v.JID)
+	case *events.Picture:
+		return v.JID.Server == types.GroupServer && (r.classes[IgnoreGroupIcon] || r.IgnoresChat(v.JID))
+	}
+	return false
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/eventschema"
+)
+
+// inboxSnippetLength is the number of characters kept of the last message
+const inboxSnippetLength = 120
+
+// InboxEntry is one conversation in the inbox view
+type InboxEntry struct {
+	ChatJID       string    `json:"chat_jid"`
+	Name          string    `json:"name,omitempty"`
+	LastMessageID string    `json:"last_message_id,omitempty"`
+	Snippet       string    `json:"snippet,omitempty"`
+	LastSender    string    `json:"last_sender,omitempty"`
+	LastFromMe    bool      `json:"last_from_me"`
+	LastMessageAt time.Time `json:"last_message_at"`
+	UnreadCount   int       `json:"unread_count"`
+	Assignee      string    `json:"assignee,omitempty"`
+	Tags          []string  `json:"tags"`
+	// SnoozedUntil hides the conversation from the inbox until then
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+}
+
+// InboxProjection maintains a denormalized inbox table in the bridge
+// database, updated from the event bus, so inbox views are a single
+// indexed read instead of a join over conversations and messages
+type InboxProjection struct {
+	db     *sql.DB
+	logger waLog.Logger
+}
+
+// inboxProjection is the active projection, nil when disabled
+var inboxProjection *InboxProjection
+
+// NewInboxProjection creates the inbox table in the bridge database
+func NewInboxProjection(db *sql.DB, logger waLog.Logger) (*InboxProjection, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS inbox (
+			chat_jid TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			last_message_id TEXT NOT NULL DEFAULT '',
+			snippet TEXT NOT NULL DEFAULT '',
+			last_sender TEXT NOT NULL DEFAULT '',
+			last_from_me BOOLEAN NOT NULL DEFAULT 0,
+			last_message_at TIMESTAMP,
+			unread_count INTEGER NOT NULL DEFAULT 0,
+			assignee TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '[]'
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_inbox_last_message_at ON inbox(last_message_at DESC, chat_jid);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inbox table: %v", err)
+	}
+	if err := ensureColumn(db, "inbox", "snoozed_until", "TIMESTAMP"); err != nil {
+		return nil, fmt.Errorf("failed to migrate inbox table: %v", err)
+	}
+
+	p := &InboxProjection{db: db, logger: logger}
+	go p.wakeSnoozed(envDuration("INBOX_SNOOZE_CHECK_INTERVAL", time.Minute))
+
+	return p, nil
+}
+
+// inboxSnippet shortens message content for the inbox, describing media
+// messages without text
+func inboxSnippet(payload MessageEventPayload) string {
+	text := strings.Join(strings.Fields(payload.Content), " ")
+	if text == "" && payload.MediaType != "" {
+		text = "[" + payload.MediaType + "]"
+	}
+	if runes := []rune(text); len(runes) > inboxSnippetLength {
+		text = string(runes[:inboxSnippetLength-1]) + "…"
+	}
+	return text
+}
+
+// HandleEvent implements EventSubscriber by applying the event to the
+// projection. Inbound messages raise the unread count, an outbound message
+// means the conversation was answered and resets it.
+func (p *InboxProjection) HandleEvent(evt Event) {
+	var err error
+
+	switch evt.Type {
+	case EventConversationCreated:
+		var payload ConversationEventPayload
+		if err = evt.DecodePayload(&payload); err != nil {
+			break
+		}
+		_, err = p.db.Exec(
+			`INSERT INTO inbox (chat_jid, name) VALUES (?, ?)
+			ON CONFLICT(chat_jid) DO UPDATE SET name = CASE WHEN excluded.name != '' THEN excluded.name ELSE inbox.name END`,
+			payload.ChatJID, payload.Name,
+		)
+
+	case EventMessageReceived, EventMessageSent:
+		var payload MessageEventPayload
+		if err = evt.DecodePayload(&payload); err != nil {
+			break
+		}
+
+		// The contact writing again ends a snooze early
+		if !payload.IsFromMe {
+			p.endSnooze(payload.ChatJID, eventschema.SnoozeEndedNewMessage)
+		}
+
+		unread := 1
+		if payload.IsFromMe {
+			unread = 0
+		}
+
+		// Older messages (history, out-of-order delivery) never replace a newer
+		// last message, and an older reply does not clear newer unread messages
+		_, err = p.db.Exec(
+			`INSERT INTO inbox (chat_jid, last_message_id, snippet, last_sender, last_from_me, last_message_at, unread_count)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(chat_jid) DO UPDATE SET
+				last_message_id = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at >= inbox.last_message_at THEN excluded.last_message_id ELSE inbox.last_message_id END,
+				snippet = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at >= inbox.last_message_at THEN excluded.snippet ELSE inbox.snippet END,
+				last_sender = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at >= inbox.last_message_at THEN excluded.last_sender ELSE inbox.last_sender END,
+				last_from_me = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at >= inbox.last_message_at THEN excluded.last_from_me ELSE inbox.last_from_me END,
+				last_message_at = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at >= inbox.last_message_at THEN excluded.last_message_at ELSE inbox.last_message_at END,
+				unread_count = CASE WHEN NOT excluded.last_from_me THEN inbox.unread_count + 1 WHEN inbox.last_message_at IS NULL OR excluded.last_message_at >= inbox.last_message_at THEN 0 ELSE inbox.unread_count END`,
+			payload.ChatJID, payload.ID, inboxSnippet(payload), payload.Sender, payload.IsFromMe, payload.Timestamp.UTC(), unread,
+		)
+	}
+
+	if err != nil {
+		p.logger.Warnf("Failed to update inbox for event %s: %v", evt.ID, err)
+	}
+}
+
+// InboxQuery filters and pages the inbox
+type InboxQuery struct {
+	Limit      int
+	CursorTime time.Time
+	CursorJID  string
+	Assignee   string
+	Tag        string
+	UnreadOnly bool
+	// Snoozed lists only snoozed conversations instead of hiding them
+	Snoozed bool
+}
+
+// List returns inbox entries, most recently active first
+func (p *InboxProjection) List(query InboxQuery) ([]InboxEntry, error) {
+	sqlQuery := `SELECT chat_jid, name, last_message_id, snippet, last_sender, last_from_me, last_message_at, unread_count, assignee, tags, snoozed_until
+		FROM inbox WHERE last_message_at IS NOT NULL`
+	var args []interface{}
+
+	if !query.CursorTime.IsZero() {
+		sqlQuery += " AND (last_message_at < ? OR (last_message_at = ? AND chat_jid > ?))"
+		args = append(args, query.CursorTime.UTC(), query.CursorTime.UTC(), query.CursorJID)
+	}
+	if query.Assignee != "" {
+		sqlQuery += " AND assignee = ?"
+		args = append(args, query.Assignee)
+	}
+	if query.Tag != "" {
+		sqlQuery += " AND EXISTS (SELECT 1 FROM json_each(inbox.tags) WHERE json_each.value = ?)"
+		args = append(args, query.Tag)
+	}
+	if query.UnreadOnly {
+		sqlQuery += " AND unread_count > 0"
+	}
+	if query.Snoozed {
+		sqlQuery += " AND snoozed_until IS NOT NULL"
+	} else {
+		sqlQuery += " AND snoozed_until IS NULL"
+	}
+
+	sqlQuery += " ORDER BY last_message_at DESC, chat_jid ASC LIMIT ?"
+	args = append(args, query.Limit)
+
+	rows, err := p.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []InboxEntry{}
+	for rows.Next() {
+		var entry InboxEntry
+		var tags string
+		var snoozedUntil sql.NullTime
+		err := rows.Scan(&entry.ChatJID, &entry.Name, &entry.LastMessageID, &entry.Snippet, &entry.LastSender,
+			&entry.LastFromMe, &entry.LastMessageAt, &entry.UnreadCount, &entry.Assignee, &tags, &snoozedUntil)
+		if err != nil {
+			return nil, err
+		}
+		if snoozedUntil.Valid {
+			entry.SnoozedUntil = &snoozedUntil.Time
+		}
+		if err := json.Unmarshal([]byte(tags), &entry.Tags); err != nil || entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// update changes a single column of an inbox entry, creating it if needed
+func (p *InboxProjection) update(chatJID, column string, value interface{}) error {
+	_, err := p.db.Exec(
+		fmt.Sprintf(`INSERT INTO inbox (chat_jid, %[1]s) VALUES (?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET %[1]s = excluded.%[1]s`, column),
+		chatJID, value,
+	)
+	return err
+}
+
+// MergeChat moves the inbox entry of a chat merged into another. When both
+// have one, unread counts add up, tags are combined, the target keeps its
+// assignee unless it has none, and the later last message wins.
+func (p *InboxProjection) MergeChat(fromJID, intoJID string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO inbox (chat_jid, name, last_message_id, snippet, last_sender, last_from_me, last_message_at, unread_count, assignee, tags, snoozed_until)
+		SELECT ?, name, last_message_id, snippet, last_sender, last_from_me, last_message_at, unread_count, assignee, tags, snoozed_until
+		FROM inbox WHERE chat_jid = ?
+		ON CONFLICT(chat_jid) DO UPDATE SET
+			unread_count = inbox.unread_count + excluded.unread_count,
+			assignee = CASE WHEN inbox.assignee = '' THEN excluded.assignee ELSE inbox.assignee END,
+			tags = (SELECT json_group_array(value) FROM (SELECT value FROM json_each(inbox.tags) UNION SELECT value FROM json_each(excluded.tags))),
+			last_message_id = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at > inbox.last_message_at THEN excluded.last_message_id ELSE inbox.last_message_id END,
+			snippet = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at > inbox.last_message_at THEN excluded.snippet ELSE inbox.snippet END,
+			last_sender = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at > inbox.last_message_at THEN excluded.last_sender ELSE inbox.last_sender END,
+			last_from_me = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at > inbox.last_message_at THEN excluded.last_from_me ELSE inbox.last_from_me END,
+			last_message_at = CASE WHEN inbox.last_message_at IS NULL OR excluded.last_message_at > inbox.last_message_at THEN excluded.last_message_at ELSE inbox.last_message_at END`,
+		intoJID, fromJID,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM inbox WHERE chat_jid = ?", fromJID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Assign sets the assignee of a conversation, empty to unassign
+func (p *InboxProjection) Assign(chatJID, assignee string) error {
+	return p.update(chatJID, "assignee", assignee)
+}
+
+// SetTags replaces the tags of a conversation
+func (p *InboxProjection) SetTags(chatJID string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	encoded, _ := json.Marshal(tags)
+	return p.update(chatJID, "tags", string(encoded))
+}
+
+// Tags returns the tags of a conversation, none when it has no entry
+func (p *InboxProjection) Tags(chatJID string) ([]string, error) {
+	var encoded string
+	err := p.db.QueryRow("SELECT tags FROM inbox WHERE chat_jid = ?", chatJID).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(encoded), &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Snooze hides a conversation from the inbox until the given time, or
+// unsnoozes it when until is nil
+func (p *InboxProjection) Snooze(chatJID string, until *time.Time) error {
+	if until == nil {
+		return p.update(chatJID, "snoozed_until", nil)
+	}
+	return p.update(chatJID, "snoozed_until", until.UTC())
+}
+
+// endSnooze clears the snooze of a conversation, if any, and emits the
+// reminder event
+func (p *InboxProjection) endSnooze(chatJID, reason string) {
+	var name string
+	var until sql.NullTime
+	err := p.db.QueryRow("SELECT name, snoozed_until FROM inbox WHERE chat_jid = ?", chatJID).Scan(&name, &until)
+	if err != nil || !until.Valid {
+		return
+	}
+
+	result, err := p.db.Exec("UPDATE inbox SET snoozed_until = NULL WHERE chat_jid = ? AND snoozed_until IS NOT NULL", chatJID)
+	if err != nil {
+		p.logger.Warnf("Failed to end snooze of %s: %v", chatJID, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	// Emitted from a new goroutine as this may run inside an event subscriber
+	go emitEvent(EventSnoozeEnded, chatJID, SnoozeEventPayload{
+		ChatJID:      chatJID,
+		Name:         name,
+		SnoozedUntil: until.Time,
+		Reason:       reason,
+	})
+}
+
+// wakeSnoozed ends expired snoozes until the process exits
+func (p *InboxProjection) wakeSnoozed(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rows, err := p.db.Query("SELECT chat_jid FROM inbox WHERE snoozed_until <= ?", time.Now().UTC())
+		if err != nil {
+			p.logger.Warnf("Failed to read snoozed conversations: %v", err)
+			continue
+		}
+
+		var expired []string
+		for rows.Next() {
+			var chatJID string
+			if rows.Scan(&chatJID) == nil {
+				expired = append(expired, chatJID)
+			}
+		}
+		rows.Close()
+
+		for _, chatJID := range expired {
+			p.endSnooze(chatJID, eventschema.SnoozeEndedExpired)
+		}
+	}
+}
+
+// MarkRead resets the unread count of a conversation
+func (p *InboxProjection) MarkRead(chatJID string) error {
+	return p.update(chatJID, "unread_count", 0)
+}
+
+// InboxResponse represents the response for the inbox API
+type InboxResponse struct {
+	Success    bool         `json:"success"`
+	Message    string       `json:"message,omitempty"`
+	Entries    []InboxEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// inboxUnavailable answers inbox requests while the projection is disabled
+func inboxUnavailable(w http.ResponseWriter) bool {
+	if inboxProjection != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"message": "Inbox projection is disabled (set INBOX_PROJECTION=true)",
+	})
+	return true
+}
+
+// handleInbox serves GET /api/inbox?limit=<n>&cursor=<cursor>&assignee=<name>&tag=<tag>&unread=true&snoozed=true
+func handleInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if inboxUnavailable(w) {
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	query := InboxQuery{
+		Limit:      limit + 1,
+		Assignee:   params.Get("assignee"),
+		Tag:        params.Get("tag"),
+		UnreadOnly: params.Get("unread") == "true",
+		Snoozed:    params.Get("snoozed") == "true",
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		query.CursorTime, query.CursorJID, err = decodeCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	entries, err := inboxProjection.List(query)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(InboxResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read inbox: %v", err),
+			Entries: []InboxEntry{},
+		})
+		return
+	}
+
+	resp := InboxResponse{Success: true}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[len(entries)-1]
+		resp.NextCursor = encodeCursor(last.LastMessageAt, last.ChatJID)
+	}
+	resp.Entries = entries
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// InboxUpdateRequest represents the request body for inbox updates
+type InboxUpdateRequest struct {
+	ChatJID  string   `json:"chat_jid"`
+	Assignee string   `json:"assignee"`
+	Tags     []string `json:"tags"`
+	// Until is the RFC 3339 snooze end, omitted to unsnooze
+	Until *time.Time `json:"until"`
+}
+
+// handleInboxUpdate serves the POST /api/inbox/<action> routes that change
+// triage state on an inbox entry
+func handleInboxUpdate(apply func(req InboxUpdateRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if inboxUnavailable(w) {
+			return
+		}
+
+		var req InboxUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := apply(req); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Failed to update inbox: %v", err),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"chat_jid": req.ChatJID,
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChatSummary is a single entry of the chat list API
+type ChatSummary struct {
+	JID             string    `json:"jid"`
+	LastMessageTime time.Time `json:"last_message_time"`
+}
+
+// ListChatsResponse represents the response for the chat list API
+type ListChatsResponse struct {
+	Success    bool          `json:"success"`
+	Message    string        `json:"message,omitempty"`
+	Chats      []ChatSummary `json:"chats"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ListMessagesResponse represents the response for the message list API
+type ListMessagesResponse struct {
+	Success    bool      `json:"success"`
+	Message    string    `json:"message,omitempty"`
+	ChatJID    string    `json:"chat_jid"`
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// listCursor is the keyset position of the last item on a page. It is
+// handed to clients as an opaque base64 string.
+type listCursor struct {
+	Time int64  `json:"t"`
+	Key  string `json:"k"`
+}
+
+// encodeCursor builds an opaque cursor from a timestamp and tie-breaker key
+func encodeCursor(t time.Time, key string) string {
+	raw, _ := json.Marshal(listCursor{Time: t.UnixNano(), Key: key})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Time == 0 {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+
+	return time.Unix(0, c.Time), c.Key, nil
+}
+
+// parseLimit reads the limit query parameter, bounded by max
+func parseLimit(r *http.Request, def, max int) (int, error) {
+	limitParam := r.URL.Query().Get("limit")
+	if limitParam == "" {
+		return def, nil
+	}
+
+	parsed, err := strconv.Atoi(limitParam)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	return min(parsed, max), nil
+}
+
+// parseMessageFilters reads the after, before, direction and media_type
+// query parameters into a message query. Timestamps are RFC 3339, media
+// types a comma-separated list.
+func parseMessageFilters(r *http.Request, query *MessageQuery) error {
+	params := r.URL.Query()
+
+	for _, bound := range []struct {
+		name   string
+		target *time.Time
+	}{
+		{"after", &query.After},
+		{"before", &query.Before},
+	} {
+		value := params.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s timestamp, expected RFC 3339", bound.name)
+		}
+		*bound.target = parsed
+	}
+
+	switch direction := params.Get("direction"); direction {
+	case "", DirectionIn, DirectionOut:
+		query.Direction = direction
+	default:
+		return fmt.Errorf("Invalid direction, expected %q or %q", DirectionIn, DirectionOut)
+	}
+
+	if mediaTypes := params.Get("media_type"); mediaTypes != "" {
+		for _, mediaType := range strings.Split(mediaTypes, ",") {
+			if mediaType = strings.TrimSpace(mediaType); mediaType != "" {
+				query.MediaTypes = append(query.MediaTypes, mediaType)
+			}
+		}
+	}
+
+	if pinned := params.Get("pinned"); pinned != "" {
+		value, err := strconv.ParseBool(pinned)
+		if err != nil {
+			return fmt.Errorf("Invalid pinned, expected true or false")
+		}
+		query.Pinned = value
+	}
+
+	return nil
+}
+
+// includes reports whether the comma-separated include query parameter
+// names an option
+func includes(r *http.Request, option string) bool {
+	for _, value := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(value) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// handleListChats serves GET /api/chats?limit=<n>&cursor=<cursor>, most
+// recently active chats first
+func handleListChats(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit, err := parseLimit(r, 100, 1000)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		var cursorTime time.Time
+		var cursorJID string
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			cursorTime, cursorJID, err = decodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		chats, err := messageStore.GetChats()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ListChatsResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to list chats: %v", err),
+				Chats:   []ChatSummary{},
+			})
+			return
+		}
+
+		summaries := make([]ChatSummary, 0, len(chats))
+		for jid, lastMessageTime := range chats {
+			// Keep only chats after the cursor position in (time desc, jid asc) order
+			if !cursorTime.IsZero() {
+				if lastMessageTime.After(cursorTime) || (lastMessageTime.Equal(cursorTime) && jid <= cursorJID) {
+					continue
+				}
+			}
+			summaries = append(summaries, ChatSummary{JID: jid, LastMessageTime: lastMessageTime})
+		}
+		sort.Slice(summaries, func(i, j int) bool {
+			if !summaries[i].LastMessageTime.Equal(summaries[j].LastMessageTime) {
+				return summaries[i].LastMessageTime.After(summaries[j].LastMessageTime)
+			}
+			return summaries[i].JID < summaries[j].JID
+		})
+
+		resp := ListChatsResponse{Success: true}
+		if len(summaries) > limit {
+			summaries = summaries[:limit]
+			last := summaries[len(summaries)-1]
+			resp.NextCursor = encodeCursor(last.LastMessageTime, last.JID)
+		}
+		resp.Chats = summaries
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// handleListMessages serves GET /api/messages?chat_jid=<jid>&limit=<n>&cursor=<cursor>,
+// newest messages first, optionally filtered with after, before, direction
+// and media_type. Messages carry their reactions per emoji. With
+// include=quoted, replies also carry the message they quote.
+func handleListMessages(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Query parameter chat_jid is required", http.StatusBadRequest)
+			return
+		}
+
+		limit, err := parseLimit(r, 50, 1000)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		// Ask for one extra row to know whether another page exists
+		query := MessageQuery{Limit: limit + 1}
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			query.CursorTime, query.CursorID, err = decodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := parseMessageFilters(r, &query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		messages, err := messageStore.GetMessages(chatJID, query)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ListMessagesResponse{
+				Success:  false,
+				Message:  fmt.Sprintf("Failed to list messages: %v", err),
+				ChatJID:  chatJID,
+				Messages: []Message{},
+			})
+			return
+		}
+
+		resp := ListMessagesResponse{Success: true, ChatJID: chatJID}
+		if len(messages) > limit {
+			messages = messages[:limit]
+			last := messages[len(messages)-1]
+			resp.NextCursor = encodeCursor(last.Time, last.ID)
+		}
+		if messages == nil {
+			messages = []Message{}
+		}
+		if err := attachReactions(messageStore, chatJID, messages); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ListMessagesResponse{
+				Success:  false,
+				Message:  fmt.Sprintf("Failed to load reactions: %v", err),
+				ChatJID:  chatJID,
+				Messages: []Message{},
+			})
+			return
+		}
+		if includes(r, "quoted") {
+			if err := hydrateQuoted(messageStore, chatJID, messages); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(ListMessagesResponse{
+					Success:  false,
+					Message:  fmt.Sprintf("Failed to load quoted messages: %v", err),
+					ChatJID:  chatJID,
+					Messages: []Message{},
+				})
+				return
+			}
+		}
+		resp.Messages = messages
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
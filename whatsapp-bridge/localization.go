@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Sources of a chat's preferred language
+const (
+	// LanguageSourceDetected is set from the classifier's language of
+	// inbound messages
+	LanguageSourceDetected = "detected"
+	// LanguageSourceManual is set through the API and never overwritten by
+	// detection
+	LanguageSourceManual = "manual"
+)
+
+// normalizeLanguage lowercases a language tag and uses "-" between its
+// parts, so "pt_BR" and "pt-br" match
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+}
+
+// ChatLanguage is the preferred language of a conversation
+type ChatLanguage struct {
+	ChatJID   string    `json:"chat_jid"`
+	Language  string    `json:"language"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChatLanguages keeps the preferred language per conversation in the
+// bridge database
+type ChatLanguages struct {
+	db     *sql.DB
+	logger waLog.Logger
+}
+
+// chatLanguages is the active language store
+var chatLanguages *ChatLanguages
+
+// NewChatLanguages creates the chat_languages table in the bridge database
+func NewChatLanguages(db *sql.DB, logger waLog.Logger) (*ChatLanguages, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_languages (
+			chat_jid TEXT PRIMARY KEY,
+			language TEXT NOT NULL,
+			source TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat languages table: %v", err)
+	}
+	return &ChatLanguages{db: db, logger: logger}, nil
+}
+
+// Get returns the preferred language of a chat, nil when none is known
+func (l *ChatLanguages) Get(chatJID string) (*ChatLanguage, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	chat := ChatLanguage{ChatJID: chatJID}
+	err := l.db.QueryRow("SELECT language, source, updated_at FROM chat_languages WHERE chat_jid = ?", chatJID).
+		Scan(&chat.Language, &chat.Source, &chat.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// Set stores the preferred language of a chat, or clears it when language
+// is empty
+func (l *ChatLanguages) Set(chatJID, language, source string) error {
+	language = normalizeLanguage(language)
+	if language == "" {
+		_, err := l.db.Exec("DELETE FROM chat_languages WHERE chat_jid = ?", chatJID)
+		return err
+	}
+
+	_, err := l.db.Exec(
+		`INSERT INTO chat_languages (chat_jid, language, source, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET language = excluded.language, source = excluded.source, updated_at = excluded.updated_at`,
+		chatJID, language, source, time.Now().UTC(),
+	)
+	return err
+}
+
+// Detected records the language detected in an inbound message, unless the
+// chat's language was set manually
+func (l *ChatLanguages) Detected(chatJID, language string) {
+	language = normalizeLanguage(language)
+	if l == nil || language == "" {
+		return
+	}
+
+	_, err := l.db.Exec(
+		`INSERT INTO chat_languages (chat_jid, language, source, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET language = excluded.language, updated_at = excluded.updated_at
+		WHERE chat_languages.source != ?`,
+		chatJID, language, LanguageSourceDetected, time.Now().UTC(), LanguageSourceManual,
+	)
+	if err != nil {
+		l.logger.Warnf("Failed to store detected language of %s: %v", chatJID, err)
+	}
+}
+
+// MessageTemplates holds canned responses with a variant per language.
+// Variants are Go text/template strings rendered with the send request's
+// variables.
+type MessageTemplates struct {
+	defaultLanguage string
+
+	// supabase and table are set when templates are also loaded from a
+	// Supabase table, see Refresh
+	supabase *SupabaseClient
+	table    string
+	logger   waLog.Logger
+
+	mutex     sync.RWMutex
+	templates map[string]map[string]*template.Template
+}
+
+// messageTemplates is the active template set, nil when none are configured
+var messageTemplates *MessageTemplates
+
+// NewMessageTemplates loads templates from MESSAGE_TEMPLATES, or the file
+// named by MESSAGE_TEMPLATES_FILE, as JSON like
+// {"greeting": {"en": "Hello {{.name}}", "nl": "Hallo {{.name}}"}}, and
+// from the Supabase table named by MESSAGE_TEMPLATES_TABLE, which are
+// refreshed periodically. It returns nil when none is set.
+func NewMessageTemplates(messageStore MessageStoreInterface, logger waLog.Logger) (*MessageTemplates, error) {
+	t := &MessageTemplates{
+		defaultLanguage: normalizeLanguage(envString("TEMPLATE_DEFAULT_LANGUAGE", "en")),
+		table:           envString("MESSAGE_TEMPLATES_TABLE", ""),
+		logger:          logger,
+	}
+	if t.table != "" {
+		store, ok := supabaseStoreOf(messageStore)
+		if !ok {
+			return nil, fmt.Errorf("MESSAGE_TEMPLATES_TABLE needs the Supabase message store")
+		}
+		t.supabase = store.client
+	}
+
+	config, err := readTemplateConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil && t.supabase == nil {
+		return nil, nil
+	}
+
+	// Invalid configured templates stop the bridge; the table is loaded
+	// after them, so an outage only leaves the configured ones until the
+	// next refresh
+	if t.templates, err = parseTemplates(config); err != nil {
+		return nil, err
+	}
+	if t.supabase != nil {
+		if err := t.Refresh(); err != nil {
+			logger.Warnf("Failed to load message templates, using the configured ones: %v", err)
+		}
+		go t.refreshEvery(envDuration("MESSAGE_TEMPLATES_REFRESH_INTERVAL", 5*time.Minute))
+	}
+	return t, nil
+}
+
+// readTemplateConfig reads MESSAGE_TEMPLATES or MESSAGE_TEMPLATES_FILE, nil
+// when neither is set
+func readTemplateConfig() (map[string]map[string]string, error) {
+	raw := envString("MESSAGE_TEMPLATES", "")
+	if path := envString("MESSAGE_TEMPLATES_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MESSAGE_TEMPLATES_FILE: %v", err)
+		}
+		raw = string(data)
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	var config map[string]map[string]string
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse message templates: %v", err)
+	}
+	return config, nil
+}
+
+// parseTemplates parses the variants of each template by name and language
+func parseTemplates(config map[string]map[string]string) (map[string]map[string]*template.Template, error) {
+	templates := make(map[string]map[string]*template.Template)
+	for name, variants := range config {
+		if len(variants) == 0 {
+			return nil, fmt.Errorf("template %s has no variants", name)
+		}
+		templates[name] = make(map[string]*template.Template)
+		for language, text := range variants {
+			language = normalizeLanguage(language)
+			tmpl, err := template.New(name + "." + language).Option("missingkey=zero").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s variant of template %s: %v", language, name, err)
+			}
+			templates[name][language] = tmpl
+		}
+	}
+	return templates, nil
+}
+
+// variant picks the variant of a template for a language: the exact tag,
+// then its base language ("pt" for "pt-br"), then the default language,
+// then the first variant in tag order
+func (t *MessageTemplates) variant(variants map[string]*template.Template, language string) (string, *template.Template) {
+	candidates := []string{language}
+	if base, _, found := strings.Cut(language, "-"); found {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, t.defaultLanguage)
+
+	for _, candidate := range candidates {
+		if tmpl, ok := variants[candidate]; ok && candidate != "" {
+			return candidate, tmpl
+		}
+	}
+
+	languages := make([]string, 0, len(variants))
+	for language := range variants {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages[0], variants[languages[0]]
+}
+
+// Render renders a template in the given language, falling back as
+// described on variant. It returns the text and the language used.
+func (t *MessageTemplates) Render(name, language string, variables map[string]interface{}) (string, string, error) {
+	if t == nil {
+		return "", "", fmt.Errorf("no message templates are configured")
+	}
+
+	t.mutex.RLock()
+	variants, ok := t.templates[name]
+	t.mutex.RUnlock()
+	if !ok {
+		return "", "", fmt.Errorf("unknown template %q", name)
+	}
+
+	used, tmpl := t.variant(variants, normalizeLanguage(language))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", "", fmt.Errorf("failed to render template %s: %v", name, err)
+	}
+	return buf.String(), used, nil
+}
+
+// TemplateInfo describes a template in the templates API
+type TemplateInfo struct {
+	Name      string   `json:"name"`
+	Languages []string `json:"languages"`
+}
+
+// List returns the configured templates by name
+func (t *MessageTemplates) List() []TemplateInfo {
+	infos := []TemplateInfo{}
+	if t == nil {
+		return infos
+	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for name, variants := range t.templates {
+		info := TemplateInfo{Name: name}
+		for language := range variants {
+			info.Languages = append(info.Languages, language)
+		}
+		sort.Strings(info.Languages)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// renderForChat renders a template in the chat's preferred language unless
+// the request names one
+func renderForChat(name, chatJID, language string, variables map[string]interface{}) (string, error) {
+	if language == "" {
+		preference, err := chatLanguages.Get(chatJID)
+		if err != nil {
+			return "", fmt.Errorf("failed to read language of %s: %v", chatJID, err)
+		}
+		if preference != nil {
+			language = preference.Language
+		}
+	}
+
+	text, _, err := messageTemplates.Render(name, language, variables)
+	return text, err
+}
+
+// handleTemplates serves GET /api/templates, listing the configured
+// templates and their languages
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"templates": messageTemplates.List(),
+	})
+}
+
+// ChatLanguageRequest represents the request body for setting a chat's
+// language
+type ChatLanguageRequest struct {
+	ChatJID string `json:"chat_jid"`
+	// Language is a tag such as "en" or "pt-BR", empty to clear it
+	Language string `json:"language"`
+}
+
+// handleChatLanguage serves GET /api/chats/language?chat_jid=<jid> and
+// POST /api/chats/language {"chat_jid","language"}, which pins the language
+// so detection no longer changes it and needs the send scope
+func handleChatLanguage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chatJID := r.URL.Query().Get("chat_jid")
+	if r.Method == http.MethodPost {
+		if !requireScope(w, r, ScopeSend) {
+			return
+		}
+
+		var req ChatLanguageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		chatJID = req.ChatJID
+		if chatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+		if err := chatLanguages.Set(chatJID, req.Language, LanguageSourceManual); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to set language: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if chatJID == "" {
+		http.Error(w, "Query parameter chat_jid is required", http.StatusBadRequest)
+		return
+	}
+
+	preference, err := chatLanguages.Get(chatJID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read language: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"chat_jid": chatJID,
+		"language": preference,
+	})
+}
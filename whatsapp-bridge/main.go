@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"math"
 	"math/rand"
@@ -16,6 +17,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -25,6 +27,7 @@ import (
 	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
@@ -42,22 +45,119 @@ var (
 
 // Message represents a chat message for our client
 type Message struct {
-	Time      time.Time
-	Sender    string
-	Content   string
-	IsFromMe  bool
-	MediaType string
-	Filename  string
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Time      time.Time `json:"timestamp"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	// QuotedID is the ID of the message this one replies to
+	QuotedID string `json:"quoted_id,omitempty"`
+	// QuotedSender and QuotedSnippet describe the replied-to message as
+	// carried in the reply
+	QuotedSender  string `json:"quoted_sender,omitempty"`
+	QuotedSnippet string `json:"quoted_snippet,omitempty"`
+	// Quoted is the replied-to message, filled in on request
+	Quoted *Message `json:"quoted,omitempty"`
+	// Mentions lists the JIDs the message @mentions
+	Mentions []string `json:"mentions,omitempty"`
+	// MentionedMe is set when the message @mentions the logged in account
+	MentionedMe bool `json:"mentioned_me,omitempty"`
+	// Reactions counts the reactions to the message per emoji
+	Reactions []ReactionSummary `json:"reactions,omitempty"`
+	// PinnedAt is when the message was pinned in its chat, nil when it is
+	// not pinned. PinExpiresAt is when the pin lapses.
+	PinnedAt     *time.Time `json:"pinned_at,omitempty"`
+	PinnedBy     string     `json:"pinned_by,omitempty"`
+	PinExpiresAt *time.Time `json:"pin_expires_at,omitempty"`
 }
 
-// MessageStoreInterface defines the interface for message storage
+// MessageQuery narrows down a message listing. Messages are returned newest
+// first; when a cursor is set only messages older than the cursor position
+// (timestamp, then ID) are returned, so pages stay stable while new
+// messages arrive. The remaining fields are optional filters that stores
+// apply in their query rather than in memory.
+type MessageQuery struct {
+	Limit      int
+	CursorTime time.Time
+	CursorID   string
+
+	// After and Before bound the message timestamp (exclusive)
+	After  time.Time
+	Before time.Time
+	// Direction is "in" for received messages, "out" for sent ones
+	Direction string
+	// MediaTypes restricts results to these media types; "text" matches
+	// messages without media
+	MediaTypes []string
+	// Pinned restricts results to messages pinned in the chat whose pin
+	// has not lapsed
+	Pinned bool
+}
+
+// Message directions accepted by MessageQuery.Direction
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// MessageRecord holds the fields of a single message write
+type MessageRecord struct {
+	ID            string
+	ChatJID       string
+	Sender        string
+	Content       string
+	Timestamp     time.Time
+	IsFromMe      bool
+	MediaType     string
+	Filename      string
+	URL           string
+	MediaKey      []byte
+	FileSHA256    []byte
+	FileEncSHA256 []byte
+	FileLength    uint64
+	QuotedID      string
+	QuotedSender  string
+	QuotedSnippet string
+	Mentions      []string
+	MentionedMe   bool
+	// Product is the catalog data of a product, order or product inquiry
+	Product *ProductInfo
+	// Status is the delivery status of a message the bridge sent, and
+	// FailureReason one of the SendFailure reasons when it failed
+	Status        string
+	FailureReason string
+}
+
+// BatchMessageStore is implemented by message stores that can write many
+// messages at once, used for history sync
+type BatchMessageStore interface {
+	StoreMessages(records []MessageRecord) error
+}
+
+// MessageStoreInterface is what every message store backend implements.
+// Backends are registered by name with RegisterMessageStore. Features
+// beyond these methods are optional interfaces, such as BatchMessageStore,
+// TransactionalStore or ReactionStore, that the bridge detects with a type
+// assertion and skips for stores without them.
 type MessageStoreInterface interface {
+	// Close releases the store's connections
 	Close() error
+	// StoreChat creates a chat if needed, setting its name when not empty
+	// and moving its last message time forward
 	StoreChat(jid, name string, lastMessageTime time.Time) error
+	// StoreMessage stores a live message, updating an earlier copy
 	StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
 		mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error
-	GetMessages(chatJID string, limit int) ([]Message, error)
+	// GetMessages lists a chat's messages newest first, see MessageQuery
+	GetMessages(chatJID string, query MessageQuery) ([]Message, error)
+	// GetChats returns every chat with its last message time
 	GetChats() (map[string]time.Time, error)
+	// GetMediaInfo returns the media type, filename, URL, media key,
+	// SHA-256, encrypted SHA-256 and length needed to download a message's
+	// media
 	GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error)
 }
 
@@ -74,7 +174,7 @@ func NewMessageStore() (*MessageStore, error) {
 	}
 
 	// Open SQLite database for messages
-	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on")
+	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open message database: %v", err)
 	}
@@ -104,15 +204,106 @@ func NewMessageStore() (*MessageStore, error) {
 			PRIMARY KEY (id, chat_jid),
 			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
 		);
+
+		CREATE TABLE IF NOT EXISTS reactions (
+			message_id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			emoji TEXT NOT NULL,
+			is_from_me BOOLEAN NOT NULL,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, sender)
+		);
+
+		CREATE TABLE IF NOT EXISTS group_participants (
+			group_jid TEXT,
+			participant_jid TEXT,
+			phone_number TEXT,
+			role TEXT NOT NULL,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (group_jid, participant_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS contacts (
+			jid TEXT PRIMARY KEY,
+			first_name TEXT,
+			full_name TEXT,
+			push_name TEXT,
+			business_name TEXT,
+			updated_at TIMESTAMP
+		);
 	`)
 	if err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to create tables: %v", err)
 	}
 
+	// Add columns introduced after the initial schema
+	for _, col := range []struct{ table, name, definition string }{
+		{"messages", "sentiment", "TEXT"},
+		{"messages", "language", "TEXT"},
+		{"chats", "summary", "TEXT"},
+		{"chats", "summary_updated_at", "TIMESTAMP"},
+		{"chats", "person_id", "TEXT"},
+		{"messages", "is_read", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"messages", "expires_at", "TIMESTAMP"},
+		{"messages", "product", "TEXT"},
+		{"messages", "quoted_id", "TEXT"},
+		{"messages", "mentioned_me", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"messages", "status", "TEXT"},
+		{"messages", "edited_body", "TEXT"},
+		{"messages", "deleted_at", "TIMESTAMP"},
+		{"messages", "trashed_at", "TIMESTAMP"},
+		{"chats", "trashed_at", "TIMESTAMP"},
+		{"chats", "archived", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"chats", "pinned", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"chats", "muted", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"chats", "muted_until", "TIMESTAMP"},
+		{"messages", "quoted_sender", "TEXT"},
+		{"messages", "quoted_snippet", "TEXT"},
+		{"messages", "mentions", "TEXT"},
+		{"messages", "pinned_at", "TIMESTAMP"},
+		{"messages", "pinned_by", "TEXT"},
+		{"messages", "pin_expires_at", "TIMESTAMP"},
+		{"messages", "metadata", "TEXT"},
+		{"messages", "failure_reason", "TEXT"},
+	} {
+		if err := ensureColumn(db, col.table, col.name, col.definition); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate tables: %v", err)
+		}
+	}
+
 	return &MessageStore{db: db}, nil
 }
 
+// ensureColumn adds a column to an existing SQLite table if it is missing
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // Close the database connection
 func (store *MessageStore) Close() error {
 	return store.db.Close()
@@ -120,36 +311,229 @@ func (store *MessageStore) Close() error {
 
 // Store a chat in the database
 func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
-	_, err := store.db.Exec(
-		"INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)",
+	created, err := upsertChat(store.db, jid, name, lastMessageTime)
+	if err == nil && created {
+		emitEvent(EventConversationCreated, jid, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+	return err
+}
+
+// upsertChat stores a chat and reports whether it is new. A message newer
+// than the chat's trash restores the chat.
+func upsertChat(db sqlExecutor, jid, name string, lastMessageTime time.Time) (bool, error) {
+	var trashedAt sql.NullTime
+	err := db.QueryRow("SELECT trashed_at FROM chats WHERE jid = ?", jid).Scan(&trashedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	exists := err == nil
+
+	// Upsert rather than replace so columns such as summary and person_id
+	// survive new messages. An empty name keeps the chat's name, and an
+	// older message, such as one from history sync, keeps its last message
+	// time. Times are compared with julianday as they are stored with the
+	// zone they came in.
+	update := `name = COALESCE(NULLIF(excluded.name, ''), chats.name),
+		last_message_time = CASE WHEN chats.last_message_time IS NULL
+			OR julianday(excluded.last_message_time) > julianday(chats.last_message_time)
+			THEN excluded.last_message_time ELSE chats.last_message_time END`
+	if trashedAt.Valid && lastMessageTime.After(trashedAt.Time) {
+		update += ", trashed_at = NULL"
+	}
+	_, err = db.Exec(
+		`INSERT INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET `+update,
 		jid, name, lastMessageTime,
 	)
-	return err
+	return err == nil && !exists, err
 }
 
 // Store a message in the database
 func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
 	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
+	return insertMessage(store.db, MessageRecord{
+		ID:            id,
+		ChatJID:       chatJID,
+		Sender:        sender,
+		Content:       content,
+		Timestamp:     timestamp,
+		IsFromMe:      isFromMe,
+		MediaType:     mediaType,
+		Filename:      filename,
+		URL:           url,
+		MediaKey:      mediaKey,
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		FileLength:    fileLength,
+	})
+}
+
+// insertMessageSQL stores a message, or updates the columns a message
+// carries when it is stored again by a redelivery or history sync. State
+// added after the insert stays: the trash, pins, metadata, sentiment and
+// language, the expiry, and the status a receipt advanced. An edited
+// message keeps its edited content.
+const insertMessageSQL = `INSERT INTO messages
+		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length, quoted_id, quoted_sender, quoted_snippet, mentions, mentioned_me, product, status, failure_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))
+		ON CONFLICT(id, chat_jid) DO UPDATE SET
+		sender = excluded.sender,
+		content = CASE WHEN messages.edited_body IS NULL THEN excluded.content ELSE messages.content END,
+		timestamp = excluded.timestamp,
+		is_from_me = excluded.is_from_me,
+		media_type = excluded.media_type,
+		filename = excluded.filename,
+		url = excluded.url,
+		media_key = excluded.media_key,
+		file_sha256 = excluded.file_sha256,
+		file_enc_sha256 = excluded.file_enc_sha256,
+		file_length = excluded.file_length,
+		quoted_id = excluded.quoted_id,
+		quoted_sender = excluded.quoted_sender,
+		quoted_snippet = excluded.quoted_snippet,
+		mentions = excluded.mentions,
+		mentioned_me = excluded.mentioned_me,
+		product = COALESCE(excluded.product, messages.product),
+		status = COALESCE(messages.status, excluded.status),
+		failure_reason = COALESCE(messages.failure_reason, excluded.failure_reason)`
+
+// insertMessage stores a message unless it has neither content nor media
+func insertMessage(db sqlExecutor, r MessageRecord) error {
 	// Only store if there's actual content or media
-	if content == "" && mediaType == "" {
+	if r.Content == "" && r.MediaType == "" {
 		return nil
 	}
 
-	_, err := store.db.Exec(
-		`INSERT OR REPLACE INTO messages 
-		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
+	var mentions, product interface{}
+	if len(r.Mentions) > 0 {
+		encoded, err := json.Marshal(r.Mentions)
+		if err != nil {
+			return err
+		}
+		mentions = string(encoded)
+	}
+	if r.Product != nil {
+		encoded, err := json.Marshal(r.Product)
+		if err != nil {
+			return err
+		}
+		product = string(encoded)
+	}
+
+	_, err := db.Exec(insertMessageSQL,
+		r.ID, r.ChatJID, r.Sender, r.Content, r.Timestamp, r.IsFromMe, r.MediaType, r.Filename, r.URL,
+		r.MediaKey, r.FileSHA256, r.FileEncSHA256, r.FileLength, r.QuotedID, r.QuotedSender, r.QuotedSnippet, mentions, r.MentionedMe, product,
+		r.Status, r.FailureReason,
 	)
 	return err
 }
 
+// Store several messages in one transaction
+func (store *MessageStore) StoreMessages(records []MessageRecord) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertMessageSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		// Only store if there's actual content or media
+		if r.Content == "" && r.MediaType == "" {
+			continue
+		}
+		var mentions, product interface{}
+		if len(r.Mentions) > 0 {
+			encoded, err := json.Marshal(r.Mentions)
+			if err != nil {
+				return err
+			}
+			mentions = string(encoded)
+		}
+		if r.Product != nil {
+			encoded, err := json.Marshal(r.Product)
+			if err != nil {
+				return err
+			}
+			product = string(encoded)
+		}
+		_, err := stmt.Exec(r.ID, r.ChatJID, r.Sender, r.Content, r.Timestamp, r.IsFromMe, r.MediaType, r.Filename, r.URL,
+			r.MediaKey, r.FileSHA256, r.FileEncSHA256, r.FileLength, r.QuotedID, r.QuotedSender, r.QuotedSnippet, mentions, r.MentionedMe,
+			product, r.Status, r.FailureReason)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // Get messages from a chat
-func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, error) {
-	rows, err := store.db.Query(
-		"SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?",
-		chatJID, limit,
+func (store *MessageStore) GetMessages(chatJID string, query MessageQuery) ([]Message, error) {
+	sqlQuery, args := appendMessageFilters(
+		"SELECT "+messageColumns+" FROM messages WHERE chat_jid = ?",
+		[]interface{}{chatJID}, query,
 	)
+	return store.queryMessages(sqlQuery, args...)
+}
+
+// appendMessageFilters adds the cursor, filters, order and limit of a
+// message query to a SELECT on the messages table, leaving out trashed
+// messages. Times are compared with julianday, as timestamps are stored
+// with the zone they came in and would compare wrongly as text.
+func appendMessageFilters(sqlQuery string, args []interface{}, query MessageQuery) (string, []interface{}) {
+	sqlQuery += " AND trashed_at IS NULL"
+	if !query.CursorTime.IsZero() {
+		sqlQuery += " AND (julianday(timestamp) < julianday(?) OR (julianday(timestamp) = julianday(?) AND id < ?))"
+		args = append(args, query.CursorTime, query.CursorTime, query.CursorID)
+	}
+	if !query.After.IsZero() {
+		sqlQuery += " AND julianday(timestamp) > julianday(?)"
+		args = append(args, query.After)
+	}
+	if !query.Before.IsZero() {
+		sqlQuery += " AND julianday(timestamp) < julianday(?)"
+		args = append(args, query.Before)
+	}
+	switch query.Direction {
+	case DirectionIn:
+		sqlQuery += " AND is_from_me = 0"
+	case DirectionOut:
+		sqlQuery += " AND is_from_me = 1"
+	}
+	if len(query.MediaTypes) > 0 {
+		placeholders := make([]string, len(query.MediaTypes))
+		for i, mediaType := range query.MediaTypes {
+			placeholders[i] = "?"
+			if mediaType == "text" {
+				mediaType = ""
+			}
+			args = append(args, mediaType)
+		}
+		sqlQuery += " AND COALESCE(media_type, '') IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if query.Pinned {
+		sqlQuery += " AND pinned_at IS NOT NULL AND (pin_expires_at IS NULL OR pin_expires_at > ?)"
+		args = append(args, time.Now().UTC())
+	}
+
+	sqlQuery += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, query.Limit)
+	return sqlQuery, args
+}
+
+// messageColumns is the select list scanned by queryMessages
+const messageColumns = "id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, COALESCE(quoted_id, ''), " +
+	"COALESCE(quoted_sender, ''), COALESCE(quoted_snippet, ''), mentions, mentioned_me, pinned_at, COALESCE(pinned_by, ''), pin_expires_at"
+
+// queryMessages scans the rows of a SELECT of messageColumns
+func (store *MessageStore) queryMessages(sqlQuery string, args ...interface{}) ([]Message, error) {
+	rows, err := store.db.Query(sqlQuery, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -159,10 +543,24 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 	for rows.Next() {
 		var msg Message
 		var timestamp time.Time
-		err := rows.Scan(&msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename)
+		var mentions sql.NullString
+		var pinnedAt, pinExpiresAt sql.NullTime
+		err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename,
+			&msg.QuotedID, &msg.QuotedSender, &msg.QuotedSnippet, &mentions, &msg.MentionedMe, &pinnedAt, &msg.PinnedBy, &pinExpiresAt)
 		if err != nil {
 			return nil, err
 		}
+		if mentions.Valid {
+			if err := json.Unmarshal([]byte(mentions.String), &msg.Mentions); err != nil {
+				return nil, err
+			}
+		}
+		if pinnedAt.Valid {
+			msg.PinnedAt = &pinnedAt.Time
+		}
+		if pinExpiresAt.Valid {
+			msg.PinExpiresAt = &pinExpiresAt.Time
+		}
 		msg.Time = timestamp
 		messages = append(messages, msg)
 	}
@@ -170,9 +568,27 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 	return messages, nil
 }
 
+// Store classifier results for a message
+func (store *MessageStore) StoreEnrichment(id, chatJID, sentiment, language string) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET sentiment = ?, language = ? WHERE id = ? AND chat_jid = ?",
+		sentiment, language, id, chatJID,
+	)
+	return err
+}
+
+// Store a conversation summary on the chat
+func (store *MessageStore) StoreChatSummary(chatJID, summary string, updatedAt time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE chats SET summary = ?, summary_updated_at = ? WHERE jid = ?",
+		summary, updatedAt, chatJID,
+	)
+	return err
+}
+
 // Get all chats
 func (store *MessageStore) GetChats() (map[string]time.Time, error) {
-	rows, err := store.db.Query("SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC")
+	rows, err := store.db.Query("SELECT jid, last_message_time FROM chats WHERE trashed_at IS NULL ORDER BY last_message_time DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -205,14 +621,27 @@ func extractTextContent(msg *waProto.Message) string {
 		return extendedText.GetText()
 	}
 
+	// Catalog products and orders are described by their text or title
+	if text := productText(msg); text != "" {
+		return text
+	}
+
 	// For now, we're ignoring non-text messages
 	return ""
 }
 
 // SendMessageResponse represents the response for the send message API
 type SendMessageResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	MessageID string            `json:"message_id,omitempty"`
+	HeldUntil *time.Time        `json:"held_until,omitempty"`
+	Errors    []ValidationError `json:"errors,omitempty"`
+	// FailureReason is one of the SendFailure reasons when the send failed
+	FailureReason string `json:"failure_reason,omitempty"`
+	// Status is the stored SendStatus of a message sent earlier with the
+	// same idempotency key
+	Status string `json:"status,omitempty"`
 }
 
 // SendMessageRequest represents the request body for the send message API
@@ -220,12 +649,23 @@ type SendMessageRequest struct {
 	Recipient string `json:"recipient"`
 	Message   string `json:"message"`
 	MediaPath string `json:"media_path,omitempty"`
+	// IdempotencyKey deduplicates retried sends and is echoed in receipt
+	// events; the Idempotency-Key header may be used instead
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Urgent bypasses quiet hours
+	Urgent bool `json:"urgent,omitempty"`
+	// Template names a canned response sent instead of Message, rendered
+	// with Variables in the chat's language unless Language is given
+	Template  string                 `json:"template,omitempty"`
+	Language  string                 `json:"language,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
 }
 
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string) {
+// Function to send a WhatsApp message. On failure it also returns one of
+// the SendFailure reasons.
+func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string, messageID types.MessageID) (bool, string, string) {
 	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
+		return false, "Not connected to WhatsApp", SendFailureNotConnected
 	}
 
 	// Create JID for recipient
@@ -239,24 +679,49 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		// Parse the JID string
 		recipientJID, err = types.ParseJID(recipient)
 		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
+			return false, fmt.Sprintf("Error parsing JID: %v", err), SendFailureInvalidRecipient
 		}
 	} else {
 		// Create JID from phone number
 		recipientJID = types.JID{
-			User:   recipient,
+			User:   normalizePhone(recipient),
 			Server: "s.whatsapp.net", // For personal chats
 		}
 	}
 
+	msg, result, reason := buildWhatsAppMessage(client, recipientJID, message, mediaPath)
+	if msg == nil {
+		return false, result, reason
+	}
+
+	if ok, result, reason := deliverWhatsAppMessage(client, recipientJID, msg, message, messageID); !ok {
+		return false, result, reason
+	}
+
+	return true, fmt.Sprintf("Message sent to %s", recipient), ""
+}
+
+// buildWhatsAppMessage builds the message for a text or a media file,
+// uploading the media. On failure it returns a nil message, the error and
+// one of the SendFailure reasons.
+func buildWhatsAppMessage(client *whatsmeow.Client, recipientJID types.JID, message string, mediaPath string) (*waProto.Message, string, string) {
 	msg := &waProto.Message{}
 
 	// Check if we have media to send
 	if mediaPath != "" {
-		// Read media file
-		mediaData, err := os.ReadFile(mediaPath)
+		// Open the media file; it is streamed to the uploader, not read into memory
+		mediaFile, err := os.Open(mediaPath)
 		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
+			return nil, fmt.Sprintf("Error reading media file: %v", err), SendFailureMediaUnreadable
+		}
+		defer mediaFile.Close()
+
+		info, err := mediaFile.Stat()
+		if err != nil {
+			return nil, fmt.Sprintf("Error reading media file: %v", err), SendFailureMediaUnreadable
+		}
+		if maxSize := mediaMaxUploadBytes(); info.Size() > maxSize {
+			return nil, fmt.Sprintf("Media file is %d bytes, larger than the %d byte limit", info.Size(), maxSize), SendFailureTooLarge
 		}
 
 		// Determine media type and mime type based on file extension
@@ -302,10 +767,14 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 			mimeType = "application/octet-stream"
 		}
 
-		// Upload media to WhatsApp servers
-		resp, err := client.Upload(context.Background(), mediaData, mediaType)
+		// Upload media to WhatsApp servers, encrypting through a temporary file
+		resp, err := client.UploadReader(context.Background(), mediaFile, nil, mediaType)
 		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
+			reason := classifySendError(client, recipientJID, err)
+			if reason == SendFailureUnknown {
+				reason = SendFailureMediaUpload
+			}
+			return nil, fmt.Sprintf("Error uploading media: %v", err), reason
 		}
 
 		fmt.Println("Media uploaded", resp)
@@ -330,12 +799,17 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 
 			// Try to analyze the ogg file
 			if strings.Contains(mimeType, "ogg") {
+				// Voice notes are small, the analyzer works on the whole file
+				mediaData, err := os.ReadFile(mediaPath)
+				if err != nil {
+					return nil, fmt.Sprintf("Error reading media file: %v", err), SendFailureMediaUnreadable
+				}
 				analyzedSeconds, analyzedWaveform, err := analyzeOggOpus(mediaData)
 				if err == nil {
 					seconds = analyzedSeconds
 					waveform = analyzedWaveform
 				} else {
-					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)
+					return nil, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err), SendFailureMediaUnreadable
 				}
 			} else {
 				fmt.Printf("Not an Ogg Opus file: %s\n", mimeType)
@@ -381,14 +855,35 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		msg.Conversation = proto.String(message)
 	}
 
+	return msg, "", ""
+}
+
+// deliverWhatsAppMessage sends a built message within the warm-up limit and
+// the sending cadence. text is the message's text, which sets how long the
+// cadence shows the account typing.
+func deliverWhatsAppMessage(client *whatsmeow.Client, recipientJID types.JID, msg *waProto.Message, text string, messageID types.MessageID) (bool, string, string) {
+	// Freshly linked numbers may only send so many messages a day
+	allowed, limit, err := accountWarmup.Reserve(client, recipientJID)
+	if err != nil {
+		return false, fmt.Sprintf("Failed to check warm-up limit: %v", err), SendFailureUnknown
+	}
+	if !allowed {
+		return false, fmt.Sprintf("Warm-up limit of %d messages for today reached", limit), SendFailureWarmupLimit
+	}
+
+	// Space out follow-up messages to the same recipient like a person typing
+	done := sendingCadence.Begin(client, recipientJID, text)
+	defer done()
+
 	// Send message
-	_, err = client.SendMessage(context.Background(), recipientJID, msg)
+	_, err = client.SendMessage(context.Background(), recipientJID, msg, whatsmeow.SendRequestExtra{ID: messageID})
 
 	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err)
+		accountWarmup.Release(client, recipientJID)
+		return false, fmt.Sprintf("Error sending message: %v", err), classifySendError(client, recipientJID, err)
 	}
 
-	return true, fmt.Sprintf("Message sent to %s", recipient)
+	return true, fmt.Sprintf("Message sent to %s", recipientJID), ""
 }
 
 // Extract media info from a message
@@ -428,8 +923,9 @@ func extractMediaInfo(msg *waProto.Message) (mediaType string, filename string,
 	return "", "", "", nil, nil, nil, 0
 }
 
-// Handle regular incoming messages with media support
-func handleMessage(client *whatsmeow.Client, messageStore MessageStoreInterface, msg *events.Message, logger waLog.Logger) {
+// handleMessage stores a message and notifies subscribers. It reports
+// whether the message was stored, or had nothing to store.
+func handleMessage(client *whatsmeow.Client, messageStore MessageStoreInterface, msg *events.Message, logger waLog.Logger) bool {
 	// Save message to database
 	chatJID := msg.Info.Chat.String()
 	sender := msg.Info.Sender.User
@@ -437,57 +933,145 @@ func handleMessage(client *whatsmeow.Client, messageStore MessageStoreInterface,
 	// Get appropriate chat name (pass nil for conversation since we don't have one for regular messages)
 	name := GetChatName(client, messageStore, msg.Info.Chat, chatJID, nil, sender, logger)
 
-	// Update chat in database with the message timestamp (keeps last message time updated)
-	err := messageStore.StoreChat(chatJID, name, msg.Info.Timestamp)
-	if err != nil {
-		logger.Warnf("Failed to store chat: %v", err)
-	}
-
 	// Extract text content
 	content := extractTextContent(msg.Message)
 
 	// Extract media info
 	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg.Message)
 
-	// Skip if there's no content and no media
-	if content == "" && mediaType == "" {
-		return
+	hasContent := content != "" || mediaType != ""
+	quote := quotedMessage(msg.Message)
+	record := MessageRecord{
+		ID:            msg.Info.ID,
+		ChatJID:       chatJID,
+		Sender:        sender,
+		Content:       content,
+		Timestamp:     msg.Info.Timestamp,
+		IsFromMe:      msg.Info.IsFromMe,
+		MediaType:     mediaType,
+		Filename:      filename,
+		URL:           url,
+		MediaKey:      mediaKey,
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		FileLength:    fileLength,
+		QuotedID:      quote.ID,
+		QuotedSender:  quote.Sender,
+		QuotedSnippet: quote.Snippet,
+		Mentions:      mentionedJIDs(msg.Message),
+		MentionedMe:   !msg.Info.IsFromMe && mentionsMe(client, msg.Message),
+		// Keep catalog data of products, orders and product inquiries
+		Product: extractProductInfo(msg.Message),
 	}
 
-	// Store message in database
-	err = messageStore.StoreMessage(
-		msg.Info.ID,
-		chatJID,
-		sender,
-		content,
-		msg.Info.Timestamp,
-		msg.Info.IsFromMe,
-		mediaType,
-		filename,
-		url,
-		mediaKey,
-		fileSHA256,
-		fileEncSHA256,
-		fileLength,
-	)
-
-	if err != nil {
-		logger.Warnf("Failed to store message: %v", err)
+	var err error
+	if txStore, ok := messageStore.(TransactionalStore); ok {
+		// Store the message and the chat update it implies as one unit
+		err = txStore.InTransaction(func(tx StoreTx) error {
+			if err := tx.StoreChat(chatJID, name, msg.Info.Timestamp); err != nil {
+				return err
+			}
+			if !hasContent {
+				return nil
+			}
+			return tx.StoreMessage(record)
+		})
+		if err != nil && !hasContent {
+			logger.Warnf("Failed to store chat: %v", err)
+		}
 	} else {
-		// Log message reception
-		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
-		direction := "←"
-		if msg.Info.IsFromMe {
-			direction = "→"
+		// Update chat in database with the message timestamp (keeps last message time updated)
+		if err := messageStore.StoreChat(chatJID, name, msg.Info.Timestamp); err != nil {
+			logger.Warnf("Failed to store chat: %v", err)
 		}
 
-		// Log based on message type
-		if mediaType != "" {
-			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, mediaType, filename, content)
-		} else if content != "" {
-			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+		// Store message in database
+		if hasContent {
+			err = storeMessageRecord(messageStore, record)
 		}
 	}
+
+	// Skip if there's no content and no media
+	if !hasContent {
+		return true
+	}
+
+	if err != nil {
+		logger.Warnf("Failed to store message: %v", err)
+		return false
+	}
+
+	// Log message reception
+	timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
+	direction := "←"
+	if msg.Info.IsFromMe {
+		direction = "→"
+	}
+
+	// Log based on message type
+	if mediaType != "" {
+		fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, mediaType, filename, content)
+	} else if content != "" {
+		fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+	}
+
+	// Notify event subscribers
+	eventType := EventMessageReceived
+	if msg.Info.IsFromMe {
+		eventType = EventMessageSent
+	}
+	payload := MessageEventPayload{
+		ID:          msg.Info.ID,
+		ChatJID:     chatJID,
+		Sender:      sender,
+		Content:     content,
+		Timestamp:   msg.Info.Timestamp,
+		IsFromMe:    msg.Info.IsFromMe,
+		MediaType:   mediaType,
+		Filename:    filename,
+		Product:     record.Product,
+		MentionedMe: record.MentionedMe,
+	}
+	emitEvent(eventType, chatJID, payload)
+	if record.MentionedMe {
+		emitEvent(EventMessageMentioned, chatJID, payload)
+	}
+
+	// Queue inbound text for sentiment and language tagging
+	if !msg.Info.IsFromMe {
+		messageEnricher.Enqueue(msg.Info.ID, chatJID, sender, content)
+	}
+	return true
+}
+
+// storeChatMessage stores a message together with the chat update it
+// implies, as one unit when the store supports transactions. The chat's
+// name is kept when name is empty.
+func storeChatMessage(messageStore MessageStoreInterface, name string, record MessageRecord) error {
+	if txStore, ok := messageStore.(TransactionalStore); ok {
+		return txStore.InTransaction(func(tx StoreTx) error {
+			if err := tx.StoreChat(record.ChatJID, name, record.Timestamp); err != nil {
+				return err
+			}
+			return tx.StoreMessage(record)
+		})
+	}
+
+	if err := messageStore.StoreChat(record.ChatJID, name, record.Timestamp); err != nil {
+		return err
+	}
+	return storeMessageRecord(messageStore, record)
+}
+
+// storeMessageRecord stores a message outside a transaction. Only a batch
+// store takes the whole record; StoreMessage leaves out its quote,
+// mentions and product.
+func storeMessageRecord(messageStore MessageStoreInterface, record MessageRecord) error {
+	if batchStore, ok := messageStore.(BatchMessageStore); ok {
+		return batchStore.StoreMessages([]MessageRecord{record})
+	}
+	return messageStore.StoreMessage(record.ID, record.ChatJID, record.Sender, record.Content, record.Timestamp, record.IsFromMe,
+		record.MediaType, record.Filename, record.URL, record.MediaKey, record.FileSHA256, record.FileEncSHA256, record.FileLength)
 }
 
 // DownloadMediaRequest represents the request body for the download media API
@@ -603,7 +1187,10 @@ func downloadMedia(client *whatsmeow.Client, messageStore MessageStoreInterface,
 	}
 
 	// Generate a local path for the file
-	localPath = fmt.Sprintf("%s/%s", chatDir, filename)
+	localPath, err = mediaLocalPath(chatDir, filename)
+	if err != nil {
+		return false, "", "", "", err
+	}
 
 	// Get absolute path
 	absPath, err := filepath.Abs(localPath)
@@ -622,6 +1209,10 @@ func downloadMedia(client *whatsmeow.Client, messageStore MessageStoreInterface,
 		return false, "", "", "", fmt.Errorf("incomplete media information for download")
 	}
 
+	if maxSize := mediaMaxDownloadBytes(); int64(fileLength) > maxSize {
+		return false, "", "", "", fmt.Errorf("media is %d bytes, larger than the %d byte limit", fileLength, maxSize)
+	}
+
 	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, chatJID)
 
 	// Extract direct path from URL
@@ -652,18 +1243,36 @@ func downloadMedia(client *whatsmeow.Client, messageStore MessageStoreInterface,
 		MediaType:     waMediaType,
 	}
 
-	// Download the media using whatsmeow client
-	mediaData, err := client.Download(context.Background(), downloader)
+	// Download and decrypt into a temporary file next to the target, so
+	// large media never sits in memory and partial files are never served
+	tmpFile, err := os.CreateTemp(chatDir, ".download-*")
+	if err != nil {
+		return false, "", "", "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = client.DownloadToFile(context.Background(), downloader, tmpFile)
+	if err == nil {
+		err = tmpFile.Chmod(0644)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return false, "", "", "", fmt.Errorf("failed to download media: %v", err)
 	}
 
 	// Save the downloaded media to file
-	if err := os.WriteFile(localPath, mediaData, 0644); err != nil {
+	if err := os.Rename(tmpFile.Name(), localPath); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
 	}
 
-	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, len(mediaData))
+	// Copy new downloads to S3 or Supabase Storage
+	if mediaStorage != nil {
+		go mediaStorage.Downloaded(messageID, chatJID, filename, absPath)
+	}
+
+	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, fileLength)
 	return true, mediaType, filename, absPath, nil
 }
 
@@ -735,12 +1344,12 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 			html += `
         <div class="status connected">✓ Connected</div>
         <p>WhatsApp is connected and ready to use.</p>
-        <p><a href="/api/status">View Status API</a></p>`
+        <p><a href="/api/v1/status">View Status API</a></p>`
 		} else if qr != "" {
 			html += `
         <div class="status waiting">Waiting for scan...</div>
         <div class="qr-container">
-            <img src="/api/qr?format=png" alt="QR Code" />
+            <img src="/api/v1/qr?format=png" alt="QR Code" />
         </div>
         <div class="instructions">
             <strong>Option 1: Scan QR Code</strong>
@@ -755,6 +1364,8 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
             <strong>Option 2: Link with Phone Number</strong>
             <p style="font-size:13px;color:#666;">Enter phone with country code (no + or spaces)</p>
             <input type="text" id="phone" placeholder="31612345678" />
+            <p style="font-size:13px;color:#666;">Once API keys exist, an admin key is required</p>
+            <input type="password" id="apikey" placeholder="Admin API key" />
             <button onclick="pairPhone()">Get Code</button>
             <div id="result" class="result"></div>
         </div>
@@ -775,11 +1386,21 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
                 result.style.display = 'block';
                 result.innerHTML = 'Requesting code...';
                 try {
-                    const resp = await fetch('/api/pair-phone', {
+                    const headers = {'Content-Type': 'application/json'};
+                    const apiKey = document.getElementById('apikey').value;
+                    if (apiKey) {
+                        headers['Authorization'] = 'Bearer ' + apiKey;
+                    }
+                    const resp = await fetch('/api/v1/pair-phone', {
                         method: 'POST',
-                        headers: {'Content-Type': 'application/json'},
+                        headers: headers,
                         body: JSON.stringify({phone_number: phone})
                     });
+                    if (resp.status === 401 || resp.status === 403) {
+                        result.className = 'result error';
+                        result.innerHTML = 'An admin API key is required';
+                        return;
+                    }
                     const data = await resp.json();
                     if (data.success) {
                         result.className = 'result success';
@@ -810,7 +1431,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 	})
 
 	// Handler for QR code - returns current QR code for authentication
-	http.HandleFunc("/api/qr", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/qr", ScopePublic, func(w http.ResponseWriter, r *http.Request) {
 		qrCodeMutex.RLock()
 		qr := currentQRCode
 		qrCodeMutex.RUnlock()
@@ -867,13 +1488,13 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"authenticated": false,
 			"qr_code":       qr,
-			"qr_image_url":  "/api/qr?format=png",
+			"qr_image_url":  "/api/v1/qr?format=png",
 			"message":       "Scan this QR code with WhatsApp",
 		})
 	})
 
 	// Handler for status check
-	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/status", ScopePublic, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		authMutex.RLock()
@@ -890,7 +1511,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 	})
 
 	// Handler for phone number pairing (alternative to QR code)
-	http.HandleFunc("/api/pair-phone", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/pair-phone", ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		if r.Method != http.MethodPost {
@@ -944,7 +1565,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 	})
 
 	// Handler for sending messages
-	http.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/send", ScopeSend, func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -958,39 +1579,57 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 			return
 		}
 
-		// Validate request
-		if req.Recipient == "" {
-			http.Error(w, "Recipient is required", http.StatusBadRequest)
-			return
-		}
+		// Set response headers
+		w.Header().Set("Content-Type", "application/json")
 
-		if req.Message == "" && req.MediaPath == "" {
-			http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		// Validate the request before anything reaches WhatsApp
+		outbound, validationErrors := composeMessage(client, req)
+		if len(validationErrors) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: validationErrors[0].Message,
+				Errors:  validationErrors,
+			})
 			return
 		}
 
-		fmt.Println("Received request to send message", req.Message, req.MediaPath)
-
-		// Send the message
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath)
-		fmt.Println("Message sent", success, message)
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
-
-		// Set appropriate status code
-		if !success {
-			w.WriteHeader(http.StatusInternalServerError)
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = r.Header.Get("Idempotency-Key")
 		}
 
-		// Send response
-		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
+		status, response := outboundSender.Dispatch(outbound, SendOptions{
+			IdempotencyKey: req.IdempotencyKey,
+			Urgent:         req.Urgent,
+			Key:            requestAPIKey(r),
 		})
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
 	})
 
+	// Handlers for canned responses and the language they are sent in
+	handleAPI("/templates", ScopeRead, handleTemplates)
+	handleAPI("/admin/templates/refresh", ScopeAdmin, handleTemplatesRefresh)
+	handleAPI("/chats/language", ScopeRead, handleChatLanguage)
+
+	// Handler for seeding conversations from CRM data
+	handleAPI("/chats/import", ScopeAdmin, handleChatImport(messageStore))
+
+	// Handlers for moving chats and messages to the trash and back
+	handleAPI("/trash", ScopeAdmin, handleTrash(false))
+	handleAPI("/trash/restore", ScopeAdmin, handleTrash(true))
+
+	// Handler for sending catalog products
+	handleAPI("/send/product", ScopeSend, handleSendProduct(client))
+
+	// Handler for pinning and unpinning messages in their chat
+	handleAPI("/messages/pin", ScopeSend, handlePinMessage(client, messageStore))
+
+	// Handler for annotating stored messages with external metadata
+	handleAPI("/messages/{id}/metadata", ScopeRead, handleMessageMetadata(messageStore))
+
 	// Handler for downloading media
-	http.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/download", ScopeMedia, func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1040,13 +1679,110 @@ func startRESTServer(client *whatsmeow.Client, messageStore MessageStoreInterfac
 		})
 	})
 
+	// Handler for fuzzy contact search by name, push name or phone number
+	handleAPI("/contacts/search", ScopeRead, handleContactSearch(client))
+	handleAPI("/contacts/person", ScopeRead, handleContactPerson)
+	handleAPI("/contacts/link", ScopeAdmin, handleContactLink)
+	handleAPI("/contacts/unlink", ScopeAdmin, handleContactUnlink)
+
+	// Handlers for listing chats and messages, compressed and cacheable
+	handleAPI("/chats", ScopeRead, withConditionalGzip(handleListChats(messageStore)))
+	handleAPI("/messages", ScopeRead, withConditionalGzip(handleListMessages(messageStore)))
+
+	// Handler for the feed of messages mentioning the logged in account
+	handleAPI("/mentions", ScopeRead, withConditionalGzip(handleListMentions(messageStore)))
+
+	// Handler for on-demand conversation summaries
+	handleAPI("/chats/summarize", ScopeSend, handleSummarizeChat)
+
+	// Handler for chat transcripts formatted for language models
+	handleAPI("/chats/transcript", ScopeRead, withConditionalGzip(handleChatTranscript(client, messageStore)))
+
+	// Handler for daily group activity digests
+	handleAPI("/groups/digest", ScopeRead, handleGroupDigest)
+
+	// Handler for exporting the media of a chat as a zip archive
+	handleAPI("/chats/export", ScopeMedia, handleExportMedia(client, messageStore))
+
+	// Handlers for short-lived signed media URLs
+	handleAPI("/media/sign", ScopeMedia, handleSignMedia(messageStore))
+	handleAPI("/media/file", ScopePublic, handleSignedMediaFile(client, messageStore))
+
+	// Handlers for polling and acknowledging journaled events
+	handleAPI("/events/poll", ScopeRead, handleEventPoll)
+	handleAPI("/events/ack", ScopeRead, handleEventAck)
+	handleAPI("/events/schema", ScopeRead, handleEventSchema)
+
+	// Handlers for the inbox projection
+	handleAPI("/inbox", ScopeRead, withConditionalGzip(handleInbox))
+	handleAPI("/inbox/assign", ScopeSend, handleInboxUpdate(func(req InboxUpdateRequest) error {
+		return inboxProjection.Assign(req.ChatJID, req.Assignee)
+	}))
+	handleAPI("/inbox/tags", ScopeSend, handleInboxUpdate(func(req InboxUpdateRequest) error {
+		return inboxProjection.SetTags(req.ChatJID, req.Tags)
+	}))
+	handleAPI("/inbox/read", ScopeSend, handleInboxUpdate(func(req InboxUpdateRequest) error {
+		// Reset the store's read state and unread count too
+		readStateSync.MarkRead(req.ChatJID)
+		return inboxProjection.MarkRead(req.ChatJID)
+	}))
+	handleAPI("/inbox/snooze", ScopeSend, handleInboxUpdate(func(req InboxUpdateRequest) error {
+		return inboxProjection.Snooze(req.ChatJID, req.Until)
+	}))
+
+	// Handlers for conversation metrics
+	handleAPI("/stats", ScopeRead, handleStats)
+	handleAPI("/stats/resolve", ScopeSend, handleStatsResolve)
+
+	// Handlers for inspecting, retrying and cancelling held messages
+	handleAPI("/outbox", ScopeAdmin, handleOutbox)
+	handleAPI("/outbox/retry", ScopeAdmin, handleOutboxAction(true))
+	handleAPI("/outbox/cancel", ScopeAdmin, handleOutboxAction(false))
+
+	// Handlers for reviewing messages held for approval
+	handleAPI("/approvals", ScopeAdmin, handleApprovals)
+	handleAPI("/approvals/approve", ScopeAdmin, handleApprovalDecision(true))
+	handleAPI("/approvals/reject", ScopeAdmin, handleApprovalDecision(false))
+
+	// Handler for per-key send usage
+	handleAPI("/admin/usage", ScopeAdmin, handleAdminUsage)
+
+	// Handler for the warm-up progress of a freshly linked number
+	handleAPI("/admin/warmup", ScopeAdmin, handleWarmup(client))
+
+	// Handlers for managing API keys at runtime
+	handleAPI("/admin/keys", ScopePublic, handleAdminKeysRoute)
+	handleAPI("/admin/keys/rotate", ScopeAdmin, handleAdminRotateKey)
+	handleAPI("/admin/keys/revoke", ScopeAdmin, handleAdminRevokeKey)
+
+	// Handler for switching bot mode per chat
+	handleAPI("/bot/chats", ScopeAdmin, handleBotChats)
+
+	// Handler for listing detected history gaps
+	handleAPI("/admin/gaps", ScopeAdmin, handleHistoryGaps)
+
+	// Handler for the audit log of security code changes
+	handleAPI("/admin/identity-changes", ScopeAdmin, handleIdentityChanges)
+
+	// Handler for re-delivering journaled events to a webhook
+	handleAPI("/admin/webhooks/replay", ScopeAdmin, handleWebhookReplay)
+
+	// Handler for the backlog of Supabase writes waiting out an outage
+	handleAPI("/admin/write-queue", ScopeAdmin, handleWriteQueueStats(messageStore))
+
+	// Handler for comparing and repairing the stores in dual-write mode
+	handleAPI("/admin/dual-write", ScopeAdmin, handleDualWrite(messageStore))
+
+	// Handler for the recorded Supabase requests and responses
+	handleAPI("/admin/supabase-debug", ScopeAdmin, handleSupabaseDebug)
+
 	// Start the server
 	serverAddr := fmt.Sprintf(":%d", port)
 	fmt.Printf("Starting REST API server on %s...\n", serverAddr)
 
 	// Run server in a goroutine so it doesn't block
 	go func() {
-		if err := http.ListenAndServe(serverAddr, nil); err != nil {
+		if err := http.ListenAndServe(serverAddr, withNetworkPolicy(http.DefaultServeMux)); err != nil {
 			fmt.Printf("REST API server error: %v\n", err)
 		}
 	}()
@@ -1056,9 +1792,41 @@ func main() {
 	// Load .env file if present
 	_ = godotenv.Load()
 
+	// Per-account settings replace the shared ones before anything reads them
+	account, overridden, err := applyAccountOverrides()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid account configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Subcommands run instead of the bridge
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Multi-account mode runs a bridge per account instead
+	if accounts := envString("BRIDGE_ACCOUNTS", ""); accounts != "" && account == "" {
+		if err := runAccounts(strings.Split(accounts, ",")); err != nil {
+			fmt.Fprintf(os.Stderr, "Multi-account mode failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	messageStoreName := flag.String("message-store", envString("MESSAGE_STORE", "auto"),
+		"message store backend: auto, a postgres:// connection string or one of "+strings.Join(MessageStoreNames(), ", "))
+	flag.Parse()
+
 	// Set up logger
 	logger := waLog.Stdout("Client", "INFO", true)
 	logger.Infof("Starting WhatsApp client...")
+	if account != "" {
+		logger.Infof("Running as account %s with overrides of %s", account, strings.Join(overridden, ", "))
+	}
 
 	// Create database connection for storing session data
 	dbLog := waLog.Stdout("Database", "INFO", true)
@@ -1095,47 +1863,376 @@ func main() {
 		return
 	}
 
-	// Initialize message store - try Supabase first, fall back to SQLite
-	var messageStore MessageStoreInterface
-	supabaseStore, err := NewSupabaseMessageStore()
+	// For at-least-once storage, send the delivery receipt of a message
+	// only after the store confirmed writing it. WhatsApp delivers messages
+	// it got no receipt for again when the bridge reconnects; the decrypted
+	// event buffer lets the bridge process such a message a second time.
+	ackAfterStore := envBool("ACK_AFTER_STORE", false)
+	if ackAfterStore {
+		client.SynchronousAck = true
+		client.EnableDecryptedEventBuffer = true
+	}
+
+	// Stamp every event with the fields that identify this deployment
+	eventSource, err = NewEventSource()
+	if err != nil {
+		logger.Errorf("Failed to configure event source: %v", err)
+		return
+	}
+
+	// Initialize the message store chosen by -message-store or
+	// MESSAGE_STORE, see openMessageStore for the default
+	messageStore, storeName, err := openMessageStore(*messageStoreName, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize %s message store: %v", storeName, err)
+		return
+	}
+	logger.Infof("Using %s for message storage", storeName)
+	if missing := unsupportedFeatures(messageStore); len(missing) > 0 {
+		logger.Warnf("The %s message store does not support %s; these features are disabled", storeName, strings.Join(missing, ", "))
+	}
+	defer messageStore.Close()
+
+	// Open the bridge state database used by the event journal and friends
+	bridgeDB, err = openBridgeDB()
+	if err != nil {
+		logger.Errorf("Failed to open bridge database: %v", err)
+		return
+	}
+	defer bridgeDB.Close()
+
+	// Queue Supabase message writes locally so outages do not lose messages
+	if store, ok := supabaseStoreOf(messageStore); ok {
+		if _, err := NewWriteQueue(bridgeDB, store, logger); err != nil {
+			logger.Errorf("Failed to configure Supabase write queue: %v", err)
+			return
+		}
+	}
+
+	// Require API keys from the first one configured or created through the admin API on
+	apiKeys, err = NewAPIKeyStore(bridgeDB)
+	if err != nil {
+		logger.Errorf("Failed to configure API keys: %v", err)
+		return
+	}
+	if apiKeys.Enabled() {
+		logger.Infof("API key authentication enabled")
+		if !apiKeys.HasKeys() {
+			logger.Warnf("Every API key was revoked, create a new one with API_BOOTSTRAP_SECRET")
+		}
+	}
+
+	// Cap the daily sends of a freshly linked number during its warm-up
+	accountWarmup, err = NewAccountWarmup(bridgeDB, logger)
 	if err != nil {
-		logger.Warnf("Supabase not configured, falling back to SQLite: %v", err)
-		sqliteStore, err := NewMessageStore()
+		logger.Errorf("Failed to configure warm-up: %v", err)
+		return
+	}
+
+	// Show typing and pause between messages sent to one recipient in a row
+	sendingCadence = NewSendingCadence(logger)
+	if sendingCadence != nil {
+		logger.Infof("Human sending cadence enabled")
+	}
+
+	// Accept bearer tokens from an OIDC provider when OIDC_ISSUER is configured
+	oidcVerifier, err = NewOIDCVerifier()
+	if err != nil {
+		logger.Errorf("Failed to configure OIDC: %v", err)
+		return
+	}
+	if oidcVerifier != nil {
+		logger.Infof("OIDC authentication enabled")
+	}
+
+	// Restrict source addresses and trust X-Forwarded-For only from known proxies
+	networkPolicy, err = NewNetworkPolicy()
+	if err != nil {
+		logger.Errorf("Failed to configure network policy: %v", err)
+		return
+	}
+
+	// Resolve names for chats first seen under a bare number
+	nameResolver = NewNameResolver(client, messageStore, logger)
+
+	// Start optional enrichment stage for sentiment and language tagging
+	messageEnricher = NewEnricher(messageStore, logger)
+	if messageEnricher != nil {
+		logger.Infof("Message classifier enabled")
+	}
+
+	// Preferred language per chat, used to localize templates
+	chatLanguages, err = NewChatLanguages(bridgeDB, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize chat languages: %v", err)
+		return
+	}
+
+	messageTemplates, err = NewMessageTemplates(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to load message templates: %v", err)
+		return
+	}
+
+	// Start optional conversation summarization hook
+	chatSummarizer = NewSummarizer(messageStore, logger)
+	if chatSummarizer != nil {
+		logger.Infof("Conversation summarizer enabled")
+	}
+
+	groupDigests, err = NewGroupDigests(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize group digests: %v", err)
+		return
+	}
+
+	// Journal events for cursor-based consumers
+	if envBool("EVENT_JOURNAL", true) {
+		eventJournal, err = NewEventJournal(bridgeDB, logger)
 		if err != nil {
-			logger.Errorf("Failed to initialize SQLite message store: %v", err)
+			logger.Errorf("Failed to initialize event journal: %v", err)
 			return
 		}
-		messageStore = sqliteStore
-		logger.Infof("Using SQLite for message storage")
-	} else {
-		messageStore = supabaseStore
-		logger.Infof("Using Supabase for message storage")
+		subscribeEvents(eventJournal.HandleEvent)
+	}
+
+	// Track API sends so receipts can be reported with their idempotency key
+	sendTracker, err = NewSendTracker(bridgeDB, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize send tracker: %v", err)
+		return
+	}
+
+	// Check and store what the API, the outbox and bot mode send
+	outboundSender = NewOutboundSender(client, messageStore, logger)
+
+	// Hold outbound messages, such as those sent during quiet hours
+	outbox, err = NewOutbox(bridgeDB, client, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize outbox: %v", err)
+		return
+	}
+
+	quietHours, err = NewQuietHours()
+	if err != nil {
+		logger.Errorf("Failed to configure quiet hours: %v", err)
+		return
+	}
+
+	ignoreRules, err = NewIgnoreRules()
+	if err != nil {
+		logger.Errorf("Failed to configure ignore rules: %v", err)
+		return
+	}
+
+	contentPolicy, err = NewContentPolicy()
+	if err != nil {
+		logger.Errorf("Failed to configure content policy: %v", err)
+		return
+	}
+
+	// Link JIDs that belong to the same person
+	contactAliases, err = NewContactAliases(bridgeDB, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize contact aliases: %v", err)
+		return
+	}
+	subscribeEvents(contactAliases.HandleEvent)
+
+	// Maintain the inbox read model from the same events
+	if envBool("INBOX_PROJECTION", true) {
+		inboxProjection, err = NewInboxProjection(bridgeDB, logger)
+		if err != nil {
+			logger.Errorf("Failed to initialize inbox projection: %v", err)
+			return
+		}
+		subscribeEvents(inboxProjection.HandleEvent)
+	}
+
+	// Track first response time and resolution per conversation
+	if envBool("CONVERSATION_METRICS", true) {
+		conversationStats, err = NewConversationStats(bridgeDB, logger)
+		if err != nil {
+			logger.Errorf("Failed to initialize conversation metrics: %v", err)
+			return
+		}
+		subscribeEvents(conversationStats.HandleEvent)
+	}
+
+	// Detect and repair messages missing from the stored history
+	historyGaps, err = NewHistoryGaps(bridgeDB, client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize history gap detection: %v", err)
+		return
+	}
+
+	// Keep an audit log of contacts' security code changes
+	identityChanges, err = NewIdentityChanges(bridgeDB, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize identity change log: %v", err)
+		return
+	}
+
+	// Tag or delete messages sent with a disappearing timer
+	disappearingMessages, err = NewDisappearingMessages(bridgeDB, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to configure disappearing messages: %v", err)
+		return
+	}
+
+	// Run commands the owner sends to their own chat
+	selfCommands, err = NewSelfCommands(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to configure self-chat commands: %v", err)
+		return
+	}
+
+	// Answer chats in bot mode through the configured responder
+	botMode, err = NewBotMode(bridgeDB, client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to initialize bot mode: %v", err)
+		return
+	}
+	if botMode != nil {
+		subscribeEvents(botMode.HandleEvent)
+		logger.Infof("Bot mode enabled")
+	}
+
+	// Copy chats read on the phone to the store and inbox
+	readStateSync = NewReadStateSync(messageStore, logger)
+
+	// Copy contact names from the device store to the message store
+	contactSync = NewContactSync(client, messageStore, logger)
+
+	// Keep deleted chats and messages restorable until they are purged
+	softDeletes = NewSoftDeletes(messageStore, logger)
+
+	// Invoke Supabase Edge Functions on configured events
+	edgeFunctions, err := NewEdgeFunctionInvoker(logger)
+	if err != nil {
+		logger.Errorf("Failed to configure edge functions: %v", err)
+		return
+	}
+	if edgeFunctions != nil {
+		subscribeEvents(edgeFunctions.HandleEvent)
+		logger.Infof("Edge function integration enabled")
+	}
+
+	// Deliver events to configured webhooks
+	webhookDispatcher, err = NewWebhookDispatcher(logger)
+	if err != nil {
+		logger.Errorf("Failed to configure webhooks: %v", err)
+		return
+	}
+	if webhookDispatcher != nil {
+		subscribeEvents(webhookDispatcher.HandleEvent)
+		logger.Infof("Webhooks enabled")
+	}
+
+	// Send outbound rows other applications insert into Supabase
+	realtimeOutbound = NewRealtimeOutbound(client, messageStore, logger)
+
+	// Upload downloaded media to S3 or Supabase Storage
+	mediaStorage = newMediaStorage(client, messageStore, logger)
+	switch mediaStorage.(type) {
+	case *S3MediaStorage:
+		logger.Infof("S3 media upload enabled")
+	case *SupabaseMediaStorage:
+		logger.Infof("Supabase Storage media upload enabled")
+	}
+
+	// Warm caches before events start arriving
+	warmCache := envBool("WARM_CACHE", true)
+	if warmCache {
+		preloadStoreCache(messageStore, logger)
 	}
-	defer messageStore.Close()
 
 	// Setup event handling for messages and history sync
-	client.AddEventHandler(func(evt interface{}) {
+	client.AddEventHandlerWithSuccessStatus(func(evt interface{}) bool {
 		switch v := evt.(type) {
 		case *events.Message:
-			// Process regular messages
-			handleMessage(client, messageStore, v, logger)
+			// Drop noisy system messages before anything sees them
+			if ignoreRules.Ignores(v.Info.Chat, v.Message) {
+				break
+			}
+
+			// Process regular messages. With ACK_AFTER_STORE a failed write
+			// withholds the receipt, and the rest waits for the redelivery.
+			if !handleMessage(client, messageStore, v, logger) && ackAfterStore {
+				logger.Warnf("Withholding receipt of message %s until it is stored", v.Info.ID)
+				return false
+			}
+			handleReaction(messageStore, v, logger)
+			handleMessageEdit(client, messageStore, v, logger)
+			handlePin(messageStore, v, logger)
+			historyGaps.HandleMessage(v)
+			disappearingMessages.HandleMessage(v)
+			selfCommands.HandleMessage(v)
+			if mediaStorage != nil {
+				mediaStorage.HandleMessage(v)
+			}
 
 		case *events.HistorySync:
 			// Process history sync events
 			handleHistorySync(client, messageStore, v, logger)
 
+		case *events.Receipt:
+			// Report delivery and read status of messages sent through the API
+			sendTracker.HandleReceipt(v)
+			// Keep the status of stored outbound messages in step for ticks
+			handleReceiptStatus(messageStore, v, logger)
+			// Own devices' read receipts mean the owner read the chat
+			readStateSync.HandleReceipt(v)
+			historyGaps.HandleReceipt(v)
+
+		case *events.MarkChatAsRead:
+			readStateSync.HandleMarkChatAsRead(v)
+
+		case *events.Archive, *events.Pin, *events.Mute:
+			// Mirror chat state changed on the phone to the store
+			handleChatState(messageStore, v, logger)
+
+		case *events.IdentityChange:
+			if !ignoreRules.IgnoresEvent(v) {
+				identityChanges.HandleIdentityChange(v)
+			}
+
+		case *events.Picture:
+			if !ignoreRules.IgnoresEvent(v) {
+				handleGroupEvent(v)
+			}
+
+		case *events.GroupInfo, *events.JoinedGroup:
+			// Keep cached group metadata current
+			handleGroupEvent(v)
+			// Keep stored group members current
+			handleGroupParticipants(messageStore, v, logger)
+
 		case *events.Connected:
 			logger.Infof("Connected to WhatsApp")
+			if client.Store.ID != nil {
+				setEventAccount(client.Store.ID.ToNonAD().String())
+			}
+			if warmCache {
+				go preloadClientCaches(client, logger)
+			}
+			go syncGroupParticipants(client, messageStore, logger)
+			contactSync.Trigger()
+
+		case *events.AppStateSyncComplete:
+			// Address book names arrive with app state sync
+			contactSync.Trigger()
 
 		case *events.LoggedOut:
 			logger.Warnf("Device logged out, please scan QR code to log in again")
 		}
+		return true
 	})
 
 	// Create channel to track connection success
 	connected := make(chan bool, 1)
 
-	// Start REST API server EARLY so /api/qr and /api/status work during QR scan
+	// Start REST API server EARLY so /api/v1/qr and /api/v1/status work during QR scan
 	bridgePort := 8080
 	if portEnv := os.Getenv("BRIDGE_PORT"); portEnv != "" {
 		fmt.Sscanf(portEnv, "%d", &bridgePort)
@@ -1164,8 +2261,8 @@ func main() {
 					qrCodeMutex.Unlock()
 
 					fmt.Println("\nScan this QR code with your WhatsApp app:")
-					fmt.Println("Or use /api/pair-phone for phone number pairing")
-					fmt.Println("Or visit /api/qr?format=png for QR image")
+					fmt.Println("Or use /api/v1/pair-phone for phone number pairing")
+					fmt.Println("Or visit /api/v1/qr?format=png for QR image")
 					qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
 				} else if evt.Event == "success" {
 					// Clear QR code and set authenticated
@@ -1190,7 +2287,7 @@ func main() {
 			case <-connected:
 				// Already handled in goroutine above
 			case <-time.After(10 * time.Minute):
-				logger.Warnf("Timeout waiting for pairing - service still running, try /api/pair-phone or /api/qr")
+				logger.Warnf("Timeout waiting for pairing - service still running, try /api/v1/pair-phone or /api/v1/qr")
 			}
 		}()
 	} else {
@@ -1273,12 +2370,15 @@ func GetChatName(client *whatsmeow.Client, messageStore MessageStoreInterface, j
 
 		// If we didn't get a name, try group info
 		if name == "" {
-			groupInfo, err := client.GetGroupInfo(context.Background(), jid)
+			groupInfo, err := cachedGroupInfo(client, jid)
 			if err == nil && groupInfo.Name != "" {
 				name = groupInfo.Name
+			} else if resolved, ok := nameResolver.Resolved(jid); ok {
+				name = resolved
 			} else {
-				// Fallback name for groups
+				// Fallback name for groups until the resolver finds one
 				name = fmt.Sprintf("Group %s", jid.User)
+				nameResolver.Enqueue(jid)
 			}
 		}
 
@@ -1287,16 +2387,18 @@ func GetChatName(client *whatsmeow.Client, messageStore MessageStoreInterface, j
 		// This is an individual contact
 		logger.Infof("Getting name for contact: %s", chatJID)
 
-		// Just use contact info (full name)
-		contact, err := client.Store.Contacts.GetContact(context.Background(), jid)
-		if err == nil && contact.FullName != "" {
-			name = contact.FullName
-		} else if sender != "" {
-			// Fallback to sender
-			name = sender
+		// Use contact info, then a name resolved in the background
+		if contactName := storedContactName(client, jid); contactName != "" {
+			name = contactName
+		} else if resolved, ok := nameResolver.Resolved(jid); ok {
+			name = resolved
 		} else {
-			// Last fallback to JID
-			name = jid.User
+			// Fall back to the sender or JID until the resolver finds a name
+			name = sender
+			if name == "" {
+				name = jid.User
+			}
+			nameResolver.Enqueue(jid)
 		}
 
 		logger.Infof("Using contact name: %s", name)
@@ -1305,146 +2407,185 @@ func GetChatName(client *whatsmeow.Client, messageStore MessageStoreInterface, j
 	return name
 }
 
-// Handle history sync events
+// Handle history sync events. Conversations are spread over a bounded pool
+// of workers; each conversation is handled by a single worker so its
+// messages are still stored in order.
 func handleHistorySync(client *whatsmeow.Client, messageStore MessageStoreInterface, historySync *events.HistorySync, logger waLog.Logger) {
 	fmt.Printf("Received history sync event with %d conversations\n", len(historySync.Data.Conversations))
 
-	syncedCount := 0
-	for _, conversation := range historySync.Data.Conversations {
-		// Parse JID from the conversation
-		if conversation.ID == nil {
-			continue
-		}
+	workers := max(envInt("HISTORY_SYNC_WORKERS", 4), 1)
+	conversations := make(chan *waHistorySync.Conversation)
+	var syncedCount atomic.Int64
+	var wg sync.WaitGroup
 
-		chatJID := *conversation.ID
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for conversation := range conversations {
+				syncedCount.Add(int64(syncConversation(client, messageStore, conversation, logger)))
+			}
+		}()
+	}
 
-		// Try to parse the JID
-		jid, err := types.ParseJID(chatJID)
-		if err != nil {
-			logger.Warnf("Failed to parse JID %s: %v", chatJID, err)
-			continue
-		}
+	for _, conversation := range historySync.Data.Conversations {
+		conversations <- conversation
+	}
+	close(conversations)
+	wg.Wait()
 
-		// Get appropriate chat name by passing the history sync conversation directly
-		name := GetChatName(client, messageStore, jid, chatJID, conversation, "", logger)
+	fmt.Printf("History sync complete. Stored %d messages.\n", syncedCount.Load())
+}
 
-		// Process messages
-		messages := conversation.Messages
-		if len(messages) > 0 {
-			// Update chat with latest message timestamp
-			latestMsg := messages[0]
-			if latestMsg == nil || latestMsg.Message == nil {
-				continue
-			}
+// syncConversation stores one history sync conversation and returns the
+// number of messages stored
+func syncConversation(client *whatsmeow.Client, messageStore MessageStoreInterface, conversation *waHistorySync.Conversation, logger waLog.Logger) int {
+	// Parse JID from the conversation
+	if conversation.ID == nil {
+		return 0
+	}
 
-			// Get timestamp from message info
-			timestamp := time.Time{}
-			if ts := latestMsg.Message.GetMessageTimestamp(); ts != 0 {
-				timestamp = time.Unix(int64(ts), 0)
-			} else {
-				continue
-			}
+	chatJID := *conversation.ID
 
-			messageStore.StoreChat(chatJID, name, timestamp)
+	// Try to parse the JID
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		logger.Warnf("Failed to parse JID %s: %v", chatJID, err)
+		return 0
+	}
 
-			// Store messages
-			for _, msg := range messages {
-				if msg == nil || msg.Message == nil {
-					continue
-				}
+	if ignoreRules.IgnoresChat(jid) {
+		return 0
+	}
 
-				// Extract text content
-				var content string
-				if msg.Message.Message != nil {
-					if conv := msg.Message.Message.GetConversation(); conv != "" {
-						content = conv
-					} else if ext := msg.Message.Message.GetExtendedTextMessage(); ext != nil {
-						content = ext.GetText()
-					}
-				}
+	// Get appropriate chat name by passing the history sync conversation directly
+	name := GetChatName(client, messageStore, jid, chatJID, conversation, "", logger)
 
-				// Extract media info
-				var mediaType, filename, url string
-				var mediaKey, fileSHA256, fileEncSHA256 []byte
-				var fileLength uint64
+	// Process messages
+	messages := conversation.Messages
+	if len(messages) == 0 {
+		return 0
+	}
 
-				if msg.Message.Message != nil {
-					mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength = extractMediaInfo(msg.Message.Message)
-				}
+	// Update chat with latest message timestamp
+	latestMsg := messages[0]
+	if latestMsg == nil || latestMsg.Message == nil {
+		return 0
+	}
 
-				// Log the message content for debugging
-				logger.Infof("Message content: %v, Media Type: %v", content, mediaType)
+	// Get timestamp from message info
+	ts := latestMsg.Message.GetMessageTimestamp()
+	if ts == 0 {
+		return 0
+	}
+	messageStore.StoreChat(chatJID, name, time.Unix(int64(ts), 0))
 
-				// Skip messages with no content and no media
-				if content == "" && mediaType == "" {
-					continue
-				}
+	var records []MessageRecord
+	for _, msg := range messages {
+		if msg == nil || msg.Message == nil || ignoreRules.Ignores(jid, msg.Message.Message) {
+			continue
+		}
 
-				// Determine sender
-				var sender string
-				isFromMe := false
-				if msg.Message.Key != nil {
-					if msg.Message.Key.FromMe != nil {
-						isFromMe = *msg.Message.Key.FromMe
-					}
-					if !isFromMe && msg.Message.Key.Participant != nil && *msg.Message.Key.Participant != "" {
-						sender = *msg.Message.Key.Participant
-					} else if isFromMe {
-						sender = client.Store.ID.User
-					} else {
-						sender = jid.User
-					}
-				} else {
-					sender = jid.User
-				}
+		record := MessageRecord{ChatJID: chatJID}
 
-				// Store message
-				msgID := ""
-				if msg.Message.Key != nil && msg.Message.Key.ID != nil {
-					msgID = *msg.Message.Key.ID
-				}
+		// Extract text content
+		if msg.Message.Message != nil {
+			if conv := msg.Message.Message.GetConversation(); conv != "" {
+				record.Content = conv
+			} else if ext := msg.Message.Message.GetExtendedTextMessage(); ext != nil {
+				record.Content = ext.GetText()
+			}
+		}
 
-				// Get message timestamp
-				timestamp := time.Time{}
-				if ts := msg.Message.GetMessageTimestamp(); ts != 0 {
-					timestamp = time.Unix(int64(ts), 0)
-				} else {
-					continue
-				}
+		// Extract media info
+		if msg.Message.Message != nil {
+			record.MediaType, record.Filename, record.URL, record.MediaKey, record.FileSHA256, record.FileEncSHA256, record.FileLength = extractMediaInfo(msg.Message.Message)
+			quote := quotedMessage(msg.Message.Message)
+			record.QuotedID, record.QuotedSender, record.QuotedSnippet = quote.ID, quote.Sender, quote.Snippet
+			record.Mentions = mentionedJIDs(msg.Message.Message)
+		}
 
-				err = messageStore.StoreMessage(
-					msgID,
-					chatJID,
-					sender,
-					content,
-					timestamp,
-					isFromMe,
-					mediaType,
-					filename,
-					url,
-					mediaKey,
-					fileSHA256,
-					fileEncSHA256,
-					fileLength,
-				)
-				if err != nil {
-					logger.Warnf("Failed to store history message: %v", err)
-				} else {
-					syncedCount++
-					// Log successful message storage
-					if mediaType != "" {
-						logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, mediaType, filename, content)
-					} else {
-						logger.Infof("Stored message: [%s] %s -> %s: %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, content)
-					}
-				}
+		// Skip messages with no content and no media
+		if record.Content == "" && record.MediaType == "" {
+			continue
+		}
+
+		// Determine sender
+		if msg.Message.Key != nil {
+			if msg.Message.Key.FromMe != nil {
+				record.IsFromMe = *msg.Message.Key.FromMe
 			}
+			if !record.IsFromMe && msg.Message.Key.Participant != nil && *msg.Message.Key.Participant != "" {
+				record.Sender = *msg.Message.Key.Participant
+			} else if record.IsFromMe {
+				record.Sender = client.Store.ID.User
+			} else {
+				record.Sender = jid.User
+			}
+		} else {
+			record.Sender = jid.User
+		}
+		record.MentionedMe = !record.IsFromMe && mentionsMe(client, msg.Message.Message)
+
+		// Store message
+		if msg.Message.Key != nil && msg.Message.Key.ID != nil {
+			record.ID = *msg.Message.Key.ID
+		}
+
+		// Get message timestamp
+		if ts := msg.Message.GetMessageTimestamp(); ts != 0 {
+			record.Timestamp = time.Unix(int64(ts), 0)
+		} else {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	// Prefer a single bulk write when the store supports it
+	if batchStore, ok := messageStore.(BatchMessageStore); ok {
+		if err := batchStore.StoreMessages(records); err != nil {
+			logger.Warnf("Failed to store history messages for %s: %v", chatJID, err)
+			return 0
+		}
+		logger.Infof("Stored %d history messages for %s", len(records), chatJID)
+		return len(records)
+	}
+
+	stored := 0
+	for _, record := range records {
+		err := messageStore.StoreMessage(
+			record.ID,
+			record.ChatJID,
+			record.Sender,
+			record.Content,
+			record.Timestamp,
+			record.IsFromMe,
+			record.MediaType,
+			record.Filename,
+			record.URL,
+			record.MediaKey,
+			record.FileSHA256,
+			record.FileEncSHA256,
+			record.FileLength,
+		)
+		if err != nil {
+			logger.Warnf("Failed to store history message: %v", err)
+			continue
+		}
+
+		stored++
+		// Log successful message storage
+		if record.MediaType != "" {
+			logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
+				record.Timestamp.Format("2006-01-02 15:04:05"), record.Sender, chatJID, record.MediaType, record.Filename, record.Content)
+		} else {
+			logger.Infof("Stored message: [%s] %s -> %s: %s",
+				record.Timestamp.Format("2006-01-02 15:04:05"), record.Sender, chatJID, record.Content)
 		}
 	}
 
-	fmt.Printf("History sync complete. Stored %d messages.\n", syncedCount)
+	return stored
 }
 
 // Request history sync from the server
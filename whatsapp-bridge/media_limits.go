@@ -0,0 +1,15 @@
+package main
+
+// Media is streamed through files rather than held in memory. These limits,
+// configured in megabytes, keep a single oversized file from filling the
+// disk or tying up the connection.
+
+// mediaMaxDownloadBytes is the largest attachment the bridge will download
+func mediaMaxDownloadBytes() int64 {
+	return int64(envInt("MEDIA_MAX_DOWNLOAD_MB", 512)) << 20
+}
+
+// mediaMaxUploadBytes is the largest file the bridge will upload and send
+func mediaMaxUploadBytes() int64 {
+	return int64(envInt("MEDIA_MAX_UPLOAD_MB", 100)) << 20
+}
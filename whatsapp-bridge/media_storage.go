@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MediaStorage copies downloaded media to remote storage, so deployments
+// without access to the bridge's disk can serve attachments
+type MediaStorage interface {
+	// HandleMessage downloads and uploads incoming media right away when
+	// the storage is set to
+	HandleMessage(msg *events.Message)
+	// Downloaded uploads media that was just saved to the local cache
+	Downloaded(messageID, chatJID, filename, localPath string)
+}
+
+// mediaStorage is the active media upload, nil when disabled
+var mediaStorage MediaStorage
+
+// newMediaStorage returns the S3 storage when S3_BUCKET is set, else
+// Supabase Storage when SUPABASE_STORAGE_BUCKET is, and nil when neither
+// is configured or usable
+func newMediaStorage(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) MediaStorage {
+	if envString("S3_BUCKET", "") != "" {
+		if storage := NewS3MediaStorage(client, messageStore, logger); storage != nil {
+			return storage
+		}
+		return nil
+	}
+	if storage := NewSupabaseMediaStorage(client, messageStore, logger); storage != nil {
+		return storage
+	}
+	return nil
+}
+
+// mediaObjectKey is where a message's media is kept in a bucket
+func mediaObjectKey(messageID, chatJID, filename string) string {
+	return fmt.Sprintf("%s/%s_%s", strings.ReplaceAll(chatJID, ":", "_"), messageID, filepath.Base(filename))
+}
+
+// mediaContentType guesses the content type of a media file from its name
+func mediaContentType(filename string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// mediaDownloader downloads incoming media as it arrives for a storage
+// backend, on a queue served by a few workers, and hands each file to
+// uploaded
+type mediaDownloader struct {
+	client       *whatsmeow.Client
+	autoDownload bool
+	queue        chan *events.Message
+	logger       waLog.Logger
+	uploaded     func(messageID, chatJID, filename, localPath string)
+}
+
+// newMediaDownloader reads <prefix>_AUTO_DOWNLOAD, <prefix>_QUEUE_SIZE and
+// <prefix>_WORKERS, and starts the workers
+func newMediaDownloader(client *whatsmeow.Client, prefix string, logger waLog.Logger, uploaded func(messageID, chatJID, filename, localPath string)) *mediaDownloader {
+	d := &mediaDownloader{
+		client:       client,
+		autoDownload: envBool(prefix+"_AUTO_DOWNLOAD", false),
+		queue:        make(chan *events.Message, envInt(prefix+"_QUEUE_SIZE", 256)),
+		logger:       logger,
+		uploaded:     uploaded,
+	}
+	for i := 0; i < max(1, envInt(prefix+"_WORKERS", 2)); i++ {
+		go d.run()
+	}
+	return d
+}
+
+// HandleMessage queues incoming media for download and upload when auto
+// download is enabled
+func (d *mediaDownloader) HandleMessage(msg *events.Message) {
+	if d == nil || !d.autoDownload {
+		return
+	}
+	if mediaType, _, _, _, _, _, _ := extractMediaInfo(msg.Message); mediaType == "" {
+		return
+	}
+
+	select {
+	case d.queue <- msg:
+	default:
+		d.logger.Warnf("Media upload queue full, skipping message %s", msg.Info.ID)
+	}
+}
+
+// downloadableMedia returns the media part of a message
+func downloadableMedia(msg *waProto.Message) whatsmeow.DownloadableMessage {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage()
+	}
+	return nil
+}
+
+// run downloads queued media straight from the message, without a store
+// lookup, then uploads it
+func (d *mediaDownloader) run() {
+	for msg := range d.queue {
+		if err := d.download(msg); err != nil {
+			d.logger.Warnf("Failed to download media of message %s for upload: %v", msg.Info.ID, err)
+		}
+	}
+}
+
+// mediaLocalPath returns where a media file is kept in a chat directory.
+// Document names are chosen by the sender, so only the base name is used
+// and the path must stay inside the directory.
+func mediaLocalPath(chatDir, filename string) (string, error) {
+	name := filepath.Base(filename)
+	if name == "." || name == ".." || name == string(filepath.Separator) || name == "" {
+		return "", fmt.Errorf("invalid media file name %q", filename)
+	}
+
+	localPath := filepath.Join(chatDir, name)
+	if filepath.Dir(localPath) != filepath.Clean(chatDir) {
+		return "", fmt.Errorf("media file name %q leaves the chat directory", filename)
+	}
+	return localPath, nil
+}
+
+// download saves a message's media to the local cache and uploads it
+func (d *mediaDownloader) download(msg *events.Message) error {
+	_, filename, _, _, _, _, fileLength := extractMediaInfo(msg.Message)
+	if maxSize := mediaMaxDownloadBytes(); int64(fileLength) > maxSize {
+		return fmt.Errorf("media is %d bytes, larger than the %d byte limit", fileLength, maxSize)
+	}
+
+	chatJID := msg.Info.Chat.String()
+	chatDir := fmt.Sprintf("store/%s", strings.ReplaceAll(chatJID, ":", "_"))
+	if err := os.MkdirAll(chatDir, 0755); err != nil {
+		return fmt.Errorf("failed to create chat directory: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp(chatDir, ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = d.client.DownloadToFile(context.Background(), downloadableMedia(msg.Message), tmpFile)
+	if err == nil {
+		err = tmpFile.Chmod(0644)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download media: %v", err)
+	}
+
+	localPath, err := mediaLocalPath(chatDir, filename)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), localPath); err != nil {
+		return fmt.Errorf("failed to save media file: %v", err)
+	}
+
+	d.uploaded(msg.Info.ID, chatJID, filename, localPath)
+	return nil
+}
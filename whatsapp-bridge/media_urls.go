@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// MediaURLSigner mints short-lived URLs for the media of a stored message
+type MediaURLSigner interface {
+	SignMediaURL(messageID, chatJID string, ttl time.Duration) (string, error)
+}
+
+// mediaURLSigners are consulted in order; the first signer that can serve a
+// message wins. Remote storage backends register themselves ahead of the
+// local signer.
+var (
+	mediaURLSigners []MediaURLSigner
+	mediaSignerOnce sync.Once
+	localSigner     *LocalMediaSigner
+)
+
+// registerMediaURLSigner adds a signer that is tried before the local signer
+func registerMediaURLSigner(signer MediaURLSigner) {
+	mediaURLSigners = append(mediaURLSigners, signer)
+}
+
+// LocalMediaSigner issues HMAC-signed URLs that are served by the bridge
+// itself from the local media cache
+type LocalMediaSigner struct {
+	key     []byte
+	baseURL string
+}
+
+// getLocalMediaSigner returns the local signer, keyed by MEDIA_SIGNING_KEY.
+// Without a configured key a random one is generated, so URLs do not survive
+// a restart.
+func getLocalMediaSigner() *LocalMediaSigner {
+	mediaSignerOnce.Do(func() {
+		key := []byte(envString("MEDIA_SIGNING_KEY", ""))
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			rand.Read(key)
+		}
+		localSigner = &LocalMediaSigner{
+			key:     key,
+			baseURL: strings.TrimSuffix(envString("PUBLIC_BASE_URL", ""), "/"),
+		}
+	})
+	return localSigner
+}
+
+// signature computes the URL signature for a message and expiry timestamp
+func (s *LocalMediaSigner) signature(messageID, chatJID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%d", chatJID, messageID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignMediaURL implements MediaURLSigner
+func (s *LocalMediaSigner) SignMediaURL(messageID, chatJID string, ttl time.Duration) (string, error) {
+	expires := time.Now().Add(ttl).Unix()
+
+	query := url.Values{}
+	query.Set("message_id", messageID)
+	query.Set("chat_jid", chatJID)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.signature(messageID, chatJID, expires))
+
+	return fmt.Sprintf("%s/api/v1/media/file?%s", s.baseURL, query.Encode()), nil
+}
+
+// Verify checks the signature and expiry of a signed media URL
+func (s *LocalMediaSigner) Verify(messageID, chatJID, expiresParam, signature string) error {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry")
+	}
+
+	expected := s.signature(messageID, chatJID, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("link expired")
+	}
+
+	return nil
+}
+
+// signMediaURL asks the registered signers for a URL, falling back to the
+// local signer
+func signMediaURL(messageID, chatJID string, ttl time.Duration) (string, error) {
+	for _, signer := range mediaURLSigners {
+		signedURL, err := signer.SignMediaURL(messageID, chatJID, ttl)
+		if err == nil && signedURL != "" {
+			return signedURL, nil
+		}
+	}
+	return getLocalMediaSigner().SignMediaURL(messageID, chatJID, ttl)
+}
+
+// SignMediaRequest represents the request body for the media signing API
+type SignMediaRequest struct {
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+}
+
+// SignMediaResponse represents the response for the media signing API
+type SignMediaResponse struct {
+	Success   bool      `json:"success"`
+	Message   string    `json:"message,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// handleSignMedia serves POST /api/media/sign
+func handleSignMedia(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SignMediaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.MessageID == "" || req.ChatJID == "" {
+			http.Error(w, "Message ID and Chat JID are required", http.StatusBadRequest)
+			return
+		}
+
+		// Default to 15 minutes, never more than a day
+		ttl := time.Duration(req.ExpiresIn) * time.Second
+		if ttl <= 0 {
+			ttl = envDuration("MEDIA_URL_TTL", 15*time.Minute)
+		}
+		if ttl > 24*time.Hour {
+			ttl = 24 * time.Hour
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		mediaType, _, _, _, _, _, _, err := messageStore.GetMediaInfo(req.MessageID, req.ChatJID)
+		if err != nil || mediaType == "" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(SignMediaResponse{
+				Success: false,
+				Message: "No media found for this message",
+			})
+			return
+		}
+
+		signedURL, err := signMediaURL(req.MessageID, req.ChatJID, ttl)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SignMediaResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to sign media URL: %v", err),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(SignMediaResponse{
+			Success:   true,
+			URL:       signedURL,
+			ExpiresAt: time.Now().Add(ttl).UTC(),
+		})
+	}
+}
+
+// handleSignedMediaFile serves GET /api/media/file for locally signed URLs.
+// The signature is the only credential, so this route must stay reachable
+// without other API authentication.
+func handleSignedMediaFile(client *whatsmeow.Client, messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		messageID := query.Get("message_id")
+		chatJID := query.Get("chat_jid")
+
+		if err := getLocalMediaSigner().Verify(messageID, chatJID, query.Get("expires"), query.Get("signature")); err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+
+		success, _, _, path, err := downloadMedia(client, messageStore, messageID, chatJID)
+		if !success || err != nil {
+			http.Error(w, "Media not available", http.StatusNotFound)
+			return
+		}
+
+		http.ServeFile(w, r, path)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// mentionsMe reports whether a message @mentions the logged in account, by
+// phone number or LID
+func mentionsMe(client *whatsmeow.Client, msg *waProto.Message) bool {
+	if client.Store.ID == nil {
+		return false
+	}
+
+	for _, mentioned := range messageContextInfo(msg).GetMentionedJID() {
+		jid, err := types.ParseJID(mentioned)
+		if err != nil {
+			continue
+		}
+		if jid.User == client.Store.ID.User && jid.Server == client.Store.ID.Server {
+			return true
+		}
+		if !client.Store.LID.IsEmpty() && jid.User == client.Store.LID.User && jid.Server == client.Store.LID.Server {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionedJIDs returns the JIDs a message @mentions
+func mentionedJIDs(msg *waProto.Message) []string {
+	return messageContextInfo(msg).GetMentionedJID()
+}
+
+// MentionStore is implemented by message stores that can keep the mentions
+// of a message and list messages mentioning the logged in account
+type MentionStore interface {
+	// StoreMentions saves the mentioned JIDs of a message and flags it when
+	// one of them is the logged in account
+	StoreMentions(id, chatJID string, mentioned []string, mentionedMe bool) error
+	// GetMentions lists flagged messages of all chats, newest first
+	GetMentions(query MessageQuery) ([]Message, error)
+}
+
+// StoreMentions saves the mentioned JIDs as JSON in the mentions column and
+// sets the mentioned_me flag of a message
+func (store *MessageStore) StoreMentions(id, chatJID string, mentioned []string, mentionedMe bool) error {
+	encoded, err := json.Marshal(mentioned)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec("UPDATE messages SET mentions = ?, mentioned_me = ? WHERE id = ? AND chat_jid = ?", string(encoded), mentionedMe, id, chatJID)
+	return err
+}
+
+// GetMentions lists messages mentioning the logged in account across chats
+func (store *MessageStore) GetMentions(query MessageQuery) ([]Message, error) {
+	sqlQuery, args := appendMessageFilters(
+		"SELECT "+messageColumns+" FROM messages WHERE mentioned_me = 1",
+		nil, query,
+	)
+	return store.queryMessages(sqlQuery, args...)
+}
+
+// StoreMentions saves the mentioned JIDs under "mentioned_jids" in the
+// message metadata and sets "mentioned_me" when the account is one of them
+func (s *SupabaseMessageStore) StoreMentions(id, chatJID string, mentioned []string, mentionedMe bool) error {
+	metadata := map[string]interface{}{"mentioned_jids": mentioned}
+	if mentionedMe {
+		metadata["mentioned_me"] = true
+	}
+	return s.mergeMetadata(id, chatJID, metadata)
+}
+
+// GetMentions lists messages mentioning the logged in account across
+// conversations, reading each message's chat from its conversation
+func (s *SupabaseMessageStore) GetMentions(query MessageQuery) ([]Message, error) {
+	params := messageQueryFilters(query)
+	params.Set("channel", "eq.whatsapp")
+	params.Set("metadata->>mentioned_me", "eq.true")
+	params.Set("select", supabaseMessageColumnsSelect+",conversation:conversations(contact_identifier)")
+	params.Set("order", "created_at.desc,external_id.desc")
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	resp, err := s.client.makeRequest("GET", "messages?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mentions: %v", err)
+	}
+
+	var rows []struct {
+		supabaseMessageRow
+		Conversation struct {
+			ContactIdentifier string `json:"contact_identifier"`
+		} `json:"conversation"`
+	}
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse mentions: %v", err)
+	}
+
+	messages := make([]Message, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, row.message(row.Conversation.ContactIdentifier))
+	}
+	return messages, nil
+}
+
+// ListMentionsResponse represents the response for the mentions feed
+type ListMentionsResponse struct {
+	Success    bool      `json:"success"`
+	Message    string    `json:"message,omitempty"`
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// handleListMentions serves GET /api/mentions?limit=<n>&cursor=<cursor>,
+// messages of all chats that mention the logged in account, newest first.
+// The after and before filters of the message list also apply.
+func handleListMentions(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		store, ok := messageStore.(MentionStore)
+		if !ok {
+			http.Error(w, "Mentions are not supported by the message store", http.StatusNotImplemented)
+			return
+		}
+
+		limit, err := parseLimit(r, 50, 1000)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		// Ask for one extra row to know whether another page exists
+		query := MessageQuery{Limit: limit + 1}
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			query.CursorTime, query.CursorID, err = decodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := parseMessageFilters(r, &query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		messages, err := store.GetMentions(query)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ListMentionsResponse{
+				Success:  false,
+				Message:  fmt.Sprintf("Failed to list mentions: %v", err),
+				Messages: []Message{},
+			})
+			return
+		}
+
+		resp := ListMentionsResponse{Success: true}
+		if len(messages) > limit {
+			messages = messages[:limit]
+			last := messages[len(messages)-1]
+			resp.NextCursor = encodeCursor(last.Time, last.ID)
+		}
+		if messages == nil {
+			messages = []Message{}
+		}
+		resp.Messages = messages
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
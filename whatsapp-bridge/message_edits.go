@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MessageEdit is an earlier version of an edited message
+type MessageEdit struct {
+	Body string `json:"body"`
+	// ReplacedAt is when the edit that replaced this version was made
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// EditStore is implemented by message stores that keep message edits and
+// revocations
+type EditStore interface {
+	// StoreEdit replaces a message's body, adding the old one to its
+	// edited_body history
+	StoreEdit(id, chatJID, body string, editedAt time.Time) error
+	// MarkRevoked sets deleted_at on a message its sender deleted
+	MarkRevoked(id, chatJID string, revokedAt time.Time) error
+}
+
+// StoreEdit replaces the content of a stored message and appends the old
+// content to its edit history
+func (store *MessageStore) StoreEdit(id, chatJID, body string, editedAt time.Time) error {
+	var content sql.NullString
+	var history sql.NullString
+	err := store.db.QueryRow("SELECT content, edited_body FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID).Scan(&content, &history)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var edits []MessageEdit
+	if history.Valid && history.String != "" {
+		if err := json.Unmarshal([]byte(history.String), &edits); err != nil {
+			return fmt.Errorf("failed to parse edit history: %v", err)
+		}
+	}
+	edits = append(edits, MessageEdit{Body: content.String, ReplacedAt: editedAt.UTC()})
+	encoded, err := json.Marshal(edits)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec("UPDATE messages SET content = ?, edited_body = ? WHERE id = ? AND chat_jid = ?", body, string(encoded), id, chatJID)
+	return err
+}
+
+// MarkRevoked records when a stored message was deleted by its sender. The
+// message is kept so the archive still shows what was said.
+func (store *MessageStore) MarkRevoked(id, chatJID string, revokedAt time.Time) error {
+	_, err := store.db.Exec("UPDATE messages SET deleted_at = ? WHERE id = ? AND chat_jid = ? AND deleted_at IS NULL", revokedAt.UTC(), id, chatJID)
+	return err
+}
+
+// StoreEdit replaces the body of a Supabase message and appends the old
+// body to its edited_body column, created with e.g.
+//
+//	ALTER TABLE messages ADD COLUMN edited_body jsonb, ADD COLUMN deleted_at timestamptz;
+func (s *SupabaseMessageStore) StoreEdit(id, chatJID, body string, editedAt time.Time) error {
+	filter, err := s.messageFilter(id, chatJID)
+	if err != nil || filter == "" {
+		return err
+	}
+	resp, err := s.client.makeRequest("GET", "messages?"+filter+"&select=id,body,edited_body", nil)
+	if err != nil {
+		return fmt.Errorf("failed to query message: %v", err)
+	}
+
+	var messages []struct {
+		ID         string        `json:"id"`
+		Body       *string       `json:"body"`
+		EditedBody []MessageEdit `json:"edited_body"`
+	}
+	if err := json.Unmarshal(resp, &messages); err != nil {
+		return fmt.Errorf("failed to parse message response: %v", err)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	previous := ""
+	if messages[0].Body != nil {
+		previous = *messages[0].Body
+	}
+	update := map[string]interface{}{
+		"body":        body,
+		"edited_body": append(messages[0].EditedBody, MessageEdit{Body: previous, ReplacedAt: editedAt.UTC()}),
+	}
+	_, err = s.client.makeRequest("PATCH", fmt.Sprintf("messages?id=eq.%s", pgValue(messages[0].ID)), update)
+	return err
+}
+
+// MarkRevoked sets deleted_at on a Supabase message its sender deleted
+func (s *SupabaseMessageStore) MarkRevoked(id, chatJID string, revokedAt time.Time) error {
+	filter, err := s.messageFilter(id, chatJID)
+	if err != nil || filter == "" {
+		return err
+	}
+	_, err = s.client.makeRequest("PATCH", "messages?"+filter+"&deleted_at=is.null", map[string]interface{}{"deleted_at": revokedAt.UTC().Format(time.RFC3339)})
+	return err
+}
+
+// handleMessageEdit applies edits and revocations of earlier messages to
+// the stored copies, when the store keeps them. Only the message's sender
+// may edit it; group admins may also revoke it.
+func handleMessageEdit(client *whatsmeow.Client, messageStore MessageStoreInterface, msg *events.Message, logger waLog.Logger) {
+	protocol := msg.Message.GetProtocolMessage()
+	store, ok := messageStore.(EditStore)
+	if protocol == nil || !ok {
+		return
+	}
+	revoke := protocol.GetType() == waProto.ProtocolMessage_REVOKE
+	if !revoke && protocol.GetType() != waProto.ProtocolMessage_MESSAGE_EDIT {
+		return
+	}
+
+	id := protocol.GetKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	timestamp := msg.Info.Timestamp
+	if ms := protocol.GetTimestampMS(); ms != 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	if allowed, err := editAllowed(client, messageStore, msg, id, revoke); err != nil {
+		logger.Warnf("Failed to look up edited message %s: %v", id, err)
+		return
+	} else if !allowed {
+		logger.Warnf("Ignoring change of message %s in %s by %s, who did not send it", id, chatJID, msg.Info.Sender)
+		return
+	}
+
+	switch protocol.GetType() {
+	case waProto.ProtocolMessage_MESSAGE_EDIT:
+		body := extractTextContent(protocol.GetEditedMessage())
+		// Caption edits of media messages carry the new caption
+		if body == "" {
+			body = mediaCaption(protocol.GetEditedMessage())
+		}
+		if err := store.StoreEdit(id, chatJID, body, timestamp); err != nil {
+			logger.Warnf("Failed to store edit of message %s: %v", id, err)
+		}
+
+	case waProto.ProtocolMessage_REVOKE:
+		if err := store.MarkRevoked(id, chatJID, timestamp); err != nil {
+			logger.Warnf("Failed to store deletion of message %s: %v", id, err)
+		}
+	}
+}
+
+// editAllowed reports whether the sender of an edit or revocation sent the
+// stored message it changes, or may revoke it as a group admin. Messages
+// that are not stored have nothing to change.
+func editAllowed(client *whatsmeow.Client, messageStore MessageStoreInterface, msg *events.Message, id string, revoke bool) (bool, error) {
+	store, ok := messageStore.(MessageLookupStore)
+	if !ok {
+		return false, nil
+	}
+	messages, err := store.MessagesByID(msg.Info.Chat.String(), []string{id})
+	if err != nil {
+		return false, err
+	}
+	original, found := messages[id]
+	if !found {
+		return false, nil
+	}
+
+	// Stores keep the user part of the sender, in the addressing the
+	// message came with
+	if original.IsFromMe {
+		if msg.Info.IsFromMe {
+			return true, nil
+		}
+	} else if original.Sender == msg.Info.Sender.User || (!msg.Info.SenderAlt.IsEmpty() && original.Sender == msg.Info.SenderAlt.User) {
+		return true, nil
+	}
+
+	if revoke && msg.Info.Chat.Server == types.GroupServer {
+		if isGroupAdmin(client, msg.Info.Chat, msg.Info.Sender) {
+			return true, nil
+		}
+		return !msg.Info.SenderAlt.IsEmpty() && isGroupAdmin(client, msg.Info.Chat, msg.Info.SenderAlt), nil
+	}
+	return false, nil
+}
+
+// mediaCaption returns the caption of an image, video or document message
+func mediaCaption(msg *waProto.Message) string {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
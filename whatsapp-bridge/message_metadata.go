@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// errMessageNotFound is returned when a message to update is not stored
+var errMessageNotFound = errors.New("message not found")
+
+// reservedMetadataKeys are the metadata keys the bridge writes itself.
+// Annotations cannot overwrite them, as media downloads, pins and replies
+// read them back.
+var reservedMetadataKeys = map[string]bool{
+	"media_type": true, "filename": true, "whatsapp_url": true, "media_key": true,
+	"file_sha256": true, "file_enc_sha256": true, "file_length": true,
+	"quoted_id": true, "quoted_sender": true, "quoted_snippet": true,
+	"mentioned_jids": true, "mentioned_me": true,
+	"pinned_at": true, "pinned_by": true, "pin_expires_at": true,
+	"sentiment": true, "language": true, "product": true,
+	"ephemeral": true, "expires_at": true,
+	"storage_backend": true, "storage_bucket": true, "storage_path": true, "media_url": true, "media_url_expires_at": true,
+	"send_error": true, "send_failure_reason": true,
+	compressedFieldKey: true, truncatedFieldsKey: true,
+}
+
+// MetadataStore is implemented by message stores that keep free-form
+// metadata on messages, so external systems can annotate them with ticket
+// IDs, handlers or classifications. Annotations survive the message being
+// stored again by a redelivery or history sync: SQLite's upsert leaves the
+// metadata column alone, and the other stores merge the stored metadata
+// with the message's, Supabase through the trigger of migration 0004.
+type MetadataStore interface {
+	// PatchMessageMetadata merges keys into a message's metadata. Keys set
+	// to nil are cleared. It returns errMessageNotFound for unknown
+	// messages.
+	PatchMessageMetadata(id, chatJID string, patch map[string]interface{}) error
+	// MessageMetadata returns a message's metadata. It returns
+	// errMessageNotFound for unknown messages.
+	MessageMetadata(id, chatJID string) (map[string]interface{}, error)
+}
+
+// decodeMetadata parses a stored metadata column, empty for NULL
+func decodeMetadata(encoded []byte) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse message metadata: %v", err)
+		}
+	}
+	return metadata, nil
+}
+
+// PatchMessageMetadata merges keys into the metadata column of a message.
+// Without chatJID the message is found by ID alone.
+func (store *MessageStore) PatchMessageMetadata(id, chatJID string, patch map[string]interface{}) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT chat_jid, metadata FROM messages WHERE id = ? AND (chat_jid = ? OR ? = '')", id, chatJID, chatJID)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		chatJID  string
+		metadata sql.NullString
+	}
+	var found []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.chatJID, &r.metadata); err != nil {
+			rows.Close()
+			return err
+		}
+		found = append(found, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+
+	for _, r := range found {
+		metadata := make(map[string]interface{})
+		if r.metadata.String != "" {
+			if err := json.Unmarshal([]byte(r.metadata.String), &metadata); err != nil {
+				return fmt.Errorf("failed to parse message metadata: %v", err)
+			}
+		}
+		for key, value := range patch {
+			if value == nil {
+				delete(metadata, key)
+				continue
+			}
+			metadata[key] = value
+		}
+
+		encoded, err := json.Marshal(getMetadataPolicy().Apply(metadata))
+		if err != nil {
+			return fmt.Errorf("failed to encode message metadata: %v", err)
+		}
+		if _, err := tx.Exec("UPDATE messages SET metadata = ? WHERE id = ? AND chat_jid = ?", string(encoded), id, r.chatJID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MessageMetadata returns the metadata column of a message. Without
+// chatJID the first message with the ID is used.
+func (store *MessageStore) MessageMetadata(id, chatJID string) (map[string]interface{}, error) {
+	var encoded sql.NullString
+	err := store.db.QueryRow("SELECT metadata FROM messages WHERE id = ? AND (chat_jid = ? OR ? = '') LIMIT 1", id, chatJID, chatJID).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeMetadata([]byte(encoded.String))
+}
+
+// PatchMessageMetadata merges keys into a message's metadata in Supabase
+func (s *SupabaseMessageStore) PatchMessageMetadata(id, chatJID string, patch map[string]interface{}) error {
+	return s.mergeMetadata(id, chatJID, patch)
+}
+
+// MessageMetadata returns a message's metadata from Supabase
+func (s *SupabaseMessageStore) MessageMetadata(id, chatJID string) (map[string]interface{}, error) {
+	filter, err := s.messageFilter(id, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	if filter == "" {
+		return nil, fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+	resp, err := s.client.makeRequest("GET", "messages?"+filter+"&select=metadata", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message: %v", err)
+	}
+
+	var rows []struct {
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse message response: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+	if string(rows[0].Metadata) == "null" {
+		return make(map[string]interface{}), nil
+	}
+	return decodeMetadata(rows[0].Metadata)
+}
+
+// PatchMessageMetadata merges keys into a message's metadata
+func (s *PostgresMessageStore) PatchMessageMetadata(id, chatJID string, patch map[string]interface{}) error {
+	return s.mergeMetadata(id, patch)
+}
+
+// MessageMetadata returns a message's metadata
+func (s *PostgresMessageStore) MessageMetadata(id, chatJID string) (map[string]interface{}, error) {
+	var encoded []byte
+	err := s.pool.QueryRow(context.Background(),
+		"SELECT metadata FROM "+s.messages+" WHERE external_id = $1 AND channel = 'whatsapp' LIMIT 1", id,
+	).Scan(&encoded)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message: %v", err)
+	}
+	return decodeMetadata(encoded)
+}
+
+// PatchMessageMetadata merges keys into a message's metadata
+func (s *MySQLMessageStore) PatchMessageMetadata(id, chatJID string, patch map[string]interface{}) error {
+	return s.mergeMetadata(id, chatJID, patch)
+}
+
+// MessageMetadata returns a message's metadata
+func (s *MySQLMessageStore) MessageMetadata(id, chatJID string) (map[string]interface{}, error) {
+	var encoded []byte
+	err := s.db.QueryRow(mysqlChatMessageQuery("m.metadata"), id, chatJID).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message: %v", err)
+	}
+	return decodeMetadata(encoded)
+}
+
+// MessageMetadataRequest is the body of a metadata patch. Metadata holds
+// the keys to set, null clearing a key; ChatJID narrows the lookup in
+// stores where message IDs are only unique per chat.
+type MessageMetadataRequest struct {
+	ChatJID  string                 `json:"chat_jid,omitempty"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// MessageMetadataResponse represents the response for the metadata API
+type MessageMetadataResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	MessageID string `json:"message_id"`
+	// Keys lists the keys set or cleared
+	Keys []string `json:"keys,omitempty"`
+	// Metadata is the message's metadata, returned by GET
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// handleMessageMetadata serves GET /api/messages/{id}/metadata?chat_jid=<jid>,
+// returning a stored message's metadata, and PATCH, which merges the given
+// keys into it and needs the send scope. Keys the bridge writes itself are
+// rejected.
+func handleMessageMetadata(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Method == http.MethodPatch && !requireScope(w, r, ScopeSend) {
+			return
+		}
+
+		store, ok := messageStore.(MetadataStore)
+		if !ok {
+			http.Error(w, "Message store does not support metadata", http.StatusNotImplemented)
+			return
+		}
+
+		id := r.PathValue("id")
+		if id == "" {
+			http.Error(w, "Message ID is required", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			metadata, err := store.MessageMetadata(id, r.URL.Query().Get("chat_jid"))
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, errMessageNotFound) {
+					status = http.StatusNotFound
+				}
+				w.WriteHeader(status)
+				json.NewEncoder(w).Encode(MessageMetadataResponse{
+					Success:   false,
+					Message:   fmt.Sprintf("Failed to read metadata: %v", err),
+					MessageID: id,
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(MessageMetadataResponse{Success: true, MessageID: id, Metadata: metadata})
+			return
+		}
+
+		var req MessageMetadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if len(req.Metadata) == 0 {
+			http.Error(w, "metadata must set at least one key", http.StatusBadRequest)
+			return
+		}
+
+		keys := make([]string, 0, len(req.Metadata))
+		var reserved []string
+		for key := range req.Metadata {
+			if key == "" {
+				http.Error(w, "Metadata keys must not be empty", http.StatusBadRequest)
+				return
+			}
+			if reservedMetadataKeys[key] {
+				reserved = append(reserved, key)
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(reserved) > 0 {
+			sort.Strings(reserved)
+			http.Error(w, fmt.Sprintf("Metadata keys written by the bridge cannot be patched: %s", strings.Join(reserved, ", ")), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := store.PatchMessageMetadata(id, req.ChatJID, req.Metadata); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errMessageNotFound) {
+				status = http.StatusNotFound
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(MessageMetadataResponse{
+				Success:   false,
+				Message:   fmt.Sprintf("Failed to update metadata: %v", err),
+				MessageID: id,
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(MessageMetadataResponse{Success: true, MessageID: id, Keys: keys})
+	}
+}
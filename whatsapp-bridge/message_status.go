@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MessageStatusStore is implemented by message stores that keep the
+// delivery status of outbound messages
+type MessageStatusStore interface {
+	// UpdateMessageStatus moves the given outbound messages of a chat to a
+	// status, leaving messages that are already further along untouched
+	UpdateMessageStatus(chatJID string, ids []string, status string) error
+}
+
+// earlierStatuses returns the statuses a message may move to status from
+func earlierStatuses(status string) []string {
+	var earlier []string
+	for _, candidate := range []string{SendStatusSent, SendStatusDelivered, SendStatusRead} {
+		if sendStatusRank[candidate] < sendStatusRank[status] {
+			earlier = append(earlier, candidate)
+		}
+	}
+	return earlier
+}
+
+// UpdateMessageStatus sets the status column of outbound messages
+func (store *MessageStore) UpdateMessageStatus(chatJID string, ids []string, status string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []interface{}{status, chatJID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	earlier := earlierStatuses(status)
+	for _, s := range earlier {
+		args = append(args, s)
+	}
+
+	query := `UPDATE messages SET status = ? WHERE chat_jid = ? AND is_from_me = 1
+		AND id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + `)
+		AND (status IS NULL`
+	if len(earlier) > 0 {
+		query += ` OR status IN (` + strings.TrimSuffix(strings.Repeat("?,", len(earlier)), ",") + `)`
+	}
+	_, err := store.db.Exec(query+")", args...)
+	return err
+}
+
+// UpdateMessageStatus PATCHes the status column of outbound messages by
+// external ID within the chat's conversation
+func (s *SupabaseMessageStore) UpdateMessageStatus(chatJID string, ids []string, status string) error {
+	conversationID, err := s.conversationID(chatJID)
+	if err != nil || conversationID == "" {
+		return err
+	}
+	return s.client.UpdateMessageStatus(conversationID, ids, status)
+}
+
+// UpdateMessageStatus moves outbound WhatsApp messages of a conversation to
+// a status unless their row already has a later one
+func (s *SupabaseClient) UpdateMessageStatus(conversationID string, ids []string, status string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	conditions := []string{"status.is.null"}
+	if earlier := earlierStatuses(status); len(earlier) > 0 {
+		quoted := make([]string, len(earlier))
+		for i, s := range earlier {
+			quoted[i] = pgQuote(s)
+		}
+		conditions = append(conditions, "status.in.("+strings.Join(quoted, ",")+")")
+	}
+
+	endpoint := fmt.Sprintf("messages?conversation_id=eq.%s&channel=eq.whatsapp&direction=eq.outbound&external_id=in.%s&or=%s",
+		pgValue(conversationID), pgList(ids), pgValue("("+strings.Join(conditions, ",")+")"))
+	if _, err := s.makeRequest("PATCH", endpoint, map[string]interface{}{"status": status}); err != nil {
+		return fmt.Errorf("failed to update message status: %v", err)
+	}
+	return nil
+}
+
+// receiptStatuses remembers the status last stored per message, so the
+// receipts of every member of a group move a message once per status
+// instead of each making its own update
+var receiptStatuses = newLRUCache(10000, time.Hour)
+
+// handleReceiptStatus stores the delivered and read status that receipts
+// report for the account's messages, when the store keeps it. Messages the
+// status was already stored for, or a later one, are skipped.
+func handleReceiptStatus(messageStore MessageStoreInterface, receipt *events.Receipt, logger waLog.Logger) {
+	store, ok := messageStore.(MessageStatusStore)
+	// Receipts from our own devices say nothing about the recipient
+	if !ok || receipt.IsFromMe {
+		return
+	}
+
+	var status string
+	switch receipt.Type {
+	case types.ReceiptTypeDelivered:
+		status = SendStatusDelivered
+	case types.ReceiptTypeRead, types.ReceiptTypePlayed:
+		status = SendStatusRead
+	default:
+		return
+	}
+
+	chatJID := receipt.Chat.String()
+	var ids []string
+	for _, id := range receipt.MessageIDs {
+		if stored, ok := receiptStatuses.Get(chatJID + "|" + id); ok && sendStatusRank[stored] >= sendStatusRank[status] {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	if err := store.UpdateMessageStatus(chatJID, ids, status); err != nil {
+		logger.Warnf("Failed to store %s status of %d messages in %s: %v", status, len(ids), receipt.Chat, err)
+		return
+	}
+	for _, id := range ids {
+		receiptStatuses.Set(chatJID+"|"+id, status)
+	}
+}
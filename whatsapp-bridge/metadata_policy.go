@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// Metadata size policies applied before message metadata is written to
+// Supabase
+const (
+	// MetadataPolicyCompress gzips oversized fields and drops fields that
+	// are still too large
+	MetadataPolicyCompress = "compress"
+	// MetadataPolicyTruncate shortens oversized strings and drops other
+	// oversized fields
+	MetadataPolicyTruncate = "truncate"
+	// MetadataPolicyOff stores metadata as is
+	MetadataPolicyOff = "off"
+)
+
+// compressedFieldKey marks a field whose JSON value was replaced by its
+// gzipped, base64 encoded form
+const compressedFieldKey = "$gzip"
+
+// truncatedFieldsKey lists the fields that were cut or dropped
+const truncatedFieldsKey = "_truncated"
+
+// MetadataPolicy bounds the size of metadata rows. Small fields such as
+// media_type are never touched, so they stay filterable in PostgREST.
+type MetadataPolicy struct {
+	mode          string
+	maxFieldBytes int
+	maxTotalBytes int
+}
+
+var (
+	metadataPolicy     *MetadataPolicy
+	metadataPolicyOnce sync.Once
+)
+
+// getMetadataPolicy reads SUPABASE_METADATA_POLICY, with field and row
+// limits from SUPABASE_METADATA_MAX_FIELD_BYTES and
+// SUPABASE_METADATA_MAX_BYTES
+func getMetadataPolicy() *MetadataPolicy {
+	metadataPolicyOnce.Do(func() {
+		metadataPolicy = &MetadataPolicy{
+			mode:          envString("SUPABASE_METADATA_POLICY", MetadataPolicyCompress),
+			maxFieldBytes: envInt("SUPABASE_METADATA_MAX_FIELD_BYTES", 8<<10),
+			maxTotalBytes: envInt("SUPABASE_METADATA_MAX_BYTES", 64<<10),
+		}
+	})
+	return metadataPolicy
+}
+
+// Apply returns metadata that fits the configured limits. The input map is
+// not modified.
+func (p *MetadataPolicy) Apply(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil || p.mode == MetadataPolicyOff {
+		return metadata
+	}
+
+	result := make(map[string]interface{}, len(metadata))
+	var truncated []string
+
+	// Visit keys in a stable order so the same input always produces the
+	// same row
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	total := 0
+	for _, key := range keys {
+		value := metadata[key]
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			truncated = append(truncated, key)
+			continue
+		}
+
+		if len(encoded) > p.maxFieldBytes {
+			shrunk, reencoded, ok := p.shrink(value, encoded)
+			if !ok {
+				truncated = append(truncated, key)
+				continue
+			}
+			if p.mode == MetadataPolicyTruncate {
+				truncated = append(truncated, key)
+			}
+			value, encoded = shrunk, reencoded
+		}
+
+		if total+len(encoded) > p.maxTotalBytes {
+			truncated = append(truncated, key)
+			continue
+		}
+
+		total += len(encoded)
+		result[key] = value
+	}
+
+	if len(truncated) > 0 {
+		result[truncatedFieldsKey] = truncated
+	}
+	return result
+}
+
+// shrink reduces an oversized field according to the policy mode. It
+// returns false when the field should be dropped.
+func (p *MetadataPolicy) shrink(value interface{}, encoded []byte) (interface{}, []byte, bool) {
+	switch p.mode {
+	case MetadataPolicyCompress:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(encoded)
+		gz.Close()
+
+		compressed := map[string]interface{}{compressedFieldKey: base64.StdEncoding.EncodeToString(buf.Bytes())}
+		reencoded, _ := json.Marshal(compressed)
+		if len(reencoded) <= p.maxFieldBytes {
+			return compressed, reencoded, true
+		}
+
+	case MetadataPolicyTruncate:
+		if text, ok := value.(string); ok {
+			// Leave room for JSON quoting and escapes
+			cut := truncateUTF8(text, p.maxFieldBytes/2)
+			reencoded, _ := json.Marshal(cut)
+			if len(reencoded) <= p.maxFieldBytes {
+				return cut, reencoded, true
+			}
+		}
+	}
+
+	return nil, nil, false
+}
+
+// truncateUTF8 cuts a string to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ConversationMetrics are the support metrics of a single conversation
+type ConversationMetrics struct {
+	ChatJID          string     `json:"chat_jid"`
+	FirstInboundAt   *time.Time `json:"first_inbound_at,omitempty"`
+	FirstResponseAt  *time.Time `json:"first_response_at,omitempty"`
+	FirstResponseSec *int64     `json:"first_response_seconds,omitempty"`
+	InboundCount     int        `json:"inbound_count"`
+	OutboundCount    int        `json:"outbound_count"`
+	// Exchanges counts replies to the contact, a run of inbound messages
+	// followed by an answer is one exchange
+	Exchanges int `json:"exchanges"`
+	// AwaitingSince is set while the contact is waiting for an answer
+	AwaitingSince *time.Time `json:"awaiting_since,omitempty"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+}
+
+// StatsSummary aggregates metrics over conversations
+type StatsSummary struct {
+	Conversations          int     `json:"conversations"`
+	Responded              int     `json:"responded"`
+	Awaiting               int     `json:"awaiting"`
+	Resolved               int     `json:"resolved"`
+	Exchanges              int     `json:"exchanges"`
+	AvgFirstResponseSec    float64 `json:"avg_first_response_seconds"`
+	MedianFirstResponseSec int64   `json:"median_first_response_seconds"`
+}
+
+// ConversationStats maintains per-conversation support metrics in the
+// bridge database, updated from message events
+type ConversationStats struct {
+	db     *sql.DB
+	logger waLog.Logger
+}
+
+// conversationStats is the active metrics tracker, nil when disabled
+var conversationStats *ConversationStats
+
+// NewConversationStats creates the metrics table in the bridge database
+func NewConversationStats(db *sql.DB, logger waLog.Logger) (*ConversationStats, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversation_metrics (
+			chat_jid TEXT PRIMARY KEY,
+			first_inbound_at TIMESTAMP,
+			first_response_at TIMESTAMP,
+			first_response_seconds INTEGER,
+			inbound_count INTEGER NOT NULL DEFAULT 0,
+			outbound_count INTEGER NOT NULL DEFAULT 0,
+			exchanges INTEGER NOT NULL DEFAULT 0,
+			awaiting_since TIMESTAMP,
+			resolved_at TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation metrics table: %v", err)
+	}
+
+	return &ConversationStats{db: db, logger: logger}, nil
+}
+
+// HandleEvent implements EventSubscriber by updating the metrics of the
+// conversation a message belongs to
+func (s *ConversationStats) HandleEvent(evt Event) {
+	if evt.Type != EventMessageReceived && evt.Type != EventMessageSent {
+		return
+	}
+
+	var payload MessageEventPayload
+	if err := evt.DecodePayload(&payload); err != nil {
+		return
+	}
+
+	if err := s.record(payload); err != nil {
+		s.logger.Warnf("Failed to update metrics for %s: %v", payload.ChatJID, err)
+	}
+}
+
+// record applies a single message to the metrics of its conversation
+func (s *ConversationStats) record(msg MessageEventPayload) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var firstInbound, firstResponse, awaiting sql.NullTime
+	err = tx.QueryRow(
+		"SELECT first_inbound_at, first_response_at, awaiting_since FROM conversation_metrics WHERE chat_jid = ?",
+		msg.ChatJID,
+	).Scan(&firstInbound, &firstResponse, &awaiting)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("INSERT INTO conversation_metrics (chat_jid) VALUES (?)", msg.ChatJID)
+	}
+	if err != nil {
+		return err
+	}
+
+	at := msg.Timestamp.UTC()
+	if !msg.IsFromMe {
+		// A new question reopens a resolved conversation
+		if !firstInbound.Valid {
+			firstInbound = sql.NullTime{Time: at, Valid: true}
+		}
+		if !awaiting.Valid {
+			awaiting = sql.NullTime{Time: at, Valid: true}
+		}
+		_, err = tx.Exec(
+			`UPDATE conversation_metrics SET first_inbound_at = ?, awaiting_since = ?, inbound_count = inbound_count + 1,
+			resolved_at = NULL WHERE chat_jid = ?`,
+			firstInbound, awaiting, msg.ChatJID,
+		)
+	} else {
+		exchange := 0
+		if awaiting.Valid {
+			exchange = 1
+		}
+		var responseSec sql.NullInt64
+		if !firstResponse.Valid && firstInbound.Valid && !at.Before(firstInbound.Time) {
+			firstResponse = sql.NullTime{Time: at, Valid: true}
+			responseSec = sql.NullInt64{Int64: int64(at.Sub(firstInbound.Time) / time.Second), Valid: true}
+		}
+		_, err = tx.Exec(
+			`UPDATE conversation_metrics SET first_response_at = ?, first_response_seconds = COALESCE(first_response_seconds, ?),
+			awaiting_since = NULL, outbound_count = outbound_count + 1, exchanges = exchanges + ? WHERE chat_jid = ?`,
+			firstResponse, responseSec, exchange, msg.ChatJID,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergeChat moves the metrics of a chat merged into another. When both have
+// them, counts add up and the earlier first message and response, and the
+// earlier wait for a reply, are kept.
+func (s *ConversationStats) MergeChat(fromJID, intoJID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_metrics (chat_jid, first_inbound_at, first_response_at, first_response_seconds,
+			inbound_count, outbound_count, exchanges, awaiting_since, resolved_at)
+		SELECT ?, first_inbound_at, first_response_at, first_response_seconds,
+			inbound_count, outbound_count, exchanges, awaiting_since, resolved_at
+		FROM conversation_metrics WHERE chat_jid = ?
+		ON CONFLICT(chat_jid) DO UPDATE SET
+			inbound_count = conversation_metrics.inbound_count + excluded.inbound_count,
+			outbound_count = conversation_metrics.outbound_count + excluded.outbound_count,
+			exchanges = conversation_metrics.exchanges + excluded.exchanges,
+			first_inbound_at = COALESCE(MIN(conversation_metrics.first_inbound_at, excluded.first_inbound_at), conversation_metrics.first_inbound_at, excluded.first_inbound_at),
+			first_response_seconds = CASE WHEN conversation_metrics.first_response_at IS NULL OR excluded.first_response_at < conversation_metrics.first_response_at
+				THEN excluded.first_response_seconds ELSE conversation_metrics.first_response_seconds END,
+			first_response_at = CASE WHEN conversation_metrics.first_response_at IS NULL OR excluded.first_response_at < conversation_metrics.first_response_at
+				THEN excluded.first_response_at ELSE conversation_metrics.first_response_at END,
+			awaiting_since = COALESCE(MIN(conversation_metrics.awaiting_since, excluded.awaiting_since), conversation_metrics.awaiting_since, excluded.awaiting_since),
+			resolved_at = COALESCE(MAX(conversation_metrics.resolved_at, excluded.resolved_at), conversation_metrics.resolved_at, excluded.resolved_at)`,
+		intoJID, fromJID,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM conversation_metrics WHERE chat_jid = ?", fromJID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Resolve marks a conversation resolved; the next inbound message reopens it
+func (s *ConversationStats) Resolve(chatJID string, at time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO conversation_metrics (chat_jid, resolved_at) VALUES (?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET resolved_at = excluded.resolved_at, awaiting_since = NULL`,
+		chatJID, at.UTC(),
+	)
+	return err
+}
+
+// Get returns the metrics of a conversation, nil when none are recorded
+func (s *ConversationStats) Get(chatJID string) (*ConversationMetrics, error) {
+	list, err := s.query("WHERE chat_jid = ?", chatJID)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// query returns metrics rows matching a WHERE clause
+func (s *ConversationStats) query(where string, args ...interface{}) ([]ConversationMetrics, error) {
+	rows, err := s.db.Query(
+		`SELECT chat_jid, first_inbound_at, first_response_at, first_response_seconds, inbound_count, outbound_count,
+		exchanges, awaiting_since, resolved_at FROM conversation_metrics `+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nullTime := func(t sql.NullTime) *time.Time {
+		if !t.Valid {
+			return nil
+		}
+		return &t.Time
+	}
+
+	var list []ConversationMetrics
+	for rows.Next() {
+		var m ConversationMetrics
+		var firstInbound, firstResponse, awaiting, resolved sql.NullTime
+		var responseSec sql.NullInt64
+		err := rows.Scan(&m.ChatJID, &firstInbound, &firstResponse, &responseSec, &m.InboundCount, &m.OutboundCount,
+			&m.Exchanges, &awaiting, &resolved)
+		if err != nil {
+			return nil, err
+		}
+		m.FirstInboundAt, m.FirstResponseAt = nullTime(firstInbound), nullTime(firstResponse)
+		m.AwaitingSince, m.ResolvedAt = nullTime(awaiting), nullTime(resolved)
+		if responseSec.Valid {
+			m.FirstResponseSec = &responseSec.Int64
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// Summary aggregates the conversations first contacted in [from, to)
+func (s *ConversationStats) Summary(from, to time.Time) (*StatsSummary, error) {
+	list, err := s.query("WHERE first_inbound_at >= ? AND first_inbound_at < ?", from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &StatsSummary{Conversations: len(list)}
+	var responseTimes []int64
+	var total int64
+	for _, m := range list {
+		summary.Exchanges += m.Exchanges
+		if m.FirstResponseSec != nil {
+			summary.Responded++
+			responseTimes = append(responseTimes, *m.FirstResponseSec)
+			total += *m.FirstResponseSec
+		}
+		if m.AwaitingSince != nil {
+			summary.Awaiting++
+		}
+		if m.ResolvedAt != nil {
+			summary.Resolved++
+		}
+	}
+
+	if len(responseTimes) > 0 {
+		sort.Slice(responseTimes, func(i, j int) bool { return responseTimes[i] < responseTimes[j] })
+		summary.AvgFirstResponseSec = float64(total) / float64(len(responseTimes))
+		summary.MedianFirstResponseSec = responseTimes[len(responseTimes)/2]
+	}
+
+	return summary, nil
+}
+
+// StatsResponse represents the response for the stats API
+type StatsResponse struct {
+	Success      bool                 `json:"success"`
+	Message      string               `json:"message,omitempty"`
+	From         *time.Time           `json:"from,omitempty"`
+	To           *time.Time           `json:"to,omitempty"`
+	Summary      *StatsSummary        `json:"summary,omitempty"`
+	Conversation *ConversationMetrics `json:"conversation,omitempty"`
+}
+
+// statsUnavailable answers stats requests while metrics are disabled
+func statsUnavailable(w http.ResponseWriter) bool {
+	if conversationStats != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(StatsResponse{
+		Success: false,
+		Message: "Conversation metrics are disabled (set CONVERSATION_METRICS=true)",
+	})
+	return true
+}
+
+// handleStats serves GET /api/stats?chat_jid=<jid> with the metrics of one
+// conversation, or GET /api/stats?from=<RFC3339>&to=<RFC3339> with a summary
+// of the conversations first contacted in that period (default: last 7 days)
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if statsUnavailable(w) {
+		return
+	}
+
+	params := r.URL.Query()
+	if chatJID := params.Get("chat_jid"); chatJID != "" {
+		metrics, err := conversationStats.Get(chatJID)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(StatsResponse{Success: false, Message: fmt.Sprintf("Failed to read metrics: %v", err)})
+		case metrics == nil:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(StatsResponse{Success: false, Message: "No metrics for this conversation"})
+		default:
+			json.NewEncoder(w).Encode(StatsResponse{Success: true, Conversation: metrics})
+		}
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-7 * 24 * time.Hour)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s, expected RFC 3339", name), http.StatusBadRequest)
+			return
+		}
+		*target = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	summary, err := conversationStats.Summary(from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(StatsResponse{Success: false, Message: fmt.Sprintf("Failed to read metrics: %v", err)})
+		return
+	}
+
+	json.NewEncoder(w).Encode(StatsResponse{Success: true, From: &from, To: &to, Summary: summary})
+}
+
+// handleStatsResolve serves POST /api/stats/resolve {"chat_jid": "..."}
+func handleStatsResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if statsUnavailable(w) {
+		return
+	}
+
+	var req struct {
+		ChatJID string `json:"chat_jid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.ChatJID == "" {
+		http.Error(w, "Chat JID is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := conversationStats.Resolve(req.ChatJID, time.Now()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Failed to resolve conversation: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"chat_jid": req.ChatJID,
+	})
+}
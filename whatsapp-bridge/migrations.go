@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// supabaseMigrationFiles are the versioned SQL migrations of the Supabase
+// schema, named <version>_<name>.sql. Every statement is idempotent, so a
+// dump of all of them can be run on a database that already has some.
+//
+//go:embed migrations/*.sql
+var supabaseMigrationFiles embed.FS
+
+// migrationsTable records the applied migration versions
+const migrationsTable = "bridge_schema_migrations"
+
+// schemaMigration is one versioned SQL migration
+type schemaMigration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations returns the embedded migrations ordered by version
+func loadMigrations() ([]schemaMigration, error) {
+	return loadMigrationsFrom(supabaseMigrationFiles, "migrations")
+}
+
+// loadMigrationsFrom returns the migrations in a directory of files ordered
+// by version
+func loadMigrationsFrom(files embed.FS, dir string) ([]schemaMigration, error) {
+	entries, err := files.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []schemaMigration
+	for _, entry := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		n, err := strconv.Atoi(version)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", entry.Name())
+		}
+		sql, err := files.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, schemaMigration{Version: n, Name: name, SQL: string(sql)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationsTableSQL creates the table of applied versions
+const migrationsTableSQL = `CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+);
+`
+
+// script returns the migration followed by the insert recording it
+func (m schemaMigration) script() string {
+	return fmt.Sprintf("%s\nINSERT INTO %s (version, name) VALUES (%d, '%s') ON CONFLICT (version) DO NOTHING;\n",
+		m.SQL, migrationsTable, m.Version, strings.ReplaceAll(m.Name, "'", "''"))
+}
+
+// writeMigrationDump writes all migrations as one script, each in its own
+// transaction, to paste into the Supabase SQL editor or pipe into psql.
+// Tables are renamed and created in the schema configured for the bridge.
+func writeMigrationDump(out io.Writer, migrations []schemaMigration, tables *supabaseTables) error {
+	var b strings.Builder
+	b.WriteString(migrationsTableSQL)
+	for _, m := range migrations {
+		fmt.Fprintf(&b, "\n-- %04d %s\nBEGIN;\n%sCOMMIT;\n", m.Version, m.Name, m.script())
+	}
+	_, err := io.WriteString(out, "-- WhatsApp bridge schema, safe to run again on a migrated database\n\n"+tables.rewriteSQL(b.String()))
+	return err
+}
+
+// migrationsLockKey is the advisory lock held while migrating, so bridges
+// starting together wait for each other instead of both migrating
+const migrationsLockKey = "hashtext('" + migrationsTable + "')"
+
+// migratePostgres applies pending migrations over a database connection,
+// each in a single transaction. The connection's search_path is left on the
+// configured schema.
+func migratePostgres(ctx context.Context, conn *pgx.Conn, tables *supabaseTables, logger waLog.Logger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock("+migrationsLockKey+")"); err != nil {
+		return fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock("+migrationsLockKey+")")
+
+	if _, err := conn.Exec(ctx, tables.rewriteSQL(migrationsTableSQL)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+	rows, err := conn.Query(ctx, "SELECT version FROM "+migrationsTable)
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	applied := make(map[int]bool, len(versions))
+	for _, version := range versions {
+		applied[int(version)] = true
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		pending++
+		logger.Infof("Applying migration %04d %s", m.Version, m.Name)
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, tables.rewriteSQL(m.script()))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d %s: %v", m.Version, m.Name, err)
+		}
+	}
+	if pending == 0 {
+		logger.Infof("Schema is up to date")
+	}
+	return nil
+}
+
+// runMigrateCommand implements the migrate subcommand. It applies pending
+// migrations over a connection to SUPABASE_DB_URL, the Postgres connection
+// string of the Supabase project, else DATABASE_URL, or with -dump writes
+// them as a SQL file.
+func runMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	databaseURL := flags.String("database-url", envString("SUPABASE_DB_URL", envString("DATABASE_URL", "")), "Postgres connection string")
+	dump := flags.String("dump", "", "write the migrations to this file, - for stdout, instead of applying them")
+	verify := flags.Bool("verify", false, "only check the schema through the REST API")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *verify {
+		client, err := NewSupabaseClient()
+		if err != nil {
+			return err
+		}
+		if err := verifySupabaseSchema(client); err != nil {
+			return err
+		}
+		fmt.Println("Schema has the tables and columns the bridge needs")
+		return nil
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+	tables, err := newSupabaseTables()
+	if err != nil {
+		return err
+	}
+
+	if *dump != "" {
+		if *dump == "-" {
+			return writeMigrationDump(os.Stdout, migrations, tables)
+		}
+		f, err := os.Create(*dump)
+		if err != nil {
+			return err
+		}
+		if err := writeMigrationDump(f, migrations, tables); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	if *databaseURL == "" {
+		return fmt.Errorf("set SUPABASE_DB_URL, DATABASE_URL or -database-url, or use -dump to write the SQL")
+	}
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, *databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+	return migratePostgres(ctx, conn, tables, waLog.Stdout("Migrate", "INFO", true))
+}
+
+// requiredSupabaseColumns are the columns the bridge cannot work without.
+// Columns of optional features are left to those features.
+var requiredSupabaseColumns = map[string][]string{
+	"conversations": {"id", "channel", "contact_identifier", "contact_name", "last_message_at", "status", "unread_count"},
+	"messages":      {"id", "conversation_id", "channel", "direction", "sender", "recipient", "body", "external_id", "metadata", "is_read", "status", "created_at"},
+}
+
+// verifySupabaseSchema selects the required columns of each table through
+// the REST API, which fails for missing tables and columns
+func verifySupabaseSchema(client *SupabaseClient) error {
+	tables := make([]string, 0, len(requiredSupabaseColumns))
+	for table := range requiredSupabaseColumns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var problems []string
+	for _, table := range tables {
+		endpoint := table + "?select=" + strings.Join(requiredSupabaseColumns[table], ",") + "&limit=0"
+		if _, err := client.makeRequest("GET", endpoint, nil); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("schema check failed, run the migrate command: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+-- Conversations and messages, the tables every Supabase deployment needs
+CREATE TABLE IF NOT EXISTS conversations (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	channel text NOT NULL DEFAULT 'whatsapp',
+	contact_identifier text NOT NULL,
+	contact_name text,
+	last_message_at timestamptz,
+	status text NOT NULL DEFAULT 'active',
+	unread_count integer NOT NULL DEFAULT 0,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- One conversation per contact, so concurrent creates cannot duplicate it
+CREATE UNIQUE INDEX IF NOT EXISTS conversations_contact_identifier_channel_key
+	ON conversations (contact_identifier, channel);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	conversation_id uuid NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+	channel text NOT NULL DEFAULT 'whatsapp',
+	direction text NOT NULL,
+	sender text NOT NULL,
+	recipient text NOT NULL,
+	body text,
+	external_id text,
+	metadata jsonb,
+	is_read boolean NOT NULL DEFAULT false,
+	status text,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Upserts of re-processed WhatsApp messages conflict on this key
+CREATE UNIQUE INDEX IF NOT EXISTS messages_external_id_channel_key
+	ON messages (external_id, channel);
+
+CREATE INDEX IF NOT EXISTS messages_conversation_id_created_at_idx
+	ON messages (conversation_id, created_at DESC);
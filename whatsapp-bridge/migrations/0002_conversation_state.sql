@@ -0,0 +1,14 @@
+-- Columns of summaries, contact aliases, chat state, edits and the trash
+ALTER TABLE conversations
+	ADD COLUMN IF NOT EXISTS summary text,
+	ADD COLUMN IF NOT EXISTS summary_updated_at timestamptz,
+	ADD COLUMN IF NOT EXISTS person_id text,
+	ADD COLUMN IF NOT EXISTS is_pinned boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS is_muted boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS muted_until timestamptz,
+	ADD COLUMN IF NOT EXISTS trashed_at timestamptz;
+
+ALTER TABLE messages
+	ADD COLUMN IF NOT EXISTS edited_body jsonb,
+	ADD COLUMN IF NOT EXISTS deleted_at timestamptz,
+	ADD COLUMN IF NOT EXISTS trashed_at timestamptz;
@@ -0,0 +1,35 @@
+-- Contact sync, group participants and reactions
+CREATE TABLE IF NOT EXISTS contacts (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	jid text NOT NULL,
+	channel text NOT NULL DEFAULT 'whatsapp',
+	first_name text,
+	full_name text,
+	push_name text,
+	business_name text,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	UNIQUE (jid, channel)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	chat_jid text NOT NULL,
+	channel text NOT NULL DEFAULT 'whatsapp',
+	participant_jid text NOT NULL,
+	phone_number text,
+	role text NOT NULL DEFAULT 'member',
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	UNIQUE (chat_jid, channel, participant_jid)
+);
+
+CREATE TABLE IF NOT EXISTS reactions (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	message_id text NOT NULL,
+	chat_jid text NOT NULL,
+	channel text NOT NULL DEFAULT 'whatsapp',
+	sender text NOT NULL,
+	emoji text NOT NULL,
+	is_from_me boolean NOT NULL DEFAULT false,
+	reacted_at timestamptz NOT NULL,
+	UNIQUE (message_id, channel, sender)
+);
@@ -0,0 +1,18 @@
+-- Upserts (SUPABASE_UPSERT=true) send a re-processed message's whole row
+-- again. Merge the metadata they carry into the stored metadata instead of
+-- replacing it, so keys added after the insert (quotes, pins, media keys,
+-- annotations) survive. PATCHes of the metadata still replace it.
+CREATE OR REPLACE FUNCTION messages_merge_upsert_metadata() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+	IF current_setting('request.method', true) = 'POST' THEN
+		NEW.metadata := COALESCE(OLD.metadata, '{}'::jsonb) || COALESCE(NEW.metadata, '{}'::jsonb);
+	END IF;
+	RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS messages_merge_upsert_metadata ON messages;
+CREATE TRIGGER messages_merge_upsert_metadata
+	BEFORE UPDATE OF metadata ON messages
+	FOR EACH ROW EXECUTE FUNCTION messages_merge_upsert_metadata();
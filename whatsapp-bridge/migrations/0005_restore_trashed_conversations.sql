@@ -0,0 +1,17 @@
+-- A message newer than a trashed conversation's trash shows the
+-- conversation again, as the bridge does for its SQLite chats. The
+-- messages trashed with the conversation stay trashed.
+CREATE OR REPLACE FUNCTION conversations_restore_on_message() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+	IF NEW.trashed_at IS NOT NULL AND NEW.last_message_at > NEW.trashed_at THEN
+		NEW.trashed_at := NULL;
+	END IF;
+	RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS conversations_restore_on_message ON conversations;
+CREATE TRIGGER conversations_restore_on_message
+	BEFORE UPDATE OF last_message_at ON conversations
+	FOR EACH ROW EXECUTE FUNCTION conversations_restore_on_message();
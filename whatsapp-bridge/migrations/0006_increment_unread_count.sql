@@ -0,0 +1,6 @@
+-- Adds a newly stored inbound message to a conversation's unread count in
+-- one statement, so concurrent writers and read syncs do not lose updates
+CREATE OR REPLACE FUNCTION increment_unread_count(conversation uuid) RETURNS void
+LANGUAGE sql AS $$
+	UPDATE conversations SET unread_count = unread_count + 1 WHERE id = conversation;
+$$;
@@ -0,0 +1,11 @@
+-- Merges keys into a message's metadata in one statement, so concurrent
+-- merges (enrichment, quotes, pins, media URLs, annotations) do not lose
+-- each other's keys. It returns whether the message exists.
+CREATE OR REPLACE FUNCTION merge_message_metadata(message_id text, conversation uuid, patch jsonb) RETURNS boolean
+LANGUAGE plpgsql AS $$
+BEGIN
+	UPDATE messages SET metadata = COALESCE(metadata, '{}'::jsonb) || patch
+		WHERE external_id = message_id AND channel = 'whatsapp' AND conversation_id = conversation;
+	RETURN FOUND;
+END;
+$$;
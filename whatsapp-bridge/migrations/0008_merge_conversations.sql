@@ -0,0 +1,19 @@
+-- Moves the messages of one conversation into another and trashes or
+-- deletes the emptied one in a single transaction, so a failed merge leaves
+-- both conversations as they were. The target keeps the later activity and
+-- takes over the unread count.
+CREATE OR REPLACE FUNCTION merge_conversations(source uuid, target uuid, trash boolean) RETURNS void
+LANGUAGE plpgsql AS $$
+BEGIN
+	UPDATE messages SET conversation_id = target WHERE conversation_id = source;
+	UPDATE conversations SET
+		last_message_at = GREATEST(conversations.last_message_at, merged.last_message_at),
+		unread_count = conversations.unread_count + merged.unread_count
+		FROM conversations merged WHERE conversations.id = target AND merged.id = source;
+	IF trash THEN
+		UPDATE conversations SET trashed_at = now(), unread_count = 0 WHERE id = source;
+	ELSE
+		DELETE FROM conversations WHERE id = source;
+	END IF;
+END;
+$$;
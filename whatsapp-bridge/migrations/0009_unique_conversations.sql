@@ -0,0 +1,27 @@
+-- Makes (contact_identifier, channel) unique on databases created before
+-- 0001 had the key. Concurrent creates may have duplicated conversations,
+-- so the messages and unread counts of every duplicate are first moved to
+-- the oldest conversation of its contact and the duplicates deleted.
+CREATE TEMPORARY TABLE duplicate_conversations ON COMMIT DROP AS
+SELECT id, keep FROM (
+	SELECT id, first_value(id) OVER (PARTITION BY contact_identifier, channel ORDER BY created_at, id) AS keep
+	FROM conversations
+) ranked WHERE id <> keep;
+
+UPDATE messages SET conversation_id = duplicate_conversations.keep
+	FROM duplicate_conversations WHERE messages.conversation_id = duplicate_conversations.id;
+
+UPDATE conversations SET
+	last_message_at = GREATEST(conversations.last_message_at, merged.last_message_at),
+	unread_count = conversations.unread_count + merged.unread_count
+	FROM (
+		SELECT keep, max(last_message_at) AS last_message_at, sum(unread_count)::integer AS unread_count
+		FROM duplicate_conversations JOIN conversations USING (id) GROUP BY keep
+	) merged WHERE conversations.id = merged.keep;
+
+DELETE FROM conversations USING duplicate_conversations WHERE conversations.id = duplicate_conversations.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS conversations_contact_identifier_channel_key
+	ON conversations (contact_identifier, channel);
+
+DROP INDEX IF EXISTS conversations_contact_identifier_channel_idx;
@@ -0,0 +1,47 @@
+-- Conversations and messages, the Supabase schema in MySQL's dialect with
+-- the columns of the later Postgres migrations included. IDs are UUIDs
+-- generated by the bridge. Identifiers use a binary collation, as WhatsApp
+-- IDs are case sensitive.
+CREATE TABLE IF NOT EXISTS conversations (
+	id char(36) NOT NULL PRIMARY KEY,
+	channel varchar(32) NOT NULL DEFAULT 'whatsapp',
+	contact_identifier varchar(255) NOT NULL,
+	contact_name text,
+	last_message_at datetime(6),
+	status varchar(32) NOT NULL DEFAULT 'active',
+	unread_count integer NOT NULL DEFAULT 0,
+	created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	summary text,
+	summary_updated_at datetime(6),
+	person_id varchar(255),
+	is_pinned boolean NOT NULL DEFAULT false,
+	is_muted boolean NOT NULL DEFAULT false,
+	muted_until datetime(6),
+	trashed_at datetime(6),
+	-- Unique, unlike in Postgres, so concurrent writers cannot both create
+	-- a chat's conversation
+	UNIQUE KEY conversations_contact_identifier_channel_key (contact_identifier, channel)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS messages (
+	id char(36) NOT NULL PRIMARY KEY,
+	conversation_id char(36) NOT NULL,
+	channel varchar(32) NOT NULL DEFAULT 'whatsapp',
+	direction varchar(16) NOT NULL,
+	sender varchar(255) NOT NULL,
+	recipient varchar(255) NOT NULL,
+	body mediumtext,
+	external_id varchar(128),
+	metadata json,
+	is_read boolean NOT NULL DEFAULT false,
+	status varchar(32),
+	created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	edited_body json,
+	deleted_at datetime(6),
+	trashed_at datetime(6),
+	-- Upserts of re-processed WhatsApp messages conflict on this key
+	UNIQUE KEY messages_external_id_channel_key (external_id, channel),
+	KEY messages_conversation_id_created_at_idx (conversation_id, created_at),
+	CONSTRAINT messages_conversation_id_fkey FOREIGN KEY (conversation_id)
+		REFERENCES conversations (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// mysqlMigrationFiles are the versioned migrations of the MySQL schema,
+// named like the Supabase ones. MySQL cannot roll back DDL, so a migration
+// that fails halfway has to be finished by hand.
+//
+//go:embed mysql_migrations/*.sql
+var mysqlMigrationFiles embed.FS
+
+// mysqlHistoryBatchSize caps the rows of one INSERT during history sync
+const mysqlHistoryBatchSize = 500
+
+// MySQLMessageStore keeps messages in MySQL or MariaDB, in the
+// conversations and messages tables of the Supabase schema, for
+// deployments without Postgres. The schema is migrated when the store
+// opens.
+type MySQLMessageStore struct {
+	db                *sql.DB
+	conversationCache *lruCache
+}
+
+func init() {
+	RegisterMessageStore("mysql", func(logger waLog.Logger) (MessageStoreInterface, error) {
+		return NewMySQLMessageStore(logger)
+	})
+}
+
+// NewMySQLMessageStore connects to MYSQL_DSN and applies pending migrations
+func NewMySQLMessageStore(logger waLog.Logger) (*MySQLMessageStore, error) {
+	dsn := envString("MYSQL_DSN", "")
+	if dsn == "" {
+		return nil, fmt.Errorf("MYSQL_DSN environment variable is required")
+	}
+
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MYSQL_DSN: %v", err)
+	}
+	// Timestamps are written and read as UTC
+	config.ParseTime = true
+	config.Loc = time.UTC
+	if config.Timeout == 0 {
+		config.Timeout = envDuration("MYSQL_CONNECT_TIMEOUT", 10*time.Second)
+	}
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MYSQL_DSN: %v", err)
+	}
+
+	db := sql.OpenDB(connector)
+	if maxConns := envInt("MYSQL_MAX_CONNS", 0); maxConns > 0 {
+		db.SetMaxOpenConns(maxConns)
+	}
+	// Servers close idle connections after wait_timeout
+	db.SetConnMaxLifetime(envDuration("MYSQL_CONN_MAX_LIFETIME", 5*time.Minute))
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	if err := migrateMySQL(db, logger); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &MySQLMessageStore{
+		db:                db,
+		conversationCache: newLRUCache(envInt("SUPABASE_CONVERSATION_CACHE_SIZE", 10000), envDuration("SUPABASE_CONVERSATION_CACHE_TTL", 24*time.Hour)),
+	}, nil
+}
+
+// migrateMySQL applies the migrations the database does not have yet,
+// recording each in the migrations table
+func migrateMySQL(db *sql.DB, logger waLog.Logger) error {
+	migrations, err := loadMigrationsFrom(mysqlMigrationFiles, "mysql_migrations")
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + migrationsTable + ` (
+		version integer PRIMARY KEY,
+		name varchar(255) NOT NULL,
+		applied_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+	rows, err := db.Query("SELECT version FROM " + migrationsTable)
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		logger.Infof("Applying MySQL migration %04d %s", m.Version, m.Name)
+		// The driver runs one statement per call
+		for _, statement := range strings.Split(m.SQL, ";\n") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err := db.Exec(statement); err != nil {
+				return fmt.Errorf("migration %04d %s: %v", m.Version, m.Name, err)
+			}
+		}
+		if _, err := db.Exec("INSERT INTO "+migrationsTable+" (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+			return fmt.Errorf("failed to record migration %04d: %v", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *MySQLMessageStore) Close() error {
+	return s.db.Close()
+}
+
+// executor returns tx, or the database outside a transaction
+func (s *MySQLMessageStore) executor(tx *sql.Tx) sqlExecutor {
+	if tx != nil {
+		return tx
+	}
+	return s.db
+}
+
+// conversationID returns the ID of a chat's conversation, creating it when
+// create is set, and whether it was created. The unique key on the JID
+// makes concurrent creation insert one row. IDs found within tx are not
+// cached, as the caller may still roll it back.
+func (s *MySQLMessageStore) conversationID(tx *sql.Tx, jid, name string, create bool) (string, bool, error) {
+	if err := validateJID(jid); err != nil {
+		return "", false, err
+	}
+	if id, ok := s.conversationCache.Get(jid); ok {
+		return id, false, nil
+	}
+
+	db := s.executor(tx)
+	created := false
+	if create {
+		var contactName interface{}
+		if name != "" {
+			contactName = name
+		}
+		result, err := db.Exec(
+			"INSERT IGNORE INTO conversations (id, channel, contact_identifier, contact_name, status, created_at) VALUES (?, 'whatsapp', ?, ?, ?, ?)",
+			uuid.NewString(), jid, contactName, ConversationActive, time.Now().UTC(),
+		)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to create conversation: %v", err)
+		}
+		n, _ := result.RowsAffected()
+		created = n == 1
+	}
+
+	var id string
+	err := db.QueryRow("SELECT id FROM conversations WHERE contact_identifier = ? AND channel = 'whatsapp'", jid).Scan(&id)
+	if err == sql.ErrNoRows && !create {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to query conversation: %v", err)
+	}
+	if tx == nil {
+		s.conversationCache.Set(jid, id)
+	}
+	return id, created, nil
+}
+
+// storeChat creates a chat's conversation if needed and moves its last
+// message time forward
+func (s *MySQLMessageStore) storeChat(tx *sql.Tx, jid, name string, lastMessageTime time.Time) (bool, error) {
+	conversationID, created, err := s.conversationID(tx, jid, name, true)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.touch(s.executor(tx), conversationID, lastMessageTime, name); err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// touch moves a conversation's last message time forward and sets its name
+// when not empty
+func (s *MySQLMessageStore) touch(db sqlExecutor, conversationID string, lastMessageTime time.Time, name string) error {
+	_, err := db.Exec(
+		"UPDATE conversations SET last_message_at = GREATEST(COALESCE(last_message_at, ?), ?),"+
+			" contact_name = COALESCE(NULLIF(?, ''), contact_name) WHERE id = ?",
+		lastMessageTime.UTC(), lastMessageTime.UTC(), name, conversationID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update conversation: %v", err)
+	}
+	return nil
+}
+
+// mysqlMessageInsert writes messages rows, merging the metadata of a
+// message stored before. Callers append a values tuple per row.
+const mysqlMessageInsert = "INSERT INTO messages" +
+	" (id, conversation_id, channel, direction, sender, recipient, body, external_id, metadata, created_at) VALUES "
+
+// mysqlMessageUpsert follows the values of mysqlMessageInsert
+const mysqlMessageUpsert = " ON DUPLICATE KEY UPDATE body = VALUES(body)," +
+	" metadata = JSON_MERGE_PATCH(COALESCE(metadata, '{}'), COALESCE(VALUES(metadata), '{}'))"
+
+// mysqlMessageValues is the values tuple of one row
+const mysqlMessageValues = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// mysqlMessageArgs returns the values of a row for mysqlMessageValues
+func mysqlMessageArgs(row SupabaseMessage) ([]interface{}, error) {
+	// Empty metadata is stored as NULL, like the key PostgREST omits
+	var metadata interface{}
+	if len(row.Metadata) > 0 {
+		encoded, err := json.Marshal(row.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode message metadata: %v", err)
+		}
+		metadata = string(encoded)
+	}
+	return []interface{}{uuid.NewString(), row.ConversationID, row.Channel, row.Direction, row.Sender, row.Recipient,
+		row.Body, row.ExternalID, metadata, row.CreatedAt}, nil
+}
+
+// storeRecord writes a live message and counts inbound ones as unread
+func (s *MySQLMessageStore) storeRecord(tx *sql.Tx, record MessageRecord) (bool, error) {
+	if record.Content == "" && record.MediaType == "" {
+		return false, nil
+	}
+
+	conversationID, created, err := s.conversationID(tx, record.ChatJID, "", true)
+	if err != nil {
+		return false, err
+	}
+
+	args, err := mysqlMessageArgs(newSupabaseMessage(conversationID, record))
+	if err != nil {
+		return created, err
+	}
+	db := s.executor(tx)
+	result, err := db.Exec(mysqlMessageInsert+mysqlMessageValues+mysqlMessageUpsert, args...)
+	if err != nil {
+		return created, fmt.Errorf("failed to store message: %v", err)
+	}
+	// One affected row is an insert; a redelivered message updates its row
+	// and was counted already
+	if n, _ := result.RowsAffected(); n == 1 && !record.IsFromMe {
+		if _, err := db.Exec("UPDATE conversations SET unread_count = unread_count + 1 WHERE id = ?", conversationID); err != nil {
+			return created, fmt.Errorf("failed to update unread count: %v", err)
+		}
+	}
+	return created, nil
+}
+
+// StoreChat stores or updates a chat's conversation
+func (s *MySQLMessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	created, err := s.storeChat(nil, jid, name, lastMessageTime)
+	if created {
+		emitEvent(EventConversationCreated, jid, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+	return err
+}
+
+// StoreMessage stores a live message
+func (s *MySQLMessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
+	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
+
+	record := MessageRecord{
+		ID:            id,
+		ChatJID:       chatJID,
+		Sender:        sender,
+		Content:       content,
+		Timestamp:     timestamp,
+		IsFromMe:      isFromMe,
+		MediaType:     mediaType,
+		Filename:      filename,
+		URL:           url,
+		MediaKey:      mediaKey,
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		FileLength:    fileLength,
+	}
+	created, err := s.storeRecord(nil, record)
+	if created {
+		emitEvent(EventConversationCreated, chatJID, ConversationEventPayload{ChatJID: chatJID})
+	}
+	return err
+}
+
+// StoreMessages stores history messages in one transaction, with
+// multi-row inserts, then moves each conversation's last_message_at to its
+// newest message
+func (s *MySQLMessageStore) StoreMessages(records []MessageRecord) error {
+	var rows [][]interface{}
+	latest := make(map[string]time.Time)
+	for _, record := range records {
+		if record.Content == "" && record.MediaType == "" {
+			continue
+		}
+
+		conversationID, created, err := s.conversationID(nil, record.ChatJID, "", true)
+		if err != nil {
+			return err
+		}
+		if created {
+			emitEvent(EventConversationCreated, record.ChatJID, ConversationEventPayload{ChatJID: record.ChatJID})
+		}
+
+		args, err := mysqlMessageArgs(newSupabaseMessage(conversationID, record))
+		if err != nil {
+			return err
+		}
+		rows = append(rows, args)
+		if record.Timestamp.After(latest[conversationID]) {
+			latest[conversationID] = record.Timestamp
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(rows); start += mysqlHistoryBatchSize {
+		batch := rows[start:min(start+mysqlHistoryBatchSize, len(rows))]
+		var args []interface{}
+		for _, row := range batch {
+			args = append(args, row...)
+		}
+		values := strings.TrimSuffix(strings.Repeat(mysqlMessageValues+",", len(batch)), ",")
+		if _, err := tx.Exec(mysqlMessageInsert+values+mysqlMessageUpsert, args...); err != nil {
+			return fmt.Errorf("failed to store messages: %v", err)
+		}
+	}
+
+	for conversationID, timestamp := range latest {
+		if err := s.touch(tx, conversationID, timestamp, ""); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// mysqlTx runs the writes of a unit of work in a database transaction
+type mysqlTx struct {
+	store *MySQLMessageStore
+	tx    *sql.Tx
+	// created holds chats to announce once the transaction commits
+	created []ConversationEventPayload
+}
+
+// StoreChat stores a chat in the transaction
+func (t *mysqlTx) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	created, err := t.store.storeChat(t.tx, jid, name, lastMessageTime)
+	if created {
+		t.created = append(t.created, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+	return err
+}
+
+// StoreMessage stores a message in the transaction
+func (t *mysqlTx) StoreMessage(record MessageRecord) error {
+	created, err := t.store.storeRecord(t.tx, record)
+	if created {
+		t.created = append(t.created, ConversationEventPayload{ChatJID: record.ChatJID})
+	}
+	return err
+}
+
+// InTransaction runs fn in a database transaction. Conversation events are
+// only emitted after it commits.
+func (s *MySQLMessageStore) InTransaction(fn func(tx StoreTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	unit := &mysqlTx{store: s, tx: tx}
+	if err := fn(unit); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, payload := range unit.created {
+		emitEvent(EventConversationCreated, payload.ChatJID, payload)
+	}
+	return nil
+}
+
+// mysqlMessageSelect is the select list read by scanMySQLMessages, on the
+// messages table aliased m
+const mysqlMessageSelect = "m.external_id, m.direction, m.sender, m.body, m.created_at, m.metadata"
+
+// mysqlMetadata returns the expression of a metadata key as text. MariaDB
+// lacks the ->> operator.
+func mysqlMetadata(key string) string {
+	return "JSON_UNQUOTE(JSON_EXTRACT(m.metadata, '$." + key + "'))"
+}
+
+// appendMySQLFilters adds the cursor and filters of a message query to a
+// query on the messages table aliased m, followed by its order and limit
+func appendMySQLFilters(sql string, args []interface{}, query MessageQuery) (string, []interface{}) {
+	if !query.CursorTime.IsZero() {
+		sql += " AND (m.created_at < ? OR (m.created_at = ? AND m.external_id < ?))"
+		args = append(args, query.CursorTime.UTC(), query.CursorTime.UTC(), query.CursorID)
+	}
+	if !query.After.IsZero() {
+		sql += " AND m.created_at > ?"
+		args = append(args, query.After.UTC())
+	}
+	if !query.Before.IsZero() {
+		sql += " AND m.created_at < ?"
+		args = append(args, query.Before.UTC())
+	}
+
+	switch query.Direction {
+	case DirectionIn:
+		sql += " AND m.direction = 'inbound'"
+	case DirectionOut:
+		sql += " AND m.direction = 'outbound'"
+	}
+
+	if len(query.MediaTypes) > 0 {
+		// Text messages carry no media_type in their metadata
+		var mediaTypes, conditions []string
+		for _, mediaType := range query.MediaTypes {
+			if mediaType == "text" {
+				conditions = append(conditions, mysqlMetadata("media_type")+" IS NULL")
+			} else {
+				mediaTypes = append(mediaTypes, mediaType)
+			}
+		}
+		if len(mediaTypes) > 0 {
+			conditions = append(conditions, mysqlMetadata("media_type")+" IN ("+strings.TrimSuffix(strings.Repeat("?,", len(mediaTypes)), ",")+")")
+			for _, mediaType := range mediaTypes {
+				args = append(args, mediaType)
+			}
+		}
+		sql += " AND (" + strings.Join(conditions, " OR ") + ")"
+	}
+
+	if query.Pinned {
+		// Pin times are RFC 3339 in UTC, which sort as text
+		sql += " AND " + mysqlMetadata("pinned_at") + " IS NOT NULL" +
+			" AND (" + mysqlMetadata("pin_expires_at") + " IS NULL OR " + mysqlMetadata("pin_expires_at") + " > ?)"
+		args = append(args, time.Now().UTC().Format(time.RFC3339))
+	}
+
+	if softDeletes != nil {
+		sql += " AND m.trashed_at IS NULL"
+	}
+
+	sql += " ORDER BY m.created_at DESC, m.external_id DESC"
+	if query.Limit > 0 {
+		sql += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	return sql, args
+}
+
+// scanMySQLMessages reads rows of mysqlMessageSelect. When chatJID is ""
+// each row is followed by its chat's JID.
+func scanMySQLMessages(rows *sql.Rows, chatJID string) ([]Message, error) {
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var row supabaseMessageRow
+		var externalID, body sql.NullString
+		var metadata []byte
+		jid := chatJID
+		dest := []interface{}{&externalID, &row.Direction, &row.Sender, &body, &row.CreatedAt, &metadata}
+		if chatJID == "" {
+			dest = append(dest, &jid)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row.ExternalID = externalID.String
+		if body.Valid {
+			row.Body = &body.String
+		}
+		if len(metadata) > 0 {
+			if err := json.Unmarshal(metadata, &row.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to parse message metadata: %v", err)
+			}
+		}
+		messages = append(messages, row.message(jid))
+	}
+	return messages, rows.Err()
+}
+
+// GetMessages retrieves messages from a chat, newest first. Messages are
+// ordered by created_at, the time the bridge stored them.
+func (s *MySQLMessageStore) GetMessages(chatJID string, query MessageQuery) ([]Message, error) {
+	// Reading a chat that was never stored must not create it
+	conversationID, _, err := s.conversationID(nil, chatJID, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if conversationID == "" {
+		return []Message{}, nil
+	}
+
+	sql, args := appendMySQLFilters(
+		"SELECT "+mysqlMessageSelect+" FROM messages m WHERE m.conversation_id = ?",
+		[]interface{}{conversationID}, query,
+	)
+	rows, err := s.db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %v", err)
+	}
+	return scanMySQLMessages(rows, chatJID)
+}
+
+// GetChats retrieves all WhatsApp conversations with their last message time
+func (s *MySQLMessageStore) GetChats() (map[string]time.Time, error) {
+	query := "SELECT contact_identifier, last_message_at FROM conversations WHERE channel = 'whatsapp'"
+	if softDeletes != nil {
+		query += " AND trashed_at IS NULL"
+	}
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+	defer rows.Close()
+
+	chats := make(map[string]time.Time)
+	for rows.Next() {
+		var jid string
+		var lastMessageAt sql.NullTime
+		if err := rows.Scan(&jid, &lastMessageAt); err != nil {
+			return nil, err
+		}
+		chats[jid] = lastMessageAt.Time
+	}
+	return chats, rows.Err()
+}
+
+// mysqlChatMessageQuery selects columns of a WhatsApp message by its ID
+// and chat JID, as message IDs are only unique within a chat
+func mysqlChatMessageQuery(columns string) string {
+	return "SELECT " + columns + " FROM messages m JOIN conversations c ON c.id = m.conversation_id" +
+		" WHERE m.external_id = ? AND m.channel = 'whatsapp' AND c.contact_identifier = ?"
+}
+
+// GetMediaInfo retrieves the media download fields kept in a message's
+// metadata
+func (s *MySQLMessageStore) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
+	var metadata []byte
+	err := s.db.QueryRow(mysqlChatMessageQuery("m.metadata"), id, chatJID).Scan(&metadata)
+	if err == sql.ErrNoRows {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("message %s not found", id)
+	}
+	if err != nil {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("failed to query message: %v", err)
+	}
+
+	var media struct {
+		MediaType     string `json:"media_type"`
+		Filename      string `json:"filename"`
+		URL           string `json:"whatsapp_url"`
+		MediaKey      []byte `json:"media_key"`
+		FileSHA256    []byte `json:"file_sha256"`
+		FileEncSHA256 []byte `json:"file_enc_sha256"`
+		FileLength    uint64 `json:"file_length"`
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &media); err != nil {
+			return "", "", "", nil, nil, nil, 0, fmt.Errorf("failed to parse message metadata: %v", err)
+		}
+	}
+	if media.MediaType == "" {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("message %s has no media", id)
+	}
+	return media.MediaType, media.Filename, media.URL, media.MediaKey, media.FileSHA256, media.FileEncSHA256, media.FileLength, nil
+}
+
+// mergeMetadata sets keys of a message's metadata and applies the metadata
+// policy, with the row locked so concurrent merges do not lose keys. Keys
+// set to nil are removed, as JSON nulls would read as the text "null".
+func (s *MySQLMessageStore) mergeMetadata(externalID, chatJID string, patch map[string]interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var encoded []byte
+	err = tx.QueryRow(mysqlChatMessageQuery("m.id, m.metadata")+" FOR UPDATE", externalID, chatJID).Scan(&id, &encoded)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("message %s: %w", externalID, errMessageNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query message: %v", err)
+	}
+
+	metadata := make(map[string]interface{})
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded, &metadata); err != nil {
+			return fmt.Errorf("failed to parse message metadata: %v", err)
+		}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(metadata, key)
+			continue
+		}
+		metadata[key] = value
+	}
+
+	updated, err := json.Marshal(getMetadataPolicy().Apply(metadata))
+	if err != nil {
+		return fmt.Errorf("failed to encode message metadata: %v", err)
+	}
+	if _, err := tx.Exec("UPDATE messages SET metadata = ? WHERE id = ?", string(updated), id); err != nil {
+		return fmt.Errorf("failed to update message metadata: %v", err)
+	}
+	return tx.Commit()
+}
+
+// StoreEnrichment stores classifier results in the message metadata
+func (s *MySQLMessageStore) StoreEnrichment(id, chatJID, sentiment, language string) error {
+	return s.mergeMetadata(id, chatJID, map[string]interface{}{
+		"sentiment": sentiment,
+		"language":  language,
+	})
+}
+
+// StoreQuote saves the quote of a reply in the message metadata
+func (s *MySQLMessageStore) StoreQuote(id, chatJID string, quote Quote) error {
+	metadata := map[string]interface{}{"quoted_id": quote.ID}
+	if quote.Sender != "" {
+		metadata["quoted_sender"] = quote.Sender
+	}
+	if quote.Snippet != "" {
+		metadata["quoted_snippet"] = quote.Snippet
+	}
+	return s.mergeMetadata(id, chatJID, metadata)
+}
+
+// StoreMentions saves the mentioned JIDs in the message metadata
+func (s *MySQLMessageStore) StoreMentions(id, chatJID string, mentioned []string, mentionedMe bool) error {
+	metadata := map[string]interface{}{"mentioned_jids": mentioned}
+	if mentionedMe {
+		metadata["mentioned_me"] = true
+	}
+	return s.mergeMetadata(id, chatJID, metadata)
+}
+
+// GetMentions lists messages mentioning the logged in account across
+// conversations
+func (s *MySQLMessageStore) GetMentions(query MessageQuery) ([]Message, error) {
+	sql, args := appendMySQLFilters(
+		"SELECT "+mysqlMessageSelect+", c.contact_identifier FROM messages m"+
+			" JOIN conversations c ON c.id = m.conversation_id"+
+			" WHERE m.channel = 'whatsapp' AND "+mysqlMetadata("mentioned_me")+" = 'true'",
+		nil, query,
+	)
+	rows, err := s.db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mentions: %v", err)
+	}
+	return scanMySQLMessages(rows, "")
+}
+
+// MessagesByID returns the WhatsApp messages with the given IDs
+func (s *MySQLMessageStore) MessagesByID(chatJID string, ids []string) (map[string]Message, error) {
+	messages := make(map[string]Message)
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	query := "SELECT " + mysqlMessageSelect + " FROM messages m WHERE m.channel = 'whatsapp'" +
+		" AND m.external_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+	if softDeletes != nil {
+		query += " AND m.trashed_at IS NULL"
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	list, err := scanMySQLMessages(rows, chatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range list {
+		messages[msg.ID] = msg
+	}
+	return messages, nil
+}
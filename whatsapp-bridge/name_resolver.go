@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ChatNameStore is implemented by message stores that can rename a chat
+// without touching its last message time
+type ChatNameStore interface {
+	UpdateChatName(jid, name string) error
+}
+
+// UpdateChatName renames a chat in the database
+func (store *MessageStore) UpdateChatName(jid, name string) error {
+	_, err := store.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", name, jid)
+	return err
+}
+
+// UpdateChatName renames a conversation in Supabase
+func (s *SupabaseMessageStore) UpdateChatName(jid, name string) error {
+	return s.client.UpdateConversationName(jid, name)
+}
+
+// nameTask is a queued name lookup
+type nameTask struct {
+	jid      types.JID
+	attempts int
+}
+
+// NameResolver looks up names for chats that were stored under a bare phone
+// number or group ID. Lookups run in the background so the event handler
+// never waits on the network, and are retried with backoff since push names
+// and group metadata often arrive shortly after the first message.
+type NameResolver struct {
+	client      *whatsmeow.Client
+	store       ChatNameStore
+	queue       chan nameTask
+	maxAttempts int
+	retryDelay  time.Duration
+	logger      waLog.Logger
+
+	mutex    sync.Mutex
+	pending  map[types.JID]bool
+	resolved map[types.JID]string
+}
+
+// nameResolver is the active name resolver, nil when disabled
+var nameResolver *NameResolver
+
+// NewNameResolver creates the resolver from environment variables. It
+// returns nil when NAME_RESOLVER is false or the store cannot rename chats.
+func NewNameResolver(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) *NameResolver {
+	if !envBool("NAME_RESOLVER", true) {
+		return nil
+	}
+
+	store, ok := messageStore.(ChatNameStore)
+	if !ok {
+		logger.Warnf("Message store does not support renaming chats, name resolver disabled")
+		return nil
+	}
+
+	r := &NameResolver{
+		client:      client,
+		store:       store,
+		queue:       make(chan nameTask, envInt("NAME_RESOLVER_QUEUE_SIZE", 256)),
+		maxAttempts: max(1, envInt("NAME_RESOLVER_ATTEMPTS", 4)),
+		retryDelay:  envDuration("NAME_RESOLVER_RETRY_DELAY", 30*time.Second),
+		logger:      logger,
+		pending:     make(map[types.JID]bool),
+		resolved:    make(map[types.JID]string),
+	}
+
+	go r.run()
+
+	return r
+}
+
+// Resolved returns a name found earlier for jid
+func (r *NameResolver) Resolved(jid types.JID) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	name, ok := r.resolved[jid]
+	return name, ok
+}
+
+// Enqueue schedules a name lookup for jid unless one is already pending.
+// Lookups are dropped when the queue is full; the next message from the
+// chat enqueues it again.
+func (r *NameResolver) Enqueue(jid types.JID) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	if r.pending[jid] {
+		r.mutex.Unlock()
+		return
+	}
+	r.pending[jid] = true
+	r.mutex.Unlock()
+
+	r.push(nameTask{jid: jid})
+}
+
+// push adds a task to the queue, releasing its pending mark when full
+func (r *NameResolver) push(task nameTask) {
+	select {
+	case r.queue <- task:
+	default:
+		r.logger.Warnf("Name resolver queue full, skipping %s", task.jid)
+		r.mutex.Lock()
+		delete(r.pending, task.jid)
+		r.mutex.Unlock()
+	}
+}
+
+// run processes queued lookups one at a time to stay well clear of
+// WhatsApp's rate limits
+func (r *NameResolver) run() {
+	for task := range r.queue {
+		name := r.lookup(task.jid)
+		if name == "" {
+			task.attempts++
+			if task.attempts < r.maxAttempts {
+				delay := r.retryDelay << (task.attempts - 1)
+				time.AfterFunc(delay, func() { r.push(task) })
+				continue
+			}
+
+			r.logger.Debugf("No name found for %s after %d attempts", task.jid, task.attempts)
+			r.mutex.Lock()
+			delete(r.pending, task.jid)
+			r.mutex.Unlock()
+			continue
+		}
+
+		r.mutex.Lock()
+		r.resolved[task.jid] = name
+		delete(r.pending, task.jid)
+		r.mutex.Unlock()
+
+		if err := r.store.UpdateChatName(task.jid.String(), name); err != nil {
+			r.logger.Warnf("Failed to store resolved name for %s: %v", task.jid, err)
+			continue
+		}
+		r.logger.Infof("Resolved name for %s: %s", task.jid, name)
+	}
+}
+
+// lookup tries each name source in turn: group metadata for groups, and the
+// contact store followed by the verified business name for users
+func (r *NameResolver) lookup(jid types.JID) string {
+	if jid.Server == types.GroupServer {
+		groupInfo, err := cachedGroupInfo(r.client, jid)
+		if err != nil {
+			return ""
+		}
+		return groupInfo.Name
+	}
+
+	if name := storedContactName(r.client, jid); name != "" {
+		return name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	users, err := r.client.GetUserInfo(ctx, []types.JID{jid})
+	if err != nil {
+		r.logger.Debugf("Failed to get user info for %s: %v", jid, err)
+		return ""
+	}
+	if info, ok := users[jid]; ok && info.VerifiedName != nil && info.VerifiedName.Details != nil {
+		return info.VerifiedName.Details.GetVerifiedName()
+	}
+
+	return ""
+}
+
+// storedContactName returns the best name the device's contact store has
+// for jid: the address book name, then the business name, then the name
+// the user chose for themselves
+func storedContactName(client *whatsmeow.Client, jid types.JID) string {
+	contact, err := client.Store.Contacts.GetContact(context.Background(), jid)
+	if err != nil || !contact.Found {
+		return ""
+	}
+
+	switch {
+	case contact.FullName != "":
+		return contact.FullName
+	case contact.BusinessName != "":
+		return contact.BusinessName
+	default:
+		return contact.PushName
+	}
+}
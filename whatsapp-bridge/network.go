@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NetworkPolicy restricts which source addresses may reach the bridge and
+// decides when X-Forwarded-For can be believed
+type NetworkPolicy struct {
+	allowed        []*net.IPNet
+	trustedProxies []*net.IPNet
+}
+
+// networkPolicy is the active policy, nil when no restrictions are set
+var networkPolicy *NetworkPolicy
+
+// NewNetworkPolicy reads ALLOWED_IPS and TRUSTED_PROXIES, comma separated
+// lists of CIDRs or single addresses. It returns nil when neither is set.
+func NewNetworkPolicy() (*NetworkPolicy, error) {
+	allowed, err := parseCIDRs(envList("ALLOWED_IPS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_IPS: %v", err)
+	}
+
+	trusted, err := parseCIDRs(envList("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	if len(allowed) == 0 && len(trusted) == 0 {
+		return nil, nil
+	}
+
+	return &NetworkPolicy{allowed: allowed, trustedProxies: trusted}, nil
+}
+
+// parseCIDRs parses CIDR ranges, treating bare addresses as a single host
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", value)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether any of the ranges contains the address
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made the request. The
+// X-Forwarded-For chain is only followed through trusted proxies, taking the
+// right-most hop that is not one of them, so clients cannot spoof their
+// address by sending the header themselves.
+func (p *NetworkPolicy) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || p == nil || !containsIP(p.trustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// A malformed entry ends the trustworthy part of the chain
+			break
+		}
+		ip = hop
+		if !containsIP(p.trustedProxies, hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Allows reports whether the client address passes the allowlist
+func (p *NetworkPolicy) Allows(ip net.IP) bool {
+	if len(p.allowed) == 0 {
+		return true
+	}
+	return ip != nil && containsIP(p.allowed, ip)
+}
+
+// clientIP resolves the request's client address under the active policy
+func clientIP(r *http.Request) net.IP {
+	return networkPolicy.ClientIP(r)
+}
+
+// withNetworkPolicy rejects requests from addresses outside ALLOWED_IPS
+func withNetworkPolicy(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if networkPolicy != nil && !networkPolicy.Allows(clientIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
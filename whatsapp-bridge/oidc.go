@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCVerifier validates bearer tokens issued by an OpenID Connect provider
+// against the provider's published signing keys
+type OIDCVerifier struct {
+	issuer        string
+	audience      string
+	jwksURL       string
+	scopePrefix   string
+	defaultScopes []APIScope
+	leeway        time.Duration
+	client        *http.Client
+
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	mutex     sync.Mutex
+}
+
+// oidcVerifier is the active verifier, nil when OIDC is not configured
+var oidcVerifier *OIDCVerifier
+
+// jwksRefreshInterval bounds how often an unknown key ID triggers a refetch
+const jwksRefreshInterval = time.Minute
+
+// NewOIDCVerifier configures token validation from OIDC_ISSUER and
+// OIDC_AUDIENCE. It returns nil when no issuer is set. The JWKS location is
+// taken from OIDC_JWKS_URL or the issuer's discovery document.
+func NewOIDCVerifier() (*OIDCVerifier, error) {
+	issuer := strings.TrimSuffix(envString("OIDC_ISSUER", ""), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	audience := envString("OIDC_AUDIENCE", "")
+	if audience == "" {
+		return nil, fmt.Errorf("OIDC_AUDIENCE is required when OIDC_ISSUER is set")
+	}
+
+	v := &OIDCVerifier{
+		issuer:      issuer,
+		audience:    audience,
+		jwksURL:     envString("OIDC_JWKS_URL", ""),
+		scopePrefix: envString("OIDC_SCOPE_PREFIX", ""),
+		leeway:      envDuration("OIDC_LEEWAY", time.Minute),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, scope := range envList("OIDC_DEFAULT_SCOPES") {
+		if !validScopes[APIScope(scope)] {
+			return nil, fmt.Errorf("OIDC_DEFAULT_SCOPES has unknown scope %q", scope)
+		}
+		v.defaultScopes = append(v.defaultScopes, APIScope(scope))
+	}
+
+	if v.jwksURL == "" {
+		jwksURL, err := v.discoverJWKS()
+		if err != nil {
+			return nil, err
+		}
+		v.jwksURL = jwksURL
+	}
+
+	return v, nil
+}
+
+// getJSON fetches a JSON document from the identity provider
+func (v *OIDCVerifier) getJSON(url string, target interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("identity provider error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return json.Unmarshal(body, target)
+}
+
+// discoverJWKS reads the jwks_uri from the issuer's discovery document
+func (v *OIDCVerifier) discoverJWKS() (string, error) {
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(v.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return "", fmt.Errorf("failed to fetch OIDC discovery document: %v", err)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery document has no jwks_uri")
+	}
+	return doc.JWKSURI, nil
+}
+
+// jsonWebKey is a single entry of a JWKS document
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes an RSA or EC key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// refreshKeys refetches the JWKS document
+func (v *OIDCVerifier) refreshKeys() error {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(v.jwksURL, &doc); err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+// signingKey returns the key with the given ID, refetching the JWKS when the
+// ID is unknown so provider key rotation is picked up
+func (v *OIDCVerifier) signingKey(kid string) (crypto.PublicKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(v.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := v.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verifySignature checks a JWS signature for the supported algorithms
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %q does not match RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %q does not match EC key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported key")
+}
+
+// oidcClaims are the token claims the bridge looks at
+type oidcClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt float64         `json:"exp"`
+	NotBefore float64         `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       json.RawMessage `json:"scp"`
+}
+
+// stringOrList decodes a claim that may be a string or an array of strings
+func stringOrList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return strings.Fields(single)
+	}
+	var list []string
+	json.Unmarshal(raw, &list)
+	return list
+}
+
+// Authenticate validates a bearer token and returns the caller it
+// identifies. Token scopes (from "scope" or "scp", with OIDC_SCOPE_PREFIX
+// stripped) that name a bridge scope are granted, together with
+// OIDC_DEFAULT_SCOPES.
+func (v *OIDCVerifier) Authenticate(token string) (*APIKey, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	key, err := v.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %v", err)
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	var claims oidcClaims
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	audienceOK := false
+	for _, aud := range stringOrList(claims.Audience) {
+		if aud == v.audience {
+			audienceOK = true
+			break
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("token not issued for audience %q", v.audience)
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.Add(-v.leeway).After(time.Unix(int64(claims.ExpiresAt), 0)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(int64(claims.NotBefore), 0)) {
+		return nil, fmt.Errorf("token not yet valid")
+	}
+
+	principal := &APIKey{Name: "oidc:" + claims.Subject}
+	principal.Scopes = append(principal.Scopes, v.defaultScopes...)
+	for _, scope := range append(strings.Fields(claims.Scope), stringOrList(claims.Scp)...) {
+		if !strings.HasPrefix(scope, v.scopePrefix) {
+			continue
+		}
+		if bridgeScope := APIScope(strings.TrimPrefix(scope, v.scopePrefix)); validScopes[bridgeScope] {
+			principal.Scopes = append(principal.Scopes, bridgeScope)
+		}
+	}
+
+	return principal, nil
+}
+
+// looksLikeJWT reports whether a bearer credential has the shape of a JWT
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// SendOptions are the parts of a send request, besides the message, that
+// decide how it is checked
+type SendOptions struct {
+	// IdempotencyKey deduplicates retried requests
+	IdempotencyKey string
+	// Urgent bypasses quiet hours
+	Urgent bool
+	// Key is the API key that requested the send, nil for sends the bridge
+	// makes on its own
+	Key *APIKey
+}
+
+// OutboundSender puts every message sent on someone's behalf through the
+// checks of /api/send: idempotency, the content policy, approvals, the
+// warm-up limit and quiet hours. Messages it sends are stored, because
+// WhatsApp does not echo a device's own messages back to it.
+type OutboundSender struct {
+	client       *whatsmeow.Client
+	messageStore MessageStoreInterface
+	logger       waLog.Logger
+}
+
+// outboundSender sends the messages of the API, the outbox and bot mode
+var outboundSender *OutboundSender
+
+// NewOutboundSender creates the sender for a client and its message store
+func NewOutboundSender(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) *OutboundSender {
+	return &OutboundSender{client: client, messageStore: messageStore, logger: logger}
+}
+
+// Dispatch sends a validated message, holds it in the outbox or rejects
+// it. It returns the HTTP status and response /api/send answers with; a
+// held message is successful with status 202 Accepted.
+func (s *OutboundSender) Dispatch(outbound *OutboundMessage, opts SendOptions) (int, SendMessageResponse) {
+	// A retried request returns the message of the first attempt
+	if opts.IdempotencyKey != "" {
+		sent, err := sendTracker.Lookup(opts.IdempotencyKey)
+		if err != nil {
+			return http.StatusInternalServerError, SendMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to check idempotency key: %v", err),
+			}
+		}
+		if sent != nil {
+			return replayResponse(sent)
+		}
+	}
+
+	// Apply the content policy, rejecting or holding violating messages
+	violations := contentPolicy.Check(outbound.Body)
+	needsApproval := len(violations) > 0 && contentPolicy.Action() == PolicyActionApprove
+	if len(violations) > 0 && !needsApproval {
+		return http.StatusBadRequest, SendMessageResponse{
+			Success: false,
+			Message: violations[0].Message,
+			Errors:  violations,
+		}
+	}
+
+	// Sends by keys configured for review always wait for approval
+	approvalReason, approvalDetail := ValidationContentPolicy, ""
+	if needsApproval {
+		approvalDetail = violations[0].Message
+	} else if approvalRequired(opts.Key) {
+		needsApproval = true
+		approvalReason, approvalDetail = "api_key", fmt.Sprintf("Sends by %s require approval", opts.Key.Name)
+	}
+
+	// Reject sends past the warm-up limit before anything is recorded
+	if allowed, limit, err := accountWarmup.Allowed(s.client, outbound.Recipient); err != nil || !allowed {
+		message := fmt.Sprintf("Warm-up limit of %d messages for today reached", limit)
+		if err != nil {
+			message = fmt.Sprintf("Failed to check warm-up limit: %v", err)
+		}
+		return http.StatusTooManyRequests, SendMessageResponse{Success: false, Message: message, FailureReason: SendFailureWarmupLimit}
+	}
+
+	// Hold non-urgent messages that fall in the recipient's quiet hours
+	status := SendStatusSent
+	releaseAt, held := time.Time{}, false
+	if !opts.Urgent && !needsApproval {
+		releaseAt, held = quietHours.HoldUntil(outbound.Recipient, time.Now())
+	}
+	if held {
+		status = SendStatusHeld
+	}
+	if needsApproval {
+		status = SendStatusPendingApproval
+	}
+
+	// Charge the key's quota for the accepted message, refunded below when
+	// it is not sent after all
+	allowed, err := apiKeys.ReserveSend(opts.Key)
+	if err != nil {
+		return http.StatusInternalServerError, SendMessageResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to check send quota: %v", err),
+		}
+	}
+	if !allowed {
+		return http.StatusTooManyRequests, SendMessageResponse{Success: false, Message: "Daily send quota exceeded", FailureReason: SendFailureQuotaExceeded}
+	}
+	requestedBy, reservedAt := "", time.Now()
+	if opts.Key != nil {
+		requestedBy = opts.Key.Name
+	}
+
+	// Record the message before sending so its receipts can be matched
+	messageID := s.client.GenerateMessageID()
+	if err := sendTracker.Record(messageID, opts.IdempotencyKey, outbound.Recipient.String(), status); err != nil {
+		apiKeys.ReleaseSend(requestedBy, reservedAt)
+		return http.StatusConflict, SendMessageResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to record message: %v", err),
+		}
+	}
+
+	if needsApproval {
+		expiresAt, err := outbox.HoldForApproval(outbound, messageID, approvalReason, approvalDetail, requestedBy)
+		if err != nil {
+			apiKeys.ReleaseSend(requestedBy, reservedAt)
+			sendTracker.Forget(messageID)
+			return http.StatusInternalServerError, SendMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to hold message: %v", err),
+			}
+		}
+
+		return http.StatusAccepted, SendMessageResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Message pending approval until %s: %s", expiresAt.Format(time.RFC3339), approvalDetail),
+			MessageID: messageID,
+			Errors:    violations,
+		}
+	}
+
+	if held {
+		if err := outbox.Hold(outbound, messageID, "quiet_hours", requestedBy, releaseAt); err != nil {
+			apiKeys.ReleaseSend(requestedBy, reservedAt)
+			sendTracker.Forget(messageID)
+			return http.StatusInternalServerError, SendMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to hold message: %v", err),
+			}
+		}
+
+		return http.StatusAccepted, SendMessageResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Quiet hours for %s, message held until %s", outbound.Recipient, releaseAt.Format(time.RFC3339)),
+			MessageID: messageID,
+			HeldUntil: &releaseAt,
+		}
+	}
+
+	success, message, reason := s.Send(outbound, messageID)
+	if !success {
+		apiKeys.ReleaseSend(requestedBy, reservedAt)
+		// Keep the reason and free the idempotency key so the client can retry
+		if err := sendTracker.MarkFailed(messageID, reason); err != nil {
+			s.logger.Warnf("Failed to record failure of message %s: %v", messageID, err)
+		}
+		return http.StatusInternalServerError, SendMessageResponse{
+			Success:       false,
+			Message:       message,
+			MessageID:     messageID,
+			FailureReason: reason,
+		}
+	}
+
+	return http.StatusOK, SendMessageResponse{Success: true, Message: message, MessageID: messageID}
+}
+
+// replayResponse answers a retried request with the outcome of its first
+// attempt. Only a message that was sent, or is still held, is a success.
+func replayResponse(sent *SentMessage) (int, SendMessageResponse) {
+	response := SendMessageResponse{
+		MessageID:     sent.MessageID,
+		Status:        sent.Status,
+		FailureReason: sent.FailureReason,
+	}
+
+	switch sent.Status {
+	case SendStatusSent, SendStatusDelivered, SendStatusRead:
+		response.Success = true
+		response.Message = fmt.Sprintf("Message already %s to %s", sent.Status, sent.ChatJID)
+		return http.StatusOK, response
+	case SendStatusHeld, SendStatusPendingApproval:
+		response.Success = true
+		response.Message = fmt.Sprintf("Message to %s is already %s", sent.ChatJID, strings.ReplaceAll(sent.Status, "_", " "))
+		return http.StatusAccepted, response
+	default:
+		response.Message = fmt.Sprintf("Message to %s was %s and not sent", sent.ChatJID, sent.Status)
+		if response.FailureReason == "" {
+			response.FailureReason = SendFailureNotApproved
+			if sent.Status == SendStatusCancelled {
+				response.FailureReason = SendFailureCancelled
+			}
+		}
+		return http.StatusConflict, response
+	}
+}
+
+// Send sends a message that passed the checks and stores it, unless it
+// came from a Supabase row. On failure it also returns one of the
+// SendFailure reasons, which is stored with the message.
+func (s *OutboundSender) Send(outbound *OutboundMessage, messageID string) (bool, string, string) {
+	msg, result, reason := s.deliver(outbound, messageID)
+	if outbound.RowID == "" {
+		s.store(outbound, messageID, msg, reason)
+	}
+	if reason != "" {
+		return false, result, reason
+	}
+	return true, fmt.Sprintf("Message sent to %s", outbound.Recipient), ""
+}
+
+// deliver builds and sends a message. It returns the message, nil when it
+// could not be built, and the result and failure reason of a failed send.
+func (s *OutboundSender) deliver(outbound *OutboundMessage, messageID string) (*waProto.Message, string, string) {
+	if !s.client.IsConnected() {
+		return nil, "Not connected to WhatsApp", SendFailureNotConnected
+	}
+
+	var msg *waProto.Message
+	var result, reason string
+	if outbound.Product != nil {
+		msg, result, reason = buildProductMessage(s.client, *outbound.Product)
+	} else {
+		msg, result, reason = buildWhatsAppMessage(s.client, outbound.Recipient, outbound.Body, outbound.MediaPath)
+	}
+	if msg == nil {
+		return nil, result, reason
+	}
+
+	if ok, result, reason := deliverWhatsAppMessage(s.client, outbound.Recipient, msg, outbound.Body, messageID); !ok {
+		return msg, result, reason
+	}
+	return msg, "", ""
+}
+
+// store saves a sent message in the conversation history. A message that
+// failed is stored with the failed status and its failure reason; one that
+// could not be built is stored with its body. It emits no message.sent
+// event, which bot mode would take as a person replying.
+func (s *OutboundSender) store(outbound *OutboundMessage, messageID string, msg *waProto.Message, failureReason string) {
+	sender := ""
+	if s.client.Store.ID != nil {
+		sender = s.client.Store.ID.User
+	}
+
+	record := MessageRecord{
+		ID:        messageID,
+		ChatJID:   outbound.Recipient.String(),
+		Sender:    sender,
+		Content:   outbound.Body,
+		Timestamp: time.Now(),
+		IsFromMe:  true,
+		Status:    SendStatusSent,
+	}
+	if msg != nil {
+		record.Content = extractTextContent(msg)
+		record.MediaType, record.Filename, record.URL, record.MediaKey, record.FileSHA256, record.FileEncSHA256, record.FileLength = extractMediaInfo(msg)
+		record.Product = extractProductInfo(msg)
+	}
+	if failureReason != "" {
+		record.Status = SendStatusFailed
+		record.FailureReason = failureReason
+	}
+	if record.Content == "" && record.MediaType == "" {
+		return
+	}
+
+	if err := storeChatMessage(s.messageStore, "", record); err != nil {
+		s.logger.Warnf("Failed to store sent message %s: %v", messageID, err)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// HeldMessage is a validated outbound message waiting in the outbox
+type HeldMessage struct {
+	MessageID string `json:"message_id"`
+	Recipient string `json:"recipient"`
+	Body      string `json:"body,omitempty"`
+	MediaPath string `json:"media_path,omitempty"`
+	Reason    string `json:"reason"`
+	Detail    string `json:"detail,omitempty"`
+	Approval  string `json:"approval,omitempty"`
+	State     string `json:"state"`
+	Attempts  int    `json:"attempts"`
+	// LastError is why the last send failed, FailureReason the matching
+	// SendFailure reason
+	LastError     string `json:"last_error,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	// RequestedBy names the API key that requested the send, if known
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Product is the request of a held catalog send
+	Product *ProductMessageRequest `json:"product,omitempty"`
+	// RowID is the Supabase row of a Realtime send, updated once the
+	// message is sent or dropped
+	RowID     string     `json:"row_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ReleaseAt time.Time  `json:"release_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Approval states of held messages
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+)
+
+// Delivery states of held messages
+const (
+	// OutboxQueued messages are sent at their release time, or once
+	// approved
+	OutboxQueued = "queued"
+	// OutboxSending messages are being sent right now
+	OutboxSending = "sending"
+	// OutboxFailed messages could not be sent and wait for a retry or
+	// cancellation
+	OutboxFailed = "failed"
+)
+
+// errHeldMessageNotFound is returned for unknown or already released messages
+var errHeldMessageNotFound = fmt.Errorf("held message not found")
+
+// heldMessageColumns is the column list scanned by scanHeldMessage
+const heldMessageColumns = "message_id, recipient, body, media_path, reason, detail, approval, state, attempts, last_error, failure_reason, requested_by, row_id, product, expires_at, release_at, created_at"
+
+// Outbox holds outbound messages in the bridge database until their release
+// time and then sends them. Messages keep the ID they were given when the
+// send was requested, so receipts and idempotency keys still match.
+type Outbox struct {
+	db     *sql.DB
+	client *whatsmeow.Client
+	logger waLog.Logger
+}
+
+// outbox is the active outbox
+var outbox *Outbox
+
+// NewOutbox creates the outbox table and starts releasing due messages every
+// OUTBOX_CHECK_INTERVAL
+func NewOutbox(db *sql.DB, client *whatsmeow.Client, logger waLog.Logger) (*Outbox, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS outbox (
+			message_id TEXT PRIMARY KEY,
+			recipient TEXT NOT NULL,
+			body TEXT NOT NULL DEFAULT '',
+			media_path TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL,
+			release_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_outbox_release_at ON outbox(release_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox table: %v", err)
+	}
+	if err := ensureColumn(db, "outbox", "detail", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, fmt.Errorf("failed to migrate outbox table: %v", err)
+	}
+	for _, col := range []struct{ name, definition string }{
+		{"approval", "TEXT NOT NULL DEFAULT ''"},
+		{"requested_by", "TEXT NOT NULL DEFAULT ''"},
+		{"expires_at", "TIMESTAMP"},
+		{"state", "TEXT NOT NULL DEFAULT '" + OutboxQueued + "'"},
+		{"attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_error", "TEXT NOT NULL DEFAULT ''"},
+		{"failure_reason", "TEXT NOT NULL DEFAULT ''"},
+		{"row_id", "TEXT NOT NULL DEFAULT ''"},
+		{"product", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := ensureColumn(db, "outbox", col.name, col.definition); err != nil {
+			return nil, fmt.Errorf("failed to migrate outbox table: %v", err)
+		}
+	}
+
+	// A send interrupted by a restart may or may not have gone out; leave
+	// it to an operator to retry rather than risk sending it twice
+	_, err = db.Exec("UPDATE outbox SET state = ?, last_error = ? WHERE state = ?", OutboxFailed, "Interrupted by a restart", OutboxSending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover outbox: %v", err)
+	}
+
+	o := &Outbox{db: db, client: client, logger: logger}
+	go o.run(envDuration("OUTBOX_CHECK_INTERVAL", time.Minute))
+
+	return o, nil
+}
+
+// encodeProduct returns the JSON of a catalog send, empty for other
+// messages
+func encodeProduct(msg *OutboundMessage) string {
+	if msg.Product == nil {
+		return ""
+	}
+	encoded, _ := json.Marshal(msg.Product)
+	return string(encoded)
+}
+
+// Hold queues a message for sending at releaseAt
+func (o *Outbox) Hold(msg *OutboundMessage, messageID, reason, requestedBy string, releaseAt time.Time) error {
+	_, err := o.db.Exec(
+		"INSERT INTO outbox (message_id, recipient, body, media_path, reason, requested_by, row_id, product, release_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		messageID, msg.Recipient.String(), msg.Body, msg.MediaPath, reason, requestedBy, msg.RowID, encodeProduct(msg), releaseAt.UTC(), time.Now().UTC(),
+	)
+	return err
+}
+
+// HoldForApproval queues a message that is only sent once approved. It
+// expires unsent after APPROVAL_TTL.
+func (o *Outbox) HoldForApproval(msg *OutboundMessage, messageID, reason, detail, requestedBy string) (time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(envDuration("APPROVAL_TTL", 24*time.Hour))
+	_, err := o.db.Exec(
+		`INSERT INTO outbox (message_id, recipient, body, media_path, reason, detail, approval, requested_by, row_id, product, expires_at, release_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		messageID, msg.Recipient.String(), msg.Body, msg.MediaPath, reason, detail, ApprovalPending, requestedBy, msg.RowID, encodeProduct(msg), expiresAt, now, now,
+	)
+	return expiresAt, err
+}
+
+// query returns the held messages matching a WHERE clause
+func (o *Outbox) query(where string, args ...interface{}) ([]HeldMessage, error) {
+	rows, err := o.db.Query("SELECT "+heldMessageColumns+" FROM outbox WHERE "+where+" ORDER BY release_at, created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := []HeldMessage{}
+	for rows.Next() {
+		var msg HeldMessage
+		var expiresAt sql.NullTime
+		var product string
+		err := rows.Scan(&msg.MessageID, &msg.Recipient, &msg.Body, &msg.MediaPath, &msg.Reason, &msg.Detail,
+			&msg.Approval, &msg.State, &msg.Attempts, &msg.LastError, &msg.FailureReason, &msg.RequestedBy, &msg.RowID, &product, &expiresAt, &msg.ReleaseAt, &msg.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if product != "" {
+			if err := json.Unmarshal([]byte(product), &msg.Product); err != nil {
+				return nil, fmt.Errorf("failed to parse product of message %s: %v", msg.MessageID, err)
+			}
+		}
+		if expiresAt.Valid {
+			msg.ExpiresAt = &expiresAt.Time
+		}
+		held = append(held, msg)
+	}
+	return held, rows.Err()
+}
+
+// due returns the held messages whose release time has passed
+func (o *Outbox) due(now time.Time) ([]HeldMessage, error) {
+	return o.query("state = ? AND approval != ? AND release_at <= ?", OutboxQueued, ApprovalPending, now.UTC())
+}
+
+// Get returns a single held message
+func (o *Outbox) Get(messageID string) (*HeldMessage, error) {
+	held, err := o.query("message_id = ?", messageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(held) == 0 {
+		return nil, errHeldMessageNotFound
+	}
+	return &held[0], nil
+}
+
+// PendingApproval returns the messages waiting for approval, oldest first
+func (o *Outbox) PendingApproval() ([]HeldMessage, error) {
+	return o.query("approval = ?", ApprovalPending)
+}
+
+// Approve releases a pending message at releaseAt, or on the next check when
+// releaseAt is zero
+func (o *Outbox) Approve(messageID string, releaseAt time.Time) error {
+	if releaseAt.IsZero() {
+		releaseAt = time.Now()
+	}
+
+	result, err := o.db.Exec(
+		"UPDATE outbox SET approval = ?, release_at = ? WHERE message_id = ? AND approval = ? AND (expires_at IS NULL OR expires_at > ?)",
+		ApprovalApproved, releaseAt.UTC(), messageID, ApprovalPending, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errHeldMessageNotFound
+	}
+	return nil
+}
+
+// Reject drops a pending message without sending it
+func (o *Outbox) Reject(messageID string) error {
+	msg, err := o.Get(messageID)
+	if err != nil {
+		return err
+	}
+	result, err := o.db.Exec("DELETE FROM outbox WHERE message_id = ? AND approval = ?", messageID, ApprovalPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errHeldMessageNotFound
+	}
+	apiKeys.ReleaseSend(msg.RequestedBy, msg.CreatedAt)
+	realtimeOutbound.Settle(msg.RowID, "", "Message was rejected", SendFailureNotApproved)
+	return sendTracker.MarkRejected(messageID, false)
+}
+
+// expire drops pending messages that were not approved in time
+func (o *Outbox) expire(now time.Time) {
+	expired, err := o.query("approval = ? AND expires_at <= ?", ApprovalPending, now.UTC())
+	if err != nil {
+		o.logger.Warnf("Failed to read expired approvals: %v", err)
+		return
+	}
+
+	for _, msg := range expired {
+		if _, err := o.db.Exec("DELETE FROM outbox WHERE message_id = ?", msg.MessageID); err != nil {
+			o.logger.Warnf("Failed to remove expired message %s: %v", msg.MessageID, err)
+			continue
+		}
+		apiKeys.ReleaseSend(msg.RequestedBy, msg.CreatedAt)
+		realtimeOutbound.Settle(msg.RowID, "", "Message expired without approval", SendFailureNotApproved)
+		if err := sendTracker.MarkRejected(msg.MessageID, true); err != nil {
+			o.logger.Warnf("Failed to update status of expired message %s: %v", msg.MessageID, err)
+		}
+		o.logger.Infof("Message %s expired without approval", msg.MessageID)
+	}
+}
+
+// run releases due messages until the process exits
+func (o *Outbox) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		o.expire(time.Now())
+
+		// Leave messages held while offline instead of failing them
+		if !o.client.IsConnected() {
+			continue
+		}
+
+		held, err := o.due(time.Now())
+		if err != nil {
+			o.logger.Warnf("Failed to read outbox: %v", err)
+			continue
+		}
+
+		for _, msg := range held {
+			o.release(msg)
+		}
+	}
+}
+
+// release sends a held message and removes it from the outbox. A message
+// that fails to send stays in the outbox as failed.
+func (o *Outbox) release(msg HeldMessage) {
+	// Claim the message so a retry requested meanwhile does not send it twice
+	result, err := o.db.Exec("UPDATE outbox SET state = ? WHERE message_id = ? AND state = ?", OutboxSending, msg.MessageID, OutboxQueued)
+	if err != nil {
+		o.logger.Warnf("Failed to claim message %s: %v", msg.MessageID, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	recipient, err := types.ParseJID(msg.Recipient)
+	if err != nil {
+		o.logger.Warnf("Failed to parse recipient of message %s: %v", msg.MessageID, err)
+		return
+	}
+	success, sendResult, reason := outboundSender.Send(&OutboundMessage{Recipient: recipient, Body: msg.Body, MediaPath: msg.MediaPath, RowID: msg.RowID, Product: msg.Product}, msg.MessageID)
+	if !success {
+		o.logger.Warnf("Failed to send message %s held for %s: %s", msg.MessageID, msg.Reason, sendResult)
+		_, err := o.db.Exec("UPDATE outbox SET state = ?, attempts = attempts + 1, last_error = ?, failure_reason = ? WHERE message_id = ?",
+			OutboxFailed, sendResult, reason, msg.MessageID)
+		if err != nil {
+			o.logger.Warnf("Failed to record failure of message %s: %v", msg.MessageID, err)
+		}
+		realtimeOutbound.Settle(msg.RowID, "", sendResult, reason)
+		return
+	}
+	realtimeOutbound.Settle(msg.RowID, msg.MessageID, "", "")
+
+	if err := sendTracker.MarkSent(msg.MessageID); err != nil {
+		o.logger.Warnf("Failed to update status of released message %s: %v", msg.MessageID, err)
+	}
+	o.logger.Infof("Released message %s held for %s", msg.MessageID, msg.Reason)
+
+	if _, err := o.db.Exec("DELETE FROM outbox WHERE message_id = ?", msg.MessageID); err != nil {
+		o.logger.Warnf("Failed to remove message %s from outbox: %v", msg.MessageID, err)
+	}
+}
+
+// List returns the held messages in a state, or all of them when state is
+// empty
+func (o *Outbox) List(state string) ([]HeldMessage, error) {
+	if state == "" {
+		return o.query("1 = 1")
+	}
+	return o.query("state = ?", state)
+}
+
+// Retry sends a failed or queued message now, bypassing its release time.
+// Messages waiting for approval must be approved instead.
+func (o *Outbox) Retry(messageID string) error {
+	result, err := o.db.Exec(
+		"UPDATE outbox SET state = ?, release_at = ? WHERE message_id = ? AND state IN (?, ?) AND approval != ?",
+		OutboxQueued, time.Now().UTC(), messageID, OutboxQueued, OutboxFailed, ApprovalPending,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errHeldMessageNotFound
+	}
+
+	if !o.client.IsConnected() {
+		return nil
+	}
+	msg, err := o.Get(messageID)
+	if err != nil {
+		return err
+	}
+	go o.release(*msg)
+	return nil
+}
+
+// Cancel drops a message that is not being sent. Its idempotency key stays
+// taken, like that of a rejected message.
+func (o *Outbox) Cancel(messageID string) error {
+	msg, err := o.Get(messageID)
+	if err != nil {
+		return err
+	}
+	result, err := o.db.Exec("DELETE FROM outbox WHERE message_id = ? AND state != ?", messageID, OutboxSending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errHeldMessageNotFound
+	}
+	apiKeys.ReleaseSend(msg.RequestedBy, msg.CreatedAt)
+	realtimeOutbound.Settle(msg.RowID, "", "Message was cancelled", SendFailureCancelled)
+	return sendTracker.MarkCancelled(messageID)
+}
+
+// Counts returns the number of held messages per state, with messages
+// waiting for approval counted separately
+func (o *Outbox) Counts() (map[string]int, error) {
+	counts := map[string]int{OutboxQueued: 0, OutboxSending: 0, OutboxFailed: 0, ApprovalPending: 0}
+	rows, err := o.db.Query(
+		"SELECT CASE WHEN approval = ? AND state = ? THEN ? ELSE state END, COUNT(*) FROM outbox GROUP BY 1",
+		ApprovalPending, OutboxQueued, ApprovalPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var state string
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[state] = count
+	}
+	return counts, rows.Err()
+}
+
+// OutboxResponse represents the response for the outbox API
+type OutboxResponse struct {
+	Success  bool           `json:"success"`
+	Message  string         `json:"message,omitempty"`
+	Counts   map[string]int `json:"counts,omitempty"`
+	Messages []HeldMessage  `json:"messages"`
+}
+
+// handleOutbox serves GET /api/outbox?state=<queued|sending|failed> with the
+// messages that have not gone out yet and the number in each state
+func handleOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", OutboxQueued, OutboxSending, OutboxFailed:
+	default:
+		http.Error(w, "state must be queued, sending or failed", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	held, err := outbox.List(state)
+	var counts map[string]int
+	if err == nil {
+		counts, err = outbox.Counts()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(OutboxResponse{
+			Success:  false,
+			Message:  fmt.Sprintf("Failed to list outbox: %v", err),
+			Messages: []HeldMessage{},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(OutboxResponse{Success: true, Counts: counts, Messages: held})
+}
+
+// OutboxActionRequest represents the request body to retry or cancel a
+// held message
+type OutboxActionRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+// handleOutboxAction serves POST /api/outbox/retry and /api/outbox/cancel
+func handleOutboxAction(retry bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req OutboxActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.MessageID == "" {
+			http.Error(w, "Message ID is required", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		message := "Message cancelled"
+		if retry {
+			err = outbox.Retry(req.MessageID)
+			message = "Message queued for sending"
+		} else {
+			err = outbox.Cancel(req.MessageID)
+		}
+
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == errHeldMessageNotFound {
+				status = http.StatusNotFound
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message":    message,
+			"message_id": req.MessageID,
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Roles of a group participant
+const (
+	ParticipantMember     = "member"
+	ParticipantAdmin      = "admin"
+	ParticipantSuperAdmin = "superadmin"
+)
+
+// ParticipantRecord is a member of a group
+type ParticipantRecord struct {
+	GroupJID string
+	JID      string
+	// PhoneNumber is set when the member is known by LID
+	PhoneNumber string
+	Role        string
+}
+
+// ParticipantStore is implemented by message stores that keep the members
+// of groups
+type ParticipantStore interface {
+	// ReplaceParticipants sets the members of a group to exactly the given
+	// ones
+	ReplaceParticipants(groupJID string, participants []ParticipantRecord) error
+	// AddParticipants adds members to a group, or updates their role
+	AddParticipants(groupJID string, participants []ParticipantRecord) error
+	// RemoveParticipants removes members who left a group
+	RemoveParticipants(groupJID string, jids []string) error
+	// SetParticipantRole changes the role of members of a group
+	SetParticipantRole(groupJID string, jids []string, role string) error
+}
+
+// participantRecords converts the members in group metadata
+func participantRecords(info *types.GroupInfo) []ParticipantRecord {
+	records := make([]ParticipantRecord, 0, len(info.Participants))
+	for _, participant := range info.Participants {
+		record := ParticipantRecord{
+			GroupJID: info.JID.String(),
+			JID:      participant.JID.ToNonAD().String(),
+			Role:     ParticipantMember,
+		}
+		if !participant.PhoneNumber.IsEmpty() {
+			record.PhoneNumber = participant.PhoneNumber.ToNonAD().String()
+		}
+		switch {
+		case participant.IsSuperAdmin:
+			record.Role = ParticipantSuperAdmin
+		case participant.IsAdmin:
+			record.Role = ParticipantAdmin
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// participantJIDs converts the users of a membership change
+func participantJIDs(users []types.JID) []string {
+	jids := make([]string, len(users))
+	for i, user := range users {
+		jids[i] = user.ToNonAD().String()
+	}
+	return jids
+}
+
+// ReplaceParticipants sets the members of a group in one transaction
+func (store *MessageStore) ReplaceParticipants(groupJID string, participants []ParticipantRecord) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM group_participants WHERE group_jid = ?", groupJID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, participant := range participants {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO group_participants (group_jid, participant_jid, phone_number, role, updated_at) VALUES (?, ?, ?, ?, ?)",
+			groupJID, participant.JID, participant.PhoneNumber, participant.Role, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddParticipants adds members to a group, keeping a phone number stored
+// earlier when the new record has none
+func (store *MessageStore) AddParticipants(groupJID string, participants []ParticipantRecord) error {
+	now := time.Now().UTC()
+	for _, participant := range participants {
+		_, err := store.db.Exec(
+			`INSERT INTO group_participants (group_jid, participant_jid, phone_number, role, updated_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(group_jid, participant_jid) DO UPDATE SET role = excluded.role, updated_at = excluded.updated_at,
+			phone_number = COALESCE(NULLIF(excluded.phone_number, ''), group_participants.phone_number)`,
+			groupJID, participant.JID, participant.PhoneNumber, participant.Role, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveParticipants removes members from a group
+func (store *MessageStore) RemoveParticipants(groupJID string, jids []string) error {
+	for _, jid := range jids {
+		if _, err := store.db.Exec("DELETE FROM group_participants WHERE group_jid = ? AND participant_jid = ?", groupJID, jid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetParticipantRole changes the role of members of a group
+func (store *MessageStore) SetParticipantRole(groupJID string, jids []string, role string) error {
+	now := time.Now().UTC()
+	for _, jid := range jids {
+		_, err := store.db.Exec("UPDATE group_participants SET role = ?, updated_at = ? WHERE group_jid = ? AND participant_jid = ?",
+			role, now, groupJID, jid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supabaseParticipantConflict is the unique key of a conversation_participants
+// row. The Supabase table is created with e.g.
+//
+//	CREATE TABLE conversation_participants (
+//		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+//		chat_jid text NOT NULL,
+//		channel text NOT NULL DEFAULT 'whatsapp',
+//		participant_jid text NOT NULL,
+//		phone_number text,
+//		role text NOT NULL DEFAULT 'member',
+//		updated_at timestamptz NOT NULL DEFAULT now(),
+//		UNIQUE (chat_jid, channel, participant_jid)
+//	);
+const supabaseParticipantConflict = "chat_jid,channel,participant_jid"
+
+// supabaseParticipant is a conversation_participants row
+type supabaseParticipant struct {
+	ChatJID        string    `json:"chat_jid"`
+	Channel        string    `json:"channel"`
+	ParticipantJID string    `json:"participant_jid"`
+	PhoneNumber    *string   `json:"phone_number,omitempty"`
+	Role           string    `json:"role"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// participantRows converts members to conversation_participants rows
+func participantRows(groupJID string, participants []ParticipantRecord) []supabaseParticipant {
+	now := time.Now().UTC()
+	rows := make([]supabaseParticipant, len(participants))
+	for i, participant := range participants {
+		rows[i] = supabaseParticipant{
+			ChatJID:        groupJID,
+			Channel:        "whatsapp",
+			ParticipantJID: participant.JID,
+			Role:           participant.Role,
+			UpdatedAt:      now,
+		}
+		if participant.PhoneNumber != "" {
+			phoneNumber := participant.PhoneNumber
+			rows[i].PhoneNumber = &phoneNumber
+		}
+	}
+	return rows
+}
+
+// ReplaceParticipants upserts the members of a group in Supabase and
+// deletes the rows of everyone else
+func (s *SupabaseMessageStore) ReplaceParticipants(groupJID string, participants []ParticipantRecord) error {
+	if err := validateJID(groupJID); err != nil {
+		return err
+	}
+
+	if len(participants) > 0 {
+		if err := s.AddParticipants(groupJID, participants); err != nil {
+			return err
+		}
+	}
+
+	endpoint := fmt.Sprintf("conversation_participants?chat_jid=eq.%s&channel=eq.whatsapp", pgValue(groupJID))
+	if len(participants) > 0 {
+		jids := make([]string, len(participants))
+		for i, participant := range participants {
+			jids[i] = participant.JID
+		}
+		endpoint += "&participant_jid=not.in." + pgList(jids)
+	}
+	if _, err := s.client.makeRequest("DELETE", endpoint, nil); err != nil {
+		return fmt.Errorf("failed to delete former participants: %v", err)
+	}
+	return nil
+}
+
+// AddParticipants upserts members of a group in Supabase. Members with and
+// without a known phone number are written separately, so an upsert never
+// clears a phone number stored earlier.
+func (s *SupabaseMessageStore) AddParticipants(groupJID string, participants []ParticipantRecord) error {
+	var withPhone, withoutPhone []supabaseParticipant
+	for _, row := range participantRows(groupJID, participants) {
+		if row.PhoneNumber != nil {
+			withPhone = append(withPhone, row)
+		} else {
+			withoutPhone = append(withoutPhone, row)
+		}
+	}
+
+	for _, rows := range [][]supabaseParticipant{withPhone, withoutPhone} {
+		if len(rows) == 0 {
+			continue
+		}
+		if _, err := s.client.makeUpsertRequest("conversation_participants", supabaseParticipantConflict, rows); err != nil {
+			return fmt.Errorf("failed to store participants: %v", err)
+		}
+	}
+	return nil
+}
+
+// RemoveParticipants deletes the Supabase rows of members who left a group
+func (s *SupabaseMessageStore) RemoveParticipants(groupJID string, jids []string) error {
+	if err := validateJID(groupJID); err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("conversation_participants?chat_jid=eq.%s&channel=eq.whatsapp&participant_jid=in.%s",
+		pgValue(groupJID), pgList(jids))
+	if _, err := s.client.makeRequest("DELETE", endpoint, nil); err != nil {
+		return fmt.Errorf("failed to delete participants: %v", err)
+	}
+	return nil
+}
+
+// SetParticipantRole changes the role of members of a group in Supabase
+func (s *SupabaseMessageStore) SetParticipantRole(groupJID string, jids []string, role string) error {
+	if err := validateJID(groupJID); err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("conversation_participants?chat_jid=eq.%s&channel=eq.whatsapp&participant_jid=in.%s",
+		pgValue(groupJID), pgList(jids))
+	update := map[string]interface{}{"role": role, "updated_at": time.Now().UTC().Format(time.RFC3339)}
+	if _, err := s.client.makeRequest("PATCH", endpoint, update); err != nil {
+		return fmt.Errorf("failed to update participant roles: %v", err)
+	}
+	return nil
+}
+
+// handleGroupParticipants keeps the stored members of groups in step with
+// joins, leaves, promotions and demotions, when the store keeps them
+func handleGroupParticipants(messageStore MessageStoreInterface, evt interface{}, logger waLog.Logger) {
+	store, ok := messageStore.(ParticipantStore)
+	if !ok {
+		return
+	}
+
+	switch v := evt.(type) {
+	case *events.JoinedGroup:
+		if err := store.ReplaceParticipants(v.JID.String(), participantRecords(&v.GroupInfo)); err != nil {
+			logger.Warnf("Failed to store participants of %s: %v", v.JID, err)
+		}
+
+	case *events.GroupInfo:
+		groupJID := v.JID.String()
+		if len(v.Join) > 0 {
+			joined := make([]ParticipantRecord, len(v.Join))
+			for i, jid := range participantJIDs(v.Join) {
+				joined[i] = ParticipantRecord{GroupJID: groupJID, JID: jid, Role: ParticipantMember}
+			}
+			if err := store.AddParticipants(groupJID, joined); err != nil {
+				logger.Warnf("Failed to store joined participants of %s: %v", v.JID, err)
+			}
+		}
+		if len(v.Leave) > 0 {
+			if err := store.RemoveParticipants(groupJID, participantJIDs(v.Leave)); err != nil {
+				logger.Warnf("Failed to remove participants of %s: %v", v.JID, err)
+			}
+		}
+		if len(v.Promote) > 0 {
+			if err := store.SetParticipantRole(groupJID, participantJIDs(v.Promote), ParticipantAdmin); err != nil {
+				logger.Warnf("Failed to store promoted participants of %s: %v", v.JID, err)
+			}
+		}
+		if len(v.Demote) > 0 {
+			if err := store.SetParticipantRole(groupJID, participantJIDs(v.Demote), ParticipantMember); err != nil {
+				logger.Warnf("Failed to store demoted participants of %s: %v", v.JID, err)
+			}
+		}
+	}
+}
+
+// syncGroupParticipants stores the members of all joined groups, catching
+// up on changes made while the bridge was offline
+func syncGroupParticipants(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) {
+	store, ok := messageStore.(ParticipantStore)
+	if !ok {
+		return
+	}
+
+	groups, err := client.GetJoinedGroups(context.Background())
+	if err != nil {
+		logger.Warnf("Failed to fetch groups for participant sync: %v", err)
+		return
+	}
+
+	synced := 0
+	for _, group := range groups {
+		cacheGroupInfo(group)
+		if err := store.ReplaceParticipants(group.JID.String(), participantRecords(group)); err != nil {
+			logger.Warnf("Failed to store participants of %s: %v", group.JID, err)
+			continue
+		}
+		synced++
+	}
+	logger.Infof("Synced participants of %d groups", synced)
+}
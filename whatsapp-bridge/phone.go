@@ -0,0 +1,111 @@
+package main
+
+import "strings"
+
+// phoneRegion describes how numbers are dialled within a region
+type phoneRegion struct {
+	// countryCode is the international calling code
+	countryCode string
+	// trunkPrefix is dialled before national numbers and dropped in
+	// international form; empty where the leading 0 is part of the number
+	trunkPrefix string
+	// internationalPrefix is dialled before a foreign country code
+	internationalPrefix string
+	// nationalLength is the length of national numbers that may be written
+	// without trunk prefix, 0 when numbers without one are ambiguous
+	nationalLength int
+}
+
+// phoneRegions maps ISO 3166 region codes to their dialling rules. Regions
+// not listed here can still be used through DEFAULT_COUNTRY_CODE.
+var phoneRegions = map[string]phoneRegion{
+	"AR": {countryCode: "54", trunkPrefix: "0", internationalPrefix: "00"},
+	"AT": {countryCode: "43", trunkPrefix: "0", internationalPrefix: "00"},
+	"AU": {countryCode: "61", trunkPrefix: "0", internationalPrefix: "0011"},
+	"BE": {countryCode: "32", trunkPrefix: "0", internationalPrefix: "00"},
+	"BR": {countryCode: "55", trunkPrefix: "0", internationalPrefix: "00"},
+	"CA": {countryCode: "1", trunkPrefix: "1", internationalPrefix: "011", nationalLength: 10},
+	"CH": {countryCode: "41", trunkPrefix: "0", internationalPrefix: "00"},
+	"DE": {countryCode: "49", trunkPrefix: "0", internationalPrefix: "00"},
+	"DK": {countryCode: "45", internationalPrefix: "00", nationalLength: 8},
+	"ES": {countryCode: "34", internationalPrefix: "00", nationalLength: 9},
+	"FR": {countryCode: "33", trunkPrefix: "0", internationalPrefix: "00"},
+	"GB": {countryCode: "44", trunkPrefix: "0", internationalPrefix: "00"},
+	"ID": {countryCode: "62", trunkPrefix: "0", internationalPrefix: "00"},
+	"IE": {countryCode: "353", trunkPrefix: "0", internationalPrefix: "00"},
+	"IN": {countryCode: "91", trunkPrefix: "0", internationalPrefix: "00", nationalLength: 10},
+	"IT": {countryCode: "39", internationalPrefix: "00"},
+	"KE": {countryCode: "254", trunkPrefix: "0", internationalPrefix: "000"},
+	"MX": {countryCode: "52", internationalPrefix: "00", nationalLength: 10},
+	"NG": {countryCode: "234", trunkPrefix: "0", internationalPrefix: "009"},
+	"NL": {countryCode: "31", trunkPrefix: "0", internationalPrefix: "00"},
+	"NO": {countryCode: "47", internationalPrefix: "00", nationalLength: 8},
+	"PK": {countryCode: "92", trunkPrefix: "0", internationalPrefix: "00"},
+	"PL": {countryCode: "48", internationalPrefix: "00", nationalLength: 9},
+	"PT": {countryCode: "351", internationalPrefix: "00", nationalLength: 9},
+	"SE": {countryCode: "46", trunkPrefix: "0", internationalPrefix: "00"},
+	"TR": {countryCode: "90", trunkPrefix: "0", internationalPrefix: "00"},
+	"US": {countryCode: "1", trunkPrefix: "1", internationalPrefix: "011", nationalLength: 10},
+	"ZA": {countryCode: "27", trunkPrefix: "0", internationalPrefix: "00"},
+}
+
+// defaultPhoneRegion returns the dialling rules for numbers written without
+// country code, from DEFAULT_REGION or else DEFAULT_COUNTRY_CODE. The
+// second result is false when neither is configured.
+func defaultPhoneRegion() (phoneRegion, bool) {
+	if region, ok := phoneRegions[strings.ToUpper(envString("DEFAULT_REGION", ""))]; ok {
+		return region, true
+	}
+
+	if cc := strings.TrimPrefix(envString("DEFAULT_COUNTRY_CODE", ""), "+"); cc != "" {
+		return phoneRegion{countryCode: cc, trunkPrefix: "0", internationalPrefix: "00"}, true
+	}
+
+	return phoneRegion{}, false
+}
+
+// normalizePhone converts a phone number in international or national
+// notation to the E.164 digits, without plus, that WhatsApp uses as the JID
+// user part. "06 1234 5678" and "+31 (0)6-12345678" both become
+// "31612345678" with DEFAULT_REGION=NL. Numbers that cannot be placed in a
+// region are returned as bare digits.
+func normalizePhone(input string) string {
+	trimmed := strings.TrimSpace(input)
+	international := strings.HasPrefix(trimmed, "+")
+	if international {
+		// "+31 (0)6..." keeps the trunk prefix for readers who dial nationally
+		trimmed = strings.Replace(trimmed, "(0)", "", 1)
+	}
+
+	var digits strings.Builder
+	for _, r := range trimmed {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	number := digits.String()
+	if number == "" || international {
+		return number
+	}
+
+	region, ok := defaultPhoneRegion()
+	if !ok {
+		// Without a region only the common "00" international prefix is known
+		return strings.TrimPrefix(number, "00")
+	}
+
+	switch {
+	case strings.HasPrefix(number, region.internationalPrefix):
+		return number[len(region.internationalPrefix):]
+	case region.trunkPrefix != "" && strings.HasPrefix(number, region.trunkPrefix) &&
+		(region.nationalLength == 0 || len(number) == region.nationalLength+len(region.trunkPrefix)):
+		return region.countryCode + number[len(region.trunkPrefix):]
+	case region.trunkPrefix == "" && strings.HasPrefix(number, "0"):
+		// Italian style landlines keep their leading 0 after the country code
+		return region.countryCode + number
+	case region.nationalLength > 0 && len(number) == region.nationalLength:
+		return region.countryCode + number
+	}
+
+	return number
+}
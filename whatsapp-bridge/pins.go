@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// pinDurations are the pin lengths WhatsApp offers. A pin lapses after
+// its duration without an unpin message.
+var pinDurations = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// PinRecord is a message being pinned in or unpinned from its chat
+type PinRecord struct {
+	MessageID string
+	ChatJID   string
+	// Pinned is false for an unpin, which clears the other fields
+	Pinned    bool
+	PinnedBy  string
+	Timestamp time.Time
+	// ExpiresAt is when the pin lapses, zero when it does not
+	ExpiresAt time.Time
+}
+
+// PinStore is implemented by message stores that keep which messages are
+// pinned in their chat
+type PinStore interface {
+	StorePin(pin PinRecord) error
+}
+
+// StorePin sets or clears the pinned_at, pinned_by and pin_expires_at
+// columns of a message
+func (store *MessageStore) StorePin(pin PinRecord) error {
+	if !pin.Pinned {
+		_, err := store.db.Exec("UPDATE messages SET pinned_at = NULL, pinned_by = NULL, pin_expires_at = NULL WHERE id = ? AND chat_jid = ?",
+			pin.MessageID, pin.ChatJID)
+		return err
+	}
+
+	var expiresAt interface{}
+	if !pin.ExpiresAt.IsZero() {
+		expiresAt = pin.ExpiresAt.UTC()
+	}
+	_, err := store.db.Exec("UPDATE messages SET pinned_at = ?, pinned_by = ?, pin_expires_at = ? WHERE id = ? AND chat_jid = ?",
+		pin.Timestamp.UTC(), pin.PinnedBy, expiresAt, pin.MessageID, pin.ChatJID)
+	return err
+}
+
+// pinMetadata returns the metadata keys of a pin. An unpin sets them to
+// null.
+func pinMetadata(pin PinRecord) map[string]interface{} {
+	metadata := map[string]interface{}{"pinned_at": nil, "pinned_by": nil, "pin_expires_at": nil}
+	if pin.Pinned {
+		metadata["pinned_at"] = pin.Timestamp.UTC().Format(time.RFC3339)
+		metadata["pinned_by"] = pin.PinnedBy
+		if !pin.ExpiresAt.IsZero() {
+			metadata["pin_expires_at"] = pin.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+	}
+	return metadata
+}
+
+// StorePin saves a pin under "pinned_at", "pinned_by" and "pin_expires_at"
+// in the message metadata
+func (s *SupabaseMessageStore) StorePin(pin PinRecord) error {
+	return s.mergeMetadata(pin.MessageID, pin.ChatJID, pinMetadata(pin))
+}
+
+// StorePin saves a pin in the message metadata
+func (s *PostgresMessageStore) StorePin(pin PinRecord) error {
+	return s.mergeMetadata(pin.MessageID, pinMetadata(pin))
+}
+
+// StorePin saves a pin in the message metadata
+func (s *MySQLMessageStore) StorePin(pin PinRecord) error {
+	return s.mergeMetadata(pin.MessageID, pin.ChatJID, pinMetadata(pin))
+}
+
+// handlePin stores pins and unpins of messages made in a chat, by the
+// account on another device or by other participants
+func handlePin(messageStore MessageStoreInterface, msg *events.Message, logger waLog.Logger) {
+	pin := msg.Message.GetPinInChatMessage()
+	store, ok := messageStore.(PinStore)
+	if pin == nil || !ok {
+		return
+	}
+
+	record := PinRecord{
+		MessageID: pin.GetKey().GetID(),
+		ChatJID:   msg.Info.Chat.String(),
+		Pinned:    pin.GetType() == waProto.PinInChatMessage_PIN_FOR_ALL,
+		PinnedBy:  msg.Info.Sender.User,
+		Timestamp: msg.Info.Timestamp,
+	}
+	if ms := pin.GetSenderTimestampMS(); ms != 0 {
+		record.Timestamp = time.UnixMilli(ms)
+	}
+	if seconds := msg.Message.GetMessageContextInfo().GetMessageAddOnDurationInSecs(); record.Pinned && seconds > 0 {
+		record.ExpiresAt = record.Timestamp.Add(time.Duration(seconds) * time.Second)
+	}
+
+	if err := store.StorePin(record); err != nil {
+		logger.Warnf("Failed to store pin of message %s: %v", record.MessageID, err)
+	}
+}
+
+// PinMessageRequest pins or unpins a message for everyone in its chat
+type PinMessageRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	// Sender is the message's author, looked up in the store when empty.
+	// Messages the account sent need none.
+	Sender string `json:"sender,omitempty"`
+	Unpin  bool   `json:"unpin,omitempty"`
+	// Duration is 24h, 7d or 30d, 7d by default
+	Duration string `json:"duration,omitempty"`
+}
+
+// pinSender returns the JID of a message's author for its message key,
+// empty for the account's own messages
+func pinSender(messageStore MessageStoreInterface, req PinMessageRequest) (types.JID, error) {
+	sender := req.Sender
+	if sender == "" {
+		store, ok := messageStore.(MessageLookupStore)
+		if !ok {
+			return types.EmptyJID, fmt.Errorf("sender is required")
+		}
+		messages, err := store.MessagesByID(req.ChatJID, []string{req.MessageID})
+		if err != nil {
+			return types.EmptyJID, fmt.Errorf("failed to look up message: %v", err)
+		}
+		msg, found := messages[req.MessageID]
+		if !found {
+			return types.EmptyJID, fmt.Errorf("message %s not found, set sender", req.MessageID)
+		}
+		if msg.IsFromMe {
+			return types.EmptyJID, nil
+		}
+		sender = msg.Sender
+	}
+
+	// Stores keep the user part of the sender
+	if !strings.Contains(sender, "@") {
+		return types.NewJID(sender, types.DefaultUserServer), nil
+	}
+	return types.ParseJID(sender)
+}
+
+// handlePinMessage serves POST /api/messages/pin, sending a pin or unpin of
+// a message to the chat and storing it, as the account's own pins are not
+// echoed back
+func handlePinMessage(client *whatsmeow.Client, messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req PinMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		chat, err := types.ParseJID(req.ChatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid chat_jid: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Duration == "" {
+			req.Duration = "7d"
+		}
+		duration, ok := pinDurations[req.Duration]
+		if !ok {
+			http.Error(w, "Invalid duration, expected 24h, 7d or 30d", http.StatusBadRequest)
+			return
+		}
+		sender, err := pinSender(messageStore, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !client.IsConnected() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Not connected to WhatsApp"})
+			return
+		}
+
+		// Groups where only admins edit the settings only let admins pin
+		if chat.Server == types.GroupServer && client.Store.ID != nil {
+			if info, err := cachedGroupInfo(client, chat); err == nil && info.IsLocked && !isGroupAdmin(client, chat, *client.Store.ID) {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Only group admins can pin messages in this group"})
+				return
+			}
+		}
+
+		now := time.Now()
+		pinType := waProto.PinInChatMessage_PIN_FOR_ALL
+		if req.Unpin {
+			pinType = waProto.PinInChatMessage_UNPIN_FOR_ALL
+		}
+		msg := &waProto.Message{
+			PinInChatMessage: &waProto.PinInChatMessage{
+				Key:               client.BuildMessageKey(chat, sender, req.MessageID),
+				Type:              pinType.Enum(),
+				SenderTimestampMS: proto.Int64(now.UnixMilli()),
+			},
+		}
+		if !req.Unpin {
+			msg.MessageContextInfo = &waProto.MessageContextInfo{
+				MessageAddOnDurationInSecs: proto.Uint32(uint32(duration.Seconds())),
+			}
+		}
+
+		if _, err := client.SendMessage(context.Background(), chat, msg); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":        false,
+				"message":        fmt.Sprintf("Error sending pin: %v", err),
+				"failure_reason": classifySendError(client, chat, err),
+			})
+			return
+		}
+
+		pin := PinRecord{MessageID: req.MessageID, ChatJID: chat.String(), Pinned: !req.Unpin}
+		if pin.Pinned {
+			if client.Store.ID != nil {
+				pin.PinnedBy = client.Store.ID.User
+			}
+			pin.Timestamp = now
+			pin.ExpiresAt = now.Add(duration)
+		}
+		if store, ok := messageStore.(PinStore); ok {
+			if err := store.StorePin(pin); err != nil {
+				fmt.Printf("Failed to store pin of message %s: %v\n", req.MessageID, err)
+			}
+		}
+
+		response := map[string]interface{}{"success": true, "pinned": pin.Pinned}
+		if pin.Pinned {
+			response["expires_at"] = pin.ExpiresAt.UTC()
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// PostgresMessageStore connects straight to a Postgres database, the one of
+// a Supabase project or a plain server, instead of going through PostgREST,
+// saving a round-trip per write and allowing transactions. It uses the
+// conversations and messages tables of SupabaseMessageStore, so the inbox
+// reads the same rows. History sync is loaded with COPY; live messages use
+// statements prepared on every connection of the pool.
+type PostgresMessageStore struct {
+	pool *pgxpool.Pool
+	// conversations and messages are the table names, schema-qualified
+	// when SUPABASE_SCHEMA is set
+	conversations string
+	messages      string
+
+	conversationCache *lruCache
+}
+
+// pgExecutor is satisfied by the pool and by transactions, so writes can
+// run inside or outside a transaction
+type pgExecutor interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// NewPostgresMessageStore connects to a database and prepares the
+// statements of the hot path. Tables are renamed and looked up in the
+// schema of SUPABASE_TABLES and SUPABASE_SCHEMA, like the REST client.
+// With DATABASE_MIGRATE the schema is migrated first, for servers without
+// the Supabase tooling to run the migrate command. Tenants and column maps
+// are not supported, so setting them is an error rather than writing
+// unscoped rows.
+func NewPostgresMessageStore(databaseURL string, logger waLog.Logger) (*PostgresMessageStore, error) {
+	if databaseURL == "" {
+		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
+	}
+	for _, name := range []string{"SUPABASE_TENANT_ID", "SUPABASE_COLUMN_MAP", "SUPABASE_COLUMN_MAP_FILE"} {
+		if envString(name, "") != "" {
+			return nil, fmt.Errorf("%s is not supported by the postgres message store", name)
+		}
+	}
+
+	tables, err := newSupabaseTables()
+	if err != nil {
+		return nil, err
+	}
+	qualified := func(table string) string {
+		if tables != nil && tables.schema != "" {
+			return tables.schema + "." + tables.name(table)
+		}
+		return tables.name(table)
+	}
+
+	s := &PostgresMessageStore{
+		conversations:     qualified("conversations"),
+		messages:          qualified("messages"),
+		conversationCache: newLRUCache(envInt("SUPABASE_CONVERSATION_CACHE_SIZE", 10000), envDuration("SUPABASE_CONVERSATION_CACHE_TTL", 24*time.Hour)),
+	}
+
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %v", err)
+	}
+	if maxConns := envInt("DATABASE_MAX_CONNS", 0); maxConns > 0 {
+		config.MaxConns = int32(maxConns)
+	}
+	statements := s.statements()
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		for name, sql := range statements {
+			if _, err := conn.Prepare(ctx, name, sql); err != nil {
+				return fmt.Errorf("failed to prepare %s: %v", name, err)
+			}
+		}
+		return nil
+	}
+
+	connectTimeout := envDuration("DATABASE_CONNECT_TIMEOUT", 10*time.Second)
+	// Statements are prepared on connect, so the tables must exist before
+	// the pool opens
+	if envBool("DATABASE_MIGRATE", false) {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		conn, err := pgx.ConnectConfig(ctx, config.ConnConfig.Copy())
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %v", err)
+		}
+		// Migrations can build indexes on large tables, so they do not
+		// run under the connect timeout
+		err = migratePostgres(context.Background(), conn, tables, logger)
+		conn.Close(context.Background())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	s.pool = pool
+	return s, nil
+}
+
+// statements returns the prepared statements by name. Every live message
+// runs them, so they are planned once per connection.
+func (s *PostgresMessageStore) statements() map[string]string {
+	return map[string]string{
+		"find_conversation": "SELECT id::text FROM " + s.conversations +
+			" WHERE contact_identifier = $1 AND channel = 'whatsapp' LIMIT 1",
+		"touch_conversation": "UPDATE " + s.conversations + " SET" +
+			" last_message_at = GREATEST(last_message_at, $2)," +
+			" contact_name = COALESCE(NULLIF($3, ''), contact_name)" +
+			" WHERE id = $1",
+		// inserted is false when the message was stored before
+		"insert_message": "INSERT INTO " + s.messages + " AS m" +
+			" (" + strings.Join(postgresMessageColumns, ", ") + ")" +
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)" +
+			" ON CONFLICT (external_id, channel) DO UPDATE SET body = EXCLUDED.body," +
+			" metadata = COALESCE(m.metadata, '{}') || COALESCE(EXCLUDED.metadata, '{}')" +
+			" RETURNING (xmax = 0) AS inserted",
+		"increment_unread": "UPDATE " + s.conversations + " SET unread_count = unread_count + 1 WHERE id = $1",
+	}
+}
+
+// Close closes the connection pool
+func (s *PostgresMessageStore) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+// executor returns tx, or the pool outside a transaction
+func (s *PostgresMessageStore) executor(tx pgx.Tx) pgExecutor {
+	if tx != nil {
+		return tx
+	}
+	return s.pool
+}
+
+// conversationID returns the ID of a chat's conversation, creating it when
+// create is set, and whether it was created. Creation holds an advisory
+// lock on the JID until its transaction ends, so concurrent writers do not
+// both create the conversation. IDs found within tx are not cached, as the
+// caller may still roll it back.
+func (s *PostgresMessageStore) conversationID(ctx context.Context, tx pgx.Tx, jid, name string, create bool) (string, bool, error) {
+	if err := validateJID(jid); err != nil {
+		return "", false, err
+	}
+	if id, ok := s.conversationCache.Get(jid); ok {
+		return id, false, nil
+	}
+
+	var id string
+	err := s.executor(tx).QueryRow(ctx, "find_conversation", jid).Scan(&id)
+	if err == nil {
+		if tx == nil {
+			s.conversationCache.Set(jid, id)
+		}
+		return id, false, nil
+	}
+	if err != pgx.ErrNoRows {
+		return "", false, fmt.Errorf("failed to query conversation: %v", err)
+	}
+	if !create {
+		return "", false, nil
+	}
+
+	lockTx := tx
+	if lockTx == nil {
+		if lockTx, err = s.pool.Begin(ctx); err != nil {
+			return "", false, err
+		}
+		defer lockTx.Rollback(ctx)
+	}
+	if _, err := lockTx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", jid); err != nil {
+		return "", false, fmt.Errorf("failed to lock conversation: %v", err)
+	}
+
+	created := false
+	err = lockTx.QueryRow(ctx, "find_conversation", jid).Scan(&id)
+	if err == pgx.ErrNoRows {
+		err = lockTx.QueryRow(ctx,
+			"INSERT INTO "+s.conversations+" (channel, contact_identifier, contact_name, status)"+
+				" VALUES ('whatsapp', $1, NULLIF($2, ''), $3) RETURNING id::text",
+			jid, name, ConversationActive,
+		).Scan(&id)
+		created = true
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create conversation: %v", err)
+	}
+
+	if tx == nil {
+		if err := lockTx.Commit(ctx); err != nil {
+			return "", false, err
+		}
+		s.conversationCache.Set(jid, id)
+	}
+	return id, created, nil
+}
+
+// storeChat creates a chat's conversation if needed and moves its last
+// message time forward
+func (s *PostgresMessageStore) storeChat(ctx context.Context, tx pgx.Tx, jid, name string, lastMessageTime time.Time) (bool, error) {
+	conversationID, created, err := s.conversationID(ctx, tx, jid, name, true)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := s.executor(tx).Exec(ctx, "touch_conversation", conversationID, lastMessageTime, name); err != nil {
+		return created, fmt.Errorf("failed to update conversation: %v", err)
+	}
+	return created, nil
+}
+
+// storeRecord writes a live message and counts inbound ones as unread
+func (s *PostgresMessageStore) storeRecord(ctx context.Context, tx pgx.Tx, record MessageRecord) (bool, error) {
+	if record.Content == "" && record.MediaType == "" {
+		return false, nil
+	}
+
+	conversationID, created, err := s.conversationID(ctx, tx, record.ChatJID, "", true)
+	if err != nil {
+		return false, err
+	}
+
+	db := s.executor(tx)
+	var inserted bool
+	if err := db.QueryRow(ctx, "insert_message", postgresMessageArgs(newSupabaseMessage(conversationID, record))...).Scan(&inserted); err != nil {
+		return created, fmt.Errorf("failed to store message: %v", err)
+	}
+	// A redelivered message was counted when it was first stored
+	if inserted && !record.IsFromMe {
+		if _, err := db.Exec(ctx, "increment_unread", conversationID); err != nil {
+			return created, fmt.Errorf("failed to update unread count: %v", err)
+		}
+	}
+	return created, nil
+}
+
+// postgresMessageColumns are the written columns of a messages row, in the
+// order of postgresMessageArgs
+var postgresMessageColumns = []string{"conversation_id", "channel", "direction", "sender", "recipient", "body", "external_id", "metadata", "created_at"}
+
+// postgresMessageArgs returns the values of a row for postgresMessageColumns
+func postgresMessageArgs(row SupabaseMessage) []interface{} {
+	// Empty metadata is stored as NULL, like the key PostgREST omits
+	var metadata interface{}
+	if len(row.Metadata) > 0 {
+		metadata = row.Metadata
+	}
+	return []interface{}{row.ConversationID, row.Channel, row.Direction, row.Sender, row.Recipient, row.Body, row.ExternalID, metadata, row.CreatedAt}
+}
+
+// StoreChat stores or updates a chat's conversation
+func (s *PostgresMessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	created, err := s.storeChat(context.Background(), nil, jid, name, lastMessageTime)
+	if created {
+		emitEvent(EventConversationCreated, jid, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+	return err
+}
+
+// StoreMessage stores a live message
+func (s *PostgresMessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
+	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
+
+	record := MessageRecord{
+		ID:            id,
+		ChatJID:       chatJID,
+		Sender:        sender,
+		Content:       content,
+		Timestamp:     timestamp,
+		IsFromMe:      isFromMe,
+		MediaType:     mediaType,
+		Filename:      filename,
+		URL:           url,
+		MediaKey:      mediaKey,
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		FileLength:    fileLength,
+	}
+	created, err := s.storeRecord(context.Background(), nil, record)
+	if created {
+		emitEvent(EventConversationCreated, chatJID, ConversationEventPayload{ChatJID: chatJID})
+	}
+	return err
+}
+
+// StoreMessages stores history messages in one transaction: they are
+// copied into a temporary table and merged into messages from there, then
+// each conversation's last_message_at moves to its newest message
+func (s *PostgresMessageStore) StoreMessages(records []MessageRecord) error {
+	ctx := context.Background()
+
+	var rows [][]interface{}
+	latest := make(map[string]time.Time)
+	for _, record := range records {
+		if record.Content == "" && record.MediaType == "" {
+			continue
+		}
+
+		conversationID, created, err := s.conversationID(ctx, nil, record.ChatJID, "", true)
+		if err != nil {
+			return err
+		}
+		if created {
+			emitEvent(EventConversationCreated, record.ChatJID, ConversationEventPayload{ChatJID: record.ChatJID})
+		}
+
+		rows = append(rows, postgresMessageArgs(newSupabaseMessage(conversationID, record)))
+		if record.Timestamp.After(latest[conversationID]) {
+			latest[conversationID] = record.Timestamp
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE bridge_history_import (LIKE "+s.messages+" INCLUDING DEFAULTS) ON COMMIT DROP"); err != nil {
+		return fmt.Errorf("failed to create import table: %v", err)
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"bridge_history_import"}, postgresMessageColumns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("failed to copy messages: %v", err)
+	}
+
+	// A message synced twice in one batch would make the upsert touch its
+	// row twice, which Postgres refuses
+	columns := strings.Join(postgresMessageColumns, ", ")
+	_, err = tx.Exec(ctx, "INSERT INTO "+s.messages+" AS m ("+columns+")"+
+		" SELECT DISTINCT ON (external_id, channel) "+columns+" FROM bridge_history_import"+
+		" ON CONFLICT (external_id, channel) DO UPDATE SET body = EXCLUDED.body,"+
+		" metadata = COALESCE(m.metadata, '{}') || COALESCE(EXCLUDED.metadata, '{}')")
+	if err != nil {
+		return fmt.Errorf("failed to store messages: %v", err)
+	}
+
+	for conversationID, timestamp := range latest {
+		if _, err := tx.Exec(ctx, "touch_conversation", conversationID, timestamp, ""); err != nil {
+			return fmt.Errorf("failed to update conversation: %v", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// postgresTx runs the writes of a unit of work in a database transaction
+type postgresTx struct {
+	store *PostgresMessageStore
+	ctx   context.Context
+	tx    pgx.Tx
+	// created holds chats to announce once the transaction commits
+	created []ConversationEventPayload
+}
+
+// StoreChat stores a chat in the transaction
+func (t *postgresTx) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	created, err := t.store.storeChat(t.ctx, t.tx, jid, name, lastMessageTime)
+	if created {
+		t.created = append(t.created, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+	return err
+}
+
+// StoreMessage stores a message in the transaction
+func (t *postgresTx) StoreMessage(record MessageRecord) error {
+	created, err := t.store.storeRecord(t.ctx, t.tx, record)
+	if created {
+		t.created = append(t.created, ConversationEventPayload{ChatJID: record.ChatJID})
+	}
+	return err
+}
+
+// InTransaction runs fn in a database transaction. Conversation events are
+// only emitted after it commits.
+func (s *PostgresMessageStore) InTransaction(fn func(tx StoreTx) error) error {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	unit := &postgresTx{store: s, ctx: ctx, tx: tx}
+	if err := fn(unit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	for _, payload := range unit.created {
+		emitEvent(EventConversationCreated, payload.ChatJID, payload)
+	}
+	return nil
+}
+
+// postgresMessageSelect is the select list read by scanMessages, on the
+// messages table aliased m
+const postgresMessageSelect = "m.external_id, m.direction, m.sender, m.body, m.created_at, m.metadata"
+
+// appendPostgresFilters adds the cursor and filters of a message query to
+// a query on the messages table aliased m, followed by its order and limit
+func appendPostgresFilters(sql string, args []interface{}, query MessageQuery) (string, []interface{}) {
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !query.CursorTime.IsZero() {
+		cursorTime, cursorID := arg(query.CursorTime), arg(query.CursorID)
+		sql += fmt.Sprintf(" AND (m.created_at < %[1]s OR (m.created_at = %[1]s AND m.external_id < %[2]s))", cursorTime, cursorID)
+	}
+	if !query.After.IsZero() {
+		sql += " AND m.created_at > " + arg(query.After)
+	}
+	if !query.Before.IsZero() {
+		sql += " AND m.created_at < " + arg(query.Before)
+	}
+
+	switch query.Direction {
+	case DirectionIn:
+		sql += " AND m.direction = 'inbound'"
+	case DirectionOut:
+		sql += " AND m.direction = 'outbound'"
+	}
+
+	if len(query.MediaTypes) > 0 {
+		// Text messages carry no media_type in their metadata
+		var mediaTypes, conditions []string
+		for _, mediaType := range query.MediaTypes {
+			if mediaType == "text" {
+				conditions = append(conditions, "m.metadata->>'media_type' IS NULL")
+			} else {
+				mediaTypes = append(mediaTypes, mediaType)
+			}
+		}
+		if len(mediaTypes) > 0 {
+			conditions = append(conditions, "m.metadata->>'media_type' = ANY("+arg(mediaTypes)+")")
+		}
+		sql += " AND (" + strings.Join(conditions, " OR ") + ")"
+	}
+
+	if query.Pinned {
+		sql += " AND m.metadata->>'pinned_at' IS NOT NULL" +
+			" AND (m.metadata->>'pin_expires_at' IS NULL OR (m.metadata->>'pin_expires_at')::timestamptz > now())"
+	}
+
+	if softDeletes != nil {
+		sql += " AND m.trashed_at IS NULL"
+	}
+
+	sql += " ORDER BY m.created_at DESC, m.external_id DESC"
+	if query.Limit > 0 {
+		sql += " LIMIT " + arg(query.Limit)
+	}
+	return sql, args
+}
+
+// scanMessages reads rows of postgresMessageSelect. When chatJID is "" each
+// row is followed by its chat's JID.
+func scanMessages(rows pgx.Rows, chatJID string) ([]Message, error) {
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var row supabaseMessageRow
+		var externalID *string
+		var metadata []byte
+		jid := chatJID
+		dest := []interface{}{&externalID, &row.Direction, &row.Sender, &row.Body, &row.CreatedAt, &metadata}
+		if chatJID == "" {
+			dest = append(dest, &jid)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if externalID != nil {
+			row.ExternalID = *externalID
+		}
+		if len(metadata) > 0 {
+			if err := json.Unmarshal(metadata, &row.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to parse message metadata: %v", err)
+			}
+		}
+		messages = append(messages, row.message(jid))
+	}
+	return messages, rows.Err()
+}
+
+// GetMessages retrieves messages from a chat, newest first. Messages are
+// ordered by created_at, the time the bridge stored them.
+func (s *PostgresMessageStore) GetMessages(chatJID string, query MessageQuery) ([]Message, error) {
+	ctx := context.Background()
+
+	// Reading a chat that was never stored must not create it
+	conversationID, _, err := s.conversationID(ctx, nil, chatJID, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if conversationID == "" {
+		return []Message{}, nil
+	}
+
+	sql, args := appendPostgresFilters(
+		"SELECT "+postgresMessageSelect+" FROM "+s.messages+" m WHERE m.conversation_id = $1",
+		[]interface{}{conversationID}, query,
+	)
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %v", err)
+	}
+	return scanMessages(rows, chatJID)
+}
+
+// GetChats retrieves all WhatsApp conversations with their last message time
+func (s *PostgresMessageStore) GetChats() (map[string]time.Time, error) {
+	sql := "SELECT contact_identifier, last_message_at FROM " + s.conversations + " WHERE channel = 'whatsapp'"
+	if softDeletes != nil {
+		sql += " AND trashed_at IS NULL"
+	}
+	rows, err := s.pool.Query(context.Background(), sql)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+	defer rows.Close()
+
+	chats := make(map[string]time.Time)
+	for rows.Next() {
+		var jid string
+		var lastMessageAt *time.Time
+		if err := rows.Scan(&jid, &lastMessageAt); err != nil {
+			return nil, err
+		}
+		chats[jid] = time.Time{}
+		if lastMessageAt != nil {
+			chats[jid] = *lastMessageAt
+		}
+	}
+	return chats, rows.Err()
+}
+
+// GetMediaInfo retrieves the media download fields kept in a message's
+// metadata
+func (s *PostgresMessageStore) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
+	var metadata []byte
+	err := s.pool.QueryRow(context.Background(),
+		"SELECT metadata FROM "+s.messages+" WHERE external_id = $1 AND channel = 'whatsapp'", id,
+	).Scan(&metadata)
+	if err == pgx.ErrNoRows {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("message %s not found", id)
+	}
+	if err != nil {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("failed to query message: %v", err)
+	}
+
+	var media struct {
+		MediaType     string `json:"media_type"`
+		Filename      string `json:"filename"`
+		URL           string `json:"whatsapp_url"`
+		MediaKey      []byte `json:"media_key"`
+		FileSHA256    []byte `json:"file_sha256"`
+		FileEncSHA256 []byte `json:"file_enc_sha256"`
+		FileLength    uint64 `json:"file_length"`
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &media); err != nil {
+			return "", "", "", nil, nil, nil, 0, fmt.Errorf("failed to parse message metadata: %v", err)
+		}
+	}
+	if media.MediaType == "" {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("message %s has no media", id)
+	}
+	return media.MediaType, media.Filename, media.URL, media.MediaKey, media.FileSHA256, media.FileEncSHA256, media.FileLength, nil
+}
+
+// mergeMetadata sets keys of a message's metadata and applies the metadata
+// policy, with the row locked so concurrent merges do not lose keys
+func (s *PostgresMessageStore) mergeMetadata(externalID string, patch map[string]interface{}) error {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	var encoded []byte
+	err = tx.QueryRow(ctx,
+		"SELECT id::text, metadata FROM "+s.messages+" WHERE external_id = $1 AND channel = 'whatsapp' FOR UPDATE", externalID,
+	).Scan(&id, &encoded)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("message %s: %w", externalID, errMessageNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query message: %v", err)
+	}
+
+	metadata := make(map[string]interface{})
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded, &metadata); err != nil {
+			return fmt.Errorf("failed to parse message metadata: %v", err)
+		}
+	}
+	for key, value := range patch {
+		metadata[key] = value
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE "+s.messages+" SET metadata = $2 WHERE id = $1", id, getMetadataPolicy().Apply(metadata)); err != nil {
+		return fmt.Errorf("failed to update message metadata: %v", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// StoreEnrichment stores classifier results in the message metadata
+func (s *PostgresMessageStore) StoreEnrichment(id, chatJID, sentiment, language string) error {
+	return s.mergeMetadata(id, map[string]interface{}{
+		"sentiment": sentiment,
+		"language":  language,
+	})
+}
+
+// StoreQuote saves the quote of a reply in the message metadata
+func (s *PostgresMessageStore) StoreQuote(id, chatJID string, quote Quote) error {
+	metadata := map[string]interface{}{"quoted_id": quote.ID}
+	if quote.Sender != "" {
+		metadata["quoted_sender"] = quote.Sender
+	}
+	if quote.Snippet != "" {
+		metadata["quoted_snippet"] = quote.Snippet
+	}
+	return s.mergeMetadata(id, metadata)
+}
+
+// StoreMentions saves the mentioned JIDs in the message metadata
+func (s *PostgresMessageStore) StoreMentions(id, chatJID string, mentioned []string, mentionedMe bool) error {
+	metadata := map[string]interface{}{"mentioned_jids": mentioned}
+	if mentionedMe {
+		metadata["mentioned_me"] = true
+	}
+	return s.mergeMetadata(id, metadata)
+}
+
+// GetMentions lists messages mentioning the logged in account across
+// conversations
+func (s *PostgresMessageStore) GetMentions(query MessageQuery) ([]Message, error) {
+	sql, args := appendPostgresFilters(
+		"SELECT "+postgresMessageSelect+", c.contact_identifier FROM "+s.messages+" m"+
+			" JOIN "+s.conversations+" c ON c.id = m.conversation_id"+
+			" WHERE m.channel = 'whatsapp' AND m.metadata->>'mentioned_me' = 'true'",
+		nil, query,
+	)
+	rows, err := s.pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mentions: %v", err)
+	}
+	return scanMessages(rows, "")
+}
+
+// MessagesByID returns the WhatsApp messages with the given IDs
+func (s *PostgresMessageStore) MessagesByID(chatJID string, ids []string) (map[string]Message, error) {
+	sql := "SELECT " + postgresMessageSelect + " FROM " + s.messages + " m WHERE m.channel = 'whatsapp' AND m.external_id = ANY($1)"
+	if softDeletes != nil {
+		sql += " AND m.trashed_at IS NULL"
+	}
+	rows, err := s.pool.Query(context.Background(), sql, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	list, err := scanMessages(rows, chatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(map[string]Message)
+	for _, msg := range list {
+		messages[msg.ID] = msg
+	}
+	return messages, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Values interpolated into PostgREST endpoints built with fmt.Sprintf must go
+// through these helpers. A raw "&" would start a new query parameter and a
+// raw "," or ")" would end an in() list or logic tree, letting a crafted
+// value add filters of its own.
+
+// jidPattern matches user[.agent][:device]@server, the only JID shape the
+// bridge stores
+var jidPattern = regexp.MustCompile(`^[0-9A-Za-z_-]+(\.[0-9]+)?(:[0-9]+)?@[0-9a-z.-]+$`)
+
+// validateJID rejects JIDs that WhatsApp would never produce before they are
+// used in a query
+func validateJID(jid string) error {
+	if !jidPattern.MatchString(jid) {
+		return fmt.Errorf("invalid JID %q", jid)
+	}
+	if _, err := types.ParseJID(jid); err != nil {
+		return fmt.Errorf("invalid JID %q: %v", jid, err)
+	}
+	return nil
+}
+
+// pgValue escapes a value for a filter such as "external_id=eq.<value>"
+func pgValue(value string) string {
+	return url.QueryEscape(value)
+}
+
+// pgQuote double-quotes a value for use inside in() lists and or()/and()
+// trees, where , . : ( ) are reserved. The result still needs query string
+// escaping, which url.Values does.
+func pgQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// pgList builds the escaped operand of an in. filter from values
+func pgList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = pgQuote(value)
+	}
+	return url.QueryEscape("(" + strings.Join(quoted, ",") + ")")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// CachePreloader is implemented by message stores that can warm their
+// lookup caches with bulk queries at startup
+type CachePreloader interface {
+	PreloadCache() (int, error)
+}
+
+// preloadStoreCache warms the message store's caches, when it has any
+func preloadStoreCache(messageStore MessageStoreInterface, logger waLog.Logger) {
+	preloader, ok := messageStore.(CachePreloader)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	count, err := preloader.PreloadCache()
+	if err != nil {
+		logger.Warnf("Failed to preload conversation cache: %v", err)
+		return
+	}
+	logger.Infof("Preloaded %d conversations in %v", count, time.Since(start).Round(time.Millisecond))
+}
+
+// preloadClientCaches loads all contacts and joined groups in one request
+// each, so name lookups for the first burst of events hit memory
+func preloadClientCaches(client *whatsmeow.Client, logger waLog.Logger) {
+	ctx := context.Background()
+	start := time.Now()
+
+	// Reading all contacts fills the device store's contact cache
+	contacts, err := client.Store.Contacts.GetAllContacts(ctx)
+	if err != nil {
+		logger.Warnf("Failed to preload contacts: %v", err)
+	}
+
+	groups, err := client.GetJoinedGroups(ctx)
+	if err != nil {
+		logger.Warnf("Failed to preload groups: %v", err)
+	}
+
+	for _, group := range groups {
+		cacheGroupInfo(group)
+	}
+
+	logger.Infof("Preloaded %d contacts and %d groups in %v", len(contacts), len(groups), time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// QuietWindow is a daily period during which non-urgent messages are held
+type QuietWindow struct {
+	// Start and End are "HH:MM" in Timezone; a window may span midnight
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone is an IANA name such as "Europe/Amsterdam", UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+
+	start, end time.Duration
+	location   *time.Location
+}
+
+// QuietHoursConfig selects a quiet window per chat, recipient region or as
+// default, in that order of precedence. Regions are ISO codes known to the
+// phone normalizer ("NL") or country calling codes ("31"). A window with
+// empty start and end disables quiet hours for that chat or region.
+type QuietHoursConfig struct {
+	Default *QuietWindow            `json:"default,omitempty"`
+	Regions map[string]*QuietWindow `json:"regions,omitempty"`
+	Chats   map[string]*QuietWindow `json:"chats,omitempty"`
+}
+
+// QuietHours decides whether an outbound message should be held
+type QuietHours struct {
+	config QuietHoursConfig
+	// regions is keyed by country calling code
+	regions map[string]*QuietWindow
+}
+
+// quietHours is the active quiet hours policy, nil when disabled
+var quietHours *QuietHours
+
+// parseClock parses "HH:MM" into the offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// prepare validates a window and resolves its times and timezone
+func (w *QuietWindow) prepare() error {
+	if w.Start == "" && w.End == "" {
+		return nil
+	}
+
+	var err error
+	if w.start, err = parseClock(w.Start); err != nil {
+		return err
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return err
+	}
+	if w.location, err = time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", w.Timezone, err)
+	}
+	return nil
+}
+
+// ReleaseTime returns when the window ends if now falls inside it
+func (w *QuietWindow) ReleaseTime(now time.Time) (time.Time, bool) {
+	if w.location == nil || w.start == w.end {
+		return time.Time{}, false
+	}
+
+	local := now.In(w.location)
+	clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second
+
+	// Build the end from the wall clock so DST changes cannot shift it
+	endOn := func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), int(w.end/time.Hour), int(w.end%time.Hour/time.Minute), 0, 0, w.location)
+	}
+
+	if w.start < w.end {
+		if clock >= w.start && clock < w.end {
+			return endOn(local), true
+		}
+		return time.Time{}, false
+	}
+
+	// The window spans midnight
+	switch {
+	case clock >= w.start:
+		return endOn(local.AddDate(0, 0, 1)), true
+	case clock < w.end:
+		return endOn(local), true
+	}
+	return time.Time{}, false
+}
+
+// NewQuietHours loads QUIET_HOURS, a JSON QuietHoursConfig. It returns nil
+// when nothing is configured.
+func NewQuietHours() (*QuietHours, error) {
+	raw := envString("QUIET_HOURS", "")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var config QuietHoursConfig
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse QUIET_HOURS: %v", err)
+	}
+
+	q := &QuietHours{config: config, regions: make(map[string]*QuietWindow)}
+
+	if config.Default != nil {
+		if err := config.Default.prepare(); err != nil {
+			return nil, fmt.Errorf("quiet hours default: %v", err)
+		}
+	}
+	for region, window := range config.Regions {
+		if err := window.prepare(); err != nil {
+			return nil, fmt.Errorf("quiet hours for region %s: %v", region, err)
+		}
+		code := strings.TrimPrefix(region, "+")
+		if known, ok := phoneRegions[strings.ToUpper(code)]; ok {
+			code = known.countryCode
+		}
+		q.regions[code] = window
+	}
+	for chat, window := range config.Chats {
+		if err := window.prepare(); err != nil {
+			return nil, fmt.Errorf("quiet hours for chat %s: %v", chat, err)
+		}
+	}
+
+	return q, nil
+}
+
+// window returns the quiet window that applies to a recipient
+func (q *QuietHours) window(recipient types.JID) *QuietWindow {
+	if window, ok := q.config.Chats[recipient.String()]; ok {
+		return window
+	}
+
+	if recipient.Server == types.DefaultUserServer {
+		// Prefer the longest matching calling code, e.g. 353 over 3
+		var best *QuietWindow
+		bestLen := 0
+		for code, window := range q.regions {
+			if len(code) > bestLen && strings.HasPrefix(recipient.User, code) {
+				best, bestLen = window, len(code)
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+
+	return q.config.Default
+}
+
+// HoldUntil returns when a message to recipient may be sent, if it falls
+// in quiet hours now
+func (q *QuietHours) HoldUntil(recipient types.JID, now time.Time) (time.Time, bool) {
+	if q == nil {
+		return time.Time{}, false
+	}
+
+	window := q.window(recipient)
+	if window == nil {
+		return time.Time{}, false
+	}
+	return window.ReleaseTime(now)
+}
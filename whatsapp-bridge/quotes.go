@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+// quoteSnippetLength is the number of characters kept of a quoted message
+const quoteSnippetLength = 200
+
+// Quote describes the message a reply quotes, as carried in the reply
+// itself so it can be shown even when the quoted message is not stored
+type Quote struct {
+	ID string
+	// Sender is the JID of the quoted message's author
+	Sender string
+	// Snippet is the start of the quoted text, or its media type in
+	// brackets for media without a caption
+	Snippet string
+}
+
+// quotedMessage extracts the quote of a reply from its context info
+func quotedMessage(msg *waProto.Message) Quote {
+	info := messageContextInfo(msg)
+	quote := Quote{ID: info.GetStanzaID(), Sender: info.GetParticipant()}
+	if quote.ID == "" {
+		return Quote{}
+	}
+
+	quoted := info.GetQuotedMessage()
+	text := extractTextContent(quoted)
+	if text == "" {
+		text = mediaCaption(quoted)
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		if mediaType, _, _, _, _, _, _ := extractMediaInfo(quoted); mediaType != "" {
+			text = "[" + mediaType + "]"
+		}
+	}
+	if runes := []rune(text); len(runes) > quoteSnippetLength {
+		text = string(runes[:quoteSnippetLength-1]) + "…"
+	}
+	quote.Snippet = text
+	return quote
+}
+
+// QuoteStore is implemented by message stores that can keep the quote of a
+// reply
+type QuoteStore interface {
+	StoreQuote(id, chatJID string, quote Quote) error
+}
+
+// StoreQuote saves the quoted message ID, sender and snippet on the message
+// row
+func (store *MessageStore) StoreQuote(id, chatJID string, quote Quote) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET quoted_id = ?, quoted_sender = NULLIF(?, ''), quoted_snippet = NULLIF(?, '') WHERE id = ? AND chat_jid = ?",
+		quote.ID, quote.Sender, quote.Snippet, id, chatJID,
+	)
+	return err
+}
+
+// StoreQuote saves the quote under "quoted_id", "quoted_sender" and
+// "quoted_snippet" in the message metadata
+func (s *SupabaseMessageStore) StoreQuote(id, chatJID string, quote Quote) error {
+	metadata := map[string]interface{}{"quoted_id": quote.ID}
+	if quote.Sender != "" {
+		metadata["quoted_sender"] = quote.Sender
+	}
+	if quote.Snippet != "" {
+		metadata["quoted_snippet"] = quote.Snippet
+	}
+	return s.mergeMetadata(id, chatJID, metadata)
+}
+
+// MessageLookupStore is implemented by message stores that can fetch
+// messages of a chat by ID
+type MessageLookupStore interface {
+	MessagesByID(chatJID string, ids []string) (map[string]Message, error)
+}
+
+// MessagesByID returns the stored messages of a chat with the given IDs
+func (store *MessageStore) MessagesByID(chatJID string, ids []string) (map[string]Message, error) {
+	args := []interface{}{chatJID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := store.queryMessages(
+		"SELECT "+messageColumns+" FROM messages WHERE chat_jid = ? AND trashed_at IS NULL AND id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+")",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(map[string]Message)
+	for _, msg := range rows {
+		messages[msg.ID] = msg
+	}
+	return messages, nil
+}
+
+// MessagesByID returns the WhatsApp messages with the given IDs in a chat's
+// conversation from Supabase
+func (s *SupabaseMessageStore) MessagesByID(chatJID string, ids []string) (map[string]Message, error) {
+	messages := make(map[string]Message)
+	conversationID, err := s.conversationID(chatJID)
+	if err != nil || conversationID == "" {
+		return messages, err
+	}
+	endpoint := fmt.Sprintf("messages?channel=eq.whatsapp&conversation_id=eq.%s&external_id=in.%s&select=%s",
+		pgValue(conversationID), pgList(ids), supabaseMessageColumnsSelect)
+	if softDeletes != nil {
+		endpoint += "&trashed_at=is.null"
+	}
+	resp, err := s.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+
+	var rows []supabaseMessageRow
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse message response: %v", err)
+	}
+
+	for _, row := range rows {
+		messages[row.ExternalID] = row.message(chatJID)
+	}
+	return messages, nil
+}
+
+// hydrateQuoted fills in the quoted message of each reply with a single
+// lookup for the whole page. Quotes of messages the store does not hold are
+// left unset.
+func hydrateQuoted(messageStore MessageStoreInterface, chatJID string, messages []Message) error {
+	store, ok := messageStore.(MessageLookupStore)
+	if !ok {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, msg := range messages {
+		if msg.QuotedID != "" && !seen[msg.QuotedID] {
+			seen[msg.QuotedID] = true
+			ids = append(ids, msg.QuotedID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	quoted, err := store.MessagesByID(chatJID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if q, ok := quoted[messages[i].QuotedID]; ok {
+			messages[i].Quoted = &q
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ReactionRecord is one sender's current reaction to a message. An empty
+// emoji means the reaction was removed.
+type ReactionRecord struct {
+	MessageID string
+	ChatJID   string
+	Sender    string
+	Emoji     string
+	IsFromMe  bool
+	Timestamp time.Time
+}
+
+// ReactionSummary is the number of reactions with one emoji
+type ReactionSummary struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	// Reacted is set when the logged in account is among the reactors
+	Reacted bool `json:"reacted"`
+}
+
+// ReactionStore is implemented by message stores that keep reactions
+type ReactionStore interface {
+	StoreReaction(reaction ReactionRecord) error
+	ReactionSummaries(chatJID string, ids []string) (map[string][]ReactionSummary, error)
+}
+
+// StoreReaction saves a sender's reaction, replacing their earlier one.
+// Reactions can arrive out of order, so an older one never overwrites a
+// newer one and removals are kept as empty rows.
+func (store *MessageStore) StoreReaction(reaction ReactionRecord) error {
+	_, err := store.db.Exec(
+		`INSERT INTO reactions (message_id, chat_jid, sender, emoji, is_from_me, timestamp) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(message_id, chat_jid, sender) DO UPDATE SET emoji = excluded.emoji, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= reactions.timestamp`,
+		reaction.MessageID, reaction.ChatJID, reaction.Sender, reaction.Emoji, reaction.IsFromMe, reaction.Timestamp,
+	)
+	return err
+}
+
+// ReactionSummaries counts the reactions to the given messages of a chat
+// per emoji, most used first
+func (store *MessageStore) ReactionSummaries(chatJID string, ids []string) (map[string][]ReactionSummary, error) {
+	args := []interface{}{chatJID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := store.db.Query(
+		`SELECT message_id, emoji, COUNT(*), MAX(is_from_me) FROM reactions
+		WHERE chat_jid = ? AND emoji != '' AND message_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+`)
+		GROUP BY message_id, emoji ORDER BY COUNT(*) DESC, emoji`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make(map[string][]ReactionSummary)
+	for rows.Next() {
+		var messageID string
+		var summary ReactionSummary
+		if err := rows.Scan(&messageID, &summary.Emoji, &summary.Count, &summary.Reacted); err != nil {
+			return nil, err
+		}
+		summaries[messageID] = append(summaries[messageID], summary)
+	}
+	return summaries, rows.Err()
+}
+
+// supabaseReactionConflict is the unique key of a reactions row. The
+// Supabase table is created with e.g.
+//
+//	CREATE TABLE reactions (
+//		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+//		message_id text NOT NULL,
+//		chat_jid text NOT NULL,
+//		channel text NOT NULL DEFAULT 'whatsapp',
+//		sender text NOT NULL,
+//		emoji text NOT NULL,
+//		is_from_me boolean NOT NULL DEFAULT false,
+//		reacted_at timestamptz NOT NULL,
+//		UNIQUE (message_id, channel, sender)
+//	);
+const supabaseReactionConflict = "message_id,channel,sender"
+
+// supabaseReaction is a reactions row
+type supabaseReaction struct {
+	MessageID string    `json:"message_id"`
+	ChatJID   string    `json:"chat_jid"`
+	Channel   string    `json:"channel"`
+	Sender    string    `json:"sender"`
+	Emoji     string    `json:"emoji"`
+	IsFromMe  bool      `json:"is_from_me"`
+	ReactedAt time.Time `json:"reacted_at"`
+}
+
+// StoreReaction upserts a sender's reaction in Supabase unless a newer one
+// is already stored. Removals are kept as rows with an empty emoji.
+func (s *SupabaseMessageStore) StoreReaction(reaction ReactionRecord) error {
+	endpoint := fmt.Sprintf("reactions?message_id=eq.%s&channel=eq.whatsapp&sender=eq.%s&select=reacted_at",
+		pgValue(reaction.MessageID), pgValue(reaction.Sender))
+	resp, err := s.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to query reaction: %v", err)
+	}
+
+	var existing []struct {
+		ReactedAt time.Time `json:"reacted_at"`
+	}
+	if err := json.Unmarshal(resp, &existing); err != nil {
+		return fmt.Errorf("failed to parse reaction response: %v", err)
+	}
+	if len(existing) > 0 && existing[0].ReactedAt.After(reaction.Timestamp) {
+		return nil
+	}
+
+	row := supabaseReaction{
+		MessageID: reaction.MessageID,
+		ChatJID:   reaction.ChatJID,
+		Channel:   "whatsapp",
+		Sender:    reaction.Sender,
+		Emoji:     reaction.Emoji,
+		IsFromMe:  reaction.IsFromMe,
+		ReactedAt: reaction.Timestamp.UTC(),
+	}
+	if _, err := s.client.makeUpsertRequest("reactions", supabaseReactionConflict, row); err != nil {
+		return fmt.Errorf("failed to store reaction: %v", err)
+	}
+	return nil
+}
+
+// ReactionSummaries counts the reactions stored in Supabase for the given
+// messages of a chat per emoji, most used first
+func (s *SupabaseMessageStore) ReactionSummaries(chatJID string, ids []string) (map[string][]ReactionSummary, error) {
+	if err := validateJID(chatJID); err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("reactions?channel=eq.whatsapp&chat_jid=eq.%s&message_id=in.%s&emoji=neq.&select=message_id,emoji,is_from_me",
+		pgValue(chatJID), pgList(ids))
+	resp, err := s.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reactions: %v", err)
+	}
+
+	var rows []supabaseReaction
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse reactions: %v", err)
+	}
+
+	counts := make(map[string]map[string]*ReactionSummary)
+	for _, row := range rows {
+		byEmoji, ok := counts[row.MessageID]
+		if !ok {
+			byEmoji = make(map[string]*ReactionSummary)
+			counts[row.MessageID] = byEmoji
+		}
+		summary, ok := byEmoji[row.Emoji]
+		if !ok {
+			summary = &ReactionSummary{Emoji: row.Emoji}
+			byEmoji[row.Emoji] = summary
+		}
+		summary.Count++
+		summary.Reacted = summary.Reacted || row.IsFromMe
+	}
+
+	summaries := make(map[string][]ReactionSummary)
+	for messageID, byEmoji := range counts {
+		list := make([]ReactionSummary, 0, len(byEmoji))
+		for _, summary := range byEmoji {
+			list = append(list, *summary)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Emoji < list[j].Emoji
+		})
+		summaries[messageID] = list
+	}
+	return summaries, nil
+}
+
+// handleReaction stores reaction messages when the store keeps reactions
+func handleReaction(messageStore MessageStoreInterface, msg *events.Message, logger waLog.Logger) {
+	reaction := msg.Message.GetReactionMessage()
+	store, ok := messageStore.(ReactionStore)
+	if reaction == nil || !ok {
+		return
+	}
+
+	timestamp := msg.Info.Timestamp
+	if ms := reaction.GetSenderTimestampMS(); ms != 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	err := store.StoreReaction(ReactionRecord{
+		MessageID: reaction.GetKey().GetID(),
+		ChatJID:   msg.Info.Chat.String(),
+		Sender:    msg.Info.Sender.User,
+		Emoji:     reaction.GetText(),
+		IsFromMe:  msg.Info.IsFromMe,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		logger.Warnf("Failed to store reaction: %v", err)
+	}
+}
+
+// attachReactions fills in the reactions of a page of messages with a
+// single query
+func attachReactions(messageStore MessageStoreInterface, chatJID string, messages []Message) error {
+	store, ok := messageStore.(ReactionStore)
+	if !ok || len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+
+	summaries, err := store.ReactionSummaries(chatJID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = summaries[messages[i].ID]
+	}
+	return nil
+}
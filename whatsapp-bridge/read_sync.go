@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ReadStateStore is implemented by message stores that track which inbound
+// messages have been read
+type ReadStateStore interface {
+	// MarkChatRead marks the inbound messages of a chat received up to a
+	// time as read
+	MarkChatRead(chatJID string, upTo time.Time) error
+}
+
+// MarkChatRead sets is_read on the chat's inbound messages up to a time
+func (store *MessageStore) MarkChatRead(chatJID string, upTo time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET is_read = 1 WHERE chat_jid = ? AND is_from_me = 0 AND is_read = 0 AND julianday(timestamp) <= julianday(?)",
+		chatJID, upTo,
+	)
+	return err
+}
+
+// MarkChatRead marks the conversation's inbound messages stored up to a time
+// as read in Supabase, then sets unread_count to what is left unread
+func (s *SupabaseMessageStore) MarkChatRead(chatJID string, upTo time.Time) error {
+	conversationID, ok := s.cachedConversationID(chatJID)
+	if !ok {
+		var err error
+		conversationID, err = s.client.GetOrCreateConversation(chatJID, "")
+		if err != nil {
+			return err
+		}
+		s.cacheConversationID(chatJID, conversationID)
+	}
+	return s.client.MarkConversationRead(conversationID, upTo)
+}
+
+// MarkConversationRead sets is_read on inbound messages created up to a time
+// and recounts the conversation's unread messages
+func (s *SupabaseClient) MarkConversationRead(conversationID string, upTo time.Time) error {
+	endpoint := fmt.Sprintf(
+		"messages?conversation_id=eq.%s&direction=eq.inbound&is_read=eq.false&created_at=lte.%s",
+		pgValue(conversationID), upTo.UTC().Format(time.RFC3339),
+	)
+	if _, err := s.makeRequest("PATCH", endpoint, map[string]interface{}{"is_read": true}); err != nil {
+		return fmt.Errorf("failed to mark messages read: %v", err)
+	}
+
+	endpoint = fmt.Sprintf("messages?conversation_id=eq.%s&direction=eq.inbound&is_read=eq.false&select=id", pgValue(conversationID))
+	resp, err := s.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to count unread messages: %v", err)
+	}
+	var unread []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &unread); err != nil {
+		return fmt.Errorf("failed to parse unread messages: %v", err)
+	}
+
+	return s.patchConversation(fmt.Sprintf("id=eq.%s", pgValue(conversationID)), func(map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"unread_count": len(unread)}
+	})
+}
+
+// IncrementUnreadCount adds a newly stored inbound message to the unread
+// count of a conversation. Without versioned updates it calls the
+// increment_unread_count function of migration 0006, which adds one in a
+// single statement; databases without it have the count read and written
+// back, which races with MarkConversationRead.
+func (s *SupabaseClient) IncrementUnreadCount(conversationID string) error {
+	filter := fmt.Sprintf("id=eq.%s", pgValue(conversationID))
+	increment := func(current map[string]interface{}) map[string]interface{} {
+		count := int64(0)
+		if n, ok := current["unread_count"].(json.Number); ok {
+			count, _ = n.Int64()
+		}
+		return map[string]interface{}{"unread_count": count + 1}
+	}
+	if s.versions != nil {
+		return s.patchConversation(filter, increment)
+	}
+
+	_, err := s.makePreferRequest("POST", "rest/v1/rpc/increment_unread_count", "return=minimal",
+		map[string]interface{}{"conversation": conversationID})
+	if apiErr, ok := err.(*SupabaseAPIError); !ok || apiErr.StatusCode != http.StatusNotFound {
+		return err
+	}
+
+	resp, err := s.makeRequest("GET", "conversations?"+filter+"&select=unread_count", nil)
+	if err != nil {
+		return fmt.Errorf("failed to read unread count: %v", err)
+	}
+	body, err := decodeJSONNumbers(resp)
+	if err != nil {
+		return fmt.Errorf("failed to parse unread count: %v", err)
+	}
+	rows, _ := body.([]interface{})
+	if len(rows) == 0 {
+		return nil
+	}
+	current, _ := rows[0].(map[string]interface{})
+	_, err = s.makeRequest("PATCH", "conversations?"+filter, increment(current))
+	return err
+}
+
+// ReadStateSync copies chats the owner read on the phone to the message
+// store and the inbox. Read receipts arrive in bursts, one per message or
+// batch of messages, so they are collected per chat and flushed together.
+type ReadStateSync struct {
+	store    ReadStateStore
+	interval time.Duration
+	logger   waLog.Logger
+
+	mutex   sync.Mutex
+	pending map[string]time.Time
+}
+
+// readStateSync is the active read state sync, nil when disabled
+var readStateSync *ReadStateSync
+
+// NewReadStateSync starts flushing read state every READ_SYNC_INTERVAL. It
+// returns nil when READ_SYNC is false.
+func NewReadStateSync(messageStore MessageStoreInterface, logger waLog.Logger) *ReadStateSync {
+	if !envBool("READ_SYNC", true) {
+		return nil
+	}
+
+	// Without store support only the inbox is updated
+	store, _ := messageStore.(ReadStateStore)
+
+	r := &ReadStateSync{
+		store:    store,
+		interval: envDuration("READ_SYNC_INTERVAL", 2*time.Second),
+		logger:   logger,
+		pending:  make(map[string]time.Time),
+	}
+	go r.run()
+	return r
+}
+
+// markRead queues a chat as read up to a time
+func (r *ReadStateSync) markRead(chatJID string, upTo time.Time) {
+	if upTo.IsZero() {
+		upTo = time.Now()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if current, ok := r.pending[chatJID]; !ok || upTo.After(current) {
+		r.pending[chatJID] = upTo
+	}
+}
+
+// MarkRead queues a chat marked as read through the API
+func (r *ReadStateSync) MarkRead(chatJID string) {
+	if r == nil {
+		return
+	}
+	r.markRead(chatJID, time.Now())
+}
+
+// HandleReceipt queues read receipts the owner's own devices send for
+// messages they opened
+func (r *ReadStateSync) HandleReceipt(receipt *events.Receipt) {
+	if r == nil || !receipt.IsFromMe {
+		return
+	}
+	if receipt.Type != types.ReceiptTypeRead && receipt.Type != types.ReceiptTypeReadSelf {
+		return
+	}
+	r.markRead(receipt.Chat.String(), receipt.Timestamp)
+}
+
+// HandleMarkChatAsRead queues chats marked as read from another device
+func (r *ReadStateSync) HandleMarkChatAsRead(evt *events.MarkChatAsRead) {
+	if r == nil || !evt.Action.GetRead() {
+		return
+	}
+	r.markRead(evt.JID.String(), evt.Timestamp)
+}
+
+// run flushes queued chats on every interval
+func (r *ReadStateSync) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.flush()
+	}
+}
+
+// flush writes the queued read state
+func (r *ReadStateSync) flush() {
+	r.mutex.Lock()
+	pending := r.pending
+	r.pending = make(map[string]time.Time)
+	r.mutex.Unlock()
+
+	for chatJID, upTo := range pending {
+		if r.store != nil {
+			if err := r.store.MarkChatRead(chatJID, upTo); err != nil {
+				r.logger.Warnf("Failed to sync read state of %s: %v", chatJID, err)
+			}
+		}
+		if inboxProjection != nil {
+			if err := inboxProjection.MarkRead(chatJID); err != nil {
+				r.logger.Warnf("Failed to mark %s read in the inbox: %v", chatJID, err)
+			}
+		}
+	}
+}
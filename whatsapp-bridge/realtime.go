@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RealtimeBroadcastMessage is a single message for the Realtime broadcast API
+type RealtimeBroadcastMessage struct {
+	Topic   string      `json:"topic"`
+	Event   string      `json:"event"`
+	Payload interface{} `json:"payload"`
+	Private bool        `json:"private,omitempty"`
+}
+
+// NewMessageNotification is the lightweight payload broadcast when a message
+// is stored. Subscribers fetch the full row from the messages table if needed.
+type NewMessageNotification struct {
+	ConversationID string    `json:"conversation_id"`
+	ChatJID        string    `json:"chat_jid"`
+	ExternalID     string    `json:"external_id"`
+	Sender         string    `json:"sender"`
+	Direction      string    `json:"direction"`
+	MediaType      string    `json:"media_type,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// Broadcast publishes messages on Supabase Realtime broadcast channels
+func (s *SupabaseClient) Broadcast(messages ...RealtimeBroadcastMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"messages": messages,
+	}
+
+	if _, err := s.makeServiceRequest("POST", "realtime/v1/api/broadcast", body); err != nil {
+		return fmt.Errorf("failed to broadcast: %v", err)
+	}
+	return nil
+}
+
+// broadcastNewMessage notifies the conversation channel about a stored message
+func (s *SupabaseMessageStore) broadcastNewMessage(conversationID, chatJID, externalID, sender string,
+	timestamp time.Time, isFromMe bool, mediaType string) {
+	direction := "inbound"
+	if isFromMe {
+		direction = "outbound"
+	}
+
+	err := s.client.Broadcast(RealtimeBroadcastMessage{
+		Topic: s.realtimeTopicPrefix + conversationID,
+		Event: "new_message",
+		Payload: NewMessageNotification{
+			ConversationID: conversationID,
+			ChatJID:        chatJID,
+			ExternalID:     externalID,
+			Sender:         sender,
+			Direction:      direction,
+			MediaType:      mediaType,
+			Timestamp:      timestamp,
+		},
+		Private: s.realtimePrivate,
+	})
+	if err != nil {
+		fmt.Printf("Realtime broadcast for message %s failed: %v\n", externalID, err)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Statuses of outbound rows inserted into the messages table by other
+// applications. Rows with status pending are sent by the bridge; held rows
+// wait in the outbox for quiet hours or approval.
+const (
+	OutboundStatusPending = "pending"
+	OutboundStatusSending = "sending"
+	OutboundStatusHeld    = "held"
+	OutboundStatusSent    = "sent"
+	OutboundStatusFailed  = "failed"
+)
+
+// realtimeAPIKey stands for the application inserting rows, so
+// APPROVAL_REQUIRED_KEYS=realtime holds its sends for approval
+var realtimeAPIKey = &APIKey{Name: "realtime"}
+
+// realtimeHeartbeatInterval keeps the Realtime socket alive, the server
+// drops connections silent for longer than a minute
+const realtimeHeartbeatInterval = 25 * time.Second
+
+// phoenixMessage is a frame of the Phoenix channel protocol spoken by
+// Supabase Realtime
+type phoenixMessage struct {
+	Topic   string          `json:"topic"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	Ref     string          `json:"ref,omitempty"`
+}
+
+// postgresChangesPayload is the payload of a postgres_changes frame
+type postgresChangesPayload struct {
+	Data struct {
+		Type   string      `json:"type"`
+		Table  string      `json:"table"`
+		Record outboundRow `json:"record"`
+	} `json:"data"`
+}
+
+// outboundRow is the part of a messages row needed to send it
+type outboundRow struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Channel        string                 `json:"channel"`
+	Direction      string                 `json:"direction"`
+	Recipient      string                 `json:"recipient"`
+	Body           *string                `json:"body"`
+	ExternalID     *string                `json:"external_id"`
+	Status         *string                `json:"status"`
+	Metadata       map[string]interface{} `json:"metadata"`
+}
+
+// RealtimeOutbound sends messages that other applications, such as a web
+// dashboard, insert into the messages table. It watches the table over a
+// Supabase Realtime websocket for outbound rows with status pending, sends
+// them through WhatsApp and writes back the WhatsApp message ID and status.
+// Rows are claimed by moving them to sending first, so a row is sent once
+// even when several bridges watch the same table.
+type RealtimeOutbound struct {
+	supabase       *SupabaseClient
+	client         *whatsmeow.Client
+	reconnectDelay time.Duration
+	rows           chan outboundRow
+	ref            atomic.Int64
+	logger         waLog.Logger
+}
+
+// realtimeOutbound is the active Realtime sender, nil when disabled
+var realtimeOutbound *RealtimeOutbound
+
+// NewRealtimeOutbound starts watching the messages table. It returns nil
+// unless SUPABASE_REALTIME_OUTBOUND is true and messages are stored in
+// Supabase.
+func NewRealtimeOutbound(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) *RealtimeOutbound {
+	if !envBool("SUPABASE_REALTIME_OUTBOUND", false) {
+		return nil
+	}
+
+	store, ok := supabaseStoreOf(messageStore)
+	if !ok {
+		logger.Warnf("Realtime outbound messages need the Supabase message store, disabled")
+		return nil
+	}
+
+	r := &RealtimeOutbound{
+		supabase:       store.client,
+		client:         client,
+		reconnectDelay: envDuration("SUPABASE_REALTIME_RECONNECT_DELAY", 5*time.Second),
+		rows:           make(chan outboundRow, 100),
+		logger:         logger,
+	}
+	go r.run()
+	go r.sendRows()
+	return r
+}
+
+// websocketURL builds the Realtime websocket address from SUPABASE_URL
+func (r *RealtimeOutbound) websocketURL() string {
+	base := strings.TrimSuffix(r.supabase.URL, "/")
+	base = strings.Replace(base, "https://", "wss://", 1)
+	base = strings.Replace(base, "http://", "ws://", 1)
+	return fmt.Sprintf("%s/realtime/v1/websocket?apikey=%s&vsn=1.0.0", base, url.QueryEscape(r.supabase.Key))
+}
+
+// nextRef returns a new Phoenix message reference
+func (r *RealtimeOutbound) nextRef() string {
+	return strconv.FormatInt(r.ref.Add(1), 10)
+}
+
+// run keeps a subscription open, reconnecting after failures
+func (r *RealtimeOutbound) run() {
+	r.requeueInterrupted()
+	for {
+		if err := r.subscribe(); err != nil {
+			r.logger.Warnf("Realtime outbound subscription failed: %v", err)
+		}
+		time.Sleep(r.reconnectDelay)
+	}
+}
+
+// subscribe joins the messages table channel and queues pending rows until
+// the connection drops
+func (r *RealtimeOutbound) subscribe() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, r.websocketURL(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(1 << 20)
+
+	accessToken, err := r.supabase.accessToken()
+	if err != nil {
+		return err
+	}
+
+	const topic = "realtime:whatsapp-outbound"
+	change := func(event string) map[string]string {
+		return map[string]string{"event": event, "schema": r.supabase.tables.schemaName(), "table": r.supabase.tables.name("messages"), "filter": "status=eq." + OutboundStatusPending}
+	}
+	join, _ := json.Marshal(map[string]interface{}{
+		"config": map[string]interface{}{
+			"postgres_changes": []map[string]string{change("INSERT"), change("UPDATE")},
+		},
+		"access_token": accessToken,
+	})
+	joinRef := r.nextRef()
+	if err := wsjson.Write(ctx, conn, phoenixMessage{Topic: topic, Event: "phx_join", Payload: join, Ref: joinRef}); err != nil {
+		return fmt.Errorf("failed to join channel: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(realtimeHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				heartbeat := phoenixMessage{Topic: "phoenix", Event: "heartbeat", Payload: json.RawMessage("{}"), Ref: r.nextRef()}
+				if err := wsjson.Write(ctx, conn, heartbeat); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var msg phoenixMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			return fmt.Errorf("connection lost: %v", err)
+		}
+
+		switch msg.Event {
+		case "phx_reply":
+			if msg.Ref != joinRef {
+				continue
+			}
+			var reply struct {
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal(msg.Payload, &reply); err != nil || reply.Status != "ok" {
+				return fmt.Errorf("channel join rejected: %s", string(msg.Payload))
+			}
+			r.logger.Infof("Watching Supabase for outbound messages")
+			// Rows inserted while the bridge was not subscribed
+			go r.queuePending()
+
+		case "system":
+			var system struct {
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			}
+			if json.Unmarshal(msg.Payload, &system) == nil && system.Status == "error" {
+				return fmt.Errorf("subscription error: %s", system.Message)
+			}
+
+		case "postgres_changes":
+			var change postgresChangesPayload
+			if err := json.Unmarshal(msg.Payload, &change); err != nil {
+				r.logger.Warnf("Failed to decode Realtime change: %v", err)
+				continue
+			}
+			r.rows <- change.Data.Record
+
+		case "phx_error", "phx_close":
+			return fmt.Errorf("channel closed by server (%s)", msg.Event)
+		}
+	}
+}
+
+// queuePending queues the pending outbound rows already in the table
+func (r *RealtimeOutbound) queuePending() {
+	endpoint := fmt.Sprintf("messages?status=eq.%s&direction=eq.outbound&channel=eq.whatsapp&external_id=is.null&order=created_at.asc", OutboundStatusPending)
+	resp, err := r.supabase.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		r.logger.Warnf("Failed to list pending outbound messages: %v", err)
+		return
+	}
+
+	var rows []outboundRow
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		r.logger.Warnf("Failed to parse pending outbound messages: %v", err)
+		return
+	}
+	for _, row := range rows {
+		r.rows <- row
+	}
+}
+
+// requeueInterrupted moves rows left sending by a restart back to pending.
+// A row this bridge already sent is not sent again: its idempotency key
+// finds the earlier message, whose ID is then written to the row.
+func (r *RealtimeOutbound) requeueInterrupted() {
+	endpoint := fmt.Sprintf("messages?status=eq.%s&direction=eq.outbound&channel=eq.whatsapp&external_id=is.null", OutboundStatusSending)
+	resp, err := r.supabase.makeRequest("PATCH", endpoint, map[string]interface{}{"status": OutboundStatusPending})
+	if err != nil {
+		r.logger.Warnf("Failed to requeue interrupted outbound messages: %v", err)
+		return
+	}
+
+	var requeued []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &requeued) == nil && len(requeued) > 0 {
+		r.logger.Infof("Requeued %d outbound messages interrupted by a restart", len(requeued))
+	}
+}
+
+// sendRows sends queued rows one at a time, in the order they arrived
+func (r *RealtimeOutbound) sendRows() {
+	for row := range r.rows {
+		if row.Direction != "outbound" || row.Channel != "whatsapp" || row.ExternalID != nil {
+			continue
+		}
+
+		// Rows stay pending while WhatsApp is unreachable
+		for !r.client.IsConnected() {
+			time.Sleep(time.Second)
+		}
+
+		if err := r.send(row); err != nil {
+			r.logger.Warnf("Failed to send outbound message %s: %v", row.ID, err)
+		}
+	}
+}
+
+// claim moves a row from pending to sending, reporting false when another
+// bridge or an earlier event got to it first
+func (r *RealtimeOutbound) claim(row outboundRow) (bool, error) {
+	endpoint := fmt.Sprintf("messages?id=eq.%s&status=eq.%s", pgValue(row.ID), OutboundStatusPending)
+	resp, err := r.supabase.makeRequest("PATCH", endpoint, map[string]interface{}{"status": OutboundStatusSending})
+	if err != nil {
+		return false, err
+	}
+
+	var claimed []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &claimed); err != nil {
+		return false, fmt.Errorf("failed to parse claim response: %v", err)
+	}
+	return len(claimed) > 0, nil
+}
+
+// recipient returns the row's recipient, or the JID of its conversation
+func (r *RealtimeOutbound) recipient(row outboundRow) (string, error) {
+	if row.Recipient != "" {
+		return row.Recipient, nil
+	}
+
+	resp, err := r.supabase.makeRequest("GET", fmt.Sprintf("conversations?id=eq.%s&select=contact_identifier", pgValue(row.ConversationID)), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to query conversation: %v", err)
+	}
+	var conversations []struct {
+		ContactIdentifier string `json:"contact_identifier"`
+	}
+	if err := json.Unmarshal(resp, &conversations); err != nil {
+		return "", fmt.Errorf("failed to parse conversation response: %v", err)
+	}
+	if len(conversations) == 0 {
+		return "", fmt.Errorf("conversation %s not found", row.ConversationID)
+	}
+	return conversations[0].ContactIdentifier, nil
+}
+
+// send claims a row and sends it through the checks of /api/send, so it
+// may be held for quiet hours or approval like any other message
+func (r *RealtimeOutbound) send(row outboundRow) error {
+	claimed, err := r.claim(row)
+	if err != nil {
+		return fmt.Errorf("failed to claim message: %v", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	recipient, err := r.recipient(row)
+	if err != nil {
+		return r.fail(row, err.Error(), SendFailureInvalidRecipient)
+	}
+
+	req := SendMessageRequest{Recipient: recipient}
+	if row.Body != nil {
+		req.Message = *row.Body
+	}
+	if mediaPath, ok := row.Metadata["media_path"].(string); ok && mediaPath != "" {
+		// Anyone who can insert rows picks the file, so it has to come from
+		// the upload directory
+		if envString("MEDIA_UPLOAD_DIR", "") == "" {
+			return r.fail(row, "media_path needs MEDIA_UPLOAD_DIR to be set", SendFailureInvalid)
+		}
+		req.MediaPath = mediaPath
+	}
+
+	outbound, validationErrors := composeMessage(r.client, req)
+	if len(validationErrors) > 0 {
+		return r.fail(row, validationErrors[0].Message, validationFailureReason(validationErrors[0].Code))
+	}
+	outbound.RowID = row.ID
+
+	urgent, _ := row.Metadata["urgent"].(bool)
+	status, response := outboundSender.Dispatch(outbound, SendOptions{
+		IdempotencyKey: "realtime:" + row.ID,
+		Urgent:         urgent,
+		Key:            realtimeAPIKey,
+	})
+	switch {
+	case !response.Success:
+		reason := response.FailureReason
+		if reason == "" && len(response.Errors) > 0 {
+			reason = validationFailureReason(response.Errors[0].Code)
+		}
+		if reason == "" {
+			reason = SendFailureUnknown
+		}
+		return r.fail(row, response.Message, reason)
+	case status == http.StatusAccepted:
+		return r.setStatus(row.ID, OutboundStatusHeld)
+	default:
+		return r.markSent(row.ID, response.MessageID)
+	}
+}
+
+// markSent writes the WhatsApp message ID of a sent row
+func (r *RealtimeOutbound) markSent(rowID, messageID string) error {
+	update := map[string]interface{}{
+		"external_id": messageID,
+		"status":      OutboundStatusSent,
+	}
+	if r.client.Store.ID != nil {
+		update["sender"] = r.client.Store.ID.User
+	}
+	if _, err := r.supabase.makeRequest("PATCH", fmt.Sprintf("messages?id=eq.%s", pgValue(rowID)), update); err != nil {
+		return fmt.Errorf("sent as %s but failed to update the row: %v", messageID, err)
+	}
+	return nil
+}
+
+// setStatus changes the status of a row
+func (r *RealtimeOutbound) setStatus(rowID, status string) error {
+	if _, err := r.supabase.makeRequest("PATCH", fmt.Sprintf("messages?id=eq.%s", pgValue(rowID)), map[string]interface{}{"status": status}); err != nil {
+		return fmt.Errorf("failed to mark message %s: %v", status, err)
+	}
+	return nil
+}
+
+// Settle updates the row of a send the outbox held once it is sent, with
+// messageID, or dropped or failed, with the error and failure reason. It
+// does nothing for messages that did not come from a row.
+func (r *RealtimeOutbound) Settle(rowID, messageID, sendError, failureReason string) {
+	if r == nil || rowID == "" {
+		return
+	}
+
+	var err error
+	if messageID != "" {
+		err = r.markSent(rowID, messageID)
+	} else {
+		row := outboundRow{ID: rowID}
+		resp, getErr := r.supabase.makeRequest("GET", fmt.Sprintf("messages?id=eq.%s&select=metadata", pgValue(rowID)), nil)
+		if getErr != nil {
+			r.logger.Warnf("Failed to read outbound message %s: %v", rowID, getErr)
+			return
+		}
+		var rows []outboundRow
+		if json.Unmarshal(resp, &rows) == nil && len(rows) > 0 {
+			row.Metadata = rows[0].Metadata
+		}
+		err = r.fail(row, sendError, failureReason)
+	}
+	if err != nil {
+		r.logger.Warnf("Outbound message %s: %v", rowID, err)
+	}
+}
+
+// fail marks a claimed row as failed with the error and the failure
+// reason in its metadata
+func (r *RealtimeOutbound) fail(row outboundRow, reason, failureReason string) error {
+	metadata := row.Metadata
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata["send_error"] = reason
+	metadata["send_failure_reason"] = failureReason
+
+	update := map[string]interface{}{
+		"status":   OutboundStatusFailed,
+		"metadata": getMetadataPolicy().Apply(metadata),
+	}
+	if _, err := r.supabase.makeRequest("PATCH", fmt.Sprintf("messages?id=eq.%s", pgValue(row.ID)), update); err != nil {
+		return fmt.Errorf("failed to mark message failed (%s): %v", reason, err)
+	}
+	return fmt.Errorf("%s", reason)
+}
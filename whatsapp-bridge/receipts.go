@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/eventschema"
+)
+
+// Receipt statuses of messages sent through the API
+const (
+	// SendStatusHeld marks messages waiting in the outbox
+	SendStatusHeld = "held"
+	// SendStatusPendingApproval marks held messages waiting for approval
+	SendStatusPendingApproval = "pending_approval"
+	// SendStatusRejected and SendStatusExpired mark held messages that were
+	// rejected, or not approved in time, and will not be sent
+	SendStatusRejected = "rejected"
+	SendStatusExpired  = "expired"
+	// SendStatusCancelled marks held messages an operator cancelled
+	SendStatusCancelled = "cancelled"
+	// SendStatusFailed marks messages WhatsApp did not accept, with a
+	// failure reason
+	SendStatusFailed    = "failed"
+	SendStatusSent      = eventschema.StatusSent
+	SendStatusDelivered = eventschema.StatusDelivered
+	SendStatusRead      = eventschema.StatusRead
+)
+
+// sendStatusRank orders statuses so receipts only ever move a message forward
+var sendStatusRank = map[string]int{
+	SendStatusHeld:            0,
+	SendStatusPendingApproval: 0,
+	SendStatusRejected:        0,
+	SendStatusExpired:         0,
+	SendStatusCancelled:       0,
+	SendStatusFailed:          0,
+	SendStatusSent:            1,
+	SendStatusDelivered:       2,
+	SendStatusRead:            3,
+}
+
+// SentMessage is a message sent through the API and its delivery status
+type SentMessage struct {
+	MessageID      string `json:"message_id"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	ChatJID        string `json:"chat_jid"`
+	Status         string `json:"status"`
+	// FailureReason is why a failed send failed
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SendTracker records messages sent through the API by idempotency key and
+// turns WhatsApp receipts for them into message.delivered and message.read
+// events, so the system that requested the send can confirm it arrived
+type SendTracker struct {
+	db     *sql.DB
+	logger waLog.Logger
+}
+
+// sendTracker is the active send tracker
+var sendTracker *SendTracker
+
+// NewSendTracker creates the sent_messages table in the bridge database
+func NewSendTracker(db *sql.DB, logger waLog.Logger) (*SendTracker, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS sent_messages (
+			message_id TEXT PRIMARY KEY,
+			idempotency_key TEXT,
+			chat_jid TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_sent_messages_key ON sent_messages(idempotency_key) WHERE idempotency_key IS NOT NULL;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sent messages table: %v", err)
+	}
+	if err := ensureColumn(db, "sent_messages", "failure_reason", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, fmt.Errorf("failed to migrate sent messages table: %v", err)
+	}
+
+	return &SendTracker{db: db, logger: logger}, nil
+}
+
+// Lookup returns the message sent earlier with an idempotency key, or nil
+func (t *SendTracker) Lookup(idempotencyKey string) (*SentMessage, error) {
+	var msg SentMessage
+	var key sql.NullString
+	err := t.db.QueryRow(
+		"SELECT message_id, idempotency_key, chat_jid, status, failure_reason, created_at, updated_at FROM sent_messages WHERE idempotency_key = ?",
+		idempotencyKey,
+	).Scan(&msg.MessageID, &key, &msg.ChatJID, &msg.Status, &msg.FailureReason, &msg.CreatedAt, &msg.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.IdempotencyKey = key.String
+	return &msg, nil
+}
+
+// Tracks reports whether a message was sent, or held, through the API
+func (t *SendTracker) Tracks(messageID string) bool {
+	var one int
+	err := t.db.QueryRow("SELECT 1 FROM sent_messages WHERE message_id = ?", messageID).Scan(&one)
+	return err == nil
+}
+
+// Record stores a message before it is sent or held, so receipts arriving
+// right after the send always find it. It fails if the idempotency key is
+// taken.
+func (t *SendTracker) Record(messageID, idempotencyKey, chatJID, status string) error {
+	var key interface{}
+	if idempotencyKey != "" {
+		key = idempotencyKey
+	}
+
+	now := time.Now().UTC()
+	_, err := t.db.Exec(
+		"INSERT INTO sent_messages (message_id, idempotency_key, chat_jid, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		messageID, key, chatJID, status, now, now,
+	)
+	return err
+}
+
+// updateHeld changes the status of a message that is still held
+func (t *SendTracker) updateHeld(messageID, status string) error {
+	_, err := t.db.Exec(
+		"UPDATE sent_messages SET status = ?, updated_at = ? WHERE message_id = ? AND status IN (?, ?)",
+		status, time.Now().UTC(), messageID, SendStatusHeld, SendStatusPendingApproval,
+	)
+	return err
+}
+
+// MarkSent records that a held message has been sent
+func (t *SendTracker) MarkSent(messageID string) error {
+	return t.updateHeld(messageID, SendStatusSent)
+}
+
+// MarkRejected records that a held message was rejected, or expired, and
+// will not be sent. The idempotency key stays taken so a retry is not
+// resubmitted.
+func (t *SendTracker) MarkRejected(messageID string, expired bool) error {
+	if expired {
+		return t.updateHeld(messageID, SendStatusExpired)
+	}
+	return t.updateHeld(messageID, SendStatusRejected)
+}
+
+// MarkCancelled records that a held message was cancelled from the outbox
+// and will not be sent
+func (t *SendTracker) MarkCancelled(messageID string) error {
+	return t.updateHeld(messageID, SendStatusCancelled)
+}
+
+// MarkFailed records why WhatsApp did not accept a message and frees its
+// idempotency key for a retry
+func (t *SendTracker) MarkFailed(messageID, reason string) error {
+	_, err := t.db.Exec(
+		"UPDATE sent_messages SET status = ?, failure_reason = ?, idempotency_key = NULL, updated_at = ? WHERE message_id = ?",
+		SendStatusFailed, reason, time.Now().UTC(), messageID,
+	)
+	return err
+}
+
+// Forget removes a message that was never sent, freeing its idempotency
+// key for a retry
+func (t *SendTracker) Forget(messageID string) error {
+	_, err := t.db.Exec("DELETE FROM sent_messages WHERE message_id = ?", messageID)
+	return err
+}
+
+// HandleReceipt advances tracked messages named in a receipt and emits an
+// event for every status change
+func (t *SendTracker) HandleReceipt(receipt *events.Receipt) {
+	// Receipts from our own devices say nothing about the recipient
+	if receipt.IsFromMe {
+		return
+	}
+
+	var status string
+	switch receipt.Type {
+	case types.ReceiptTypeDelivered:
+		status = SendStatusDelivered
+	case types.ReceiptTypeRead, types.ReceiptTypePlayed:
+		status = SendStatusRead
+	default:
+		return
+	}
+
+	for _, id := range receipt.MessageIDs {
+		var current, chatJID string
+		var key sql.NullString
+		err := t.db.QueryRow(
+			"SELECT status, chat_jid, idempotency_key FROM sent_messages WHERE message_id = ?", id,
+		).Scan(&current, &chatJID, &key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			t.logger.Warnf("Failed to look up sent message %s: %v", id, err)
+			continue
+		}
+
+		// In groups every member sends receipts; report the first only
+		if sendStatusRank[status] <= sendStatusRank[current] {
+			continue
+		}
+
+		_, err = t.db.Exec(
+			"UPDATE sent_messages SET status = ?, updated_at = ? WHERE message_id = ?",
+			status, time.Now().UTC(), id,
+		)
+		if err != nil {
+			t.logger.Warnf("Failed to update status of sent message %s: %v", id, err)
+			continue
+		}
+
+		eventType := EventMessageDelivered
+		if status == SendStatusRead {
+			eventType = EventMessageRead
+		}
+		emitEvent(eventType, chatJID, ReceiptEventPayload{
+			MessageID:      id,
+			ChatJID:        chatJID,
+			Status:         status,
+			Timestamp:      receipt.Timestamp,
+			IdempotencyKey: key.String,
+		})
+	}
+}
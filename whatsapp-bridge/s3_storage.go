@@ -0,0 +1,415 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// s3MaxPresignTTL is the longest validity SigV4 allows a presigned URL
+const s3MaxPresignTTL = 7 * 24 * time.Hour
+
+// s3UnsignedPayload lets uploads stream the file instead of hashing it
+// first. The request itself stays signed.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Client talks to AWS S3 or an S3-compatible server such as MinIO,
+// signing requests with AWS Signature Version 4. Only the few operations
+// media storage needs are implemented.
+type s3Client struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	// pathStyle addresses buckets as endpoint/bucket rather than
+	// bucket.endpoint, which MinIO and most self-hosted servers need
+	pathStyle bool
+	client    *http.Client
+}
+
+// newS3Client reads the S3_* variables. The endpoint defaults to AWS for
+// S3_REGION; credentials fall back to the standard AWS variables.
+func newS3Client() (*s3Client, error) {
+	region := envString("S3_REGION", envString("AWS_REGION", "us-east-1"))
+	rawEndpoint := envString("S3_ENDPOINT", "")
+	pathStyle := envBool("S3_FORCE_PATH_STYLE", rawEndpoint != "")
+	if rawEndpoint == "" {
+		rawEndpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(rawEndpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", rawEndpoint)
+	}
+
+	c := &s3Client{
+		endpoint:     endpoint,
+		region:       region,
+		accessKey:    envString("S3_ACCESS_KEY_ID", envString("AWS_ACCESS_KEY_ID", "")),
+		secretKey:    envString("S3_SECRET_ACCESS_KEY", envString("AWS_SECRET_ACCESS_KEY", "")),
+		sessionToken: envString("S3_SESSION_TOKEN", envString("AWS_SESSION_TOKEN", "")),
+		pathStyle:    pathStyle,
+		client:       &http.Client{Timeout: envDuration("S3_TIMEOUT", 5*time.Minute)},
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY environment variables are required")
+	}
+	return c, nil
+}
+
+// objectURL returns the unsigned URL of an object
+func (c *s3Client) objectURL(bucket, key string) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = c.endpoint.Path + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + c.endpoint.Host
+		u.Path = c.endpoint.Path + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+// s3EscapePath encodes a path the way SigV4 canonicalizes it, keeping the
+// slashes between segments
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of
+// RFC 3986, as SigV4 requires
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery returns query parameters sorted and encoded for signing
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signature signs a canonical request made at now
+func (c *s3Client) signature(now time.Time, canonicalRequest string) string {
+	date := now.Format("20060102")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		c.scope(now),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// scope is the credential scope of requests signed at now
+func (c *s3Client) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+}
+
+// sign adds the SigV4 Authorization header to a request, signing the host
+// and x-amz-* headers
+func (c *s3Client) sign(req *http.Request) {
+	now := time.Now().UTC()
+	req.Header.Set("x-amz-date", now.Format("20060102T150405Z"))
+	req.Header.Set("x-amz-content-sha256", s3UnsignedPayload)
+	if c.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, c.scope(now), signedHeaders, c.signature(now, canonicalRequest)))
+}
+
+// do sends a signed request and fails on error statuses
+func (c *s3Client) do(req *http.Request) (*http.Response, error) {
+	c.sign(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 error (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// PutObject uploads a local file, replacing any object under the key
+func (c *s3Client) PutObject(bucket, key, contentType, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.objectURL(bucket, key).String(), file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// HeadObject reports whether an object exists
+func (c *s3Client) HeadObject(bucket, key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.objectURL(bucket, key).String(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+	c.sign(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 400:
+		return false, fmt.Errorf("S3 error (status %d)", resp.StatusCode)
+	}
+	return true, nil
+}
+
+// s3PresignTTL clamps a URL validity to what SigV4 accepts
+func s3PresignTTL(ttl time.Duration) time.Duration {
+	if ttl < time.Second {
+		return time.Second
+	}
+	if ttl > s3MaxPresignTTL {
+		return s3MaxPresignTTL
+	}
+	return ttl
+}
+
+// PresignGetObject returns a URL that downloads an object without
+// credentials until ttl passes, at most seven days
+func (c *s3Client) PresignGetObject(bucket, key string, ttl time.Duration) string {
+	now := time.Now().UTC()
+	ttl = s3PresignTTL(ttl)
+
+	u := c.objectURL(bucket, key)
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", c.accessKey+"/"+c.scope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if c.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		s3CanonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	u.RawQuery = s3CanonicalQuery(query) + "&X-Amz-Signature=" + c.signature(now, canonicalRequest)
+	return u.String()
+}
+
+// S3MediaStorage copies downloaded media to an S3 or S3-compatible bucket,
+// for deployments that do not use Supabase Storage. The object key is kept
+// in the message metadata, and signed media URLs are presigned S3 URLs.
+type S3MediaStorage struct {
+	*mediaDownloader
+	s3           *s3Client
+	messageStore MessageStoreInterface
+	bucket       string
+	// prefix is prepended to object keys, to share a bucket
+	prefix string
+	// publicURL serves objects without signing, such as a CDN in front of
+	// a public bucket, empty to presign
+	publicURL string
+	urlTTL    time.Duration
+}
+
+// NewS3MediaStorage enables uploads to S3_BUCKET and registers the bucket
+// as a media URL signer. It returns nil when the bucket is not configured
+// or its credentials are missing. The object key is stored when the
+// message store keeps metadata. With S3_AUTO_DOWNLOAD, incoming media is
+// downloaded and uploaded as it arrives instead of on first access.
+func NewS3MediaStorage(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) *S3MediaStorage {
+	bucket := envString("S3_BUCKET", "")
+	if bucket == "" {
+		return nil
+	}
+
+	s3, err := newS3Client()
+	if err != nil {
+		logger.Warnf("S3 media upload disabled: %v", err)
+		return nil
+	}
+	if _, ok := messageStore.(MetadataStore); !ok {
+		logger.Warnf("Message store does not keep metadata, S3 object keys will not be stored")
+	}
+
+	prefix := strings.Trim(envString("S3_PREFIX", ""), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	m := &S3MediaStorage{
+		s3:           s3,
+		messageStore: messageStore,
+		bucket:       bucket,
+		prefix:       prefix,
+		publicURL:    strings.TrimSuffix(envString("S3_PUBLIC_URL", ""), "/"),
+		urlTTL:       envDuration("S3_URL_TTL", s3MaxPresignTTL),
+	}
+	m.mediaDownloader = newMediaDownloader(client, "S3", logger, m.Downloaded)
+
+	registerMediaURLSigner(m)
+	return m
+}
+
+// objectKey is where a message's media is kept in the bucket
+func (m *S3MediaStorage) objectKey(messageID, chatJID, filename string) string {
+	return m.prefix + mediaObjectKey(messageID, chatJID, filename)
+}
+
+// mediaURL returns the public or presigned URL of an object
+func (m *S3MediaStorage) mediaURL(key string, ttl time.Duration) string {
+	if m.publicURL != "" {
+		return m.publicURL + "/" + s3EscapePath(key)
+	}
+	return m.s3.PresignGetObject(m.bucket, key, ttl)
+}
+
+// Downloaded uploads media that was just downloaded to the local cache and
+// stores its object key and URL in the message metadata
+func (m *S3MediaStorage) Downloaded(messageID, chatJID, filename, localPath string) {
+	key := m.objectKey(messageID, chatJID, filename)
+	if err := m.s3.PutObject(m.bucket, key, mediaContentType(filename), localPath); err != nil {
+		m.logger.Warnf("Failed to upload media of message %s: %v", messageID, err)
+		return
+	}
+
+	store, ok := m.messageStore.(MetadataStore)
+	if !ok {
+		return
+	}
+	metadata := map[string]interface{}{
+		"storage_backend": "s3",
+		"storage_bucket":  m.bucket,
+		"storage_path":    key,
+		"media_url":       m.mediaURL(key, m.urlTTL),
+	}
+	if m.publicURL == "" {
+		metadata["media_url_expires_at"] = time.Now().Add(s3PresignTTL(m.urlTTL)).UTC().Format(time.RFC3339)
+	}
+	if err := store.PatchMessageMetadata(messageID, chatJID, metadata); err != nil {
+		m.logger.Warnf("Failed to store media URL of message %s: %v", messageID, err)
+	}
+}
+
+// SignMediaURL implements MediaURLSigner for media already in the bucket.
+// It fails, so the next signer is tried, for media that was never uploaded.
+func (m *S3MediaStorage) SignMediaURL(messageID, chatJID string, ttl time.Duration) (string, error) {
+	_, filename, _, _, _, _, _, err := m.messageStore.GetMediaInfo(messageID, chatJID)
+	if err != nil {
+		return "", err
+	}
+
+	key := m.objectKey(messageID, chatJID, filename)
+	exists, err := m.s3.HeadObject(m.bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("media of message %s is not in the bucket", messageID)
+	}
+	return m.mediaURL(key, ttl), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// selfCommandPrefix starts every command sent to the self-chat
+const selfCommandPrefix = "!"
+
+// selfCommand is a command the account owner can send to their own chat
+type selfCommand struct {
+	usage string
+	run   func(c *SelfCommands, args []string) string
+}
+
+// selfCommandSet lists every known command. Only those allowed by
+// SELF_COMMANDS are run.
+var selfCommandSet = map[string]selfCommand{
+	"help":   {usage: "!help", run: (*SelfCommands).help},
+	"status": {usage: "!status", run: (*SelfCommands).status},
+	"mute":   {usage: "!mute <jid or phone> [duration]", run: (*SelfCommands).mute},
+	"unmute": {usage: "!unmute <jid or phone>", run: (*SelfCommands).unmute},
+	"export": {usage: "!export <jid or phone>", run: (*SelfCommands).export},
+}
+
+// SelfCommands lets the account owner control the bridge by messaging
+// themselves, for when no terminal or API client is at hand. Replies are
+// sent back to the self-chat.
+type SelfCommands struct {
+	client   *whatsmeow.Client
+	store    MessageStoreInterface
+	commands map[string]selfCommand
+	started  time.Time
+	logger   waLog.Logger
+}
+
+// selfCommands is the active command handler, nil when disabled
+var selfCommands *SelfCommands
+
+// NewSelfCommands creates the command handler with the commands listed in
+// SELF_COMMANDS, all of them by default. It returns nil when the list is
+// "none".
+func NewSelfCommands(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) (*SelfCommands, error) {
+	names := envList("SELF_COMMANDS")
+	if len(names) == 0 {
+		for name := range selfCommandSet {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 1 && names[0] == "none" {
+		return nil, nil
+	}
+
+	commands := make(map[string]selfCommand)
+	for _, name := range names {
+		command, ok := selfCommandSet[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown self-chat command %q", name)
+		}
+		commands[name] = command
+	}
+
+	return &SelfCommands{
+		client:   client,
+		store:    messageStore,
+		commands: commands,
+		started:  time.Now(),
+		logger:   logger,
+	}, nil
+}
+
+// isSelfChat reports whether a chat is the owner's chat with themselves,
+// under either their phone number or LID
+func (c *SelfCommands) isSelfChat(chat types.JID) bool {
+	if c.client.Store.ID == nil {
+		return false
+	}
+	return chat.User == c.client.Store.ID.User || (!c.client.Store.LID.IsEmpty() && chat.User == c.client.Store.LID.User)
+}
+
+// HandleMessage runs a command the owner sent to their self-chat
+func (c *SelfCommands) HandleMessage(msg *events.Message) {
+	if c == nil || !msg.Info.IsFromMe || !c.isSelfChat(msg.Info.Chat) {
+		return
+	}
+
+	text := strings.TrimSpace(extractTextContent(msg.Message))
+	if !strings.HasPrefix(text, selfCommandPrefix) {
+		return
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(text, selfCommandPrefix))
+	if len(fields) == 0 {
+		return
+	}
+	name := strings.ToLower(fields[0])
+
+	command, ok := c.commands[name]
+	if !ok {
+		c.reply(msg.Info.Chat, fmt.Sprintf("Unknown command %q, send !help for the list", name))
+		return
+	}
+
+	c.logger.Infof("Running self-chat command %s", name)
+	// Commands such as export take a while and must not hold up the event
+	// handler
+	go func() {
+		c.reply(msg.Info.Chat, command.run(c, fields[1:]))
+	}()
+}
+
+// reply sends a command's answer to the self-chat
+func (c *SelfCommands) reply(chat types.JID, text string) {
+	if text == "" {
+		return
+	}
+	if ok, result, _ := sendWhatsAppMessage(c.client, chat.String(), text, "", c.client.GenerateMessageID()); !ok {
+		c.logger.Warnf("Failed to reply to self-chat command: %s", result)
+	}
+}
+
+// commandTarget parses the chat a command applies to
+func commandTarget(args []string) (types.JID, error) {
+	if len(args) == 0 {
+		return types.JID{}, fmt.Errorf("a JID or phone number is required")
+	}
+	if strings.Contains(args[0], "@") {
+		return types.ParseJID(args[0])
+	}
+
+	phone := normalizePhone(args[0])
+	if phone == "" {
+		return types.JID{}, fmt.Errorf("invalid phone number %q", args[0])
+	}
+	return types.JID{User: phone, Server: types.DefaultUserServer}, nil
+}
+
+// help lists the allowed commands
+func (c *SelfCommands) help(args []string) string {
+	var lines []string
+	for _, command := range c.commands {
+		lines = append(lines, command.usage)
+	}
+	sort.Strings(lines)
+	return "Commands:\n" + strings.Join(lines, "\n")
+}
+
+// status reports the connection and queues
+func (c *SelfCommands) status(args []string) string {
+	lines := []string{
+		fmt.Sprintf("Connected: %t", c.client.IsConnected()),
+		fmt.Sprintf("Up since: %s", c.started.Format(time.RFC3339)),
+	}
+	if outbox != nil {
+		if pending, err := outbox.PendingApproval(); err == nil {
+			lines = append(lines, fmt.Sprintf("Awaiting approval: %d", len(pending)))
+		}
+	}
+	if historyGaps != nil {
+		if gaps, err := historyGaps.List(GapOpen, 1000); err == nil {
+			lines = append(lines, fmt.Sprintf("Open history gaps: %d", len(gaps)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mute mutes a chat on all devices, forever or for a duration like "8h"
+func (c *SelfCommands) mute(args []string) string {
+	target, err := commandTarget(args)
+	if err != nil {
+		return err.Error()
+	}
+
+	var duration time.Duration
+	if len(args) > 1 {
+		if duration, err = time.ParseDuration(args[1]); err != nil || duration <= 0 {
+			return fmt.Sprintf("Invalid duration %q, e.g. 8h", args[1])
+		}
+	}
+
+	if err := c.client.SendAppState(context.Background(), appstate.BuildMute(target, true, duration)); err != nil {
+		return fmt.Sprintf("Failed to mute %s: %v", target, err)
+	}
+	if duration > 0 {
+		return fmt.Sprintf("Muted %s for %s", target, duration)
+	}
+	return fmt.Sprintf("Muted %s", target)
+}
+
+// unmute unmutes a chat on all devices
+func (c *SelfCommands) unmute(args []string) string {
+	target, err := commandTarget(args)
+	if err != nil {
+		return err.Error()
+	}
+
+	if err := c.client.SendAppState(context.Background(), appstate.BuildMute(target, false, 0)); err != nil {
+		return fmt.Sprintf("Failed to unmute %s: %v", target, err)
+	}
+	return fmt.Sprintf("Unmuted %s", target)
+}
+
+// export writes the media of a chat to a zip under store/exports
+func (c *SelfCommands) export(args []string) string {
+	target, err := commandTarget(args)
+	if err != nil {
+		return err.Error()
+	}
+	chatJID := target.String()
+
+	messages, err := exportMessages(c.store, chatJID, MessageQuery{})
+	if err != nil {
+		return fmt.Sprintf("Failed to list messages of %s: %v", chatJID, err)
+	}
+
+	if err := os.MkdirAll("store/exports", 0755); err != nil {
+		return fmt.Sprintf("Failed to create export directory: %v", err)
+	}
+	path := filepath.Join("store/exports", exportArchiveName(chatJID, time.Now().UTC()))
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Sprintf("Failed to create export: %v", err)
+	}
+
+	manifest, err := writeMediaExport(context.Background(), file, c.client, c.store, chatJID, messages, MessageQuery{})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Sprintf("Failed to export %s: %v", chatJID, err)
+	}
+
+	absPath, _ := filepath.Abs(path)
+	return fmt.Sprintf("Exported %d media files of %s to %s (%d failed)", manifest.Exported, chatJID, absPath, manifest.Failed)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Reasons a send failed. They are returned as failure_reason by the send
+// APIs and stored with the failed message, so callers can decide whether to
+// retry without parsing error text.
+const (
+	SendFailureNotConnected     = "not_connected"
+	SendFailureInvalidRecipient = "invalid_recipient"
+	SendFailureNotOnWhatsApp    = "not_on_whatsapp"
+	SendFailureNotInGroup       = "not_in_group"
+	SendFailureGroupNotFound    = "group_not_found"
+	SendFailureTooLarge         = "message_too_large"
+	SendFailureRateLimited      = "rate_limited"
+	SendFailureWarmupLimit      = "warmup_limit"
+	SendFailureQuotaExceeded    = "quota_exceeded"
+	SendFailureTimeout          = "timeout"
+	SendFailureMediaUnreadable  = "media_unreadable"
+	SendFailureMediaUpload      = "media_upload_failed"
+	// SendFailureNotApproved and SendFailureCancelled mark held messages
+	// that were rejected or expired, or cancelled from the outbox
+	SendFailureNotApproved = "not_approved"
+	SendFailureCancelled   = "cancelled"
+	// SendFailureInvalid marks messages the composer rejected for another
+	// reason, such as a missing body
+	SendFailureInvalid = "invalid_message"
+	SendFailureUnknown = "unknown"
+)
+
+// validationFailures maps composer validation codes to failure reasons,
+// for sends that fail validation after being accepted, like those from
+// Supabase Realtime
+var validationFailures = map[string]string{
+	ValidationInvalidJID:    SendFailureInvalidRecipient,
+	ValidationInvalidPhone:  SendFailureInvalidRecipient,
+	ValidationNotOnWhatsApp: SendFailureNotOnWhatsApp,
+	ValidationTooLong:       SendFailureTooLarge,
+	ValidationTooLarge:      SendFailureTooLarge,
+	ValidationMediaNotFound: SendFailureMediaUnreadable,
+}
+
+// validationFailureReason returns the failure reason of a validation error
+func validationFailureReason(code string) string {
+	if reason, ok := validationFailures[code]; ok {
+		return reason
+	}
+	return SendFailureInvalid
+}
+
+// serverSendErrors maps the error codes WhatsApp acknowledges a rejected
+// message with to failure reasons
+var serverSendErrors = map[int]string{
+	404: SendFailureNotOnWhatsApp,
+	413: SendFailureTooLarge,
+	429: SendFailureRateLimited,
+}
+
+// serverErrorCode returns the code of a send the server rejected, 0 for
+// other errors
+func serverErrorCode(err error) int {
+	if !errors.Is(err, whatsmeow.ErrServerReturnedError) {
+		return 0
+	}
+	text := err.Error()
+	code, _ := strconv.Atoi(text[strings.LastIndex(text, " ")+1:])
+	return code
+}
+
+// classifySendError maps an error from sending to a recipient to a failure
+// reason. When a send to a user fails for no known reason the cached
+// on-WhatsApp lookup decides whether the number is registered.
+func classifySendError(client *whatsmeow.Client, recipient types.JID, err error) string {
+	var disconnected *whatsmeow.DisconnectedError
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotConnected), errors.Is(err, whatsmeow.ErrNotLoggedIn), errors.As(err, &disconnected):
+		return SendFailureNotConnected
+	case errors.Is(err, whatsmeow.ErrNotInGroup):
+		return SendFailureNotInGroup
+	case errors.Is(err, whatsmeow.ErrGroupNotFound):
+		return SendFailureGroupNotFound
+	case errors.Is(err, whatsmeow.ErrUnknownServer), errors.Is(err, whatsmeow.ErrRecipientADJID), errors.Is(err, whatsmeow.ErrBroadcastListUnsupported):
+		return SendFailureInvalidRecipient
+	case errors.Is(err, whatsmeow.ErrIQRateOverLimit), errors.Is(err, whatsmeow.ErrIQResourceLimit):
+		return SendFailureRateLimited
+	case errors.Is(err, whatsmeow.ErrMessageTimedOut), errors.Is(err, whatsmeow.ErrIQTimedOut), errors.Is(err, context.DeadlineExceeded):
+		return SendFailureTimeout
+	}
+
+	code := serverErrorCode(err)
+	if reason, ok := serverSendErrors[code]; ok {
+		return reason
+	}
+	// Groups answer messages from removed members with forbidden
+	if code == 403 && recipient.Server == types.GroupServer {
+		return SendFailureNotInGroup
+	}
+
+	if recipient.Server == types.DefaultUserServer && client != nil {
+		if _, isIn, ok := checkOnWhatsApp(client, recipient.User); ok && !isIn {
+			return SendFailureNotOnWhatsApp
+		}
+	}
+	return SendFailureUnknown
+}
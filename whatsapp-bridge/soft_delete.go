@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// SoftDeleteStore is implemented by message stores that can hide chats and
+// messages until they are restored or purged. Hidden rows carry trashed_at,
+// which read APIs filter on. The deleted_at column is separate and records
+// messages their sender deleted in WhatsApp.
+type SoftDeleteStore interface {
+	// TrashChat hides a chat and its messages. It reports whether the chat
+	// exists and was not trashed yet. A message newer than the trash shows
+	// the chat again, without the messages trashed with it.
+	TrashChat(jid string, at time.Time) (bool, error)
+	// RestoreChat shows a trashed chat again, with the messages trashed
+	// along with it
+	RestoreChat(jid string) (bool, error)
+	// TrashMessage hides a message
+	TrashMessage(id, chatJID string, at time.Time) (bool, error)
+	// RestoreMessage shows a trashed message again
+	RestoreMessage(id, chatJID string) (bool, error)
+	// PurgeTrashed permanently deletes chats and messages trashed before a
+	// time and returns how many rows were deleted
+	PurgeTrashed(before time.Time) (int, error)
+}
+
+// TrashChat sets trashed_at on a chat and its messages in one transaction
+func (store *MessageStore) TrashChat(jid string, at time.Time) (bool, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE chats SET trashed_at = ? WHERE jid = ? AND trashed_at IS NULL", at.UTC(), jid)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE messages SET trashed_at = ? WHERE chat_jid = ? AND trashed_at IS NULL", at.UTC(), jid); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RestoreChat clears trashed_at on a chat and on the messages trashed with
+// it. Messages trashed on their own before stay trashed.
+func (store *MessageStore) RestoreChat(jid string) (bool, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE messages SET trashed_at = NULL WHERE chat_jid = ? AND trashed_at = (SELECT trashed_at FROM chats WHERE jid = ?)",
+		jid, jid,
+	)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("UPDATE chats SET trashed_at = NULL WHERE jid = ? AND trashed_at IS NOT NULL", jid)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// TrashMessage sets trashed_at on a message
+func (store *MessageStore) TrashMessage(id, chatJID string, at time.Time) (bool, error) {
+	result, err := store.db.Exec("UPDATE messages SET trashed_at = ? WHERE id = ? AND chat_jid = ? AND trashed_at IS NULL", at.UTC(), id, chatJID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// RestoreMessage clears trashed_at on a message
+func (store *MessageStore) RestoreMessage(id, chatJID string) (bool, error) {
+	result, err := store.db.Exec("UPDATE messages SET trashed_at = NULL WHERE id = ? AND chat_jid = ? AND trashed_at IS NOT NULL", id, chatJID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// PurgeTrashed deletes messages trashed before a time, and chats trashed
+// before it along with all their messages
+func (store *MessageStore) PurgeTrashed(before time.Time) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged := 0
+	for _, statement := range []string{
+		"DELETE FROM messages WHERE trashed_at < ?",
+		"DELETE FROM messages WHERE chat_jid IN (SELECT jid FROM chats WHERE trashed_at < ?)",
+		"DELETE FROM chats WHERE trashed_at < ?",
+	} {
+		result, err := tx.Exec(statement, before.UTC())
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		purged += int(n)
+	}
+	return purged, tx.Commit()
+}
+
+// TrashChat sets trashed_at on a Supabase conversation and its messages,
+// created with e.g.
+//
+//	ALTER TABLE conversations ADD COLUMN trashed_at timestamptz;
+//	ALTER TABLE messages ADD COLUMN trashed_at timestamptz;
+func (s *SupabaseMessageStore) TrashChat(jid string, at time.Time) (bool, error) {
+	conversationID, err := s.client.FindConversation(jid)
+	if err != nil || conversationID == "" {
+		return false, err
+	}
+
+	trashedAt := map[string]interface{}{"trashed_at": at.UTC().Format(time.RFC3339)}
+	updated, err := s.client.patchConversations(fmt.Sprintf("id=eq.%s&trashed_at=is.null", pgValue(conversationID)), func(map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"trashed_at": trashedAt["trashed_at"]}
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to trash conversation: %v", err)
+	}
+	if updated == 0 {
+		return false, nil
+	}
+
+	endpoint := fmt.Sprintf("messages?conversation_id=eq.%s&trashed_at=is.null", pgValue(conversationID))
+	if _, err := s.client.makePreferRequest("PATCH", "rest/v1/"+endpoint, "return=minimal", trashedAt); err != nil {
+		return false, fmt.Errorf("failed to trash messages: %v", err)
+	}
+	return true, nil
+}
+
+// RestoreChat clears trashed_at on a Supabase conversation and on the
+// messages trashed with it
+func (s *SupabaseMessageStore) RestoreChat(jid string) (bool, error) {
+	if err := validateJID(jid); err != nil {
+		return false, err
+	}
+
+	endpoint := fmt.Sprintf("conversations?contact_identifier=eq.%s&channel=eq.whatsapp&trashed_at=not.is.null&select=id,trashed_at", pgValue(jid))
+	resp, err := s.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to query conversation: %v", err)
+	}
+	var conversations []struct {
+		ID        string    `json:"id"`
+		TrashedAt time.Time `json:"trashed_at"`
+	}
+	if err := json.Unmarshal(resp, &conversations); err != nil {
+		return false, fmt.Errorf("failed to parse conversation response: %v", err)
+	}
+	if len(conversations) == 0 {
+		return false, nil
+	}
+
+	restored := map[string]interface{}{"trashed_at": nil}
+	conversation := conversations[0]
+	endpoint = fmt.Sprintf("messages?conversation_id=eq.%s&trashed_at=eq.%s",
+		pgValue(conversation.ID), pgValue(conversation.TrashedAt.UTC().Format(time.RFC3339)))
+	if _, err := s.client.makePreferRequest("PATCH", "rest/v1/"+endpoint, "return=minimal", restored); err != nil {
+		return false, fmt.Errorf("failed to restore messages: %v", err)
+	}
+	err = s.client.patchConversation(fmt.Sprintf("id=eq.%s", pgValue(conversation.ID)), func(map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"trashed_at": nil}
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to restore conversation: %v", err)
+	}
+	return true, nil
+}
+
+// TrashMessage sets trashed_at on a Supabase message
+func (s *SupabaseMessageStore) TrashMessage(id, chatJID string, at time.Time) (bool, error) {
+	return s.patchMessageTrash(id, chatJID, "trashed_at=is.null", at.UTC().Format(time.RFC3339))
+}
+
+// RestoreMessage clears trashed_at on a Supabase message
+func (s *SupabaseMessageStore) RestoreMessage(id, chatJID string) (bool, error) {
+	return s.patchMessageTrash(id, chatJID, "trashed_at=not.is.null", nil)
+}
+
+// patchMessageTrash sets trashed_at on a message of a chat matching a
+// trashed_at filter and reports whether it did
+func (s *SupabaseMessageStore) patchMessageTrash(id, chatJID, trashFilter string, trashedAt interface{}) (bool, error) {
+	filter, err := s.messageFilter(id, chatJID)
+	if err != nil || filter == "" {
+		return false, err
+	}
+	endpoint := "messages?" + filter + "&" + trashFilter + "&select=id"
+	resp, err := s.client.makeRequest("PATCH", endpoint, map[string]interface{}{"trashed_at": trashedAt})
+	if err != nil {
+		return false, fmt.Errorf("failed to update message: %v", err)
+	}
+	var updated []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &updated); err != nil {
+		return false, fmt.Errorf("failed to parse message response: %v", err)
+	}
+	return len(updated) > 0, nil
+}
+
+// PurgeTrashed deletes WhatsApp messages trashed before a time, then the
+// conversations trashed before it a page at a time, messages first
+func (s *SupabaseMessageStore) PurgeTrashed(before time.Time) (int, error) {
+	cutoff := pgValue(before.UTC().Format(time.RFC3339))
+	resp, err := s.client.makeRequest("DELETE", fmt.Sprintf("messages?channel=eq.whatsapp&trashed_at=lt.%s&select=id", cutoff), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge messages: %v", err)
+	}
+	var deleted []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &deleted); err != nil {
+		return 0, fmt.Errorf("failed to parse purged messages: %v", err)
+	}
+	purged := len(deleted)
+
+	for {
+		endpoint := fmt.Sprintf("conversations?channel=eq.whatsapp&trashed_at=lt.%s&select=id,contact_identifier&limit=%d", cutoff, supabasePageSize)
+		resp, err := s.client.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return purged, fmt.Errorf("failed to list trashed conversations: %v", err)
+		}
+		var page []struct {
+			ID                string `json:"id"`
+			ContactIdentifier string `json:"contact_identifier"`
+		}
+		if err := json.Unmarshal(resp, &page); err != nil {
+			return purged, fmt.Errorf("failed to parse trashed conversations: %v", err)
+		}
+		if len(page) == 0 {
+			return purged, nil
+		}
+
+		ids := make([]string, len(page))
+		for i, conversation := range page {
+			ids[i] = conversation.ID
+		}
+		endpoint = "rest/v1/messages?conversation_id=in." + pgList(ids)
+		if _, err := s.client.makePreferRequest("DELETE", endpoint, "return=minimal", nil); err != nil {
+			return purged, fmt.Errorf("failed to purge messages of trashed conversations: %v", err)
+		}
+		if _, err := s.client.makeRequest("DELETE", "conversations?id=in."+pgList(ids), nil); err != nil {
+			return purged, fmt.Errorf("failed to purge conversations: %v", err)
+		}
+		for _, conversation := range page {
+			s.conversationCache.Delete(conversation.ContactIdentifier)
+		}
+		purged += len(page)
+	}
+}
+
+// SoftDeletes purges trashed chats and messages once they have been in the
+// trash for the retention window
+type SoftDeletes struct {
+	store     SoftDeleteStore
+	retention time.Duration
+	logger    waLog.Logger
+}
+
+// softDeletes is the active soft delete support, nil when disabled. Read
+// APIs of the Supabase store only filter on trashed_at while it is enabled,
+// since the column is added by hand.
+var softDeletes *SoftDeletes
+
+// NewSoftDeletes starts purging trashed rows older than
+// SOFT_DELETE_RETENTION every SOFT_DELETE_PURGE_INTERVAL. It returns nil
+// when SOFT_DELETE is not enabled or the store cannot soft delete.
+func NewSoftDeletes(messageStore MessageStoreInterface, logger waLog.Logger) *SoftDeletes {
+	if !envBool("SOFT_DELETE", false) {
+		return nil
+	}
+
+	store, ok := messageStore.(SoftDeleteStore)
+	if !ok {
+		logger.Warnf("Message store does not support soft deletes, trash disabled")
+		return nil
+	}
+
+	d := &SoftDeletes{
+		store:     store,
+		retention: envDuration("SOFT_DELETE_RETENTION", 30*24*time.Hour),
+		logger:    logger,
+	}
+	go d.run(envDuration("SOFT_DELETE_PURGE_INTERVAL", time.Hour))
+	return d
+}
+
+// run purges expired rows on every interval
+func (d *SoftDeletes) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := d.store.PurgeTrashed(time.Now().Add(-d.retention))
+		if err != nil {
+			d.logger.Warnf("Failed to purge trash: %v", err)
+		}
+		if purged > 0 {
+			d.logger.Infof("Purged %d trashed chats and messages", purged)
+		}
+	}
+}
+
+// TrashRequest represents the request body of the trash and restore APIs.
+// Without a message ID the whole chat is affected.
+type TrashRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// TrashResponse represents the response of the trash and restore APIs
+type TrashResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	// PurgeAt is when a trashed chat or message is deleted for good
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+}
+
+// handleTrash serves POST /api/trash and /api/trash/restore, moving a chat
+// or a message to the trash or back out of it
+func handleTrash(restore bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if softDeletes == nil {
+			http.Error(w, "Soft deletes are disabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req TrashRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" {
+			http.Error(w, "Chat JID is required", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		var found bool
+		var err error
+		switch {
+		case restore && req.MessageID == "":
+			found, err = softDeletes.store.RestoreChat(req.ChatJID)
+		case restore:
+			found, err = softDeletes.store.RestoreMessage(req.MessageID, req.ChatJID)
+		case req.MessageID == "":
+			found, err = softDeletes.store.TrashChat(req.ChatJID, now)
+		default:
+			found, err = softDeletes.store.TrashMessage(req.MessageID, req.ChatJID, now)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(TrashResponse{Success: false, Message: err.Error()})
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			message := "Not found or already in the trash"
+			if restore {
+				message = "Not found in the trash"
+			}
+			json.NewEncoder(w).Encode(TrashResponse{Success: false, Message: message})
+			return
+		}
+
+		resp := TrashResponse{Success: true, Message: "Moved to the trash"}
+		if restore {
+			resp.Message = "Restored"
+		} else {
+			purgeAt := now.Add(softDeletes.retention)
+			resp.PurgeAt = &purgeAt
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MessageStoreFactory opens a message store backend. The logger is for
+// warnings that should not stop the bridge from starting.
+type MessageStoreFactory func(logger waLog.Logger) (MessageStoreInterface, error)
+
+// messageStoreFactories holds the registered backends by name
+var messageStoreFactories = make(map[string]MessageStoreFactory)
+
+// RegisterMessageStore makes a backend available under a name for
+// MESSAGE_STORE. Other storage is plugged in by adding a file that calls
+// it from init. Registering a name twice panics, like database/sql.
+func RegisterMessageStore(name string, factory MessageStoreFactory) {
+	if factory == nil {
+		panic("message store factory for " + name + " is nil")
+	}
+	if _, exists := messageStoreFactories[name]; exists {
+		panic("message store " + name + " is registered twice")
+	}
+	messageStoreFactories[name] = factory
+}
+
+// MessageStoreNames lists the registered backends
+func MessageStoreNames() []string {
+	names := make([]string, 0, len(messageStoreFactories))
+	for name := range messageStoreFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The built-in backends
+var (
+	_ MessageStoreInterface = (*MessageStore)(nil)
+	_ MessageStoreInterface = (*SupabaseMessageStore)(nil)
+	_ MessageStoreInterface = (*PostgresMessageStore)(nil)
+)
+
+func init() {
+	RegisterMessageStore("sqlite", func(waLog.Logger) (MessageStoreInterface, error) {
+		return NewMessageStore()
+	})
+	RegisterMessageStore("supabase", openSupabaseStore)
+	RegisterMessageStore("postgres", func(logger waLog.Logger) (MessageStoreInterface, error) {
+		return NewPostgresMessageStore(envString("DATABASE_URL", ""), logger)
+	})
+}
+
+// openSupabaseStore opens the Supabase store and checks its schema, to
+// catch a missing or outdated one before the first write does
+func openSupabaseStore(logger waLog.Logger) (MessageStoreInterface, error) {
+	store, err := NewSupabaseMessageStore()
+	if err != nil {
+		return nil, err
+	}
+
+	switch check := envString("SUPABASE_SCHEMA_CHECK", "warn"); check {
+	case "off":
+	case "warn", "strict":
+		if err := verifySupabaseSchema(store.client); err != nil {
+			if check == "strict" {
+				return nil, err
+			}
+			logger.Warnf("%v", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid SUPABASE_SCHEMA_CHECK %q, expected warn, strict or off", check)
+	}
+	return store, nil
+}
+
+// isPostgresURL reports whether a message store name is a Postgres
+// connection string rather than a backend name
+func isPostgresURL(name string) bool {
+	return strings.HasPrefix(name, "postgres://") || strings.HasPrefix(name, "postgresql://")
+}
+
+// openMessageStore opens the named backend and returns the name it opened.
+// With "auto" or no name it keeps the bridge's original choice: Postgres
+// when DATABASE_URL is set, else Supabase, falling back to SQLite when
+// Supabase is not configured. A Postgres connection string opens that
+// database, so plain Postgres is chosen without DATABASE_URL.
+func openMessageStore(name string, logger waLog.Logger) (MessageStoreInterface, string, error) {
+	if isPostgresURL(name) {
+		store, err := NewPostgresMessageStore(name, logger)
+		if err != nil {
+			return nil, "postgres", err
+		}
+		return store, "postgres", nil
+	}
+	if name == "" || name == "auto" {
+		if envString("DATABASE_URL", "") != "" {
+			name = "postgres"
+		} else if _, err := NewSupabaseClient(); err != nil {
+			logger.Warnf("Supabase not configured, falling back to SQLite: %v", err)
+			name = "sqlite"
+		} else {
+			name = "supabase"
+		}
+	}
+
+	factory, ok := messageStoreFactories[name]
+	if !ok {
+		return nil, name, fmt.Errorf("unknown message store %q, expected one of %s", name, strings.Join(MessageStoreNames(), ", "))
+	}
+	store, err := factory(logger)
+	return store, name, err
+}
+
+// storeFeature is a bridge feature that needs an optional store interface
+type storeFeature struct {
+	name      string
+	supported func(MessageStoreInterface) bool
+}
+
+// storeFeatures are the features that are turned off for stores without
+// their interface
+var storeFeatures = []storeFeature{
+	{"edits and revokes", func(s MessageStoreInterface) bool { _, ok := s.(EditStore); return ok }},
+	{"delivery status", func(s MessageStoreInterface) bool { _, ok := s.(MessageStatusStore); return ok }},
+	{"reactions", func(s MessageStoreInterface) bool { _, ok := s.(ReactionStore); return ok }},
+	{"disappearing messages", func(s MessageStoreInterface) bool { _, ok := s.(EphemeralStore); return ok }},
+	{"trash", func(s MessageStoreInterface) bool { _, ok := s.(SoftDeleteStore); return ok }},
+	{"group participants", func(s MessageStoreInterface) bool { _, ok := s.(ParticipantStore); return ok }},
+	{"contact sync", func(s MessageStoreInterface) bool { _, ok := s.(ContactStore); return ok }},
+	{"chat state", func(s MessageStoreInterface) bool { _, ok := s.(ChatStateStore); return ok }},
+	{"chat merging", func(s MessageStoreInterface) bool { _, ok := s.(ChatMerger); return ok }},
+	{"chat import", func(s MessageStoreInterface) bool { _, ok := s.(ChatImporter); return ok }},
+	{"history gap detection", func(s MessageStoreInterface) bool { _, ok := s.(MessageExistenceStore); return ok }},
+}
+
+// unsupportedFeatures lists the features a store turns off
+func unsupportedFeatures(store MessageStoreInterface) []string {
+	var missing []string
+	for _, feature := range storeFeatures {
+		if !feature.supported(store) {
+			missing = append(missing, feature.name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// SummaryStore is implemented by message stores that can persist a
+// conversation summary on the chat record
+type SummaryStore interface {
+	StoreChatSummary(chatJID, summary string, updatedAt time.Time) error
+}
+
+// SummaryMessage is a single message in the transcript sent to the summarizer
+type SummaryMessage struct {
+	Time      time.Time `json:"time"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+}
+
+// SummaryRequest is the body posted to the configured summarization endpoint
+type SummaryRequest struct {
+	ChatJID  string           `json:"chat_jid"`
+	Messages []SummaryMessage `json:"messages"`
+}
+
+// SummaryResponse is the result expected back from the summarization endpoint
+type SummaryResponse struct {
+	Summary string `json:"summary"`
+}
+
+// Summarizer sends recent chat history to an LLM endpoint and stores the
+// returned summary on the conversation
+type Summarizer struct {
+	url          string
+	token        string
+	messageLimit int
+	client       *http.Client
+	messageStore MessageStoreInterface
+	store        SummaryStore
+	logger       waLog.Logger
+
+	// lastRun is the cutoff used to find chats with new activity
+	lastRun time.Time
+	mutex   sync.Mutex
+}
+
+// chatSummarizer is the active summarization hook, nil when disabled
+var chatSummarizer *Summarizer
+
+// NewSummarizer creates the summarization hook from environment variables.
+// It returns nil when SUMMARY_URL is not set or the store cannot persist
+// summaries. When SUMMARY_INTERVAL is set, chats with new messages are
+// summarized periodically in the background.
+func NewSummarizer(messageStore MessageStoreInterface, logger waLog.Logger) *Summarizer {
+	url := envString("SUMMARY_URL", "")
+	if url == "" {
+		return nil
+	}
+
+	store, ok := messageStore.(SummaryStore)
+	if !ok {
+		logger.Warnf("Message store does not support summaries, summarizer disabled")
+		return nil
+	}
+
+	s := &Summarizer{
+		url:          url,
+		token:        envString("SUMMARY_TOKEN", ""),
+		messageLimit: envInt("SUMMARY_MESSAGE_LIMIT", 50),
+		client:       &http.Client{Timeout: envDuration("SUMMARY_TIMEOUT", 60*time.Second)},
+		messageStore: messageStore,
+		store:        store,
+		logger:       logger,
+		lastRun:      time.Now(),
+	}
+
+	if interval := envDuration("SUMMARY_INTERVAL", 0); interval > 0 {
+		go s.runPeriodic(interval)
+	}
+
+	return s
+}
+
+// runPeriodic summarizes every chat that received messages since the last run
+func (s *Summarizer) runPeriodic(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mutex.Lock()
+		since := s.lastRun
+		s.lastRun = time.Now()
+		s.mutex.Unlock()
+
+		chats, err := s.messageStore.GetChats()
+		if err != nil {
+			s.logger.Warnf("Failed to list chats for summarization: %v", err)
+			continue
+		}
+
+		for chatJID, lastMessageTime := range chats {
+			if !lastMessageTime.After(since) {
+				continue
+			}
+			if _, err := s.SummarizeChat(chatJID); err != nil {
+				s.logger.Warnf("Failed to summarize chat %s: %v", chatJID, err)
+			}
+		}
+	}
+}
+
+// SummarizeChat summarizes the most recent messages of a chat and stores the
+// result on the conversation record
+func (s *Summarizer) SummarizeChat(chatJID string) (string, error) {
+	messages, err := s.messageStore.GetMessages(chatJID, MessageQuery{Limit: s.messageLimit})
+	if err != nil {
+		return "", fmt.Errorf("failed to load messages: %v", err)
+	}
+
+	if len(messages) == 0 {
+		return "", fmt.Errorf("no messages found for chat %s", chatJID)
+	}
+
+	// Messages come back newest first, the summarizer gets them in order
+	req := SummaryRequest{ChatJID: chatJID}
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		req.Messages = append(req.Messages, SummaryMessage{
+			Time:      msg.Time,
+			Sender:    msg.Sender,
+			Content:   msg.Content,
+			IsFromMe:  msg.IsFromMe,
+			MediaType: msg.MediaType,
+		})
+	}
+
+	summary, err := s.requestSummary(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.store.StoreChatSummary(chatJID, summary, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to store summary: %v", err)
+	}
+
+	return summary, nil
+}
+
+// requestSummary posts the transcript to the summarization endpoint
+func (s *Summarizer) requestSummary(req SummaryRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("summarizer error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var result SummaryResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	if result.Summary == "" {
+		return "", fmt.Errorf("summarizer returned an empty summary")
+	}
+
+	return result.Summary, nil
+}
+
+// handleSummarizeChat serves POST /api/chats/summarize for on-demand summaries
+func handleSummarizeChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ChatJID string `json:"chat_jid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.ChatJID == "" {
+		http.Error(w, "Chat JID is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if chatSummarizer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "Summarization is not configured (set SUMMARY_URL)",
+		})
+		return
+	}
+
+	summary, err := chatSummarizer.SummarizeChat(req.ChatJID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Failed to summarize chat: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"chat_jid": req.ChatJID,
+		"summary":  summary,
+	})
+}
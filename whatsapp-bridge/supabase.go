@@ -2,11 +2,20 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,6 +24,88 @@ type SupabaseClient struct {
 	URL    string
 	Key    string
 	client *http.Client
+
+	// Requests failing with a network error, 408, 429 or 5xx are retried
+	// up to retryAttempts times in total, with exponential backoff
+	retryAttempts  int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+
+	// upsert writes messages with on_conflict=external_id,channel so
+	// re-processing a WhatsApp message updates its row instead of adding one
+	upsert bool
+
+	// limiter caps the request rate and breaker pauses requests after
+	// repeated failures; both are nil when disabled
+	limiter *tokenBucket
+	breaker *circuitBreaker
+
+	// auth sends a JWT and headers for row level security instead of the
+	// API key alone, nil when not configured
+	auth *supabaseAuth
+
+	// tenant confines requests to one tenant's rows, nil when the tables
+	// are not shared
+	tenant *supabaseTenant
+
+	// columns maps the bridge's column names to those of existing tables,
+	// nil when the tables use the bridge's schema
+	columns *supabaseColumnMap
+
+	// quiet skips conversation.created events, for when another store
+	// already emits them
+	quiet bool
+
+	// versions makes conversation updates conditional on the row not
+	// having changed since it was read, nil when updates are unconditional
+	versions *conversationVersions
+
+	// tables renames the bridge's tables and selects their schema, nil
+	// when they are the bridge's own tables in public
+	tables *supabaseTables
+}
+
+// SupabaseAPIError is returned for responses with an error status
+type SupabaseAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SupabaseAPIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the request may succeed when sent again. Other
+// 4xx responses mean the request itself is wrong.
+func (e *SupabaseAPIError) Retryable() bool {
+	return e.StatusCode >= 500 || e.Unprocessed()
+}
+
+// Unprocessed reports whether the server turned the request away without
+// running it, so even a request that is not idempotent may be sent again
+func (e *SupabaseAPIError) Unprocessed() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// idempotentRequest reports whether sending a request twice has the same
+// effect as sending it once: reads, PATCHes, DELETEs and upserts. Plain
+// inserts and RPCs could write twice when a committed request lost its
+// response.
+func idempotentRequest(method string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPatch, http.MethodDelete, http.MethodPut:
+		return true
+	}
+	prefer := header.Get("Prefer")
+	return strings.Contains(prefer, "resolution=merge-duplicates") || strings.Contains(prefer, "resolution=ignore-duplicates")
+}
+
+// requestNotSent reports whether a transport error happened before the
+// request reached the server, such as a failed DNS lookup or connect
+func requestNotSent(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial")
 }
 
 // NewSupabaseClient creates a new Supabase client from environment variables
@@ -26,51 +117,242 @@ func NewSupabaseClient() (*SupabaseClient, error) {
 		return nil, fmt.Errorf("SUPABASE_URL and SUPABASE_KEY environment variables are required")
 	}
 
+	auth, err := newSupabaseAuth()
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := newSupabaseTenant()
+	if err != nil {
+		return nil, err
+	}
+	columns, err := newSupabaseColumnMap()
+	if err != nil {
+		return nil, err
+	}
+	versions, err := newConversationVersions()
+	if err != nil {
+		return nil, err
+	}
+	tables, err := newSupabaseTables()
+	if err != nil {
+		return nil, err
+	}
+
 	return &SupabaseClient{
 		URL:    url,
 		Key:    key,
-		client: &http.Client{Timeout: 30 * time.Second},
+		client: &http.Client{Transport: supabaseTransport(), Timeout: envDuration("SUPABASE_TIMEOUT", 30*time.Second)},
+
+		retryAttempts:  max(envInt("SUPABASE_RETRY_ATTEMPTS", 4), 1),
+		retryBaseDelay: envDuration("SUPABASE_RETRY_BASE_DELAY", 250*time.Millisecond),
+		retryMaxDelay:  envDuration("SUPABASE_RETRY_MAX_DELAY", 10*time.Second),
+
+		upsert: envBool("SUPABASE_UPSERT", false),
+
+		limiter: newTokenBucket(envInt("SUPABASE_RATE_LIMIT", 0), envInt("SUPABASE_RATE_BURST", 20)),
+		breaker: newCircuitBreaker(envInt("SUPABASE_BREAKER_THRESHOLD", 5), envDuration("SUPABASE_BREAKER_COOLDOWN", 30*time.Second)),
+
+		auth:     auth,
+		tenant:   tenant,
+		columns:  columns,
+		versions: versions,
+		tables:   tables,
 	}, nil
 }
 
-// makeRequest makes an authenticated request to Supabase
+// sharedSupabaseTransport is reused by every Supabase client so the store,
+// edge functions and realtime calls share one connection pool
+var (
+	sharedSupabaseTransport *http.Transport
+	supabaseTransportOnce   sync.Once
+)
+
+// supabaseTransport returns the shared transport. The defaults of
+// http.DefaultTransport keep only two idle connections per host, which
+// forces new TLS handshakes under load; the pool size is configurable.
+func supabaseTransport() *http.Transport {
+	supabaseTransportOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = envInt("SUPABASE_MAX_IDLE_CONNS", 100)
+		transport.MaxIdleConnsPerHost = envInt("SUPABASE_MAX_IDLE_CONNS_PER_HOST", 32)
+		transport.MaxConnsPerHost = envInt("SUPABASE_MAX_CONNS_PER_HOST", 0)
+		transport.IdleConnTimeout = envDuration("SUPABASE_IDLE_CONN_TIMEOUT", 90*time.Second)
+		transport.ForceAttemptHTTP2 = envBool("SUPABASE_HTTP2", true)
+		if !transport.ForceAttemptHTTP2 {
+			// A non-nil empty map disables HTTP/2 negotiation
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		sharedSupabaseTransport = transport
+	})
+	return sharedSupabaseTransport
+}
+
+// defaultPrefer asks PostgREST to return the rows a request wrote
+const defaultPrefer = "return=representation"
+
+// makeRequest makes an authenticated request to the Supabase REST API
 func (s *SupabaseClient) makeRequest(method, endpoint string, body interface{}) ([]byte, error) {
-	var reqBody io.Reader
+	return s.makeServiceRequest(method, "rest/v1/"+endpoint, body)
+}
+
+// makeUpsertRequest POSTs rows to a REST API table, merging them into
+// existing rows that conflict on the given columns. The columns need a
+// unique constraint.
+func (s *SupabaseClient) makeUpsertRequest(endpoint, onConflict string, body interface{}) ([]byte, error) {
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+	path := "rest/v1/" + endpoint + separator + "on_conflict=" + onConflict
+	return s.makePreferRequest("POST", path, "resolution=merge-duplicates,"+defaultPrefer, body)
+}
+
+// makeServiceRequest makes an authenticated request to any Supabase service
+// path, such as "rest/v1/messages" or "realtime/v1/api/broadcast". Transient
+// failures are retried with exponential backoff and jitter. Requests wait
+// for the rate limiter and fail fast while the circuit breaker is open.
+func (s *SupabaseClient) makeServiceRequest(method, path string, body interface{}) ([]byte, error) {
+	return s.makePreferRequest(method, path, defaultPrefer, body)
+}
+
+// makePreferRequest is makeServiceRequest with a custom Prefer header
+func (s *SupabaseClient) makePreferRequest(method, path, prefer string, body interface{}) ([]byte, error) {
+	respBody, _, err := s.makeHeaderRequest(method, path, http.Header{"Prefer": {prefer}}, body)
+	return respBody, err
+}
+
+// makeHeaderRequest is makeServiceRequest with custom request headers, such
+// as Prefer and Range, that also returns the response headers
+func (s *SupabaseClient) makeHeaderRequest(method, path string, header http.Header, body interface{}) ([]byte, http.Header, error) {
+	var jsonBody []byte
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
+		var err error
+		jsonBody, err = json.Marshal(body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal body: %v", err)
+			return nil, nil, fmt.Errorf("failed to marshal body: %v", err)
+		}
+		if jsonBody, err = s.columns.mapBody(path, jsonBody); err != nil {
+			return nil, nil, err
 		}
-		reqBody = bytes.NewBuffer(jsonBody)
+		if jsonBody, err = s.tenant.scopeBody(path, jsonBody); err != nil {
+			return nil, nil, err
+		}
+	}
+	path, err := s.columns.mapPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if path, err = s.tenant.scopePath(method, path); err != nil {
+		return nil, nil, err
+	}
+	// Tables are renamed last; the column map and responses use the
+	// bridge's table names
+	requestPath, err := s.tables.mapPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.tables != nil {
+		header = header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		s.tables.setProfile(method, path, header)
+	}
+
+	attempts := max(s.retryAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		if !s.breaker.Allow() {
+			return nil, nil, errSupabaseCircuitOpen
+		}
+		s.limiter.Wait()
+
+		respBody, respHeader, retryAfter, err := s.doRequest(method, requestPath, header, jsonBody)
+		s.breaker.Record(err)
+		if err == nil {
+			respBody, err = s.columns.unmapResponse(path, respBody)
+			return respBody, respHeader, err
+		}
+
+		// A request that may have run is only sent again when running it
+		// twice is harmless
+		apiErr, isAPIErr := err.(*SupabaseAPIError)
+		retryable := !isAPIErr || apiErr.Retryable()
+		if !idempotentRequest(method, header) {
+			retryable = (isAPIErr && apiErr.Unprocessed()) || (!isAPIErr && requestNotSent(err))
+		}
+		if attempt >= attempts || !retryable {
+			return nil, nil, err
+		}
+
+		time.Sleep(max(s.retryDelay(attempt), retryAfter))
+	}
+}
+
+// retryDelay returns the backoff before the given retry: the base delay
+// doubled per attempt, capped, with up to half of it as random jitter so
+// clients that failed together do not retry together
+func (s *SupabaseClient) retryDelay(attempt int) time.Duration {
+	delay := s.retryBaseDelay << (attempt - 1)
+	if delay <= 0 || (s.retryMaxDelay > 0 && delay > s.retryMaxDelay) {
+		delay = s.retryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// doRequest sends a single request. It returns the Retry-After delay of a
+// throttled response alongside the error.
+func (s *SupabaseClient) doRequest(method, path string, header http.Header, jsonBody []byte) ([]byte, http.Header, time.Duration, error) {
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
 	}
 
-	url := fmt.Sprintf("%s/rest/v1/%s", s.URL, endpoint)
+	url := fmt.Sprintf("%s/%s", s.URL, path)
 	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, nil, 0, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	req.Header.Set("apikey", s.Key)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Key))
+	if err := s.authorize(req); err != nil {
+		return nil, nil, 0, err
+	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "return=representation")
+	for name, values := range header {
+		req.Header[name] = values
+	}
 
+	started := time.Now()
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
+		err = fmt.Errorf("request failed: %w", err)
+		supabaseDebug().Record(req, jsonBody, 0, nil, started, err)
+		return nil, nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		err = fmt.Errorf("failed to read response: %v", err)
+		supabaseDebug().Record(req, jsonBody, resp.StatusCode, nil, started, err)
+		return nil, nil, 0, err
 	}
+	supabaseDebug().Record(req, jsonBody, resp.StatusCode, respBody, started, nil)
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+			if s.retryMaxDelay > 0 && retryAfter > s.retryMaxDelay {
+				retryAfter = s.retryMaxDelay
+			}
+		}
+		return nil, nil, retryAfter, &SupabaseAPIError{StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
-	return respBody, nil
+	return respBody, resp.Header, 0, nil
 }
 
 // Conversation represents a Supabase conversation record
@@ -97,15 +379,22 @@ type SupabaseMessage struct {
 	Metadata       map[string]interface{} `json:"metadata,omitempty"`
 	IsRead         bool                   `json:"is_read,omitempty"`
 	Status         *string                `json:"status,omitempty"`
+	// CreatedAt is when the message was sent in WhatsApp, which messages
+	// are ordered and filtered by
+	CreatedAt *time.Time `json:"created_at,omitempty"`
 }
 
 // GetOrCreateConversation gets an existing conversation or creates a new one
 func (s *SupabaseClient) GetOrCreateConversation(jid, name string) (string, error) {
+	if err := validateJID(jid); err != nil {
+		return "", err
+	}
+
 	// First, try to find existing conversation
-	endpoint := fmt.Sprintf("conversations?contact_identifier=eq.%s&channel=eq.whatsapp&select=id", jid)
+	endpoint := fmt.Sprintf("conversations?contact_identifier=eq.%s&channel=eq.whatsapp&select=id", pgValue(jid))
 	resp, err := s.makeRequest("GET", endpoint, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to query conversation: %v", err)
+		return "", fmt.Errorf("failed to query conversation: %w", err)
 	}
 
 	var conversations []struct {
@@ -123,7 +412,7 @@ func (s *SupabaseClient) GetOrCreateConversation(jid, name string) (string, erro
 	conv := Conversation{
 		Channel:           "whatsapp",
 		ContactIdentifier: jid,
-		Status:            "active",
+		Status:            ConversationActive,
 	}
 	if name != "" {
 		conv.ContactName = &name
@@ -131,7 +420,14 @@ func (s *SupabaseClient) GetOrCreateConversation(jid, name string) (string, erro
 
 	resp, err = s.makeRequest("POST", "conversations", conv)
 	if err != nil {
-		return "", fmt.Errorf("failed to create conversation: %v", err)
+		// Another writer created it first, the key of migration 0001
+		// rejecting the duplicate
+		if apiErr, ok := err.(*SupabaseAPIError); ok && apiErr.StatusCode == http.StatusConflict {
+			if resp, err := s.makeRequest("GET", endpoint, nil); err == nil && json.Unmarshal(resp, &conversations) == nil && len(conversations) > 0 {
+				return conversations[0].ID, nil
+			}
+		}
+		return "", fmt.Errorf("failed to create conversation: %w", err)
 	}
 
 	var newConversations []struct {
@@ -145,42 +441,127 @@ func (s *SupabaseClient) GetOrCreateConversation(jid, name string) (string, erro
 		return "", fmt.Errorf("no conversation returned after creation")
 	}
 
+	if !s.quiet {
+		emitEvent(EventConversationCreated, jid, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+
 	return newConversations[0].ID, nil
 }
 
-// UpdateConversationLastMessage updates the last_message_at timestamp for a conversation
+// UpdateConversationLastMessage updates the last_message_at timestamp for a
+// conversation. It is a single PATCH whose filter only matches older
+// values, so a late write of an older message cannot move the time back,
+// and it needs no version check as it changes nothing else.
 func (s *SupabaseClient) UpdateConversationLastMessage(conversationID string, timestamp time.Time) error {
-	update := map[string]interface{}{
-		"last_message_at": timestamp.Format(time.RFC3339),
-	}
-
-	endpoint := fmt.Sprintf("conversations?id=eq.%s", conversationID)
-	_, err := s.makeRequest("PATCH", endpoint, update)
+	value := timestamp.UTC().Format(time.RFC3339)
+	endpoint := fmt.Sprintf("conversations?id=eq.%s&or=%s", pgValue(conversationID),
+		pgValue("(last_message_at.is.null,last_message_at.lt."+pgQuote(value)+")"))
+	_, err := s.makePreferRequest("PATCH", "rest/v1/"+endpoint, "return=minimal", map[string]interface{}{"last_message_at": value})
 	return err
 }
 
 // UpdateConversationName updates the contact_name for a conversation
 func (s *SupabaseClient) UpdateConversationName(jid, name string) error {
-	update := map[string]interface{}{
-		"contact_name": name,
+	if err := validateJID(jid); err != nil {
+		return err
 	}
+	filter := fmt.Sprintf("contact_identifier=eq.%s&channel=eq.whatsapp", pgValue(jid))
+	return s.patchConversation(filter, func(current map[string]interface{}) map[string]interface{} {
+		if current != nil && current["contact_name"] == name {
+			return nil
+		}
+		return map[string]interface{}{
+			"contact_name": name,
+		}
+	})
+}
 
-	endpoint := fmt.Sprintf("conversations?contact_identifier=eq.%s&channel=eq.whatsapp", jid)
-	_, err := s.makeRequest("PATCH", endpoint, update)
-	return err
+// UpdateConversationSummary stores a generated summary on a conversation
+// unless a newer one is stored
+func (s *SupabaseClient) UpdateConversationSummary(jid, summary string, updatedAt time.Time) error {
+	if err := validateJID(jid); err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("contact_identifier=eq.%s&channel=eq.whatsapp", pgValue(jid))
+	return s.patchConversation(filter, func(current map[string]interface{}) map[string]interface{} {
+		if last, ok := conversationTime(current, "summary_updated_at"); ok && last.After(updatedAt) {
+			return nil
+		}
+		return map[string]interface{}{
+			"summary":            summary,
+			"summary_updated_at": updatedAt.Format(time.RFC3339),
+		}
+	})
 }
 
-// StoreMessage stores a message in Supabase
-func (s *SupabaseClient) StoreMessage(conversationID, externalID, sender, recipient, content string,
-	timestamp time.Time, isFromMe bool, mediaType string) error {
+// supabasePageSize is the number of rows requested per page in bulk reads
+const supabasePageSize = 1000
 
-	// Skip empty messages
-	if content == "" && mediaType == "" {
+// ListConversationIDs returns the conversation ID of every WhatsApp
+// conversation keyed by JID, reading the table in pages
+func (s *SupabaseClient) ListConversationIDs() (map[string]string, error) {
+	ids := make(map[string]string)
+
+	err := s.EachPage("conversations?channel=eq.whatsapp&select=id,contact_identifier", PageOptions{KeyColumn: "id"}, func(rows json.RawMessage) error {
+		var page []struct {
+			ID                string `json:"id"`
+			ContactIdentifier string `json:"contact_identifier"`
+		}
+		if err := json.Unmarshal(rows, &page); err != nil {
+			return fmt.Errorf("failed to parse conversations: %v", err)
+		}
+
+		for _, conv := range page {
+			ids[conv.ContactIdentifier] = conv.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+	return ids, nil
+}
+
+// ListConversationActivity returns the last_message_at of every WhatsApp
+// conversation keyed by JID, reading the table in pages. Conversations
+// without messages have a zero time.
+func (s *SupabaseClient) ListConversationActivity() (map[string]time.Time, error) {
+	chats := make(map[string]time.Time)
+
+	endpoint := "conversations?channel=eq.whatsapp&select=id,contact_identifier,last_message_at"
+	if softDeletes != nil {
+		endpoint += "&trashed_at=is.null"
+	}
+	err := s.EachPage(endpoint, PageOptions{KeyColumn: "id"}, func(rows json.RawMessage) error {
+		var page []struct {
+			ContactIdentifier string     `json:"contact_identifier"`
+			LastMessageAt     *time.Time `json:"last_message_at"`
+		}
+		if err := json.Unmarshal(rows, &page); err != nil {
+			return fmt.Errorf("failed to parse conversations: %v", err)
+		}
+
+		for _, conv := range page {
+			var lastMessageAt time.Time
+			if conv.LastMessageAt != nil {
+				lastMessageAt = *conv.LastMessageAt
+			}
+			chats[conv.ContactIdentifier] = lastMessageAt
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
 	}
+	return chats, nil
+}
 
+// newSupabaseMessage builds the messages row for a WhatsApp message. The
+// media download fields are kept in the metadata, binary ones base64
+// encoded, so GetMediaInfo can read them back.
+func newSupabaseMessage(conversationID string, record MessageRecord) SupabaseMessage {
 	direction := "inbound"
-	if isFromMe {
+	if record.IsFromMe {
 		direction = "outbound"
 	}
 
@@ -188,40 +569,247 @@ func (s *SupabaseClient) StoreMessage(conversationID, externalID, sender, recipi
 		ConversationID: conversationID,
 		Channel:        "whatsapp",
 		Direction:      direction,
-		Sender:         sender,
-		Recipient:      recipient,
+		Sender:         record.Sender,
+		Recipient:      record.ChatJID,
 	}
 
-	if content != "" {
-		msg.Body = &content
+	if record.Content != "" {
+		msg.Body = &record.Content
 	}
 
-	if externalID != "" {
-		msg.ExternalID = &externalID
+	if record.ID != "" {
+		msg.ExternalID = &record.ID
 	}
 
-	if mediaType != "" {
-		msg.Metadata = map[string]interface{}{
-			"media_type": mediaType,
-		}
+	createdAt := record.Timestamp.UTC()
+	if record.Timestamp.IsZero() {
+		createdAt = time.Now().UTC()
 	}
+	msg.CreatedAt = &createdAt
 
-	_, err := s.makeRequest("POST", "messages", msg)
+	metadata := make(map[string]interface{})
+	if record.MediaType != "" {
+		metadata["media_type"] = record.MediaType
+		metadata["filename"] = record.Filename
+		metadata["whatsapp_url"] = record.URL
+		metadata["media_key"] = base64.StdEncoding.EncodeToString(record.MediaKey)
+		metadata["file_sha256"] = base64.StdEncoding.EncodeToString(record.FileSHA256)
+		metadata["file_enc_sha256"] = base64.StdEncoding.EncodeToString(record.FileEncSHA256)
+		metadata["file_length"] = record.FileLength
+	}
+	if record.QuotedID != "" {
+		metadata["quoted_id"] = record.QuotedID
+	}
+	if record.QuotedSender != "" {
+		metadata["quoted_sender"] = record.QuotedSender
+	}
+	if record.QuotedSnippet != "" {
+		metadata["quoted_snippet"] = record.QuotedSnippet
+	}
+	if len(record.Mentions) > 0 {
+		metadata["mentioned_jids"] = record.Mentions
+	}
+	if record.MentionedMe {
+		metadata["mentioned_me"] = true
+	}
+	if record.Product != nil {
+		metadata["product"] = record.Product
+	}
+	if record.Status != "" {
+		msg.Status = &record.Status
+	}
+	if record.FailureReason != "" {
+		metadata["send_failure_reason"] = record.FailureReason
+	}
+	if len(metadata) > 0 {
+		msg.Metadata = getMetadataPolicy().Apply(metadata)
+	}
+
+	return msg
+}
+
+// StoreMessage stores a message in Supabase and reports whether it is new
+// rather than one stored before
+func (s *SupabaseClient) StoreMessage(conversationID string, record MessageRecord) (bool, error) {
+	// Skip empty messages
+	if record.Content == "" && record.MediaType == "" {
+		return false, nil
+	}
+
+	msg := newSupabaseMessage(conversationID, record)
+	// Receipts move live outbound messages on to delivered and read
+	if record.IsFromMe && msg.Status == nil {
+		status := SendStatusSent
+		msg.Status = &status
+	}
+
+	inserted, err := s.insertMessage(msg)
 	if err != nil {
-		return fmt.Errorf("failed to store message: %v", err)
+		return false, fmt.Errorf("failed to store message: %w", err)
 	}
 
 	// Update conversation last_message_at
-	_ = s.UpdateConversationLastMessage(conversationID, timestamp)
+	_ = s.UpdateConversationLastMessage(conversationID, record.Timestamp)
 
+	return inserted, nil
+}
+
+// insertMessage writes a live message row and reports whether it was
+// inserted. With upserts a message stored before is left alone by the
+// insert, then merged into its row, so only new rows count as inserted.
+func (s *SupabaseClient) insertMessage(msg SupabaseMessage) (bool, error) {
+	if !s.upsert {
+		_, err := s.makeRequest("POST", "messages", msg)
+		return err == nil, err
+	}
+
+	path := "rest/v1/messages?on_conflict=" + supabaseMessageConflict
+	resp, err := s.makePreferRequest("POST", path, "resolution=ignore-duplicates,"+defaultPrefer, msg)
+	if err != nil {
+		return false, err
+	}
+	var inserted []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &inserted); err != nil {
+		return false, fmt.Errorf("failed to parse message response: %v", err)
+	}
+	if len(inserted) > 0 {
+		return true, nil
+	}
+	_, err = s.makeUpsertRequest("messages", supabaseMessageConflict, msg)
+	return false, err
+}
+
+// supabaseMessageConflict is the unique key of a WhatsApp message row,
+// created with e.g.
+//
+//	ALTER TABLE messages ADD CONSTRAINT messages_external_id_channel_key UNIQUE (external_id, channel);
+const supabaseMessageConflict = "external_id,channel"
+
+// postMessages inserts one or more message rows, upserting by external ID
+// unless SUPABASE_UPSERT is false
+func (s *SupabaseClient) postMessages(endpoint string, body interface{}) ([]byte, error) {
+	if !s.upsert {
+		return s.makeRequest("POST", endpoint, body)
+	}
+	return s.makeUpsertRequest(endpoint, supabaseMessageConflict, body)
+}
+
+// supabaseMessageColumns lists the columns of a bulk message insert, so
+// rows that omit optional fields fall back to the column defaults
+const supabaseMessageColumns = "conversation_id,channel,direction,sender,recipient,body,external_id,metadata,created_at"
+
+// StoreMessages inserts messages with one POST per chunk of batchSize rows
+func (s *SupabaseClient) StoreMessages(messages []SupabaseMessage, batchSize int) error {
+	// An upsert cannot touch the same row twice in one statement
+	if s.upsert {
+		messages = dedupeMessages(messages)
+	}
+
+	batchSize = max(batchSize, 1)
+	for start := 0; start < len(messages); start += batchSize {
+		end := min(start+batchSize, len(messages))
+		if _, err := s.postMessages("messages?columns="+supabaseMessageColumns, messages[start:end]); err != nil {
+			return fmt.Errorf("failed to store messages %d-%d of %d: %w", start+1, end, len(messages), err)
+		}
+	}
 	return nil
 }
 
+// dedupeMessages keeps the last of messages sharing an external ID
+func dedupeMessages(messages []SupabaseMessage) []SupabaseMessage {
+	index := make(map[string]int)
+	deduped := messages[:0:0]
+	for _, msg := range messages {
+		if msg.ExternalID == nil {
+			deduped = append(deduped, msg)
+			continue
+		}
+		if i, ok := index[*msg.ExternalID]; ok {
+			deduped[i] = msg
+			continue
+		}
+		index[*msg.ExternalID] = len(deduped)
+		deduped = append(deduped, msg)
+	}
+	return deduped
+}
+
+// MergeMessageMetadata merges the given keys into the metadata of the message
+// with the given WhatsApp message ID in a conversation, keeping existing
+// keys intact. It calls the merge_message_metadata function of migration
+// 0007, which merges in one statement so concurrent merges keep each
+// other's keys; databases without it have the metadata read and written
+// back.
+func (s *SupabaseClient) MergeMessageMetadata(externalID, conversationID string, patch map[string]interface{}) error {
+	resp, err := s.makeRequest("POST", "rpc/merge_message_metadata", map[string]interface{}{
+		"message_id":   externalID,
+		"conversation": conversationID,
+		"patch":        getMetadataPolicy().Apply(patch),
+	})
+	if err == nil {
+		var found bool
+		if err := json.Unmarshal(resp, &found); err != nil {
+			return fmt.Errorf("failed to parse metadata response: %v", err)
+		}
+		if !found {
+			return fmt.Errorf("message %s: %w", externalID, errMessageNotFound)
+		}
+		return nil
+	}
+	if apiErr, ok := err.(*SupabaseAPIError); !ok || apiErr.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to merge message metadata: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("messages?external_id=eq.%s&channel=eq.whatsapp&conversation_id=eq.%s&select=id,metadata",
+		pgValue(externalID), pgValue(conversationID))
+	resp, err = s.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to query message: %v", err)
+	}
+
+	var messages []struct {
+		ID       string                 `json:"id"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal(resp, &messages); err != nil {
+		return fmt.Errorf("failed to parse message response: %v", err)
+	}
+
+	if len(messages) == 0 {
+		return fmt.Errorf("message %s: %w", externalID, errMessageNotFound)
+	}
+
+	metadata := messages[0].Metadata
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		metadata[key] = value
+	}
+
+	endpoint = fmt.Sprintf("messages?id=eq.%s", pgValue(messages[0].ID))
+	_, err = s.makeRequest("PATCH", endpoint, map[string]interface{}{"metadata": getMetadataPolicy().Apply(metadata)})
+	return err
+}
+
 // SupabaseMessageStore implements the message storage interface using Supabase
 type SupabaseMessageStore struct {
 	client *SupabaseClient
-	// Keep a cache of conversation IDs to avoid repeated lookups
-	conversationCache map[string]string
+	// Keep a cache of conversation IDs to avoid repeated lookups. History
+	// sync workers use it concurrently. It is bounded so long-running
+	// bridges do not grow it forever, and entries expire so IDs of
+	// conversations deleted or merged elsewhere are looked up again.
+	conversationCache *lruCache
+
+	// Realtime broadcast of new messages, one channel per conversation
+	realtimeBroadcast   bool
+	realtimeTopicPrefix string
+	realtimePrivate     bool
+
+	// Durable queue that message writes go through, nil when disabled
+	writeQueue *WriteQueue
 }
 
 // NewSupabaseMessageStore creates a new Supabase-backed message store
@@ -232,8 +820,11 @@ func NewSupabaseMessageStore() (*SupabaseMessageStore, error) {
 	}
 
 	return &SupabaseMessageStore{
-		client:            client,
-		conversationCache: make(map[string]string),
+		client:              client,
+		conversationCache:   newLRUCache(envInt("SUPABASE_CONVERSATION_CACHE_SIZE", 10000), envDuration("SUPABASE_CONVERSATION_CACHE_TTL", 24*time.Hour)),
+		realtimeBroadcast:   envBool("SUPABASE_REALTIME_BROADCAST", false),
+		realtimeTopicPrefix: envString("SUPABASE_REALTIME_TOPIC_PREFIX", "conversation:"),
+		realtimePrivate:     envBool("SUPABASE_REALTIME_PRIVATE", false),
 	}, nil
 }
 
@@ -242,15 +833,43 @@ func (s *SupabaseMessageStore) Close() error {
 	return nil
 }
 
-// StoreChat stores or updates a chat/conversation in Supabase
-func (s *SupabaseMessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
-	conversationID, err := s.client.GetOrCreateConversation(jid, name)
+// PreloadCache fills the conversation cache with a bulk read so the first
+// events after startup do not each need a lookup
+func (s *SupabaseMessageStore) PreloadCache() (int, error) {
+	ids, err := s.client.ListConversationIDs()
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	for jid, conversationID := range ids {
+		s.cacheConversationID(jid, conversationID)
 	}
+	return len(ids), nil
+}
+
+// cachedConversationID looks up a conversation ID in the cache
+func (s *SupabaseMessageStore) cachedConversationID(jid string) (string, bool) {
+	return s.conversationCache.Get(jid)
+}
+
+// cacheConversationID remembers the conversation ID of a chat
+func (s *SupabaseMessageStore) cacheConversationID(jid, conversationID string) {
+	s.conversationCache.Set(jid, conversationID)
+}
+
+// StoreChat stores or updates a chat/conversation in Supabase
+func (s *SupabaseMessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	conversationID, ok := s.cachedConversationID(jid)
+	if !ok {
+		var err error
+		conversationID, err = s.client.GetOrCreateConversation(jid, name)
+		if err != nil {
+			return err
+		}
 
-	// Cache the conversation ID
-	s.conversationCache[jid] = conversationID
+		// Cache the conversation ID
+		s.cacheConversationID(jid, conversationID)
+	}
 
 	// Update the name if provided
 	if name != "" {
@@ -261,47 +880,388 @@ func (s *SupabaseMessageStore) StoreChat(jid, name string, lastMessageTime time.
 	return s.client.UpdateConversationLastMessage(conversationID, lastMessageTime)
 }
 
-// StoreMessage stores a message in Supabase
+// StoreMessage stores a message in Supabase, through the write queue when
+// it is enabled
 func (s *SupabaseMessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
 	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
 
+	record := MessageRecord{
+		ID:            id,
+		ChatJID:       chatJID,
+		Sender:        sender,
+		Content:       content,
+		Timestamp:     timestamp,
+		IsFromMe:      isFromMe,
+		MediaType:     mediaType,
+		Filename:      filename,
+		URL:           url,
+		MediaKey:      mediaKey,
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		FileLength:    fileLength,
+	}
+	return s.storeRecord(record)
+}
+
+// storeRecord stores a live message, through the write queue when it is
+// enabled
+func (s *SupabaseMessageStore) storeRecord(record MessageRecord) error {
+	if s.writeQueue != nil {
+		return s.writeQueue.Enqueue([]MessageRecord{record}, false)
+	}
+	return s.writeMessage(record)
+}
+
+// writeMessage writes a single message to Supabase
+func (s *SupabaseMessageStore) writeMessage(record MessageRecord) error {
 	// Get or create conversation
-	conversationID, ok := s.conversationCache[chatJID]
+	conversationID, ok := s.cachedConversationID(record.ChatJID)
 	if !ok {
 		var err error
-		conversationID, err = s.client.GetOrCreateConversation(chatJID, "")
+		conversationID, err = s.client.GetOrCreateConversation(record.ChatJID, "")
 		if err != nil {
-			return fmt.Errorf("failed to get conversation: %v", err)
+			return fmt.Errorf("failed to get conversation: %w", err)
+		}
+		s.cacheConversationID(record.ChatJID, conversationID)
+	}
+
+	inserted, err := s.client.StoreMessage(conversationID, record)
+	if err != nil {
+		return err
+	}
+
+	// History goes through writeMessages, so only live inbound messages
+	// count as unread, and only once when they are redelivered
+	if inserted && !record.IsFromMe {
+		if err := s.client.IncrementUnreadCount(conversationID); err != nil {
+			fmt.Printf("Failed to update unread count of %s: %v\n", record.ChatJID, err)
+		}
+	}
+
+	// Notify web frontends without making them poll the messages table
+	if s.realtimeBroadcast && (record.Content != "" || record.MediaType != "") {
+		go s.broadcastNewMessage(conversationID, record.ChatJID, record.ID, record.Sender, record.Timestamp, record.IsFromMe, record.MediaType)
+	}
+
+	return nil
+}
+
+// StoreMessages stores history messages in bulk, through the write queue
+// when it is enabled
+func (s *SupabaseMessageStore) StoreMessages(records []MessageRecord) error {
+	if s.writeQueue != nil {
+		return s.writeQueue.Enqueue(records, true)
+	}
+	return s.writeMessages(records)
+}
+
+// writeMessages writes messages in chunks of SUPABASE_BATCH_SIZE rows and
+// moves each conversation's last_message_at to its newest message
+func (s *SupabaseMessageStore) writeMessages(records []MessageRecord) error {
+	var messages []SupabaseMessage
+	latest := make(map[string]time.Time)
+
+	for _, record := range records {
+		if record.Content == "" && record.MediaType == "" {
+			continue
+		}
+
+		conversationID, ok := s.cachedConversationID(record.ChatJID)
+		if !ok {
+			var err error
+			conversationID, err = s.client.GetOrCreateConversation(record.ChatJID, "")
+			if err != nil {
+				return fmt.Errorf("failed to get conversation: %w", err)
+			}
+			s.cacheConversationID(record.ChatJID, conversationID)
+		}
+
+		messages = append(messages, newSupabaseMessage(conversationID, record))
+		if record.Timestamp.After(latest[conversationID]) {
+			latest[conversationID] = record.Timestamp
+		}
+	}
+
+	if err := s.client.StoreMessages(messages, envInt("SUPABASE_BATCH_SIZE", 500)); err != nil {
+		return err
+	}
+
+	for conversationID, timestamp := range latest {
+		_ = s.client.UpdateConversationLastMessage(conversationID, timestamp)
+	}
+	return nil
+}
+
+// mergeMetadata merges keys into the metadata of a message of a chat
+func (s *SupabaseMessageStore) mergeMetadata(id, chatJID string, patch map[string]interface{}) error {
+	conversationID, err := s.conversationID(chatJID)
+	if err != nil {
+		return err
+	}
+	if conversationID == "" {
+		return fmt.Errorf("message %s: %w", id, errMessageNotFound)
+	}
+	return s.client.MergeMessageMetadata(id, conversationID, patch)
+}
+
+// StoreEnrichment stores classifier results in the message metadata
+func (s *SupabaseMessageStore) StoreEnrichment(id, chatJID, sentiment, language string) error {
+	return s.mergeMetadata(id, chatJID, map[string]interface{}{
+		"sentiment": sentiment,
+		"language":  language,
+	})
+}
+
+// StoreChatSummary stores a conversation summary in Supabase
+func (s *SupabaseMessageStore) StoreChatSummary(chatJID, summary string, updatedAt time.Time) error {
+	return s.client.UpdateConversationSummary(chatJID, summary, updatedAt)
+}
+
+// messageQueryFilters translates the cursor and filters of a message query
+// into PostgREST query parameters on the messages table, so they are
+// evaluated by the database
+func messageQueryFilters(query MessageQuery) url.Values {
+	params := url.Values{}
+	var orGroups []string
+
+	if !query.CursorTime.IsZero() {
+		ts := query.CursorTime.UTC().Format(time.RFC3339Nano)
+		orGroups = append(orGroups, fmt.Sprintf("or(created_at.lt.%s,and(created_at.eq.%s,external_id.lt.%s))", ts, ts, pgQuote(query.CursorID)))
+	}
+	if !query.After.IsZero() {
+		params.Add("created_at", "gt."+query.After.UTC().Format(time.RFC3339Nano))
+	}
+	if !query.Before.IsZero() {
+		params.Add("created_at", "lt."+query.Before.UTC().Format(time.RFC3339Nano))
+	}
+
+	switch query.Direction {
+	case DirectionIn:
+		params.Set("direction", "eq.inbound")
+	case DirectionOut:
+		params.Set("direction", "eq.outbound")
+	}
+
+	if len(query.MediaTypes) > 0 {
+		// Text messages carry no media_type in their metadata
+		var mediaTypes []string
+		includeText := false
+		for _, mediaType := range query.MediaTypes {
+			if mediaType == "text" {
+				includeText = true
+			} else {
+				mediaTypes = append(mediaTypes, pgQuote(mediaType))
+			}
+		}
+
+		var conditions []string
+		if includeText {
+			conditions = append(conditions, "metadata->>media_type.is.null")
 		}
-		s.conversationCache[chatJID] = conversationID
+		if len(mediaTypes) > 0 {
+			conditions = append(conditions, fmt.Sprintf("metadata->>media_type.in.(%s)", strings.Join(mediaTypes, ",")))
+		}
+		orGroups = append(orGroups, fmt.Sprintf("or(%s)", strings.Join(conditions, ",")))
+	}
+
+	if query.Pinned {
+		// Pin times are stored as UTC RFC 3339, which sorts as text
+		params.Set("metadata->>pinned_at", "not.is.null")
+		orGroups = append(orGroups, fmt.Sprintf("or(metadata->>pin_expires_at.is.null,metadata->>pin_expires_at.gt.%s)", time.Now().UTC().Format(time.RFC3339)))
+	}
+
+	if softDeletes != nil {
+		params.Set("trashed_at", "is.null")
+	}
+
+	if len(orGroups) > 0 {
+		params.Set("and", "("+strings.Join(orGroups, ",")+")")
+	}
+
+	return params
+}
+
+// supabaseMessageRow is a messages row as returned by GetMessages
+type supabaseMessageRow struct {
+	ExternalID string    `json:"external_id"`
+	Direction  string    `json:"direction"`
+	Sender     string    `json:"sender"`
+	Body       *string   `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	Metadata   struct {
+		MediaType     string     `json:"media_type"`
+		Filename      string     `json:"filename"`
+		QuotedID      string     `json:"quoted_id"`
+		QuotedSender  string     `json:"quoted_sender"`
+		QuotedSnippet string     `json:"quoted_snippet"`
+		MentionedJIDs []string   `json:"mentioned_jids"`
+		MentionedMe   bool       `json:"mentioned_me"`
+		PinnedAt      *time.Time `json:"pinned_at"`
+		PinnedBy      string     `json:"pinned_by"`
+		PinExpiresAt  *time.Time `json:"pin_expires_at"`
+	} `json:"metadata"`
+}
+
+// supabaseMessageColumnsSelect is the select list of supabaseMessageRow
+const supabaseMessageColumnsSelect = "external_id,direction,sender,body,created_at,metadata"
+
+// message converts a row to the bridge's Message
+func (row supabaseMessageRow) message(chatJID string) Message {
+	msg := Message{
+		ID:            row.ExternalID,
+		ChatJID:       chatJID,
+		Time:          row.CreatedAt,
+		Sender:        row.Sender,
+		IsFromMe:      row.Direction == "outbound",
+		MediaType:     row.Metadata.MediaType,
+		Filename:      row.Metadata.Filename,
+		QuotedID:      row.Metadata.QuotedID,
+		QuotedSender:  row.Metadata.QuotedSender,
+		QuotedSnippet: row.Metadata.QuotedSnippet,
+		Mentions:      row.Metadata.MentionedJIDs,
+		MentionedMe:   row.Metadata.MentionedMe,
+		PinnedAt:      row.Metadata.PinnedAt,
+		PinnedBy:      row.Metadata.PinnedBy,
+		PinExpiresAt:  row.Metadata.PinExpiresAt,
+	}
+	if row.Body != nil {
+		msg.Content = *row.Body
+	}
+	return msg
+}
+
+// ListMessages returns the messages of a conversation matching a query,
+// newest first
+func (s *SupabaseClient) ListMessages(conversationID string, query MessageQuery) ([]supabaseMessageRow, error) {
+	params := messageQueryFilters(query)
+	params.Set("conversation_id", "eq."+conversationID)
+	params.Set("select", supabaseMessageColumnsSelect)
+	params.Set("order", "created_at.desc,external_id.desc")
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	resp, err := s.makeRequest("GET", "messages?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %v", err)
+	}
+
+	var rows []supabaseMessageRow
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse messages: %v", err)
 	}
+	return rows, nil
+}
 
-	// Determine recipient (the other party in the conversation)
-	recipient := chatJID
-	if isFromMe {
-		recipient = chatJID
+// FindConversation returns the ID of a chat's conversation, or "" when the
+// chat has none
+func (s *SupabaseClient) FindConversation(jid string) (string, error) {
+	if err := validateJID(jid); err != nil {
+		return "", err
 	}
 
-	return s.client.StoreMessage(conversationID, id, sender, recipient, content, timestamp, isFromMe, mediaType)
+	endpoint := fmt.Sprintf("conversations?contact_identifier=eq.%s&channel=eq.whatsapp&select=id", pgValue(jid))
+	resp, err := s.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to query conversation: %v", err)
+	}
+
+	var conversations []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &conversations); err != nil {
+		return "", fmt.Errorf("failed to parse conversation response: %v", err)
+	}
+	if len(conversations) == 0 {
+		return "", nil
+	}
+	return conversations[0].ID, nil
 }
 
-// GetMessages retrieves messages from a chat (minimal implementation for compatibility)
-func (s *SupabaseMessageStore) GetMessages(chatJID string, limit int) ([]Message, error) {
-	// This would require additional API calls - for now, return empty
-	// The Python MCP server handles message retrieval
-	return []Message{}, nil
+// GetMessages retrieves messages from a chat, newest first. Messages are
+// ordered by created_at, the time they were sent in WhatsApp.
+func (s *SupabaseMessageStore) GetMessages(chatJID string, query MessageQuery) ([]Message, error) {
+	conversationID, err := s.conversationID(chatJID)
+	if err != nil {
+		return nil, err
+	}
+	// Reading a chat that was never stored must not create it
+	if conversationID == "" {
+		return []Message{}, nil
+	}
+
+	rows, err := s.client.ListMessages(conversationID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, row.message(chatJID))
+	}
+	return messages, nil
+}
+
+// conversationID returns the ID of a chat's conversation without creating
+// it, empty when the chat was never stored
+func (s *SupabaseMessageStore) conversationID(chatJID string) (string, error) {
+	if conversationID, ok := s.cachedConversationID(chatJID); ok {
+		return conversationID, nil
+	}
+	conversationID, err := s.client.FindConversation(chatJID)
+	if err != nil || conversationID == "" {
+		return "", err
+	}
+	s.cacheConversationID(chatJID, conversationID)
+	return conversationID, nil
+}
+
+// messageFilter returns the filter matching a WhatsApp message in its
+// chat's conversation, as message IDs are only unique within a chat. It
+// returns an empty filter when the chat was never stored.
+func (s *SupabaseMessageStore) messageFilter(id, chatJID string) (string, error) {
+	conversationID, err := s.conversationID(chatJID)
+	if err != nil || conversationID == "" {
+		return "", err
+	}
+	return fmt.Sprintf("external_id=eq.%s&channel=eq.whatsapp&conversation_id=eq.%s", pgValue(id), pgValue(conversationID)), nil
 }
 
-// GetChats retrieves all chats (minimal implementation for compatibility)
+// GetChats retrieves all WhatsApp conversations with their last message time
 func (s *SupabaseMessageStore) GetChats() (map[string]time.Time, error) {
-	// This would require additional API calls - for now, return empty
-	// The Python MCP server handles chat retrieval
-	return make(map[string]time.Time), nil
+	return s.client.ListConversationActivity()
 }
 
-// GetMediaInfo retrieves media info for a message (not stored in Supabase yet)
+// GetMediaInfo retrieves the media download fields kept in a message's
+// metadata
 func (s *SupabaseMessageStore) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
-	// Media info is stored in metadata - would need to query Supabase
-	// For now, return empty (media download will need the local SQLite fallback)
-	return "", "", "", nil, nil, nil, 0, fmt.Errorf("media info not available in Supabase")
+	endpoint := fmt.Sprintf("messages?external_id=eq.%s&channel=eq.whatsapp&select=metadata", pgValue(id))
+	resp, err := s.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("failed to query message: %v", err)
+	}
+
+	var messages []struct {
+		Metadata struct {
+			MediaType     string `json:"media_type"`
+			Filename      string `json:"filename"`
+			URL           string `json:"whatsapp_url"`
+			MediaKey      []byte `json:"media_key"`
+			FileSHA256    []byte `json:"file_sha256"`
+			FileEncSHA256 []byte `json:"file_enc_sha256"`
+			FileLength    uint64 `json:"file_length"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(resp, &messages); err != nil {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("failed to parse message response: %v", err)
+	}
+	if len(messages) == 0 {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("message %s not found", id)
+	}
+
+	media := messages[0].Metadata
+	if media.MediaType == "" {
+		return "", "", "", nil, nil, nil, 0, fmt.Errorf("message %s has no media", id)
+	}
+	return media.MediaType, media.Filename, media.URL, media.MediaKey, media.FileSHA256, media.FileEncSHA256, media.FileLength, nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// supabaseAuth decides the credentials of Supabase requests. By default the
+// API key is also the bearer token. Projects with row level security can
+// instead send a JWT carrying the claims their policies check, such as a
+// tenant ID, either given as is or minted with the project's JWT secret,
+// and add custom headers that policies read through
+// current_setting('request.headers').
+type supabaseAuth struct {
+	// token is a fixed bearer JWT from SUPABASE_JWT
+	token string
+
+	// secret signs minted tokens with claims; tokens are minted for ttl and
+	// reused until shortly before they expire
+	secret []byte
+	claims map[string]interface{}
+	ttl    time.Duration
+
+	headers map[string]string
+
+	mutex     sync.Mutex
+	minted    string
+	expiresAt time.Time
+}
+
+// newSupabaseAuth reads SUPABASE_JWT, or SUPABASE_JWT_SECRET with the JSON
+// object SUPABASE_JWT_CLAIMS, and the JSON object SUPABASE_HEADERS. It
+// returns nil when none are set, so requests use the API key.
+func newSupabaseAuth() (*supabaseAuth, error) {
+	a := &supabaseAuth{
+		token:  envString("SUPABASE_JWT", ""),
+		secret: []byte(envString("SUPABASE_JWT_SECRET", "")),
+		ttl:    envDuration("SUPABASE_JWT_TTL", time.Hour),
+	}
+
+	if raw := envString("SUPABASE_JWT_CLAIMS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &a.claims); err != nil {
+			return nil, fmt.Errorf("failed to parse SUPABASE_JWT_CLAIMS: %v", err)
+		}
+	}
+	if raw := envString("SUPABASE_HEADERS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &a.headers); err != nil {
+			return nil, fmt.Errorf("failed to parse SUPABASE_HEADERS: %v", err)
+		}
+	}
+
+	if a.token != "" && len(a.secret) > 0 {
+		return nil, fmt.Errorf("SUPABASE_JWT and SUPABASE_JWT_SECRET are mutually exclusive")
+	}
+	if a.claims != nil && len(a.secret) == 0 {
+		return nil, fmt.Errorf("SUPABASE_JWT_CLAIMS needs SUPABASE_JWT_SECRET to sign them")
+	}
+	if a.ttl < time.Minute {
+		a.ttl = time.Minute
+	}
+
+	if a.token == "" && len(a.secret) == 0 && len(a.headers) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// bearer returns the bearer token for requests, or "" to use the API key
+func (a *supabaseAuth) bearer() (string, error) {
+	if a == nil || (a.token == "" && len(a.secret) == 0) {
+		return "", nil
+	}
+	if a.token != "" {
+		return a.token, nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Mint a new token well before the old one expires so requests in
+	// flight or being retried are not rejected
+	now := time.Now()
+	if a.minted != "" && now.Add(a.ttl/4).Before(a.expiresAt) {
+		return a.minted, nil
+	}
+
+	expiresAt := now.Add(a.ttl)
+	token, err := signHS256JWT(a.secret, a.claims, now, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	a.minted, a.expiresAt = token, expiresAt
+	return token, nil
+}
+
+// signHS256JWT signs the claims as a JWT for the authenticated role unless
+// the claims name another role
+func signHS256JWT(secret []byte, claims map[string]interface{}, issuedAt, expiresAt time.Time) (string, error) {
+	payload := map[string]interface{}{"role": "authenticated"}
+	for name, value := range claims {
+		payload[name] = value
+	}
+	payload["iat"] = issuedAt.Unix()
+	payload["exp"] = expiresAt.Unix()
+
+	encodedPayload, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(encodedPayload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// accessToken returns the bearer token of Supabase requests
+func (s *SupabaseClient) accessToken() (string, error) {
+	token, err := s.auth.bearer()
+	if err != nil || token != "" {
+		return token, err
+	}
+	return s.Key, nil
+}
+
+// authorize sets the credentials and custom headers of a Supabase request
+func (s *SupabaseClient) authorize(req *http.Request) error {
+	token, err := s.accessToken()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("apikey", s.Key)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if s.auth != nil {
+		for name, value := range s.auth.headers {
+			req.Header.Set(name, value)
+		}
+	}
+	return nil
+}
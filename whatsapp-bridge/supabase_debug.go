@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SupabaseExchange is one request to Supabase and its response, as kept by
+// the debug recorder. Bodies are truncated and credentials redacted.
+type SupabaseExchange struct {
+	Time           time.Time         `json:"time"`
+	Method         string            `json:"method"`
+	URL            string            `json:"url"`
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	RequestBody    string            `json:"request_body,omitempty"`
+	// Status is 0 when no response arrived
+	Status       int    `json:"status,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+	DurationMs   int64  `json:"duration_ms"`
+	Error        string `json:"error,omitempty"`
+}
+
+// redactedHeaders are the request headers carrying credentials
+var redactedHeaders = map[string]bool{"Apikey": true, "Authorization": true, "Cookie": true}
+
+// secretPattern matches JWTs and Supabase API keys in bodies, such as the
+// tokens of an auth response
+var secretPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|sb_(?:secret|publishable)_[A-Za-z0-9_-]+`)
+
+// supabaseRecorder keeps the last exchanges with Supabase in a ring buffer,
+// to see what was sent and answered for a row that did not appear. It is
+// nil, recording nothing, unless SUPABASE_DEBUG_RECORD is set.
+type supabaseRecorder struct {
+	bodyLimit int
+
+	mutex sync.Mutex
+	// exchanges is the ring, next the slot the next exchange goes in
+	exchanges []SupabaseExchange
+	next      int
+	full      bool
+}
+
+var (
+	sharedSupabaseRecorder *supabaseRecorder
+	supabaseRecorderOnce   sync.Once
+)
+
+// supabaseDebug returns the recorder shared by every Supabase client, nil
+// when recording is disabled
+func supabaseDebug() *supabaseRecorder {
+	supabaseRecorderOnce.Do(func() {
+		sharedSupabaseRecorder = newSupabaseRecorder(envInt("SUPABASE_DEBUG_RECORD", 0), envInt("SUPABASE_DEBUG_BODY_LIMIT", 2048))
+	})
+	return sharedSupabaseRecorder
+}
+
+// newSupabaseRecorder returns a recorder of the last size exchanges, with
+// bodies cut to bodyLimit bytes. It returns nil when size is not positive.
+func newSupabaseRecorder(size, bodyLimit int) *supabaseRecorder {
+	if size <= 0 {
+		return nil
+	}
+	return &supabaseRecorder{bodyLimit: max(bodyLimit, 0), exchanges: make([]SupabaseExchange, size)}
+}
+
+// Record adds a sent request and its response. status is 0 and body nil
+// when the request failed before a response.
+func (r *supabaseRecorder) Record(req *http.Request, reqBody []byte, status int, respBody []byte, started time.Time, err error) {
+	if r == nil {
+		return
+	}
+
+	// The credentials of this request are removed wherever they appear,
+	// not only where they look like a key
+	var secrets []string
+	if key := req.Header.Get("apikey"); key != "" {
+		secrets = append(secrets, key)
+	}
+	if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" {
+		secrets = append(secrets, token)
+	}
+	redact := func(s string) string {
+		for _, secret := range secrets {
+			s = strings.ReplaceAll(s, secret, "[redacted]")
+		}
+		return secretPattern.ReplaceAllString(s, "[redacted]")
+	}
+
+	exchange := SupabaseExchange{
+		Time:           started,
+		Method:         req.Method,
+		URL:            redact(req.URL.String()),
+		RequestHeaders: make(map[string]string, len(req.Header)),
+		RequestBody:    r.truncate(redact(string(reqBody))),
+		Status:         status,
+		ResponseBody:   r.truncate(redact(string(respBody))),
+		DurationMs:     time.Since(started).Milliseconds(),
+	}
+	for name, values := range req.Header {
+		value := strings.Join(values, ", ")
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[redacted]"
+		}
+		exchange.RequestHeaders[name] = redact(value)
+	}
+	if err != nil {
+		exchange.Error = redact(err.Error())
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.exchanges[r.next] = exchange
+	r.next = (r.next + 1) % len(r.exchanges)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// truncate cuts a body to bodyLimit bytes, noting the size of a cut body.
+// Bodies are redacted first, so no part of a cut secret is kept.
+func (r *supabaseRecorder) truncate(body string) string {
+	if len(body) <= r.bodyLimit {
+		return body
+	}
+	return truncateUTF8(body, r.bodyLimit) + fmt.Sprintf("... (%d bytes)", len(body))
+}
+
+// Exchanges returns up to limit recorded exchanges, newest first, all of
+// them when limit is not positive
+func (r *supabaseRecorder) Exchanges(limit int) []SupabaseExchange {
+	exchanges := []SupabaseExchange{}
+	if r == nil {
+		return exchanges
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.exchanges)
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	for i := 1; i <= count; i++ {
+		exchanges = append(exchanges, r.exchanges[(r.next-i+len(r.exchanges))%len(r.exchanges)])
+	}
+	return exchanges
+}
+
+// Clear drops the recorded exchanges
+func (r *supabaseRecorder) Clear() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	clear(r.exchanges)
+	r.next, r.full = 0, false
+}
+
+// SupabaseDebugResponse represents the response for the Supabase debug API
+type SupabaseDebugResponse struct {
+	Success   bool               `json:"success"`
+	Message   string             `json:"message,omitempty"`
+	Exchanges []SupabaseExchange `json:"exchanges,omitempty"`
+}
+
+// handleSupabaseDebug serves /api/admin/supabase-debug. GET returns the
+// recorded exchanges newest first, up to ?limit=, and DELETE clears them.
+func handleSupabaseDebug(w http.ResponseWriter, r *http.Request) {
+	recorder := supabaseDebug()
+	if recorder == nil {
+		http.Error(w, "Supabase debug recording is disabled, set SUPABASE_DEBUG_RECORD", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		json.NewEncoder(w).Encode(SupabaseDebugResponse{Success: true, Exchanges: recorder.Exchanges(limit)})
+	case http.MethodDelete:
+		recorder.Clear()
+		json.NewEncoder(w).Encode(SupabaseDebugResponse{Success: true, Message: "Recorded exchanges cleared"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
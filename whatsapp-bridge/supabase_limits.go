@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errSupabaseCircuitOpen is returned without contacting Supabase while the
+// circuit breaker is open
+var errSupabaseCircuitOpen = fmt.Errorf("Supabase is unavailable, requests are paused after repeated failures")
+
+// tokenBucket spaces out requests to a sustained rate while allowing short
+// bursts, so history syncs do not trip the project's rate limits
+type tokenBucket struct {
+	// rate is the number of tokens added per second
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket allowing rate requests per second with
+// bursts of up to burst requests. It returns nil, an unlimited bucket,
+// when rate is not positive.
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst = max(burst, 1)
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available and takes it
+func (b *tokenBucket) Wait() {
+	if b == nil {
+		return
+	}
+	for {
+		delay := b.take()
+		if delay == 0 {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// take takes a token if one is available, otherwise it returns how long
+// until the next one is
+func (b *tokenBucket) take() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// circuitBreaker stops sending requests to Supabase after a run of
+// consecutive failures. Once the cooldown has passed a single trial request
+// is let through: its success closes the breaker, its failure opens it for
+// another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	// probing is set while the trial request after a cooldown is in flight
+	probing bool
+}
+
+// newCircuitBreaker returns a breaker opening after threshold consecutive
+// failures. It returns nil, a breaker that never opens, when threshold is
+// not positive.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may be sent now
+func (c *circuitBreaker) Allow() bool {
+	if c == nil {
+		return true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failures < c.threshold {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// Record updates the breaker with the outcome of a request. Only failures
+// that say Supabase itself is struggling count; a rejected request does not.
+func (c *circuitBreaker) Record(err error) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.probing = false
+	if apiErr, ok := err.(*SupabaseAPIError); err == nil || (ok && !apiErr.Retryable()) {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures >= c.threshold {
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+}
+
+// Open reports whether requests are currently being refused
+func (c *circuitBreaker) Open() bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.failures >= c.threshold && time.Now().Before(c.openUntil)
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types a mapped column can be converted to
+const (
+	// ColumnText stores JSON values such as metadata as text
+	ColumnText = "text"
+	// ColumnUnix stores timestamps as Unix seconds
+	ColumnUnix = "unix"
+	// ColumnOmit drops the column for tables that do not have it
+	ColumnOmit = "omit"
+)
+
+// ColumnMapping names the column a bridge column is stored in and how its
+// values are converted. It is written in the config as the column name
+// alone, or as an object with column and type.
+type ColumnMapping struct {
+	Column string `json:"column"`
+	Type   string `json:"type,omitempty"`
+}
+
+// UnmarshalJSON accepts "content" as well as {"column": "content"}
+func (m *ColumnMapping) UnmarshalJSON(data []byte) error {
+	var column string
+	if err := json.Unmarshal(data, &column); err == nil {
+		m.Column = column
+		return nil
+	}
+
+	type mapping ColumnMapping
+	return json.Unmarshal(data, (*mapping)(m))
+}
+
+// supabaseColumnMap lets the bridge write to and read from existing tables,
+// such as those of a CRM, whose columns have other names or types than the
+// bridge's. Column names are rewritten in request bodies, filters, select
+// and order lists and back in responses, so the rest of the bridge keeps
+// using its own names.
+type supabaseColumnMap struct {
+	// tables maps bridge column names per table
+	tables map[string]map[string]ColumnMapping
+	// reverse maps stored column names back per table
+	reverse map[string]map[string]string
+}
+
+// newSupabaseColumnMap reads the mapping from SUPABASE_COLUMN_MAP, or the
+// file named by SUPABASE_COLUMN_MAP_FILE, as JSON like
+// {"messages": {"body": "content", "metadata": {"column": "extra", "type": "text"}},
+// "conversations": {"contact_identifier": "phone", "unread_count": {"type": "omit"}}}.
+// It returns nil when neither is set.
+func newSupabaseColumnMap() (*supabaseColumnMap, error) {
+	raw := envString("SUPABASE_COLUMN_MAP", "")
+	if path := envString("SUPABASE_COLUMN_MAP_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SUPABASE_COLUMN_MAP_FILE: %v", err)
+		}
+		raw = string(data)
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	var tables map[string]map[string]ColumnMapping
+	if err := json.Unmarshal([]byte(raw), &tables); err != nil {
+		return nil, fmt.Errorf("failed to parse column map: %v", err)
+	}
+
+	m := &supabaseColumnMap{tables: tables, reverse: make(map[string]map[string]string)}
+	for table, columns := range tables {
+		m.reverse[table] = make(map[string]string)
+		for name, mapping := range columns {
+			switch mapping.Type {
+			case "", ColumnText, ColumnUnix, ColumnOmit:
+			default:
+				return nil, fmt.Errorf("column %s.%s has unknown type %q", table, name, mapping.Type)
+			}
+			if mapping.Column == "" {
+				mapping.Column = name
+				columns[name] = mapping
+			}
+			if !columnNamePattern.MatchString(mapping.Column) {
+				return nil, fmt.Errorf("column %s.%s maps to invalid name %q", table, name, mapping.Column)
+			}
+			m.reverse[table][mapping.Column] = name
+		}
+	}
+	return m, nil
+}
+
+// column returns the mapping of a bridge column
+func (m *supabaseColumnMap) column(table, name string) ColumnMapping {
+	if mapping, ok := m.tables[table][name]; ok {
+		return mapping
+	}
+	return ColumnMapping{Column: name}
+}
+
+// rename maps a column reference, which may be a JSON path into the column
+// such as metadata->>media_type. Only the column before the path is mapped;
+// a path into a column stored as text cannot be followed and is an error.
+func (m *supabaseColumnMap) rename(table, ref string) (string, error) {
+	name, path := ref, ""
+	if i := strings.Index(ref, "->"); i >= 0 {
+		name, path = ref[:i], ref[i:]
+	}
+	mapping := m.column(table, name)
+	if path != "" && mapping.Type == ColumnText {
+		return "", fmt.Errorf("column %s.%s is stored as text, %s cannot be queried", table, name, ref)
+	}
+	return mapping.Column + path, nil
+}
+
+// splitList splits a select list at the commas outside the parentheses of
+// embedded resources
+func splitList(list string) []string {
+	var entries []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				entries = append(entries, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(entries, list[start:])
+}
+
+// renameList maps a comma separated column list, such as select or
+// columns, dropping omitted columns. Entries of an order list keep their
+// direction suffix, select entries their alias and cast, and embedded
+// resources such as conversations(id,contact_identifier) have their
+// columns mapped with the mapping of their own table.
+func (m *supabaseColumnMap) renameList(table, list string) (string, error) {
+	var mapped []string
+	for _, entry := range splitList(list) {
+		if open := strings.Index(entry, "("); open >= 0 && strings.HasSuffix(entry, ")") {
+			head := entry[:open]
+			relation := head[strings.LastIndex(head, ":")+1:]
+			relation, _, _ = strings.Cut(relation, "!")
+			columns, err := m.renameList(relation, entry[open+1:len(entry)-1])
+			if err != nil {
+				return "", err
+			}
+			mapped = append(mapped, head+"("+columns+")")
+			continue
+		}
+
+		alias := ""
+		if i := strings.Index(entry, ":"); i >= 0 && !strings.HasPrefix(entry[i:], "::") {
+			alias, entry = entry[:i+1], entry[i+1:]
+		}
+		entry, cast, _ := strings.Cut(entry, "::")
+		if cast != "" {
+			cast = "::" + cast
+		}
+		ref, suffix, _ := strings.Cut(entry, ".")
+		name, _, _ := strings.Cut(ref, "->")
+		if m.column(table, name).Type == ColumnOmit {
+			continue
+		}
+		if suffix != "" {
+			suffix = "." + suffix
+		}
+		renamed, err := m.rename(table, ref)
+		if err != nil {
+			return "", err
+		}
+		mapped = append(mapped, alias+renamed+cast+suffix)
+	}
+	return strings.Join(mapped, ","), nil
+}
+
+// logicColumnPattern matches the column of each condition in an and()/or()
+// tree. Values are quoted or follow an operator, so they never match.
+var logicColumnPattern = regexp.MustCompile(`([(,])([A-Za-z_][A-Za-z0-9_]*)((?:->>?[A-Za-z0-9_]+)*)\.(eq|neq|gt|gte|lt|lte|like|ilike|is|in|cs|cd|not)\.`)
+
+// mapPath rewrites the column names in the query of a REST API path
+func (m *supabaseColumnMap) mapPath(path string) (string, error) {
+	if m == nil || !strings.HasPrefix(path, "rest/v1/") {
+		return path, nil
+	}
+
+	endpoint, rawQuery, _ := strings.Cut(path, "?")
+	table := strings.TrimPrefix(endpoint, "rest/v1/")
+	if _, ok := m.tables[table]; !ok || rawQuery == "" {
+		return path, nil
+	}
+
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query of %s: %v", table, err)
+	}
+
+	mapped := url.Values{}
+	for key, values := range params {
+		for _, value := range values {
+			switch key {
+			case "select", "columns", "on_conflict", "order":
+				list, err := m.renameList(table, value)
+				if err != nil {
+					return "", err
+				}
+				mapped.Add(key, list)
+			case "and", "or", "not.and", "not.or":
+				var renameErr error
+				mapped.Add(key, logicColumnPattern.ReplaceAllStringFunc(value, func(match string) string {
+					parts := logicColumnPattern.FindStringSubmatch(match)
+					column, err := m.rename(table, parts[2]+parts[3])
+					if err != nil {
+						renameErr = err
+					}
+					return parts[1] + column + "." + parts[4] + "."
+				}))
+				if renameErr != nil {
+					return "", renameErr
+				}
+			case "limit", "offset":
+				mapped.Add(key, value)
+			default:
+				// Filters on embedded resources, such as
+				// conversations.status, use the resource's mapping
+				filterTable, column := table, key
+				if relation, name, ok := strings.Cut(key, "."); ok {
+					filterTable, column = relation, name
+				}
+				renamed, err := m.rename(filterTable, column)
+				if err != nil {
+					return "", err
+				}
+				if filterTable != table {
+					renamed = filterTable + "." + renamed
+				}
+				mapped.Add(renamed, m.filterValue(filterTable, column, value))
+			}
+		}
+	}
+	return endpoint + "?" + mapped.Encode(), nil
+}
+
+// filterValue converts the timestamp of a filter such as
+// "gt.2024-01-01T00:00:00Z" on a Unix seconds column
+func (m *supabaseColumnMap) filterValue(table, key, value string) string {
+	if m.column(table, key).Type != ColumnUnix {
+		return value
+	}
+	operator, operand, ok := strings.Cut(value, ".")
+	if !ok {
+		return value
+	}
+	if t, err := time.Parse(time.RFC3339Nano, operand); err == nil {
+		return operator + "." + strconv.FormatInt(t.Unix(), 10)
+	}
+	return value
+}
+
+// mapBody renames and converts the columns of the row or rows of a write
+func (m *supabaseColumnMap) mapBody(path string, jsonBody []byte) ([]byte, error) {
+	table := supabaseTable(path)
+	if m == nil || jsonBody == nil || m.tables[table] == nil {
+		return jsonBody, nil
+	}
+
+	body, err := decodeJSONNumbers(jsonBody)
+	if err != nil {
+		return nil, err
+	}
+
+	mapRow := func(row map[string]interface{}) (map[string]interface{}, error) {
+		mapped := make(map[string]interface{}, len(row))
+		for name, value := range row {
+			mapping := m.column(table, name)
+			switch mapping.Type {
+			case ColumnOmit:
+				continue
+			case ColumnText:
+				if _, ok := value.(string); !ok && value != nil {
+					encoded, err := json.Marshal(value)
+					if err != nil {
+						return nil, err
+					}
+					value = string(encoded)
+				}
+			case ColumnUnix:
+				if s, ok := value.(string); ok {
+					if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+						value = t.Unix()
+					}
+				}
+			}
+			mapped[mapping.Column] = value
+		}
+		return mapped, nil
+	}
+
+	switch rows := body.(type) {
+	case map[string]interface{}:
+		if body, err = mapRow(rows); err != nil {
+			return nil, err
+		}
+	case []interface{}:
+		for i, row := range rows {
+			if row, ok := row.(map[string]interface{}); ok {
+				if rows[i], err = mapRow(row); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return json.Marshal(body)
+}
+
+// unmapResponse renames the columns of returned rows back to the bridge's
+// names and converts their values back
+func (m *supabaseColumnMap) unmapResponse(path string, respBody []byte) ([]byte, error) {
+	table := supabaseTable(path)
+	if m == nil || len(respBody) == 0 || m.tables[table] == nil {
+		return respBody, nil
+	}
+
+	body, err := decodeJSONNumbers(respBody)
+	if err != nil {
+		// Not JSON rows, such as an empty response to return=minimal
+		return respBody, nil
+	}
+	rows, ok := body.([]interface{})
+	if !ok {
+		return respBody, nil
+	}
+
+	for i, row := range rows {
+		if row, ok := row.(map[string]interface{}); ok {
+			rows[i] = m.unmapRow(table, row)
+		}
+	}
+	return json.Marshal(rows)
+}
+
+// unmapRow renames the columns of a returned row back, along with those of
+// the rows of embedded resources of mapped tables
+func (m *supabaseColumnMap) unmapRow(table string, row map[string]interface{}) map[string]interface{} {
+	unmapped := make(map[string]interface{}, len(row))
+	for column, value := range row {
+		name, ok := m.reverse[table][column]
+		if !ok {
+			name = column
+		}
+		if _, embedded := m.tables[name]; embedded {
+			switch nested := value.(type) {
+			case map[string]interface{}:
+				value = m.unmapRow(name, nested)
+			case []interface{}:
+				for j, item := range nested {
+					if item, ok := item.(map[string]interface{}); ok {
+						nested[j] = m.unmapRow(name, item)
+					}
+				}
+			}
+			unmapped[name] = value
+			continue
+		}
+		switch m.column(table, name).Type {
+		case ColumnText:
+			if s, ok := value.(string); ok && (strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")) {
+				var decoded interface{}
+				if json.Unmarshal([]byte(s), &decoded) == nil {
+					value = decoded
+				}
+			}
+		case ColumnUnix:
+			if n, ok := value.(json.Number); ok {
+				if seconds, err := n.Int64(); err == nil {
+					value = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+				}
+			}
+		}
+		unmapped[name] = value
+	}
+	return unmapped
+}
+
+// supabaseTable returns the table a REST API path addresses, or ""
+func supabaseTable(path string) string {
+	if !strings.HasPrefix(path, "rest/v1/") {
+		return ""
+	}
+	endpoint, _, _ := strings.Cut(path, "?")
+	return strings.TrimPrefix(endpoint, "rest/v1/")
+}
+
+// decodeJSONNumbers decodes JSON keeping numbers as written, large ones do
+// not survive a float64
+func decodeJSONNumbers(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode body: %v", err)
+	}
+	return body, nil
+}
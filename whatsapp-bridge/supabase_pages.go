@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PageOptions selects a page of a REST API read. Pages are cut with a Range
+// header. With KeyColumn set the rows are ordered on that column and the
+// page starts after the key of the previous page's last row, which unlike
+// an offset stays correct and fast however deep the page is. The endpoint
+// should then not have an order of its own.
+type PageOptions struct {
+	// Limit is the number of rows of a page, supabasePageSize when zero
+	Limit int
+	// Offset skips rows before the page
+	Offset int
+	// KeyColumn is a unique column to page on, such as id
+	KeyColumn string
+	// Descending pages from the highest key down
+	Descending bool
+	// After is the key of the last row of the previous page
+	After string
+	// Count asks for the number of rows matching the endpoint's filters
+	Count bool
+}
+
+// Page is one page of rows
+type Page struct {
+	Rows json.RawMessage
+	// Size is the number of rows of the page
+	Size int
+	// Total is the number of matching rows, or -1 when not counted
+	Total int
+	// NextCursor is the key of the page's last row to pass as After, or ""
+	// when this is the last page
+	NextCursor string
+}
+
+// GetPage reads a page of rows from an endpoint such as
+// "messages?conversation_id=eq.<id>&select=id,body"
+func (s *SupabaseClient) GetPage(endpoint string, options PageOptions) (*Page, error) {
+	limit := options.Limit
+	if limit <= 0 {
+		limit = supabasePageSize
+	}
+
+	if options.KeyColumn != "" {
+		if !columnNamePattern.MatchString(options.KeyColumn) {
+			return nil, fmt.Errorf("invalid key column %q", options.KeyColumn)
+		}
+		direction, operator := "asc", "gt"
+		if options.Descending {
+			direction, operator = "desc", "lt"
+		}
+		separator := "?"
+		if strings.Contains(endpoint, "?") {
+			separator = "&"
+		}
+		endpoint += separator + "order=" + options.KeyColumn + "." + direction
+		if options.After != "" {
+			endpoint += "&" + options.KeyColumn + "=" + operator + "." + pgValue(options.After)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Range-Unit", "items")
+	header.Set("Range", fmt.Sprintf("%d-%d", options.Offset, options.Offset+limit-1))
+	if options.Count {
+		header.Set("Prefer", "count=exact")
+	}
+
+	resp, respHeader, err := s.makeHeaderRequest("GET", "rest/v1/"+endpoint, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+	if body, err := decodeJSONNumbers(resp); err != nil {
+		return nil, fmt.Errorf("failed to parse page: %v", err)
+	} else if list, ok := body.([]interface{}); ok {
+		for _, row := range list {
+			if row, ok := row.(map[string]interface{}); ok {
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	page := &Page{Rows: resp, Size: len(rows), Total: -1}
+	if options.Count {
+		page.Total = contentRangeTotal(respHeader.Get("Content-Range"))
+	}
+	if options.KeyColumn != "" && len(rows) == limit {
+		key, ok := rows[len(rows)-1][options.KeyColumn]
+		if !ok || key == nil {
+			return nil, fmt.Errorf("page rows do not select the key column %s", options.KeyColumn)
+		}
+		page.NextCursor = fmt.Sprint(key)
+	}
+	return page, nil
+}
+
+// EachPage calls fn with the rows of every page of an endpoint in turn,
+// paging on the key column when one is set and by offset otherwise
+func (s *SupabaseClient) EachPage(endpoint string, options PageOptions, fn func(rows json.RawMessage) error) error {
+	for {
+		page, err := s.GetPage(endpoint, options)
+		if err != nil {
+			return err
+		}
+		if page.Size > 0 {
+			if err := fn(page.Rows); err != nil {
+				return err
+			}
+		}
+		// Only the first page needs counting
+		options.Count = false
+
+		if options.KeyColumn != "" {
+			if page.NextCursor == "" {
+				return nil
+			}
+			options.After, options.Offset = page.NextCursor, 0
+			continue
+		}
+
+		limit := options.Limit
+		if limit <= 0 {
+			limit = supabasePageSize
+		}
+		if page.Size < limit {
+			return nil
+		}
+		options.Offset += limit
+	}
+}
+
+// contentRangeTotal reads the total of a Content-Range header such as
+// "0-24/3573", or returns -1 when it is unknown
+func contentRangeTotal(contentRange string) int {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.Atoi(total)
+	if err != nil {
+		return -1
+	}
+	return n
+}
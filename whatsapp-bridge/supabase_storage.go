@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// UploadObject uploads a local file to a Storage bucket, replacing any
+// object at the same path. Transient failures are retried, and the rate
+// limiter and circuit breaker apply, like other Supabase requests.
+func (s *SupabaseClient) UploadObject(bucket, objectPath, contentType, localPath string) error {
+	endpoint := fmt.Sprintf("%s/storage/v1/object/%s/%s", s.URL, bucket, escapeObjectPath(objectPath))
+
+	attempts := max(s.retryAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		if !s.breaker.Allow() {
+			return errSupabaseCircuitOpen
+		}
+		s.limiter.Wait()
+
+		err := s.uploadObject(endpoint, contentType, localPath)
+		s.breaker.Record(err)
+		if err == nil {
+			return nil
+		}
+
+		apiErr, isAPIErr := err.(*SupabaseAPIError)
+		if attempt >= attempts || (isAPIErr && !apiErr.Retryable()) {
+			return err
+		}
+		time.Sleep(s.retryDelay(attempt))
+	}
+}
+
+// uploadObject streams the file in a single upload request
+func (s *SupabaseClient) uploadObject(endpoint, contentType, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.ContentLength = info.Size()
+	if err := s.authorize(req); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("x-upsert", "true")
+
+	// The file itself is not recorded, only the upload's response
+	started := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("request failed: %v", err)
+		supabaseDebug().Record(req, nil, 0, nil, started, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	supabaseDebug().Record(req, nil, resp.StatusCode, body, started, nil)
+	if resp.StatusCode >= 400 {
+		return &SupabaseAPIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// SignObjectURL returns a time-limited URL for an object in a private bucket
+func (s *SupabaseClient) SignObjectURL(bucket, objectPath string, ttl time.Duration) (string, error) {
+	body := map[string]interface{}{"expiresIn": int(ttl.Seconds())}
+	resp, err := s.makeServiceRequest("POST", fmt.Sprintf("storage/v1/object/sign/%s/%s", bucket, escapeObjectPath(objectPath)), body)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign object URL: %v", err)
+	}
+
+	var result struct {
+		SignedURL string `json:"signedURL"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return "", fmt.Errorf("failed to parse signed URL: %v", err)
+	}
+	if result.SignedURL == "" {
+		return "", fmt.Errorf("no signed URL returned")
+	}
+	return s.URL + "/storage/v1" + result.SignedURL, nil
+}
+
+// PublicObjectURL returns the URL of an object in a public bucket
+func (s *SupabaseClient) PublicObjectURL(bucket, objectPath string) string {
+	return fmt.Sprintf("%s/storage/v1/object/public/%s/%s", s.URL, bucket, escapeObjectPath(objectPath))
+}
+
+// escapeObjectPath escapes each segment of an object path
+func escapeObjectPath(objectPath string) string {
+	segments := strings.Split(objectPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// SupabaseMediaStorage copies downloaded media to a Supabase Storage bucket.
+// The object URL is kept in the message metadata, and signed media URLs
+// point at the bucket instead of the bridge.
+type SupabaseMediaStorage struct {
+	*mediaDownloader
+	store  *SupabaseMessageStore
+	bucket string
+	public bool
+	urlTTL time.Duration
+}
+
+// NewSupabaseMediaStorage enables uploads to SUPABASE_STORAGE_BUCKET and
+// registers the bucket as a media URL signer. It returns nil when no bucket
+// is configured or messages are not stored in Supabase. With
+// SUPABASE_STORAGE_AUTO_DOWNLOAD, incoming media is downloaded and uploaded
+// as it arrives instead of on first access.
+func NewSupabaseMediaStorage(client *whatsmeow.Client, messageStore MessageStoreInterface, logger waLog.Logger) *SupabaseMediaStorage {
+	bucket := envString("SUPABASE_STORAGE_BUCKET", "")
+	if bucket == "" {
+		return nil
+	}
+
+	store, ok := supabaseStoreOf(messageStore)
+	if !ok {
+		logger.Warnf("Supabase Storage needs the Supabase message store, media upload disabled")
+		return nil
+	}
+
+	m := &SupabaseMediaStorage{
+		store:  store,
+		bucket: bucket,
+		public: envBool("SUPABASE_STORAGE_PUBLIC", false),
+		urlTTL: envDuration("SUPABASE_STORAGE_URL_TTL", 7*24*time.Hour),
+	}
+	m.mediaDownloader = newMediaDownloader(client, "SUPABASE_STORAGE", logger, m.Downloaded)
+
+	registerMediaURLSigner(m)
+	return m
+}
+
+// Downloaded uploads media that was just downloaded to the local cache and
+// stores its URL in the message metadata
+func (m *SupabaseMediaStorage) Downloaded(messageID, chatJID, filename, localPath string) {
+	if m == nil {
+		return
+	}
+
+	objectPath := mediaObjectKey(messageID, chatJID, filename)
+	if err := m.store.client.UploadObject(m.bucket, objectPath, mediaContentType(filename), localPath); err != nil {
+		m.logger.Warnf("Failed to upload media of message %s: %v", messageID, err)
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"storage_bucket": m.bucket,
+		"storage_path":   objectPath,
+	}
+	if m.public {
+		metadata["media_url"] = m.store.client.PublicObjectURL(m.bucket, objectPath)
+	} else {
+		signedURL, err := m.store.client.SignObjectURL(m.bucket, objectPath, m.urlTTL)
+		if err != nil {
+			m.logger.Warnf("Failed to sign media URL of message %s: %v", messageID, err)
+		} else {
+			metadata["media_url"] = signedURL
+			metadata["media_url_expires_at"] = time.Now().Add(m.urlTTL).UTC().Format(time.RFC3339)
+		}
+	}
+
+	if err := m.store.mergeMetadata(messageID, chatJID, metadata); err != nil {
+		m.logger.Warnf("Failed to store media URL of message %s: %v", messageID, err)
+	}
+}
+
+// SignMediaURL implements MediaURLSigner for media already in the bucket.
+// It fails, so the next signer is tried, for media that was never uploaded.
+func (m *SupabaseMediaStorage) SignMediaURL(messageID, chatJID string, ttl time.Duration) (string, error) {
+	endpoint := fmt.Sprintf("messages?external_id=eq.%s&channel=eq.whatsapp&select=metadata", pgValue(messageID))
+	resp, err := m.store.client.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to query message: %v", err)
+	}
+	var messages []struct {
+		Metadata struct {
+			StoragePath string `json:"storage_path"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(resp, &messages); err != nil {
+		return "", fmt.Errorf("failed to parse message response: %v", err)
+	}
+	if len(messages) == 0 || messages[0].Metadata.StoragePath == "" {
+		return "", fmt.Errorf("media of message %s was not uploaded", messageID)
+	}
+
+	objectPath := messages[0].Metadata.StoragePath
+	if !m.public {
+		return m.store.client.SignObjectURL(m.bucket, objectPath, ttl)
+	}
+
+	// Public URLs need no signing, only the object has to exist
+	publicURL := m.store.client.PublicObjectURL(m.bucket, objectPath)
+	head, err := m.store.client.client.Head(publicURL)
+	if err != nil {
+		return "", err
+	}
+	head.Body.Close()
+	if head.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("media of message %s is not in the bucket", messageID)
+	}
+	return publicURL, nil
+}
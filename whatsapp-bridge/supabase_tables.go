@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// supabaseTables lets the bridge use tables with other names than its own,
+// in another Postgres schema than public, for projects that already have a
+// messages table for another product. The rest of the bridge keeps using
+// its own table names; they are rewritten just before a request is sent.
+type supabaseTables struct {
+	// names maps the bridge's table names to those in the database
+	names map[string]string
+	// schema is the Postgres schema, "" for the API's default. It has to be
+	// listed in the project's exposed schemas.
+	schema string
+}
+
+// newSupabaseTables reads SUPABASE_TABLES, JSON like
+// {"conversations": "wa_conversations", "messages": "wa_messages"}, and
+// SUPABASE_SCHEMA. It returns nil when neither is set.
+func newSupabaseTables() (*supabaseTables, error) {
+	raw := envString("SUPABASE_TABLES", "")
+	schema := envString("SUPABASE_SCHEMA", "")
+	if raw == "" && schema == "" {
+		return nil, nil
+	}
+	if schema != "" && !columnNamePattern.MatchString(schema) {
+		return nil, fmt.Errorf("invalid SUPABASE_SCHEMA %q", schema)
+	}
+
+	names := make(map[string]string)
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &names); err != nil {
+			return nil, fmt.Errorf("failed to parse SUPABASE_TABLES: %v", err)
+		}
+	}
+	for table, name := range names {
+		if !columnNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("table %s maps to invalid name %q", table, name)
+		}
+	}
+	return &supabaseTables{names: names, schema: schema}, nil
+}
+
+// name returns the database name of a bridge table
+func (t *supabaseTables) name(table string) string {
+	if t != nil {
+		if name, ok := t.names[table]; ok {
+			return name
+		}
+	}
+	return table
+}
+
+// schemaName returns the Postgres schema of the tables
+func (t *supabaseTables) schemaName() string {
+	if t == nil || t.schema == "" {
+		return "public"
+	}
+	return t.schema
+}
+
+// embeddedTablePattern matches a resource embedded in a select list, such
+// as conversation:conversations( or conversations!inner(
+var embeddedTablePattern = regexp.MustCompile(`(^|[,(])([A-Za-z_][A-Za-z0-9_]*:)?([A-Za-z_][A-Za-z0-9_]*)([!(])`)
+
+// mapPath renames the table of a REST API path and the tables embedded in
+// its select list
+func (t *supabaseTables) mapPath(path string) (string, error) {
+	if t == nil || len(t.names) == 0 || !strings.HasPrefix(path, "rest/v1/") || strings.HasPrefix(path, "rest/v1/rpc/") {
+		return path, nil
+	}
+
+	endpoint, rawQuery, hasQuery := strings.Cut(path, "?")
+	endpoint = "rest/v1/" + t.name(strings.TrimPrefix(endpoint, "rest/v1/"))
+	if !hasQuery {
+		return endpoint, nil
+	}
+
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query of %s: %v", endpoint, err)
+	}
+	if selects, ok := params["select"]; ok {
+		for i, list := range selects {
+			selects[i] = embeddedTablePattern.ReplaceAllStringFunc(list, func(match string) string {
+				parts := embeddedTablePattern.FindStringSubmatch(match)
+				return parts[1] + parts[2] + t.name(parts[3]) + parts[4]
+			})
+		}
+	}
+	return endpoint + "?" + params.Encode(), nil
+}
+
+// setProfile selects the schema of a REST API request, with Accept-Profile
+// for reads and Content-Profile for writes
+func (t *supabaseTables) setProfile(method, path string, header http.Header) {
+	if t == nil || t.schema == "" || !strings.HasPrefix(path, "rest/v1/") {
+		return
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		header.Set("Accept-Profile", t.schema)
+	} else {
+		header.Set("Content-Profile", t.schema)
+	}
+}
+
+// sqlTablePattern matches the bridge's table names as whole identifiers in
+// migration SQL, along with the names of their indexes, which are renamed
+// too so they do not collide with those of the other product's tables
+var sqlTablePattern = regexp.MustCompile(`\b(conversations|messages|contacts|conversation_participants|reactions)(_[a-z_]+_(?:idx|key))?\b`)
+
+// rewriteSQL renames the tables of a migration and creates it in the
+// schema
+func (t *supabaseTables) rewriteSQL(sql string) string {
+	if t == nil {
+		return sql
+	}
+	if len(t.names) > 0 {
+		sql = sqlTablePattern.ReplaceAllStringFunc(sql, func(match string) string {
+			parts := sqlTablePattern.FindStringSubmatch(match)
+			return t.name(parts[1]) + parts[2]
+		})
+	}
+	if t.schema != "" {
+		sql = fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %[1]s;\nSET search_path TO %[1]s, public;\n%[2]s", t.schema, sql)
+	}
+	return sql
+}
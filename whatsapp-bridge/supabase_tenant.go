@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// supabaseTenant confines every REST API request to the rows of one tenant
+// when several tenants share the Supabase tables. Reads, updates and
+// deletes are filtered on the tenant column and written rows get the
+// tenant column set, whatever the caller built, so a mistake elsewhere in
+// the bridge cannot reach another tenant's rows. Unique constraints used
+// for upserts must include the tenant column.
+type supabaseTenant struct {
+	column string
+	id     string
+}
+
+// columnNamePattern matches the column names the tenant column may have
+var columnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// newSupabaseTenant reads SUPABASE_TENANT_ID and SUPABASE_TENANT_COLUMN. It
+// returns nil when no tenant is set.
+func newSupabaseTenant() (*supabaseTenant, error) {
+	id := envString("SUPABASE_TENANT_ID", "")
+	if id == "" {
+		return nil, nil
+	}
+
+	column := envString("SUPABASE_TENANT_COLUMN", "tenant_id")
+	if !columnNamePattern.MatchString(column) {
+		return nil, fmt.Errorf("invalid SUPABASE_TENANT_COLUMN %q", column)
+	}
+	return &supabaseTenant{column: column, id: id}, nil
+}
+
+// scopePath adds the tenant filter to a REST API path. Inserts are not
+// filtered, but a column list they give is extended with the tenant
+// column, and so is the on_conflict target of upserts, which has to match
+// unique constraints that include the tenant column. Other services and
+// RPC calls are left alone.
+func (t *supabaseTenant) scopePath(method, path string) (string, error) {
+	if t == nil || !strings.HasPrefix(path, "rest/v1/") || strings.HasPrefix(path, "rest/v1/rpc/") {
+		return path, nil
+	}
+
+	table, rawQuery, _ := strings.Cut(path, "?")
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query of %s: %v", table, err)
+	}
+
+	if columns := params.Get("columns"); columns != "" {
+		params.Set("columns", columns+","+t.column)
+	}
+	if onConflict := params.Get("on_conflict"); onConflict != "" {
+		params.Set("on_conflict", t.column+","+onConflict)
+	}
+	if method != "POST" {
+		params.Add(t.column, "eq."+t.id)
+	}
+	return table + "?" + params.Encode(), nil
+}
+
+// scopeBody sets the tenant column on the row or rows of a REST API write
+func (t *supabaseTenant) scopeBody(path string, jsonBody []byte) ([]byte, error) {
+	if t == nil || jsonBody == nil || !strings.HasPrefix(path, "rest/v1/") || strings.HasPrefix(path, "rest/v1/rpc/") {
+		return jsonBody, nil
+	}
+
+	body, err := decodeJSONNumbers(jsonBody)
+	if err != nil {
+		return nil, err
+	}
+
+	switch rows := body.(type) {
+	case map[string]interface{}:
+		rows[t.column] = t.id
+	case []interface{}:
+		for _, row := range rows {
+			if row, ok := row.(map[string]interface{}); ok {
+				row[t.column] = t.id
+			}
+		}
+	}
+	return json.Marshal(body)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errConversationConflict is returned when a conversation kept changing
+// under an update for all attempts
+var errConversationConflict = errors.New("conversation was changed concurrently")
+
+// conversationVersions makes conversation updates conditional, like an
+// If-Match header, so the bridge and other writers such as a CRM UI do not
+// overwrite each other's changes. Each update reads the row, decides its
+// change from the current values and PATCHes only while the version column
+// still holds what was read, bumping it. When another writer got there
+// first the update is decided again on the fresh row.
+type conversationVersions struct {
+	// column is an integer version, incremented, or a timestamp such as
+	// updated_at, set to the current time
+	column   string
+	attempts int
+	// timestamp is set when the column is a timestamp, which decides the
+	// first version written over NULL
+	timestamp bool
+}
+
+// newConversationVersions reads SUPABASE_VERSION_COLUMN. It returns nil when
+// it is not set, so conversations are updated unconditionally.
+func newConversationVersions() (*conversationVersions, error) {
+	column := envString("SUPABASE_VERSION_COLUMN", "")
+	if column == "" {
+		return nil, nil
+	}
+	if !columnNamePattern.MatchString(column) {
+		return nil, fmt.Errorf("invalid SUPABASE_VERSION_COLUMN %q", column)
+	}
+	versionType := envString("SUPABASE_VERSION_TYPE", "integer")
+	if versionType != "integer" && versionType != "timestamp" {
+		return nil, fmt.Errorf("invalid SUPABASE_VERSION_TYPE %q, expected integer or timestamp", versionType)
+	}
+	return &conversationVersions{
+		column:    column,
+		attempts:  max(envInt("SUPABASE_VERSION_ATTEMPTS", 5), 1),
+		timestamp: versionType == "timestamp",
+	}, nil
+}
+
+// conversationChange returns the columns to update given a conversation's
+// current row, or nil when it needs no update. The row is nil when updates
+// are unconditional.
+type conversationChange func(current map[string]interface{}) map[string]interface{}
+
+// patchConversation updates the conversations matching a filter such as
+// "id=eq.<id>", conditionally on their version when versions are enabled
+func (s *SupabaseClient) patchConversation(filter string, change conversationChange) error {
+	_, err := s.patchConversations(filter, change)
+	return err
+}
+
+// patchConversations is patchConversation returning how many conversations
+// were updated
+func (s *SupabaseClient) patchConversations(filter string, change conversationChange) (int, error) {
+	if s.versions == nil {
+		update := change(nil)
+		if update == nil {
+			return 0, nil
+		}
+		resp, err := s.makeRequest("PATCH", "conversations?"+filter+"&select=id", update)
+		if err != nil {
+			return 0, err
+		}
+		var updated []json.RawMessage
+		if err := json.Unmarshal(resp, &updated); err != nil {
+			return 0, fmt.Errorf("failed to parse conversation: %v", err)
+		}
+		return len(updated), nil
+	}
+
+	column := s.versions.column
+	patched := 0
+	for attempt := 1; attempt <= s.versions.attempts; attempt++ {
+		resp, err := s.makeRequest("GET", "conversations?"+filter+"&select=*", nil)
+		if err != nil {
+			return patched, fmt.Errorf("failed to read conversation: %v", err)
+		}
+		body, err := decodeJSONNumbers(resp)
+		if err != nil {
+			return patched, fmt.Errorf("failed to parse conversation: %v", err)
+		}
+		rows, _ := body.([]interface{})
+
+		conflict := false
+		for _, row := range rows {
+			current, ok := row.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			update := change(current)
+			if update == nil {
+				continue
+			}
+
+			version, ok := current[column]
+			if !ok {
+				return patched, fmt.Errorf("conversations has no version column %s", column)
+			}
+			endpoint := fmt.Sprintf("conversations?id=eq.%s&%s=", pgValue(fmt.Sprint(current["id"])), column)
+			if version == nil {
+				endpoint += "is.null"
+			} else {
+				endpoint += "eq." + pgValue(fmt.Sprint(version))
+			}
+			update[column] = s.versions.next(version)
+
+			resp, err := s.makeRequest("PATCH", endpoint+"&select=id", update)
+			if err != nil {
+				return patched, fmt.Errorf("failed to update conversation: %v", err)
+			}
+			updated, err := decodeJSONNumbers(resp)
+			if err != nil {
+				return patched, fmt.Errorf("failed to parse conversation: %v", err)
+			}
+			if list, _ := updated.([]interface{}); len(list) == 0 {
+				conflict = true
+			} else {
+				patched++
+			}
+		}
+		if !conflict {
+			return patched, nil
+		}
+	}
+	return patched, errConversationConflict
+}
+
+// next returns the version a conditional update writes: the integer
+// version plus one, or the current time for timestamps. A NULL version
+// starts at 1, or the current time when SUPABASE_VERSION_TYPE is
+// timestamp.
+func (v *conversationVersions) next(version interface{}) interface{} {
+	if n, ok := version.(interface{ Int64() (int64, error) }); ok {
+		if current, err := n.Int64(); err == nil {
+			return current + 1
+		}
+	}
+	if version == nil && !v.timestamp {
+		return 1
+	}
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// conversationTime reads a timestamp column of a conversation row
+func conversationTime(current map[string]interface{}, column string) (time.Time, bool) {
+	value, ok := current[column].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	return t, err == nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// supabaseTemplate is a row of the templates table, created with e.g.
+//
+//	CREATE TABLE message_templates (
+//		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+//		name text NOT NULL,
+//		language text NOT NULL DEFAULT 'en',
+//		body text NOT NULL,
+//		active boolean NOT NULL DEFAULT true,
+//		UNIQUE (name, language)
+//	);
+//
+// so templates can be edited in the database without a redeploy.
+type supabaseTemplate struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Body     string `json:"body"`
+}
+
+// ListTemplates reads the active templates of a table
+func (s *SupabaseClient) ListTemplates(table string) ([]supabaseTemplate, error) {
+	if !columnNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid templates table %q", table)
+	}
+
+	var templates []supabaseTemplate
+	err := s.EachPage(table+"?active=is.true&select=id,name,language,body", PageOptions{KeyColumn: "id"}, func(rows json.RawMessage) error {
+		var page []supabaseTemplate
+		if err := json.Unmarshal(rows, &page); err != nil {
+			return fmt.Errorf("failed to parse templates: %v", err)
+		}
+		templates = append(templates, page...)
+		return nil
+	})
+	return templates, err
+}
+
+// Refresh reloads the templates from the configuration and, when set, the
+// Supabase table, whose variants replace configured ones of the same name
+// and language. Invalid rows are skipped with a warning; the templates in
+// use are kept when loading fails.
+func (t *MessageTemplates) Refresh() error {
+	config, err := readTemplateConfig()
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		config = make(map[string]map[string]string)
+	}
+
+	if t.supabase != nil {
+		rows, err := t.supabase.ListTemplates(t.table)
+		if err != nil {
+			return fmt.Errorf("failed to load templates from %s: %v", t.table, err)
+		}
+		for _, row := range rows {
+			if _, err := parseTemplates(map[string]map[string]string{row.Name: {row.Language: row.Body}}); err != nil {
+				t.logger.Warnf("Skipping template %s of %s: %v", row.ID, t.table, err)
+				continue
+			}
+			if config[row.Name] == nil {
+				config[row.Name] = make(map[string]string)
+			}
+			config[row.Name][row.Language] = row.Body
+		}
+	}
+
+	templates, err := parseTemplates(config)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.templates = templates
+	t.mutex.Unlock()
+	return nil
+}
+
+// refreshEvery reloads the templates every interval
+func (t *MessageTemplates) refreshEvery(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := t.Refresh(); err != nil {
+			t.logger.Warnf("Failed to refresh message templates: %v", err)
+		}
+	}
+}
+
+// handleTemplatesRefresh serves POST /api/admin/templates/refresh, reloading
+// the templates right away after they were edited
+func handleTemplatesRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if messageTemplates == nil {
+		http.Error(w, "No message templates are configured", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := messageTemplates.Refresh(); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Failed to refresh templates: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"templates": messageTemplates.List(),
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StoreTx is the writes available within a unit of work
+type StoreTx interface {
+	StoreChat(jid, name string, lastMessageTime time.Time) error
+	StoreMessage(record MessageRecord) error
+}
+
+// TransactionalStore is implemented by message stores that can commit
+// several writes as one unit, such as a message together with the chat's
+// last message time and unread count
+type TransactionalStore interface {
+	// InTransaction runs fn and commits its writes when it returns nil.
+	// Nothing is written when it returns an error.
+	InTransaction(fn func(tx StoreTx) error) error
+}
+
+// sqlExecutor is satisfied by *sql.DB and *sql.Tx, so writes can run inside
+// or outside a transaction
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqliteTx runs the writes of a unit of work in a database transaction
+type sqliteTx struct {
+	tx *sql.Tx
+	// created holds chats to announce once the transaction commits
+	created []ConversationEventPayload
+}
+
+// StoreChat stores a chat in the transaction
+func (t *sqliteTx) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	created, err := upsertChat(t.tx, jid, name, lastMessageTime)
+	if created {
+		t.created = append(t.created, ConversationEventPayload{ChatJID: jid, Name: name})
+	}
+	return err
+}
+
+// StoreMessage stores a message in the transaction
+func (t *sqliteTx) StoreMessage(record MessageRecord) error {
+	return insertMessage(t.tx, record)
+}
+
+// InTransaction runs fn in a database transaction. Conversation events are
+// only emitted after it commits.
+func (store *MessageStore) InTransaction(fn func(tx StoreTx) error) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	unit := &sqliteTx{tx: tx}
+	if err := fn(unit); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, payload := range unit.created {
+		emitEvent(EventConversationCreated, payload.ChatJID, payload)
+	}
+	return nil
+}
+
+// supabaseTx collects the writes of a unit of work until fn returns
+type supabaseTx struct {
+	chats    []supabaseTxChat
+	messages []MessageRecord
+}
+
+// supabaseTxChat is a collected chat write
+type supabaseTxChat struct {
+	jid, name       string
+	lastMessageTime time.Time
+}
+
+// StoreChat collects a chat write
+func (t *supabaseTx) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	t.chats = append(t.chats, supabaseTxChat{jid: jid, name: name, lastMessageTime: lastMessageTime})
+	return nil
+}
+
+// StoreMessage collects a message write
+func (t *supabaseTx) StoreMessage(record MessageRecord) error {
+	t.messages = append(t.messages, record)
+	return nil
+}
+
+// InTransaction emulates a unit of work over PostgREST, which cannot span a
+// transaction across requests. Writes are collected and only sent once fn
+// succeeds. Messages go first, as they are upserted, so retrying the unit
+// after a failure rewrites rather than duplicates them; the chat updates
+// follow. A failure midway is not rolled back.
+func (s *SupabaseMessageStore) InTransaction(fn func(tx StoreTx) error) error {
+	unit := &supabaseTx{}
+	if err := fn(unit); err != nil {
+		return err
+	}
+
+	for _, record := range unit.messages {
+		if err := s.storeRecord(record); err != nil {
+			return err
+		}
+	}
+	for _, chat := range unit.chats {
+		if err := s.StoreChat(chat.jid, chat.name, chat.lastMessageTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
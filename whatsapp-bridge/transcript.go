@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// transcriptSnippetLength caps the quoted text shown for replies
+const transcriptSnippetLength = 60
+
+// TranscriptResponse represents the response for the transcript API
+type TranscriptResponse struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message,omitempty"`
+	ChatJID      string `json:"chat_jid"`
+	MessageCount int    `json:"message_count"`
+	Transcript   string `json:"transcript"`
+	// NextCursor continues with the window before this one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// transcriptRenderer formats messages as a compact transcript for language
+// models: one line per message, the speaker named only when it changes, and
+// the time only when the day changes or after a pause
+type transcriptRenderer struct {
+	client   *whatsmeow.Client
+	location *time.Location
+	gap      time.Duration
+
+	// names caches speaker names for one rendering
+	names map[string]string
+}
+
+// speaker returns the label of a message's sender: "Me", the contact's
+// name, or the phone number
+func (t *transcriptRenderer) speaker(msg Message) string {
+	if msg.IsFromMe {
+		return "Me"
+	}
+	if name, ok := t.names[msg.Sender]; ok {
+		return name
+	}
+
+	name := msg.Sender
+	if t.client != nil {
+		if stored := storedContactName(t.client, types.NewJID(msg.Sender, types.DefaultUserServer)); stored != "" {
+			name = stored
+		}
+	}
+	t.names[msg.Sender] = name
+	return name
+}
+
+// chatName returns the name of a group or contact, "" when unknown
+func (t *transcriptRenderer) chatName(chatJID string) string {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil || t.client == nil {
+		return ""
+	}
+	if jid.Server == types.GroupServer {
+		if info, err := cachedGroupInfo(t.client, jid); err == nil {
+			return info.Name
+		}
+		return ""
+	}
+	return storedContactName(t.client, jid)
+}
+
+// body returns a message's text on one line, with a placeholder for media
+// and the start of the message it replies to
+func (t *transcriptRenderer) body(msg Message) string {
+	var parts []string
+	if msg.QuotedSnippet != "" {
+		snippet := strings.Join(strings.Fields(msg.QuotedSnippet), " ")
+		if len(snippet) > transcriptSnippetLength {
+			snippet = truncateUTF8(snippet, transcriptSnippetLength) + "…"
+		}
+		parts = append(parts, fmt.Sprintf("(re: %q)", snippet))
+	}
+	switch {
+	case msg.MediaType == "document" && msg.Filename != "":
+		parts = append(parts, fmt.Sprintf("[%s: %s]", msg.MediaType, msg.Filename))
+	case msg.MediaType != "":
+		parts = append(parts, "["+msg.MediaType+"]")
+	}
+	// Line breaks inside a message would read as new messages
+	if content := strings.Join(strings.Fields(msg.Content), " "); content != "" {
+		parts = append(parts, content)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Render formats messages, given oldest first
+func (t *transcriptRenderer) Render(chatJID string, messages []Message) string {
+	var b strings.Builder
+	if name := t.chatName(chatJID); name != "" {
+		fmt.Fprintf(&b, "# %s (%s)\n", name, chatJID)
+	} else {
+		fmt.Fprintf(&b, "# %s\n", chatJID)
+	}
+	if len(messages) == 0 {
+		return b.String()
+	}
+	fmt.Fprintf(&b, "# Times in %s\n", t.location)
+
+	var lastDay, lastSpeaker string
+	var lastTime time.Time
+	for _, msg := range messages {
+		at := msg.Time.In(t.location)
+		if day := at.Format("2006-01-02"); day != lastDay {
+			fmt.Fprintf(&b, "--- %s\n", at.Format("Mon 2006-01-02"))
+			lastDay, lastSpeaker = day, ""
+			lastTime = time.Time{}
+		}
+
+		var prefix string
+		if lastTime.IsZero() || at.Sub(lastTime) >= t.gap {
+			prefix = "[" + at.Format("15:04") + "] "
+			lastSpeaker = ""
+		}
+		lastTime = at
+
+		speaker := t.speaker(msg)
+		if speaker != lastSpeaker {
+			prefix += speaker + ": "
+			lastSpeaker = speaker
+		} else if prefix == "" {
+			// Further messages of the same turn are indented
+			prefix = "  "
+		}
+		b.WriteString(prefix + t.body(msg) + "\n")
+	}
+	return b.String()
+}
+
+// handleChatTranscript serves GET /api/chats/transcript?chat_jid=<jid>&limit=<n>,
+// the last limit messages of a chat as a compact text transcript for
+// feeding to an agent. The message filters of /api/messages, cursor and tz
+// apply; format=text returns the transcript alone as plain text.
+func handleChatTranscript(client *whatsmeow.Client, messageStore MessageStoreInterface) http.HandlerFunc {
+	defaultLimit := envInt("TRANSCRIPT_DEFAULT_LIMIT", 100)
+	gap := envDuration("TRANSCRIPT_TIME_GAP", 30*time.Minute)
+	defaultLocation, err := time.LoadLocation(envString("TRANSCRIPT_TIMEZONE", "UTC"))
+	if err != nil {
+		fmt.Printf("Invalid TRANSCRIPT_TIMEZONE, using UTC: %v\n", err)
+		defaultLocation = time.UTC
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "Query parameter chat_jid is required", http.StatusBadRequest)
+			return
+		}
+
+		limit, err := parseLimit(r, defaultLimit, 1000)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		location := defaultLocation
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			if location, err = time.LoadLocation(tz); err != nil {
+				http.Error(w, "Invalid tz, expected an IANA time zone", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Ask for one extra row to know whether another window exists
+		query := MessageQuery{Limit: limit + 1}
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			query.CursorTime, query.CursorID, err = decodeCursor(cursor)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := parseMessageFilters(r, &query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		messages, err := messageStore.GetMessages(chatJID, query)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(TranscriptResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to list messages: %v", err),
+				ChatJID: chatJID,
+			})
+			return
+		}
+
+		resp := TranscriptResponse{Success: true, ChatJID: chatJID}
+		if len(messages) > limit {
+			messages = messages[:limit]
+			last := messages[len(messages)-1]
+			resp.NextCursor = encodeCursor(last.Time, last.ID)
+		}
+
+		// Messages come back newest first, the transcript reads in order
+		ordered := make([]Message, len(messages))
+		for i, msg := range messages {
+			ordered[len(messages)-1-i] = msg
+		}
+		renderer := &transcriptRenderer{client: client, location: location, gap: gap, names: make(map[string]string)}
+		resp.Transcript = renderer.Render(chatJID, ordered)
+		resp.MessageCount = len(ordered)
+
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if resp.NextCursor != "" {
+				w.Header().Set("X-Next-Cursor", resp.NextCursor)
+			}
+			fmt.Fprint(w, resp.Transcript)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
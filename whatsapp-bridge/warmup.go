@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// AccountWarmup caps the daily outbound messages of a freshly linked number
+// and raises the cap day by day, since WhatsApp is quick to ban new numbers
+// that start sending in bulk. Days are counted in UTC from the first time
+// the bridge sees the account, or from WARMUP_START.
+type AccountWarmup struct {
+	db *sql.DB
+	// schedule is the daily limit on each day of the warm-up; later days
+	// are unlimited
+	schedule []int
+	// startedAt overrides the recorded start, for numbers linked before
+	// warm-up was configured
+	startedAt time.Time
+	logger    waLog.Logger
+
+	// mutex serializes limit checks with the send increment
+	mutex sync.Mutex
+}
+
+// accountWarmup is the active warm-up profile, nil when disabled
+var accountWarmup *AccountWarmup
+
+// NewAccountWarmup reads WARMUP_SCHEDULE, the comma separated daily limits
+// of the warm-up such as "20,40,80,150,300", and creates the warm-up
+// tables in the bridge database. It returns nil when no schedule is set.
+func NewAccountWarmup(db *sql.DB, logger waLog.Logger) (*AccountWarmup, error) {
+	values := envList("WARMUP_SCHEDULE")
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	w := &AccountWarmup{db: db, logger: logger}
+	for _, value := range values {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid WARMUP_SCHEDULE limit %q", value)
+		}
+		w.schedule = append(w.schedule, limit)
+	}
+
+	if start := envString("WARMUP_START", ""); start != "" {
+		startedAt, err := time.Parse("2006-01-02", start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARMUP_START, expected YYYY-MM-DD: %v", err)
+		}
+		w.startedAt = startedAt
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS warmup_accounts (
+			jid TEXT PRIMARY KEY,
+			started_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS warmup_sends (
+			jid TEXT,
+			day TEXT,
+			sends INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (jid, day)
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create warm-up tables: %v", err)
+	}
+	return w, nil
+}
+
+// WarmupStatus is the warm-up progress of the linked account
+type WarmupStatus struct {
+	Account   string    `json:"account"`
+	StartedAt time.Time `json:"started_at"`
+	// Day is the day of the warm-up, starting at 1
+	Day int `json:"day"`
+	// DailyLimit is today's limit, 0 once the warm-up is complete
+	DailyLimit int  `json:"daily_limit"`
+	SendsToday int  `json:"sends_today"`
+	Complete   bool `json:"complete"`
+}
+
+// status returns the warm-up progress of an account, recording its start the
+// first time it is seen. The caller holds the mutex.
+func (w *AccountWarmup) status(account string, now time.Time) (*WarmupStatus, error) {
+	startedAt := w.startedAt
+	if startedAt.IsZero() {
+		_, err := w.db.Exec("INSERT OR IGNORE INTO warmup_accounts (jid, started_at) VALUES (?, ?)", account, now.UTC())
+		if err != nil {
+			return nil, fmt.Errorf("failed to record warm-up start: %v", err)
+		}
+		if err := w.db.QueryRow("SELECT started_at FROM warmup_accounts WHERE jid = ?", account).Scan(&startedAt); err != nil {
+			return nil, fmt.Errorf("failed to read warm-up start: %v", err)
+		}
+	}
+
+	status := &WarmupStatus{Account: account, StartedAt: startedAt}
+	startDay := startedAt.UTC().Truncate(24 * time.Hour)
+	today := now.UTC().Truncate(24 * time.Hour)
+	status.Day = int(today.Sub(startDay)/(24*time.Hour)) + 1
+	if status.Day < 1 {
+		status.Day = 1
+	}
+	if status.Day > len(w.schedule) {
+		status.Complete = true
+	} else {
+		status.DailyLimit = w.schedule[status.Day-1]
+	}
+
+	err := w.db.QueryRow("SELECT sends FROM warmup_sends WHERE jid = ? AND day = ?", account, usageDay(now)).Scan(&status.SendsToday)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read warm-up sends: %v", err)
+	}
+	return status, nil
+}
+
+// counts reports whether a message to the recipient counts against the
+// limit. Messages to the account itself, such as command replies, do not.
+func (w *AccountWarmup) counts(client *whatsmeow.Client, recipient types.JID) (string, bool) {
+	if w == nil || client.Store.ID == nil {
+		return "", false
+	}
+	account := client.Store.ID.ToNonAD()
+	return account.String(), recipient.User != account.User
+}
+
+// Allowed reports whether a message to the recipient fits in today's limit,
+// without counting it. It also returns the limit.
+func (w *AccountWarmup) Allowed(client *whatsmeow.Client, recipient types.JID) (bool, int, error) {
+	account, counts := w.counts(client, recipient)
+	if !counts {
+		return true, 0, nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	status, err := w.status(account, time.Now())
+	if err != nil {
+		return false, 0, err
+	}
+	return status.Complete || status.SendsToday < status.DailyLimit, status.DailyLimit, nil
+}
+
+// Reserve counts a message to the recipient against today's limit. It
+// returns false without counting when the limit is reached.
+func (w *AccountWarmup) Reserve(client *whatsmeow.Client, recipient types.JID) (bool, int, error) {
+	account, counts := w.counts(client, recipient)
+	if !counts {
+		return true, 0, nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	status, err := w.status(account, now)
+	if err != nil {
+		return false, 0, err
+	}
+	if status.Complete {
+		return true, 0, nil
+	}
+	if status.SendsToday >= status.DailyLimit {
+		return false, status.DailyLimit, nil
+	}
+
+	_, err = w.db.Exec(
+		`INSERT INTO warmup_sends (jid, day, sends) VALUES (?, ?, 1)
+		ON CONFLICT(jid, day) DO UPDATE SET sends = sends + 1`,
+		account, usageDay(now),
+	)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to record warm-up send: %v", err)
+	}
+	return true, status.DailyLimit, nil
+}
+
+// Release gives back a reservation for a message that failed to send
+func (w *AccountWarmup) Release(client *whatsmeow.Client, recipient types.JID) {
+	account, counts := w.counts(client, recipient)
+	if !counts {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err := w.db.Exec("UPDATE warmup_sends SET sends = sends - 1 WHERE jid = ? AND day = ? AND sends > 0", account, usageDay(time.Now()))
+	if err != nil {
+		w.logger.Warnf("Failed to release warm-up send: %v", err)
+	}
+}
+
+// handleWarmup serves GET /api/admin/warmup, the warm-up progress of the
+// linked account
+func handleWarmup(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if accountWarmup == nil {
+			http.Error(w, "Warm-up is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if client.Store.ID == nil {
+			http.Error(w, "Not logged in", http.StatusServiceUnavailable)
+			return
+		}
+
+		accountWarmup.mutex.Lock()
+		status, err := accountWarmup.status(client.Store.ID.ToNonAD().String(), time.Now())
+		accountWarmup.mutex.Unlock()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read warm-up status: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"warmup":  status,
+		})
+	}
+}
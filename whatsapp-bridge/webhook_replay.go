@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Limits on the number of events a single replay request delivers
+const (
+	defaultReplayLimit = 1000
+	maxReplayLimit     = 10000
+)
+
+// WebhookReplayRequest represents the request body of the webhook replay API
+type WebhookReplayRequest struct {
+	URL string `json:"url"`
+	// Secret signs the replayed events; when empty the secret of the
+	// configured webhook with the same URL is used, as is its template
+	Secret string `json:"secret,omitempty"`
+	// From, To, ChatJID and Events select the events to replay
+	From    time.Time `json:"from,omitempty"`
+	To      time.Time `json:"to,omitempty"`
+	ChatJID string    `json:"chat_jid,omitempty"`
+	Events  []string  `json:"events,omitempty"`
+	// AfterSeq resumes a replay after the last_seq of an earlier response
+	AfterSeq int64 `json:"after_seq,omitempty"`
+	Limit    int   `json:"limit,omitempty"`
+}
+
+// WebhookReplayResponse represents the response of the webhook replay API
+type WebhookReplayResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	Delivered int    `json:"delivered"`
+	// LastSeq is the journal sequence of the last delivered event; pass it
+	// as after_seq to continue
+	LastSeq int64 `json:"last_seq"`
+	// More is set when the limit was reached before the range was exhausted
+	More bool `json:"more"`
+	// FailedEventID is the event the webhook did not accept
+	FailedEventID string `json:"failed_event_id,omitempty"`
+}
+
+// configuredWebhook returns the configured webhook with a URL, or one with
+// just the URL if there is none
+func configuredWebhook(url string) WebhookConfig {
+	if webhookDispatcher != nil {
+		for _, hook := range webhookDispatcher.webhooks {
+			if hook.URL == url {
+				return hook
+			}
+		}
+	}
+	return WebhookConfig{URL: url}
+}
+
+// handleWebhookReplay serves POST /api/admin/webhooks/replay. Journaled
+// events are delivered in order with an X-Event-Replay header, stopping at
+// the first failure so the consumer never sees gaps; the response says
+// where to resume.
+func handleWebhookReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if eventJournal == nil {
+		http.Error(w, "Event journal is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req WebhookReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.URL == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && !req.To.After(req.From) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultReplayLimit
+	}
+	limit = min(limit, maxReplayLimit)
+
+	hook := configuredWebhook(req.URL)
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Read one extra entry to know whether the range continues
+	entries, err := eventJournal.Range(req.AfterSeq, limit+1, JournalFilter{
+		Types:   req.Events,
+		ChatJID: req.ChatJID,
+		From:    req.From,
+		To:      req.To,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(WebhookReplayResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read events: %v", err),
+		})
+		return
+	}
+
+	resp := WebhookReplayResponse{Success: true, LastSeq: req.AfterSeq}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		resp.More = true
+	}
+
+	client := &http.Client{Timeout: envDuration("WEBHOOK_TIMEOUT", 10*time.Second)}
+	extra := http.Header{"X-Event-Replay": []string{"true"}}
+	status := http.StatusOK
+
+	for _, entry := range entries {
+		var evt Event
+		if err := json.Unmarshal(entry.Event, &evt); err != nil {
+			resp.Success = false
+			resp.Message = fmt.Sprintf("Failed to decode journal entry %d: %v", entry.Seq, err)
+			status = http.StatusInternalServerError
+			break
+		}
+		if err := postWebhook(client, hook, evt, extra); err != nil {
+			resp.Success = false
+			resp.Message = fmt.Sprintf("Webhook failed for event %s: %v", evt.ID, err)
+			resp.FailedEventID = evt.ID
+			resp.More = true
+			status = http.StatusBadGateway
+			break
+		}
+		resp.Delivered++
+		resp.LastSeq = entry.Seq
+	}
+
+	if resp.Success {
+		resp.Message = fmt.Sprintf("Replayed %d events", resp.Delivered)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
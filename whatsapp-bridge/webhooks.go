@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// WebhookConfig is an HTTP endpoint that receives emitted events
+type WebhookConfig struct {
+	// URL receives a POST with the event envelope as JSON body
+	URL string `json:"url"`
+	// Events lists the event types to deliver, empty or "*" for all
+	Events []string `json:"events,omitempty"`
+	// Secret signs the body with HMAC-SHA256 in the X-Webhook-Signature header
+	Secret string `json:"secret,omitempty"`
+	// Template is an optional Go template producing the JSON body instead of
+	// the envelope, for receivers that expect their own field names
+	Template string `json:"template,omitempty"`
+	// PublicKey is a base64 X25519 public key. When set the body is
+	// encrypted to it as a NaCl sealed box (libsodium crypto_box_seal), so
+	// only the holder of the private key can read it.
+	PublicKey string `json:"public_key,omitempty"`
+	// Chats and Tags route the webhook to events of these chat JIDs, or of
+	// chats carrying one of these inbox tags. A routed webhook only gets
+	// events of its chats and is delivered to before the unrouted ones.
+	Chats []string `json:"chats,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Exclusive keeps events delivered to this routed webhook from the
+	// unrouted webhooks, e.g. so a VIP chat only reaches its own receiver
+	Exclusive bool `json:"exclusive,omitempty"`
+
+	tmpl      *template.Template
+	publicKey *[32]byte
+}
+
+// webhookEncryptionSealedBox names the encryption scheme of sealed bodies
+const webhookEncryptionSealedBox = "nacl-sealed-box"
+
+// EncryptedWebhookBody is posted instead of the payload to webhooks with a
+// public key
+type EncryptedWebhookBody struct {
+	Encryption string `json:"encryption"`
+	// Ciphertext is the base64 sealed box of the payload JSON
+	Ciphertext string `json:"ciphertext"`
+}
+
+// parseWebhookPublicKey decodes a base64 X25519 public key
+func parseWebhookPublicKey(encoded string) (*[32]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64: %v", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("public key is %d bytes, expected 32", len(raw))
+	}
+	var key [32]byte
+	copy(key[:], raw)
+	return &key, nil
+}
+
+// sealWebhookBody encrypts a payload to a webhook's public key
+func sealWebhookBody(publicKey *[32]byte, payload []byte) ([]byte, error) {
+	sealed, err := box.SealAnonymous(nil, payload, publicKey, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt payload: %v", err)
+	}
+	return json.Marshal(EncryptedWebhookBody{
+		Encryption: webhookEncryptionSealedBox,
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed),
+	})
+}
+
+// wants reports whether the webhook subscribes to an event type
+func (c WebhookConfig) wants(eventType string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == "*" || e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// routed reports whether the webhook only receives events of some chats
+func (c WebhookConfig) routed() bool {
+	return len(c.Chats) > 0 || len(c.Tags) > 0
+}
+
+// matches reports whether a routed webhook covers a chat with the given
+// inbox tags
+func (c WebhookConfig) matches(chatJID string, tags []string) bool {
+	for _, jid := range c.Chats {
+		if jid == chatJID {
+			return true
+		}
+	}
+	for _, want := range c.Tags {
+		for _, tag := range tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebhookDispatcher posts emitted events to configured webhooks
+type WebhookDispatcher struct {
+	webhooks []WebhookConfig
+	client   *http.Client
+	logger   waLog.Logger
+}
+
+// webhookDispatcher is the active dispatcher, nil when no webhooks are
+// configured
+var webhookDispatcher *WebhookDispatcher
+
+// NewWebhookDispatcher loads the WEBHOOKS configuration, a JSON array of
+// WebhookConfig. It returns nil when nothing is configured.
+func NewWebhookDispatcher(logger waLog.Logger) (*WebhookDispatcher, error) {
+	raw := envString("WEBHOOKS", "")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var webhooks []WebhookConfig
+	if err := json.Unmarshal([]byte(raw), &webhooks); err != nil {
+		return nil, fmt.Errorf("failed to parse WEBHOOKS: %v", err)
+	}
+	for i := range webhooks {
+		hook := &webhooks[i]
+		if hook.URL == "" {
+			return nil, fmt.Errorf("webhook %d needs a url", i)
+		}
+		if hook.Exclusive && !hook.routed() {
+			return nil, fmt.Errorf("webhook %s is exclusive but has no chats or tags", hook.URL)
+		}
+		if hook.Template != "" {
+			tmpl, err := parsePayloadTemplate(hook.URL, hook.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for webhook %s: %v", hook.URL, err)
+			}
+			hook.tmpl = tmpl
+		}
+		if hook.PublicKey != "" {
+			key, err := parseWebhookPublicKey(hook.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key for webhook %s: %v", hook.URL, err)
+			}
+			hook.publicKey = key
+		}
+	}
+
+	return &WebhookDispatcher{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: envDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+		logger:   logger,
+	}, nil
+}
+
+// HandleEvent implements EventSubscriber, delivering to matching webhooks in
+// the background. Webhooks routed to the event's chat are evaluated first;
+// when an exclusive one matches, the unrouted webhooks are skipped.
+func (d *WebhookDispatcher) HandleEvent(evt Event) {
+	exclusive := false
+	if evt.Chat != "" {
+		tags := d.chatTags(evt.Chat)
+		for _, hook := range d.webhooks {
+			if !hook.routed() || !hook.wants(evt.Type) || !hook.matches(evt.Chat, tags) {
+				continue
+			}
+			exclusive = exclusive || hook.Exclusive
+			go d.deliver(hook, evt)
+		}
+	}
+	if exclusive {
+		return
+	}
+
+	for _, hook := range d.webhooks {
+		if hook.routed() || !hook.wants(evt.Type) {
+			continue
+		}
+		go d.deliver(hook, evt)
+	}
+}
+
+// chatTags returns the inbox tags of a chat when a routed webhook matches on
+// tags and the inbox projection is enabled
+func (d *WebhookDispatcher) chatTags(chatJID string) []string {
+	if inboxProjection == nil {
+		return nil
+	}
+	for _, hook := range d.webhooks {
+		if len(hook.Tags) == 0 {
+			continue
+		}
+		tags, err := inboxProjection.Tags(chatJID)
+		if err != nil {
+			d.logger.Warnf("Failed to read tags of %s for webhook routing: %v", chatJID, err)
+		}
+		return tags
+	}
+	return nil
+}
+
+// signWebhookBody returns the X-Webhook-Signature value for a body
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts a single event to a webhook, logging failures
+func (d *WebhookDispatcher) deliver(hook WebhookConfig, evt Event) {
+	if err := postWebhook(d.client, hook, evt, nil); err != nil {
+		d.logger.Warnf("Webhook %s failed for event %s: %v", hook.URL, evt.ID, err)
+	}
+}
+
+// postWebhook posts an event to a webhook, rendered through its template and
+// encrypted to its public key if it has them, with the standard headers plus
+// any extra ones. The signature covers the body as sent.
+func postWebhook(client *http.Client, hook WebhookConfig, evt Event, extra http.Header) error {
+	body, err := renderEventPayload(hook.tmpl, evt)
+	if err != nil {
+		return err
+	}
+	if hook.publicKey != nil {
+		if body, err = sealWebhookBody(hook.publicKey, body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", evt.ID)
+	req.Header.Set("X-Event-Type", evt.Type)
+	if hook.publicKey != nil {
+		req.Header.Set("X-Webhook-Encryption", webhookEncryptionSealedBox)
+	}
+	if hook.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", signWebhookBody(hook.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"whatsapp-client/eventschema"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// WriteQueue keeps Supabase message writes in the bridge database until
+// they succeed, so messages that arrive while Supabase is unreachable are
+// not lost. Writes are applied oldest first by a background worker; a
+// write that keeps failing holds back the ones after it, which keeps
+// conversations in order, until it is moved to the dead letter table.
+type WriteQueue struct {
+	db       *sql.DB
+	store    *SupabaseMessageStore
+	interval time.Duration
+	logger   waLog.Logger
+
+	// maxAttempts is how often a write is tried before it is given up on
+	maxAttempts int
+	// wake asks the worker to flush right away
+	wake chan struct{}
+
+	// flushMutex serializes flushes so entries are applied one at a time
+	flushMutex sync.Mutex
+
+	mutex sync.Mutex
+	// retryAt is when the next flush may run after a failure. Until then
+	// new writes are only queued, so an outage does not slow down the
+	// event handler with requests that are bound to fail.
+	retryAt time.Time
+
+	// alarmSize and alarmAge raise write_queue.alarm when more writes are
+	// queued or the oldest has waited longer; zero disables a threshold
+	alarmSize int
+	alarmAge  time.Duration
+	// alarmed is set while the alarm is raised
+	alarmed bool
+}
+
+// WriteQueueStats describes the backlog of the write queue
+type WriteQueueStats struct {
+	Pending int `json:"pending"`
+	// OldestAt is when the oldest queued write was queued
+	OldestAt *time.Time `json:"oldest_at,omitempty"`
+	// Attempts and LastError are those of the oldest write, which holds
+	// back the others
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	// DeadLetters counts the writes given up on, kept in
+	// supabase_write_dead_letters
+	DeadLetters int `json:"dead_letters"`
+	// Alarm is set while a size or age threshold is exceeded
+	Alarm   bool     `json:"alarm"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// NewWriteQueue creates the supabase_write_queue table in the bridge
+// database, routes the store's message writes through it and starts the
+// background flusher. It returns nil when SUPABASE_WRITE_QUEUE is false.
+func NewWriteQueue(db *sql.DB, store *SupabaseMessageStore, logger waLog.Logger) (*WriteQueue, error) {
+	if !envBool("SUPABASE_WRITE_QUEUE", true) {
+		return nil, nil
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS supabase_write_queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			batch BOOLEAN NOT NULL,
+			records TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS supabase_write_dead_letters (
+			id INTEGER PRIMARY KEY,
+			batch BOOLEAN NOT NULL,
+			records TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL,
+			failed_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create write queue table: %v", err)
+	}
+
+	q := &WriteQueue{
+		db:       db,
+		store:    store,
+		interval: envDuration("SUPABASE_WRITE_QUEUE_RETRY_INTERVAL", 30*time.Second),
+		logger:   logger,
+
+		maxAttempts: max(envInt("SUPABASE_WRITE_QUEUE_MAX_ATTEMPTS", 20), 1),
+		wake:        make(chan struct{}, 1),
+
+		alarmSize: envInt("SUPABASE_WRITE_QUEUE_ALARM_SIZE", 1000),
+		alarmAge:  envDuration("SUPABASE_WRITE_QUEUE_ALARM_AGE", 15*time.Minute),
+	}
+
+	if pending, err := q.Pending(); err != nil {
+		return nil, fmt.Errorf("failed to read write queue: %v", err)
+	} else if pending > 0 {
+		logger.Infof("%d queued Supabase writes from a previous run will be retried", pending)
+	}
+
+	store.writeQueue = q
+	go q.run()
+	return q, nil
+}
+
+// Enqueue stores records in the queue and wakes the worker to apply them,
+// unless a recent failure is still backing off. Batches are written like
+// history sync, single records like live messages. It only fails when the
+// records cannot be queued; failed Supabase writes stay queued.
+func (q *WriteQueue) Enqueue(records []MessageRecord, batch bool) error {
+	encoded, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode queued messages: %v", err)
+	}
+
+	_, err = q.db.Exec("INSERT INTO supabase_write_queue (batch, records, created_at) VALUES (?, ?, ?)",
+		batch, string(encoded), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to queue messages: %v", err)
+	}
+
+	q.mutex.Lock()
+	backingOff := time.Now().Before(q.retryAt)
+	q.mutex.Unlock()
+	if !backingOff {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of queued writes
+func (q *WriteQueue) Pending() (int, error) {
+	var count int
+	err := q.db.QueryRow("SELECT COUNT(*) FROM supabase_write_queue").Scan(&count)
+	return count, err
+}
+
+// Stats returns the size and age of the backlog and the thresholds it
+// exceeds
+func (q *WriteQueue) Stats() (WriteQueueStats, error) {
+	var stats WriteQueueStats
+	pending, err := q.Pending()
+	if err != nil {
+		return stats, err
+	}
+	stats.Pending = pending
+
+	var oldest time.Time
+	var lastError sql.NullString
+	err = q.db.QueryRow("SELECT created_at, attempts, last_error FROM supabase_write_queue ORDER BY id LIMIT 1").Scan(&oldest, &stats.Attempts, &lastError)
+	if err != nil && err != sql.ErrNoRows {
+		return stats, err
+	}
+	if err == nil {
+		stats.OldestAt = &oldest
+		stats.LastError = lastError.String
+	}
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM supabase_write_dead_letters").Scan(&stats.DeadLetters); err != nil {
+		return stats, err
+	}
+
+	if q.alarmSize > 0 && stats.Pending >= q.alarmSize {
+		stats.Reasons = append(stats.Reasons, "size")
+	}
+	if q.alarmAge > 0 && stats.OldestAt != nil && time.Since(*stats.OldestAt) >= q.alarmAge {
+		stats.Reasons = append(stats.Reasons, "age")
+	}
+	stats.Alarm = len(stats.Reasons) > 0
+	return stats, nil
+}
+
+// checkAlarm emits write_queue.alarm when the backlog crosses a threshold
+// and again when it is back under all of them
+func (q *WriteQueue) checkAlarm() {
+	stats, err := q.Stats()
+	if err != nil {
+		q.logger.Warnf("Failed to read write queue stats: %v", err)
+		return
+	}
+
+	q.mutex.Lock()
+	changed := stats.Alarm != q.alarmed
+	q.alarmed = stats.Alarm
+	q.mutex.Unlock()
+	if !changed {
+		return
+	}
+
+	payload := WriteQueueAlarmPayload{
+		State:         eventschema.AlarmCleared,
+		Reasons:       stats.Reasons,
+		Pending:       stats.Pending,
+		OldestAt:      stats.OldestAt,
+		MaxPending:    q.alarmSize,
+		MaxAgeSeconds: int64(q.alarmAge / time.Second),
+		LastError:     stats.LastError,
+		Timestamp:     time.Now().UTC(),
+	}
+	if stats.Alarm {
+		payload.State = eventschema.AlarmRaised
+		q.logger.Errorf("Supabase write queue alarm: %d writes queued, oldest since %v, exceeding %v", stats.Pending, stats.OldestAt, stats.Reasons)
+	} else {
+		q.logger.Infof("Supabase write queue alarm cleared, %d writes queued", stats.Pending)
+	}
+	emitEvent(EventWriteQueueAlarm, "", payload)
+}
+
+// Flush applies queued writes oldest first until the queue is empty or a
+// write fails. On failure the write stays queued and new writes are only
+// queued until the retry interval has passed. A write Supabase rejects as
+// invalid, or that failed SUPABASE_WRITE_QUEUE_MAX_ATTEMPTS times, is moved
+// to the dead letter table instead so it does not block the queue.
+func (q *WriteQueue) Flush() error {
+	q.flushMutex.Lock()
+	defer q.flushMutex.Unlock()
+
+	for {
+		var id int64
+		var batch bool
+		var encoded string
+		var attempts int
+		err := q.db.QueryRow("SELECT id, batch, records, attempts FROM supabase_write_queue ORDER BY id LIMIT 1").Scan(&id, &batch, &encoded, &attempts)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read write queue: %v", err)
+		}
+
+		var records []MessageRecord
+		if err := json.Unmarshal([]byte(encoded), &records); err != nil {
+			// A corrupt entry can never be applied, do not let it hold
+			// back the rest of the queue
+			q.logger.Errorf("Dropping unreadable queued write %d: %v", id, err)
+			if _, err := q.db.Exec("DELETE FROM supabase_write_queue WHERE id = ?", id); err != nil {
+				return fmt.Errorf("failed to remove queued write: %v", err)
+			}
+			continue
+		}
+
+		if err := q.apply(records, batch); err != nil {
+			var apiErr *SupabaseAPIError
+			if (errors.As(err, &apiErr) && !apiErr.Retryable()) || attempts+1 >= q.maxAttempts {
+				q.logger.Errorf("Giving up on queued write %d after %d attempts: %v", id, attempts+1, err)
+				if err := q.deadLetter(id, err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			q.mutex.Lock()
+			q.retryAt = time.Now().Add(q.interval)
+			q.mutex.Unlock()
+
+			if _, dbErr := q.db.Exec("UPDATE supabase_write_queue SET attempts = attempts + 1, last_error = ? WHERE id = ?", err.Error(), id); dbErr != nil {
+				q.logger.Warnf("Failed to record write queue failure: %v", dbErr)
+			}
+			q.logger.Warnf("Failed to write queued messages to Supabase, retrying in %v: %v", q.interval, err)
+			return err
+		}
+
+		if _, err := q.db.Exec("DELETE FROM supabase_write_queue WHERE id = ?", id); err != nil {
+			return fmt.Errorf("failed to remove queued write: %v", err)
+		}
+	}
+}
+
+// deadLetter moves a queued write that cannot be applied to
+// supabase_write_dead_letters
+func (q *WriteQueue) deadLetter(id int64, cause error) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to move queued write: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO supabase_write_dead_letters (id, batch, records, attempts, last_error, created_at, failed_at)
+		SELECT id, batch, records, attempts + 1, ?, created_at, ? FROM supabase_write_queue WHERE id = ?`,
+		cause.Error(), time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to move queued write: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM supabase_write_queue WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to remove queued write: %v", err)
+	}
+	return tx.Commit()
+}
+
+// apply writes one queued entry to Supabase
+func (q *WriteQueue) apply(records []MessageRecord, batch bool) error {
+	if batch {
+		return q.store.writeMessages(records)
+	}
+	for _, record := range records {
+		if err := q.store.writeMessage(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run applies new writes when woken, retries queued writes every retry
+// interval and checks the alarm thresholds
+func (q *WriteQueue) run() {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.wake:
+			q.Flush()
+		case <-ticker.C:
+			if pending, err := q.Pending(); err == nil && pending > 0 && q.Flush() == nil {
+				q.logger.Infof("Queued Supabase writes are flushed")
+			}
+			q.checkAlarm()
+		}
+	}
+}
+
+// handleWriteQueueStats serves GET /api/admin/write-queue, the backlog of
+// the Supabase write queue, whether it is alarming and whether the circuit
+// breaker is refusing Supabase requests
+func handleWriteQueueStats(messageStore MessageStoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		store, ok := supabaseStoreOf(messageStore)
+		if !ok || store.writeQueue == nil {
+			http.Error(w, "Write queue is disabled", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		stats, err := store.writeQueue.Stats()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Failed to read write queue: %v", err),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"queue":        stats,
+			"circuit_open": store.client.breaker.Open(),
+		})
+	}
+}
@@ -18,7 +18,9 @@ from whatsapp import (
     send_message as whatsapp_send_message,
     send_file as whatsapp_send_file,
     send_audio_message as whatsapp_audio_voice_message,
-    download_media as whatsapp_download_media
+    download_media as whatsapp_download_media,
+    summarize_chat as whatsapp_summarize_chat,
+    get_chat_transcript as whatsapp_get_chat_transcript
 )
 
 # Initialize FastMCP server
@@ -252,6 +254,50 @@ def download_media(message_id: str, chat_jid: str) -> Dict[str, Any]:
             "message": "Failed to download media"
         }
 
+@mcp.tool()
+def summarize_chat(chat_jid: str) -> Dict[str, Any]:
+    """Summarize the recent messages of a WhatsApp chat and store the summary on the conversation.
+    
+    Args:
+        chat_jid: The JID of the chat to summarize
+    
+    Returns:
+        A dictionary containing success status and the summary or an error message
+    """
+    success, result = whatsapp_summarize_chat(chat_jid)
+    if success:
+        return {
+            "success": True,
+            "summary": result
+        }
+    return {
+        "success": False,
+        "message": result
+    }
+
+@mcp.tool()
+def get_chat_transcript(chat_jid: str, limit: int = 100, before: Optional[str] = None) -> Dict[str, Any]:
+    """Get the recent messages of a WhatsApp chat as a compact transcript, one line per message with speaker names, media placeholders and times only where they change. Cheaper to read than list_messages.
+    
+    Args:
+        chat_jid: The JID of the chat
+        limit: Number of most recent messages to include (default 100, at most 1000)
+        before: Optional RFC 3339 timestamp (e.g. 2026-10-14T09:00:00Z) to only include messages before this time
+    
+    Returns:
+        A dictionary containing success status and the transcript or an error message
+    """
+    success, result = whatsapp_get_chat_transcript(chat_jid, limit, before)
+    if success:
+        return {
+            "success": True,
+            "transcript": result
+        }
+    return {
+        "success": False,
+        "message": result
+    }
+
 if __name__ == "__main__":
     import os
     import uvicorn
@@ -279,7 +325,7 @@ if __name__ == "__main__":
     async def health_check(request):
         """Health check with WhatsApp connection status"""
         try:
-            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api")
+            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api/v1")
             async with httpx.AsyncClient() as client:
                 resp = await client.get(f"{base_url}/status", timeout=5.0)
                 wa_status = resp.json()
@@ -304,9 +350,22 @@ if __name__ == "__main__":
                 }
             })
 
+    def caller_headers(request: Request) -> dict:
+        """The caller's own credential, forwarded so the bridge checks it
+        instead of trusting WHATSAPP_API_KEY for anyone who reaches this server"""
+        headers = {}
+        for name in ("Authorization", "X-API-Key"):
+            if request.headers.get(name):
+                headers[name] = request.headers[name]
+        return headers
+
     async def api_send(request: Request):
-        """REST endpoint to send WhatsApp messages"""
+        """REST endpoint to send WhatsApp messages as the calling API key"""
         try:
+            headers = caller_headers(request)
+            if not headers:
+                return JSONResponse({"success": False, "message": "An API key is required (Authorization: Bearer <key>)"}, status_code=401)
+
             body = await request.json()
             recipient = body.get("recipient")
             message = body.get("message")
@@ -316,8 +375,12 @@ if __name__ == "__main__":
             if not message:
                 return JSONResponse({"success": False, "message": "message is required"}, status_code=400)
 
-            success, status_message = whatsapp_send_message(recipient, message)
-            return JSONResponse({"success": success, "message": status_message})
+            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api/v1")
+            async with httpx.AsyncClient() as client:
+                resp = await client.post(f"{base_url}/send", json={"recipient": recipient, "message": message}, headers=headers, timeout=30.0)
+                if resp.headers.get("content-type", "").startswith("application/json"):
+                    return JSONResponse(resp.json(), status_code=resp.status_code)
+                return JSONResponse({"success": False, "message": resp.text.strip()}, status_code=resp.status_code)
         except Exception as e:
             return JSONResponse({"success": False, "message": str(e)}, status_code=500)
 
@@ -326,7 +389,7 @@ if __name__ == "__main__":
     async def api_qr(request: Request):
         """Get QR code for WhatsApp authentication"""
         try:
-            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api")
+            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api/v1")
             # Pass query parameters (e.g., format=png)
             query_string = str(request.query_params)
             url = f"{base_url}/qr"
@@ -348,7 +411,7 @@ if __name__ == "__main__":
     async def api_status(request: Request):
         """Get WhatsApp connection status"""
         try:
-            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api")
+            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api/v1")
             async with httpx.AsyncClient() as client:
                 resp = await client.get(f"{base_url}/status", timeout=10.0)
                 return JSONResponse(resp.json())
@@ -358,11 +421,13 @@ if __name__ == "__main__":
     async def api_pair_phone(request: Request):
         """Pair WhatsApp using phone number instead of QR code"""
         try:
-            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api")
+            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api/v1")
             body = await request.json()
             async with httpx.AsyncClient() as client:
-                resp = await client.post(f"{base_url}/pair-phone", json=body, timeout=30.0)
-                return JSONResponse(resp.json())
+                resp = await client.post(f"{base_url}/pair-phone", json=body, headers=caller_headers(request), timeout=30.0)
+                if resp.headers.get("content-type", "").startswith("application/json"):
+                    return JSONResponse(resp.json(), status_code=resp.status_code)
+                return JSONResponse({"success": False, "message": resp.text.strip()}, status_code=resp.status_code)
         except Exception as e:
             return JSONResponse({"success": False, "message": str(e)}, status_code=500)
 
@@ -371,9 +436,9 @@ if __name__ == "__main__":
     async def auth_page(request: Request):
         """Proxy the WhatsApp authentication page from Go bridge"""
         try:
-            # Get base URL and extract host:port (remove /api suffix)
-            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api")
-            bridge_url = base_url.replace("/api", "")
+            # Get base URL and extract host:port (remove /api/v1 suffix)
+            base_url = os.environ.get("WHATSAPP_API_BASE_URL", "http://localhost:8080/api/v1")
+            bridge_url = base_url.split("/api")[0]
             async with httpx.AsyncClient() as client:
                 resp = await client.get(f"{bridge_url}/auth", timeout=10.0)
                 return HTMLResponse(content=resp.text, status_code=resp.status_code)
@@ -389,6 +454,10 @@ if __name__ == "__main__":
             Route("/api/qr", endpoint=api_qr),
             Route("/api/status", endpoint=api_status),
             Route("/api/pair-phone", endpoint=api_pair_phone, methods=["POST"]),
+            Route("/api/v1/send", endpoint=api_send, methods=["POST"]),
+            Route("/api/v1/qr", endpoint=api_qr),
+            Route("/api/v1/status", endpoint=api_status),
+            Route("/api/v1/pair-phone", endpoint=api_pair_phone, methods=["POST"]),
             Route("/sse", endpoint=handle_sse),
             Route("/messages", endpoint=handle_messages, methods=["POST"]),
         ]
@@ -8,7 +8,14 @@ import json
 import audio
 
 MESSAGES_DB_PATH = os.environ.get('MESSAGES_DB_PATH', os.path.join(os.path.dirname(os.path.abspath(__file__)), '..', 'whatsapp-bridge', 'store', 'messages.db'))
-WHATSAPP_API_BASE_URL = os.environ.get('WHATSAPP_API_BASE_URL', "http://localhost:8080/api")
+WHATSAPP_API_BASE_URL = os.environ.get('WHATSAPP_API_BASE_URL', "http://localhost:8080/api/v1")
+WHATSAPP_API_KEY = os.environ.get('WHATSAPP_API_KEY', '')
+
+def bridge_headers() -> dict:
+    """Headers for bridge API requests, including the API key when configured."""
+    if WHATSAPP_API_KEY:
+        return {"Authorization": f"Bearer {WHATSAPP_API_KEY}"}
+    return {}
 
 @dataclass
 class Message:
@@ -634,7 +641,7 @@ def send_message(recipient: str, message: str) -> Tuple[bool, str]:
             "message": message,
         }
         
-        response = requests.post(url, json=payload)
+        response = requests.post(url, json=payload, headers=bridge_headers())
         
         # Check if the request was successful
         if response.status_code == 200:
@@ -668,7 +675,7 @@ def send_file(recipient: str, media_path: str) -> Tuple[bool, str]:
             "media_path": media_path
         }
         
-        response = requests.post(url, json=payload)
+        response = requests.post(url, json=payload, headers=bridge_headers())
         
         # Check if the request was successful
         if response.status_code == 200:
@@ -708,7 +715,7 @@ def send_audio_message(recipient: str, media_path: str) -> Tuple[bool, str]:
             "media_path": media_path
         }
         
-        response = requests.post(url, json=payload)
+        response = requests.post(url, json=payload, headers=bridge_headers())
         
         # Check if the request was successful
         if response.status_code == 200:
@@ -741,7 +748,7 @@ def download_media(message_id: str, chat_jid: str) -> Optional[str]:
             "chat_jid": chat_jid
         }
         
-        response = requests.post(url, json=payload)
+        response = requests.post(url, json=payload, headers=bridge_headers())
         
         if response.status_code == 200:
             result = response.json()
@@ -765,3 +772,66 @@ def download_media(message_id: str, chat_jid: str) -> Optional[str]:
     except Exception as e:
         print(f"Unexpected error: {str(e)}")
         return None
+
+def summarize_chat(chat_jid: str) -> Tuple[bool, str]:
+    """Ask the bridge to summarize a chat's recent messages.
+    
+    Args:
+        chat_jid: The JID of the chat to summarize
+    
+    Returns:
+        A tuple of (success, summary or error message)
+    """
+    try:
+        url = f"{WHATSAPP_API_BASE_URL}/chats/summarize"
+        payload = {
+            "chat_jid": chat_jid
+        }
+        
+        response = requests.post(url, json=payload, headers=bridge_headers())
+        result = response.json()
+        
+        if response.status_code == 200 and result.get("success", False):
+            return True, result.get("summary", "")
+        return False, result.get("message", f"HTTP {response.status_code}")
+            
+    except requests.RequestException as e:
+        return False, f"Request error: {str(e)}"
+    except json.JSONDecodeError:
+        return False, f"Error parsing response: {response.text}"
+    except Exception as e:
+        return False, f"Unexpected error: {str(e)}"
+
+def get_chat_transcript(chat_jid: str, limit: int = 100, before: Optional[str] = None) -> Tuple[bool, str]:
+    """Fetch a chat window from the bridge as a compact transcript.
+    
+    Args:
+        chat_jid: The JID of the chat
+        limit: Number of most recent messages in the window
+        before: Optional RFC 3339 timestamp to end the window at
+    
+    Returns:
+        A tuple of (success, transcript or error message)
+    """
+    try:
+        url = f"{WHATSAPP_API_BASE_URL}/chats/transcript"
+        params = {
+            "chat_jid": chat_jid,
+            "limit": limit
+        }
+        if before:
+            params["before"] = before
+        
+        response = requests.get(url, params=params, headers=bridge_headers())
+        result = response.json()
+        
+        if response.status_code == 200 and result.get("success", False):
+            return True, result.get("transcript", "")
+        return False, result.get("message", f"HTTP {response.status_code}")
+            
+    except requests.RequestException as e:
+        return False, f"Request error: {str(e)}"
+    except json.JSONDecodeError:
+        return False, f"Error parsing response: {response.text}"
+    except Exception as e:
+        return False, f"Unexpected error: {str(e)}"